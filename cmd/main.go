@@ -3,13 +3,21 @@ package main
 import (
 	"context"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
+	"brian-nunez/bcode/internal/artifacts"
+	uihandlers "brian-nunez/bcode/internal/handlers/v1/ui"
 	"brian-nunez/bcode/internal/httpserver"
+	"brian-nunez/bcode/internal/jobs"
+	"brian-nunez/bcode/internal/monitors"
+	"brian-nunez/bcode/internal/orchestrator"
+	"brian-nunez/bcode/internal/scheduler"
+	"brian-nunez/bcode/internal/webhooks"
 )
 
 func main() {
@@ -31,16 +39,65 @@ func main() {
 		}
 	}()
 
+	background, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+
+	if store, err := artifacts.Default(); err != nil {
+		log.Printf("artifact store unavailable: %v", err)
+	} else {
+		go artifacts.RunRetention(background, store, artifacts.Retention(), time.Hour)
+	}
+
+	webhookStore, err := webhooks.Default()
+	if err != nil {
+		log.Printf("webhooks unavailable: %v", err)
+	} else {
+		jobs.Default.OnStatusChange(webhooks.NewDispatcher(background, webhookStore).JobChanged)
+	}
+
+	if store, err := scheduler.Default(); err != nil {
+		log.Printf("schedules unavailable: %v", err)
+	} else {
+		var observers []scheduler.Observer
+		monitorStore, err := monitors.Default()
+		if err == nil {
+			var artifactStore artifacts.Store
+			if artifactStore, err = artifacts.Default(); err == nil {
+				observers = append(observers, monitors.NewWatcher(monitorStore, artifactStore))
+			}
+		}
+		if err != nil {
+			log.Printf("monitors unavailable: %v", err)
+		}
+		go scheduler.New(store, uihandlers.RunJob, observers...).Run(background, time.Second)
+	}
+
+	runner, err := orchestrator.Default()
+	if err != nil {
+		log.Printf("worker runner unavailable: %v", err)
+	} else if reconciler, ok := runner.(orchestrator.Reconciler); ok {
+		go orchestrator.RunReconciliation(background, reconciler, orchestrator.ReconcileInterval())
+	}
+
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
 
 	<-quit
+	stopBackground()
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
 	log.Println("Shutting down server...")
-	err := server.Shutdown(ctx)
+	err = server.Shutdown(ctx)
+	if closer, ok := runner.(io.Closer); ok {
+		closer.Close()
+	}
+	if webhookStore != nil {
+		if err := webhookStore.Flush(); err != nil {
+			log.Printf("could not save webhooks: %v", err)
+		}
+	}
 	if err != nil {
 		log.Fatalf("Server shutdown failed: %v", err)
 	}
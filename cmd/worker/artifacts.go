@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+
+	"github.com/playwright-community/playwright-go"
+)
+
+// artifactChunkSize keeps each JOB_ARTIFACT line well under the server's
+// line buffer, so large files stream through without being held in memory.
+const artifactChunkSize = 256 * 1024
+
+type ArtifactChunk struct {
+	Name  string `json:"name"`
+	Data  []byte `json:"data"`
+	Final bool   `json:"final,omitempty"`
+}
+
+var (
+	artifactMu    sync.Mutex
+	artifactNames []string
+	artifactsWG   sync.WaitGroup
+
+	unsafeArtifactChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+)
+
+// uploadArtifact streams a file to the server in chunks and records its name
+// for the job result.
+func uploadArtifact(name string, r io.Reader) error {
+	buf := make([]byte, artifactChunkSize)
+	for {
+		n, err := io.ReadFull(r, buf)
+		final := err == io.EOF || err == io.ErrUnexpectedEOF
+		if err != nil && !final {
+			return err
+		}
+		emitEvent("JOB_ARTIFACT", ArtifactChunk{Name: name, Data: buf[:n], Final: final})
+		if final {
+			break
+		}
+	}
+
+	artifactMu.Lock()
+	artifactNames = append(artifactNames, name)
+	artifactMu.Unlock()
+	return nil
+}
+
+func uploadArtifactFile(name, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return uploadArtifact(name, f)
+}
+
+// uploadedArtifacts waits for pending downloads and returns every artifact
+// sent so far.
+func uploadedArtifacts() []string {
+	artifactsWG.Wait()
+
+	artifactMu.Lock()
+	defer artifactMu.Unlock()
+	return append([]string(nil), artifactNames...)
+}
+
+// artifactName turns an arbitrary file name into one the server accepts.
+func artifactName(prefix, name string) string {
+	name = unsafeArtifactChars.ReplaceAllString(filepath.Base(name), "_")
+	if filepath.Ext(name) == "" {
+		name += ".bin"
+	}
+	return prefix + name
+}
+
+// captureDownloads uploads every file the page downloads. Waiting for a
+// download blocks, so each one is handled off the event loop.
+func captureDownloads(page playwright.Page) {
+	page.OnDownload(func(download playwright.Download) {
+		artifactsWG.Add(1)
+		go func() {
+			defer artifactsWG.Done()
+
+			path, err := download.Path()
+			if err != nil {
+				fmt.Fprintf(stdout, "Download of %s failed: %v\n", download.SuggestedFilename(), err)
+				return
+			}
+			name := artifactName("download-", download.SuggestedFilename())
+			if err := uploadArtifactFile(name, path); err != nil {
+				fmt.Fprintf(stdout, "Could not upload %s: %v\n", name, err)
+				return
+			}
+			fmt.Fprintf(stdout, "Saved download %s\n", name)
+		}()
+	})
+}
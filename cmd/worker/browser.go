@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/playwright-community/playwright-go"
+)
+
+const (
+	browserChromium = "chromium"
+	browserFirefox  = "firefox"
+	browserWebKit   = "webkit"
+
+	xvfbDisplay = ":99"
+)
+
+type LaunchOptions struct {
+	// Headed runs the browser with a window on a virtual X display.
+	Headed    bool          `json:"headed,omitempty"`
+	Args      []string      `json:"args,omitempty"`
+	UserAgent string        `json:"user_agent,omitempty"`
+	Proxy     *ProxyOptions `json:"proxy,omitempty"`
+}
+
+type ProxyOptions struct {
+	Server   string `json:"server"`
+	Bypass   string `json:"bypass,omitempty"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+// launchBrowser starts the requested engine. Engines missing from the worker
+// image fail here with Playwright's "Executable doesn't exist" error. Proxy
+// credentials may reference the job's secrets.
+func launchBrowser(pw *playwright.Playwright, name string, options *LaunchOptions, secrets map[string]string) (playwright.Browser, error) {
+	var browserType playwright.BrowserType
+	switch name {
+	case "", browserChromium:
+		browserType = pw.Chromium
+	case browserFirefox:
+		browserType = pw.Firefox
+	case browserWebKit:
+		browserType = pw.WebKit
+	default:
+		return nil, fmt.Errorf("unknown browser %q", name)
+	}
+
+	launch := playwright.BrowserTypeLaunchOptions{}
+	if options != nil {
+		if options.Headed {
+			if err := startXvfb(); err != nil {
+				return nil, fmt.Errorf("could not start virtual display: %w", err)
+			}
+			launch.Headless = playwright.Bool(false)
+		}
+		launch.Args = options.Args
+		if options.Proxy != nil && options.Proxy.Server != "" {
+			launch.Proxy = &playwright.Proxy{
+				Server:   options.Proxy.Server,
+				Bypass:   optionalString(options.Proxy.Bypass),
+				Username: optionalString(resolveSecrets(options.Proxy.Username, secrets)),
+				Password: optionalString(resolveSecrets(options.Proxy.Password, secrets)),
+			}
+		}
+	}
+
+	fmt.Fprintf(stdout, "Launching %s\n", browserType.Name())
+	return browserType.Launch(launch)
+}
+
+// startXvfb gives headed browsers a display unless one is already set.
+func startXvfb() error {
+	if os.Getenv("DISPLAY") != "" {
+		return nil
+	}
+
+	cmd := exec.Command("Xvfb", xvfbDisplay, "-screen", "0", "1920x1080x24", "-nolisten", "tcp")
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	os.Setenv("DISPLAY", xvfbDisplay)
+
+	// Give the server a moment to accept connections
+	time.Sleep(500 * time.Millisecond)
+	return nil
+}
+
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
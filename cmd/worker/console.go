@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/playwright-community/playwright-go"
+)
+
+const (
+	levelDebug   = "debug"
+	levelInfo    = "info"
+	levelWarning = "warning"
+	levelError   = "error"
+)
+
+// ConsoleEvent is a browser-side event: a console.* call, an uncaught page
+// error, a failed request or a dialog.
+type ConsoleEvent struct {
+	Type     string `json:"type"`
+	Level    string `json:"level"`
+	Text     string `json:"text"`
+	Location string `json:"location,omitempty"`
+}
+
+type ConsoleTotals struct {
+	Debug   int `json:"debug"`
+	Info    int `json:"info"`
+	Warning int `json:"warning"`
+	Error   int `json:"error"`
+}
+
+var (
+	consoleMu     sync.Mutex
+	consoleTotals ConsoleTotals
+)
+
+// captureConsole forwards browser events from every page of the context as
+// JOB_CONSOLE events.
+func captureConsole(browserContext playwright.BrowserContext) {
+	browserContext.OnConsole(func(msg playwright.ConsoleMessage) {
+		event := ConsoleEvent{Type: "console", Level: consoleLevel(msg.Type()), Text: msg.Text()}
+		if loc := msg.Location(); loc != nil && loc.URL != "" {
+			event.Location = fmt.Sprintf("%s:%d:%d", loc.URL, loc.LineNumber+1, loc.ColumnNumber+1)
+		}
+		emitConsole(event)
+	})
+	browserContext.OnWebError(func(webError playwright.WebError) {
+		emitConsole(ConsoleEvent{Type: "pageerror", Level: levelError, Text: webError.Error().Error()})
+	})
+	browserContext.OnRequestFailed(func(request playwright.Request) {
+		text := request.Method() + " " + request.URL()
+		if err := request.Failure(); err != nil {
+			text += ": " + err.Error()
+		}
+		emitConsole(ConsoleEvent{Type: "requestfailed", Level: levelError, Text: text})
+	})
+	// Registering a dialog listener turns off Playwright's auto-dismiss, so
+	// dismiss them here to keep the same behaviour.
+	browserContext.OnDialog(func(dialog playwright.Dialog) {
+		emitConsole(ConsoleEvent{Type: "dialog", Level: levelWarning, Text: dialog.Type() + ": " + dialog.Message()})
+		go dialog.Dismiss()
+	})
+}
+
+func consoleLevel(kind string) string {
+	switch kind {
+	case "error", "assert":
+		return levelError
+	case "warning":
+		return levelWarning
+	case "debug", "trace":
+		return levelDebug
+	default:
+		return levelInfo
+	}
+}
+
+func emitConsole(event ConsoleEvent) {
+	consoleMu.Lock()
+	switch event.Level {
+	case levelDebug:
+		consoleTotals.Debug++
+	case levelWarning:
+		consoleTotals.Warning++
+	case levelError:
+		consoleTotals.Error++
+	default:
+		consoleTotals.Info++
+	}
+	consoleMu.Unlock()
+
+	event.Text = redaction.Redact(event.Text)
+	event.Location = redaction.Redact(event.Location)
+	emitEvent("JOB_CONSOLE", event)
+}
+
+func consoleSummary() *ConsoleTotals {
+	consoleMu.Lock()
+	defer consoleMu.Unlock()
+
+	totals := consoleTotals
+	return &totals
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/playwright-community/playwright-go"
+)
+
+const (
+	promptQuestion = "question"
+	promptApproval = "approval"
+	promptTakeover = "takeover"
+
+	decisionAnswer   = "answer"
+	decisionApprove  = "approve"
+	decisionReject   = "reject"
+	decisionTakeOver = "take_over"
+	decisionResume   = "resume"
+
+	defaultPauseTimeout = 5 * time.Minute
+)
+
+type ApprovalPolicy struct {
+	Patterns   []string `json:"patterns,omitempty"`
+	FormSubmit bool     `json:"form_submit,omitempty"`
+}
+
+// ControlMessage is read as a JSON line from stdin, which the orchestrator
+// keeps attached for the lifetime of the container. When the payload is sent
+// on stdin, control messages follow it.
+type ControlMessage struct {
+	Type     string      `json:"type"`
+	PromptID string      `json:"prompt_id,omitempty"`
+	Decision string      `json:"decision,omitempty"`
+	Value    string      `json:"value,omitempty"`
+	Input    *InputEvent `json:"input,omitempty"`
+}
+
+type PausePrompt struct {
+	ID      string `json:"id"`
+	Kind    string `json:"kind"`
+	Message string `json:"message"`
+	Image   string `json:"image,omitempty"`
+}
+
+// controlMessages carries the response to the pending prompt. Only one
+// response is ever accepted per prompt, so sending never blocks the reader.
+var controlMessages = make(chan ControlMessage, 1)
+
+var (
+	pendingMu sync.Mutex
+	// pendingPrompt is the ID of the prompt waitForOperator waits on, if any
+	pendingPrompt string
+	promptCount   int
+)
+
+// acceptResponse hands a response to the waiting prompt. Responses to a
+// prompt nobody waits on any more are dropped.
+func acceptResponse(msg ControlMessage) bool {
+	pendingMu.Lock()
+	defer pendingMu.Unlock()
+
+	if msg.Type != "respond" || pendingPrompt == "" || msg.PromptID != pendingPrompt {
+		return false
+	}
+	pendingPrompt = ""
+	controlMessages <- msg
+	return true
+}
+
+// expectResponse starts waiting on a new prompt and returns its ID.
+func expectResponse() string {
+	pendingMu.Lock()
+	defer pendingMu.Unlock()
+
+	promptCount++
+	pendingPrompt = fmt.Sprintf("prompt-%d", promptCount)
+	return pendingPrompt
+}
+
+// stopExpecting gives up on the pending prompt, discarding a response that
+// arrived meanwhile.
+func stopExpecting() {
+	pendingMu.Lock()
+	defer pendingMu.Unlock()
+
+	pendingPrompt = ""
+	select {
+	case <-controlMessages:
+	default:
+	}
+}
+
+func listenForControl() {
+	go func() {
+		defer close(controlMessages)
+		scanner := bufio.NewScanner(stdin)
+		for scanner.Scan() {
+			var msg ControlMessage
+			if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+				fmt.Fprintf(stdout, "Ignoring malformed control message: %v\n", err)
+				continue
+			}
+			switch msg.Type {
+			case "input":
+				if msg.Input != nil {
+					queueInput(*msg.Input)
+				}
+			case "take_over":
+				takeoverRequested.Store(true)
+			default:
+				if !acceptResponse(msg) {
+					fmt.Fprintf(stdout, "Ignoring %s for prompt %q, which is not pending\n", msg.Type, msg.PromptID)
+				}
+			}
+		}
+	}()
+}
+
+// askOperator pauses the job until the operator responds. When the operator
+// takes over, it keeps waiting until control is handed back and reports the
+// take-over with the note given on resume. ok is false when nobody answered
+// before the timeout or no control channel is attached.
+func askOperator(page playwright.Page, kind, message string, timeout time.Duration) (ControlMessage, bool) {
+	reply, ok := waitForOperator(page, kind, message, timeout)
+	if ok && reply.Decision == decisionTakeOver {
+		takeoverRequested.Store(true)
+		_, reply.Value = checkTakeover(page, timeout)
+		return reply, true
+	}
+
+	emitEvent("JOB_RESUME", struct{}{})
+	return reply, ok
+}
+
+func waitForOperator(page playwright.Page, kind, message string, timeout time.Duration) (ControlMessage, bool) {
+	prompt := PausePrompt{ID: expectResponse(), Kind: kind, Message: redaction.Redact(message)}
+	if shot, err := screenshot(page, 50); err == nil {
+		prompt.Image = base64.StdEncoding.EncodeToString(shot)
+	}
+	emitEvent("JOB_PAUSE", prompt)
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case msg, open := <-controlMessages:
+		if !open {
+			fmt.Fprintln(stdout, "No control channel attached, continuing without operator.")
+			return ControlMessage{}, false
+		}
+		return msg, true
+	case <-timer.C:
+		stopExpecting()
+		fmt.Fprintf(stdout, "No operator response after %s.\n", timeout)
+		return ControlMessage{}, false
+	}
+}
+
+type approvalRules struct {
+	patterns   []*regexp.Regexp
+	formSubmit bool
+}
+
+func newApprovalRules(policy *ApprovalPolicy) approvalRules {
+	var rules approvalRules
+	if policy == nil {
+		return rules
+	}
+
+	rules.formSubmit = policy.FormSubmit
+	for _, p := range policy.Patterns {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		re, err := regexp.Compile("(?i)" + p)
+		if err != nil {
+			// Treat invalid expressions as plain text
+			re = regexp.MustCompile("(?i)" + regexp.QuoteMeta(p))
+		}
+		rules.patterns = append(rules.patterns, re)
+	}
+
+	return rules
+}
+
+// reason returns why an action needs approval, or "" if it can run.
+func (r approvalRules) reason(action, key, description string, submits bool) string {
+	switch action {
+	case "click", "fill":
+		for _, re := range r.patterns {
+			if re.MatchString(description) {
+				return fmt.Sprintf("element matches %q", re.String()[len("(?i)"):])
+			}
+		}
+		if r.formSubmit && action == "click" && submits {
+			return "this submits a form"
+		}
+	case "press":
+		if r.formSubmit && strings.EqualFold(key, "Enter") {
+			return "pressing Enter may submit a form"
+		}
+	}
+
+	return ""
+}
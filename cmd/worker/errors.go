@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"brian-nunez/bcode/internal/jobs"
+	"github.com/playwright-community/playwright-go"
+)
+
+// newJobError builds a JobError, with the code's default retryability, for
+// JobResult.Error. The codes are the server's; CONTAINER_OOM and the others
+// it synthesizes are never reported by a worker.
+func newJobError(code jobs.ErrorCode, message string, details map[string]string) *jobs.JobError {
+	jobErr := jobs.NewError(code, message)
+	jobErr.Details = details
+	return jobErr
+}
+
+// navigationError reports a failed page.Goto, telling timeouts apart from
+// network and HTTP level failures.
+func navigationError(url string, err error) *jobs.JobError {
+	code := jobs.ErrNavigationFailed
+	if errors.Is(err, playwright.ErrTimeout) {
+		code = jobs.ErrTimeout
+	}
+	return newJobError(code, fmt.Sprintf("could not goto: %v", err), map[string]string{"url": url})
+}
+
+// pageError reports a failure inside an already loaded page.
+func pageError(message string, err error) *jobs.JobError {
+	code := jobs.ErrPageError
+	if errors.Is(err, playwright.ErrTimeout) {
+		code = jobs.ErrTimeout
+	}
+	return newJobError(code, fmt.Sprintf("%s: %v", message, err), nil)
+}
+
+// actionError reports a failed fill, click or press. Playwright only times
+// out on these while waiting for the element to become actionable.
+func actionError(action, selector string, err error) *jobs.JobError {
+	message := fmt.Sprintf("could not %s %s: %v", action, selector, err)
+	if errors.Is(err, playwright.ErrTimeout) {
+		return newJobError(jobs.ErrSelectorNotFound, message, map[string]string{"selector": selector})
+	}
+	return newJobError(jobs.ErrPageError, message, nil)
+}
+
+// ollamaStatusError reports a non-200 answer from Ollama. Client errors
+// (unknown model, bad request) will not go away on a retry.
+func ollamaStatusError(status int, body string) *jobs.JobError {
+	jobErr := newJobError(jobs.ErrLLMUnavailable, fmt.Sprintf("ollama returned status %d: %s", status, body), map[string]string{"status": strconv.Itoa(status)})
+	jobErr.Retryable = status >= 500
+	return jobErr
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/playwright-community/playwright-go"
+)
+
+// How long to wait for each extracted field's element to appear.
+const extractTimeout = 5 * time.Second
+
+// extractFields reads the text of the first element matching each named CSS
+// selector. A field whose element cannot be found is left empty, so that
+// its disappearing shows up as a change.
+func extractFields(page playwright.Page, selectors map[string]string) map[string]string {
+	fields := make(map[string]string, len(selectors))
+	for name, selector := range selectors {
+		text, err := page.Locator(selector).First().InnerText(playwright.LocatorInnerTextOptions{
+			Timeout: playwright.Float(float64(extractTimeout.Milliseconds())),
+		})
+		if err != nil {
+			fmt.Fprintf(stdout, "Could not read field %s (%s): %v\n", name, selector, err)
+		}
+		fields[name] = strings.TrimSpace(text)
+	}
+	return fields
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"strings"
+	"time"
+)
+
+// TimelineEntry places an agent history step on the session video.
+type TimelineEntry struct {
+	OffsetSeconds float64 `json:"offset_seconds"`
+	Step          string  `json:"step"`
+}
+
+// agentHistory is the list of steps fed back to the model. Each step is
+// also timestamped relative to the start of the browser session so it can
+// be lined up with the recorded video.
+type agentHistory struct {
+	start    time.Time
+	steps    []string
+	timeline []TimelineEntry
+}
+
+func newAgentHistory(start time.Time) *agentHistory {
+	return &agentHistory{start: start}
+}
+
+func (h *agentHistory) add(step string) {
+	h.steps = append(h.steps, step)
+	h.timeline = append(h.timeline, TimelineEntry{
+		OffsetSeconds: time.Since(h.start).Seconds(),
+		Step:          step,
+	})
+}
+
+func (h *agentHistory) String() string {
+	return strings.Join(h.steps, "\n")
+}
@@ -11,21 +11,71 @@ import (
 	"os"
 	"regexp"
 	"strings"
+	"time"
 
+	"brian-nunez/bcode/internal/jobs"
 	"github.com/playwright-community/playwright-go"
 )
 
 type JobPayload struct {
-	Action string `json:"action"`
-	URL    string `json:"url"`
-	Target string `json:"target,omitempty"`
+	Action              string             `json:"action"`
+	URL                 string             `json:"url"`
+	Target              string             `json:"target,omitempty"`
+	Approval            *ApprovalPolicy    `json:"approval,omitempty"`
+	PauseTimeoutSeconds int                `json:"pause_timeout_seconds,omitempty"`
+	Profile             *ProfilePayload    `json:"profile,omitempty"`
+	Secrets             map[string]string  `json:"secrets,omitempty"`
+	Redaction           *RedactionPolicy   `json:"redaction,omitempty"`
+	SavePDF             bool               `json:"save_pdf,omitempty"`
+	Screenshot          bool               `json:"screenshot,omitempty"`
+	Extract             map[string]string  `json:"extract,omitempty"`
+	Network             *NetworkOptions    `json:"network,omitempty"`
+	RecordVideo         bool               `json:"record_video,omitempty"`
+	Screencast          *ScreencastOptions `json:"screencast,omitempty"`
+	Browser             string             `json:"browser,omitempty"`
+	Launch              *LaunchOptions     `json:"launch,omitempty"`
 }
 
+// JobResult references screenshots and other files by artifact name; the
+// files themselves are sent separately as JOB_ARTIFACT chunks.
 type JobResult struct {
-	Success bool   `json:"success"`
-	Data    string `json:"data,omitempty"`
-	Image   string `json:"image,omitempty"`
-	Error   string `json:"error,omitempty"`
+	Success        bool              `json:"success"`
+	Data           string            `json:"data,omitempty"`
+	Fields         map[string]string `json:"fields,omitempty"`
+	Screenshot     string            `json:"screenshot,omitempty"`
+	Artifacts      []string          `json:"artifacts,omitempty"`
+	FailedRequests []FailedRequest   `json:"failed_requests,omitempty"`
+	Console        *ConsoleTotals    `json:"console,omitempty"`
+	Video          string            `json:"video,omitempty"`
+	Timeline       []TimelineEntry   `json:"timeline,omitempty"`
+	Error          *jobs.JobError    `json:"error,omitempty"`
+	Usage          *Usage            `json:"usage,omitempty"`
+}
+
+// Usage is what the job consumed that the server cannot measure itself; it is
+// charged to the job's workspace.
+type Usage struct {
+	LLMTokens int64 `json:"llm_tokens"`
+}
+
+// countTokens adds the prompt and completion tokens of an Ollama response.
+func (r *JobResult) countTokens(ollamaResp map[string]interface{}) {
+	promptTokens, _ := ollamaResp["prompt_eval_count"].(float64)
+	completionTokens, _ := ollamaResp["eval_count"].(float64)
+	if r.Usage == nil {
+		r.Usage = &Usage{}
+	}
+	r.Usage.LLMTokens += int64(promptTokens + completionTokens)
+}
+
+// attachScreenshot uploads the final screenshot of the job.
+func (r *JobResult) attachScreenshot(shot []byte) {
+	const name = "screenshot.jpg"
+	if err := uploadArtifact(name, bytes.NewReader(shot)); err != nil {
+		fmt.Fprintf(stdout, "Could not upload screenshot: %v\n", err)
+		return
+	}
+	r.Screenshot = name
 }
 
 func main() {
@@ -44,7 +94,7 @@ func main() {
 		if err := playwright.Install(); err != nil {
 			log.Fatalf("could not install playwright: %v", err)
 		}
-		fmt.Println("Playwright dependencies installed.")
+		fmt.Fprintln(stdout, "Playwright dependencies installed.")
 		return
 	}
 
@@ -58,42 +108,104 @@ func main() {
 		}
 	}
 
-	payloadStr := os.Getenv("JOB_PAYLOAD")
-	if payloadStr == "" {
-		log.Fatal("JOB_PAYLOAD environment variable is required")
+	payloadData, err := readPayload()
+	if err != nil {
+		log.Fatal(err)
 	}
 
 	var payload JobPayload
-	if err := json.Unmarshal([]byte(payloadStr), &payload); err != nil {
+	if err := json.Unmarshal(payloadData, &payload); err != nil {
 		log.Fatalf("failed to unmarshal payload: %v", err)
 	}
 
+	pauseTimeout := defaultPauseTimeout
+	if payload.PauseTimeoutSeconds > 0 {
+		pauseTimeout = time.Duration(payload.PauseTimeoutSeconds) * time.Second
+	}
+	approvals := newApprovalRules(payload.Approval)
+	redaction.configure(payload.Secrets, payload.Redaction)
+	listenForControl()
+
 	pw, err := playwright.Run()
 	if err != nil {
 		log.Fatalf("could not start playwright: %v", err)
 	}
 	defer pw.Stop()
 
-	browser, err := pw.Chromium.Launch()
+	browser, err := launchBrowser(pw, payload.Browser, payload.Launch, payload.Secrets)
 	if err != nil {
 		log.Fatalf("could not launch browser: %v", err)
 	}
 	defer browser.Close()
 
-	page, err := browser.NewPage()
+	contextOptions := playwright.BrowserNewContextOptions{}
+
+	var savedProfile *profileState
+	if payload.Profile != nil && len(payload.Profile.State) > 0 {
+		savedProfile, err = parseProfileState(payload.Profile.State)
+		if err != nil {
+			log.Fatalf("could not load profile %s: %v", payload.Profile.Name, err)
+		}
+		contextOptions.StorageState = savedProfile.storageState()
+		fmt.Fprintf(stdout, "Loaded browser profile %q\n", payload.Profile.Name)
+	}
+
+	if payload.Launch != nil && payload.Launch.UserAgent != "" {
+		contextOptions.UserAgent = playwright.String(payload.Launch.UserAgent)
+	}
+	if payload.Network != nil && payload.Network.HAR {
+		contextOptions.RecordHarPath = playwright.String(harPath)
+		contextOptions.RecordHarContent = playwright.HarContentPolicyOmit
+	}
+	if payload.RecordVideo {
+		contextOptions.RecordVideo = recordVideoOptions()
+	}
+
+	browserContext, err := browser.NewContext(contextOptions)
+	if err != nil {
+		log.Fatalf("could not create browser context: %v", err)
+	}
+	defer browserContext.Close()
+	captureConsole(browserContext)
+
+	var network *networkRecorder
+	if payload.Network != nil {
+		network = recordNetwork(browserContext, *payload.Network)
+	}
+
+	if savedProfile != nil {
+		if err := restoreIndexedDB(browserContext, savedProfile); err != nil {
+			log.Fatalf("could not restore IndexedDB: %v", err)
+		}
+	}
+
+	// Recording starts with the page, so history steps are timed from here
+	sessionStart := time.Now()
+	page, err := browserContext.NewPage()
 	if err != nil {
 		log.Fatalf("could not create page: %v", err)
 	}
+	handleRemoteInput(page)
+	captureDownloads(page)
+
+	if payload.Screencast != nil {
+		if err := maskScreencast(browserContext); err != nil {
+			log.Fatalf("could not mask screencast: %v", err)
+		}
+		if err := startScreencast(browserContext, page, *payload.Screencast); err != nil {
+			fmt.Fprintf(stdout, "%v, falling back to screenshots\n", err)
+		}
+	}
 
 	var result JobResult
 	switch payload.Action {
 	case "scrape":
 		if _, err = page.Goto(payload.URL); err != nil {
-			result.Error = fmt.Sprintf("could not goto: %v", err)
+			result.Error = navigationError(payload.URL, err)
 		} else {
 			content, err := page.Content()
 			if err != nil {
-				result.Error = fmt.Sprintf("could not get content: %v", err)
+				result.Error = pageError("could not get content", err)
 			} else {
 				result.Success = true
 				result.Data = content
@@ -101,7 +213,7 @@ func main() {
 		}
 	case "ai_action":
 		if _, err = page.Goto(payload.URL); err != nil {
-			result.Error = fmt.Sprintf("could not goto: %v", err)
+			result.Error = navigationError(payload.URL, err)
 			break
 		}
 
@@ -111,17 +223,26 @@ func main() {
 
 		// Agent Configuration
 		const maxIterations = 5
-		fmt.Println("🤖 Starting AI Agent Loop (Max 5 steps)...")
+		fmt.Fprintln(stdout, "🤖 Starting AI Agent Loop (Max 5 steps)...")
 
-		history := []string{}
+		history := newAgentHistory(sessionStart)
+		// stuck is why the last action failed; an agent that runs out of
+		// iterations while stuck fails with it
+		var stuck *jobs.JobError
 
 		for i := 1; i <= maxIterations; i++ {
-			fmt.Printf("\n--- Iteration %d/%d ---\n", i, maxIterations)
+			fmt.Fprintf(stdout, "\n--- Iteration %d/%d ---\n", i, maxIterations)
+
+			if tookOver, note := checkTakeover(page, pauseTimeout); tookOver {
+				history.add(fmt.Sprintf("User took over the browser and handed back control. Note: %s", note))
+			}
 
 			// 2. Observe (Index Elements)
-			pageAnalysis, err := page.Evaluate(`() => {
+			pageAnalysis, err := page.Evaluate(`(redactTypes) => {
 				const map = {};
 				const items = [];
+				const descriptions = {};
+				const submits = {};
 				
 				function getSelector(el) {
 					if (el.id) return '#' + el.id;
@@ -151,10 +272,14 @@ func main() {
 					
 					// Critical: Read current value so AI knows it's filled
 					if ((el.tagName.toLowerCase() === 'input' || el.tagName.toLowerCase() === 'textarea') && el.value) {
-						desc += ' current_value:"' + el.value.slice(0, 50) + '"';
+						// Redacted input types still report that they are filled
+						const hidden = redactTypes.includes((el.type || '').toLowerCase());
+						desc += ' current_value:"' + (hidden ? '[REDACTED]' : el.value.slice(0, 50)) + '"';
 					}
 					
 					map[id] = selector;
+					descriptions[id] = desc;
+					submits[id] = el.type === 'submit' || (el.tagName.toLowerCase() === 'button' && !!el.form && !el.getAttribute('type'));
 					items.push(id + ": " + desc);
 				});
 
@@ -162,30 +287,29 @@ func main() {
 				['script', 'style', 'svg'].forEach(s => clone.querySelectorAll(s).forEach(e => e.remove()));
 				const text = clone.innerText.replace(/\s+/g, ' ').trim().slice(0, 3000); 
 
-				return { text, items: items.join('\n'), selectorMap: map };
-			}`)
+				return { text, items: items.join('\n'), selectorMap: map, descriptions, submits };
+			}`, redaction.inputTypes)
 
 			if err != nil {
-				result.Error = fmt.Sprintf("Analysis failed: %v", err)
+				result.Error = pageError("Analysis failed", err)
 				break
 			}
 
 			data := pageAnalysis.(map[string]interface{})
-			pageText := data["text"].(string)
-			elementList := data["items"].(string)
+			// Filled secrets show up as current_value; the model only ever sees placeholders
+			pageText := redaction.secrets.Replace(data["text"].(string))
+			elementList := redaction.secrets.Replace(data["items"].(string))
 			selectorMapRaw := data["selectorMap"].(map[string]interface{})
+			descriptionsRaw, _ := data["descriptions"].(map[string]interface{})
+			submitsRaw, _ := data["submits"].(map[string]interface{})
 
-			fmt.Printf("Available IDs: %v\n", selectorMapRaw)
+			fmt.Fprintf(stdout, "Available IDs: %v\n", selectorMapRaw)
 
-			screenshot, _ := page.Screenshot(playwright.PageScreenshotOptions{Type: playwright.ScreenshotTypeJpeg})
-			encodedImage := base64.StdEncoding.EncodeToString(screenshot)
+			shot, _ := screenshot(page, 0)
+			encodedImage := base64.StdEncoding.EncodeToString(shot)
 
 			// Emit Live View Update
-			updatePayload := map[string]string{
-				"image": encodedImage,
-			}
-			updateJSON, _ := json.Marshal(updatePayload)
-			fmt.Printf("\nJOB_UPDATE:%s\n", updateJSON)
+			emitEvent("JOB_UPDATE", map[string]string{"image": encodedImage})
 
 			// 3. Think (Prompt)
 			userRequest := payload.Target
@@ -193,8 +317,8 @@ func main() {
 				userRequest = "Interact with the page."
 			}
 
-			historyStr := strings.Join(history, "\n")
-			if len(history) > 0 {
+			historyStr := history.String()
+			if len(history.steps) > 0 {
 				historyStr = "HISTORY:\n" + historyStr
 			} else {
 				historyStr = "No actions yet."
@@ -221,6 +345,8 @@ INSTRUCTIONS:
 - CHECK "current_value" in AVAILABLE ELEMENTS. If a field is already filled, DO NOT fill it again.
 - You CAN perform multiple actions in one response (e.g., fill username, fill password, click submit).
 - Return a JSON ARRAY of commands.
+- Values written as {{secret:name}} are placeholders for credentials. Use them verbatim as fill values; they are substituted when filling.
+- If you need information only the user has (e.g. a 2FA code or a missing password), return [{"action": "ask_user", "question": "What is the 2FA code?"}]. The answer will appear in HISTORY.
 - CRITICAL: If you see signs of success (e.g., "Welcome", "Log out" button, "Dashboard" text) or if the login form has disappeared, YOU MUST FINISH. Return [{"action": "finish", "result": "Logged in successfully"}].
 
 Response:`, userRequest, historyStr, elementList, pageText)
@@ -236,32 +362,44 @@ Response:`, userRequest, historyStr, elementList, pageText)
 
 			resp, err := http.Post(ollamaEndpoint+"/api/generate", "application/json", bytes.NewBuffer(reqBody))
 			if err != nil {
-				result.Error = fmt.Sprintf("Ollama Error: %v", err)
+				result.Error = newJobError(jobs.ErrLLMUnavailable, fmt.Sprintf("Ollama Error: %v", err), nil)
 				break
 			}
 			defer resp.Body.Close()
 
+			if resp.StatusCode != http.StatusOK {
+				body, _ := io.ReadAll(resp.Body)
+				result.Error = ollamaStatusError(resp.StatusCode, string(body))
+				break
+			}
+
 			var ollamaResp map[string]interface{}
 			json.NewDecoder(resp.Body).Decode(&ollamaResp)
-			aiResponse := ollamaResp["response"].(string)
+			result.countTokens(ollamaResp)
+			aiResponse, ok := ollamaResp["response"].(string)
+			if !ok {
+				result.Error = newJobError(jobs.ErrLLMBadOutput, "ollama response missing 'response' field", nil)
+				break
+			}
 
-			fmt.Printf("AI: %s\n", aiResponse)
+			fmt.Fprintf(stdout, "AI: %s\n", aiResponse)
 
 			// 4. Parse Thought & JSON
 			jsonRegex := regexp.MustCompile(`(?s)(\[.*\]|\{.*\})`) // Match array OR object
 			match := jsonRegex.FindString(aiResponse)
 
 			if match == "" {
-				history = append(history, "Error: No valid JSON found. Please output JSON.")
+				history.add("Error: No valid JSON found. Please output JSON.")
 				continue
 			}
 
 			type AgentCommand struct {
-				Action string `json:"action"`
-				ID     int    `json:"id"`
-				Value  string `json:"value"`
-				Key    string `json:"key"`
-				Result string `json:"result"`
+				Action   string `json:"action"`
+				ID       int    `json:"id"`
+				Value    string `json:"value"`
+				Key      string `json:"key"`
+				Result   string `json:"result"`
+				Question string `json:"question"`
 			}
 			var cmds []AgentCommand
 
@@ -272,25 +410,49 @@ Response:`, userRequest, historyStr, elementList, pageText)
 				if err2 := json.Unmarshal([]byte(match), &singleCmd); err2 == nil {
 					cmds = []AgentCommand{singleCmd}
 				} else {
-					history = append(history, "Error: Invalid JSON format. Return valid JSON.")
+					history.add("Error: Invalid JSON format. Return valid JSON.")
 					continue
 				}
 			}
 
 			// If we have commands, execute them
 			// Execute Commands
+		Commands:
 			for _, cmd := range cmds {
+				if tookOver, note := checkTakeover(page, pauseTimeout); tookOver {
+					history.add(fmt.Sprintf("User took over the browser and handed back control. Note: %s", note))
+					break Commands
+				}
+
 				if cmd.Action == "finish" {
 					result.Success = true
-					result.Data = fmt.Sprintf("Finished: %s\n\nHistory:\n%s", cmd.Result, strings.Join(history, "\n"))
-					result.Image = encodedImage
+					result.Data = fmt.Sprintf("Finished: %s\n\nHistory:\n%s", cmd.Result, history.String())
+					result.attachScreenshot(shot)
 					goto EndLoop
 				}
 
+				if cmd.Action == "ask_user" {
+					fmt.Fprintf(stdout, "⏸️ Asking user: %s\n", cmd.Question)
+					reply, ok := askOperator(page, promptQuestion, cmd.Question, pauseTimeout)
+					switch {
+					case !ok:
+						history.add(fmt.Sprintf("Error: No answer from user to %q.", cmd.Question))
+					case reply.Decision == decisionAnswer:
+						history.add(fmt.Sprintf("User answered %q: %s", cmd.Question, reply.Value))
+					case reply.Decision == decisionTakeOver:
+						history.add(fmt.Sprintf("User took over the browser instead of answering %q. Note: %s", cmd.Question, reply.Value))
+					default:
+						history.add(fmt.Sprintf("User declined to answer %q.", cmd.Question))
+					}
+					// The rest of the batch was planned without the answer
+					break Commands
+				}
+
 				// Map ID
 				selectorInterface, exists := selectorMapRaw[fmt.Sprintf("%d", cmd.ID)]
 				if !exists && cmd.Action != "press" {
-					history = append(history, fmt.Sprintf("Error: ID %d not found.", cmd.ID))
+					history.add(fmt.Sprintf("Error: ID %d not found.", cmd.ID))
+					stuck = newJobError(jobs.ErrSelectorNotFound, fmt.Sprintf("element ID %d not found on the page", cmd.ID), map[string]string{"id": fmt.Sprint(cmd.ID)})
 					continue
 				}
 				selector := ""
@@ -298,11 +460,35 @@ Response:`, userRequest, historyStr, elementList, pageText)
 					selector = selectorInterface.(string)
 				}
 
+				idKey := fmt.Sprintf("%d", cmd.ID)
+				description, _ := descriptionsRaw[idKey].(string)
+				description = redaction.secrets.Replace(description)
+				submits, _ := submitsRaw[idKey].(bool)
+				if reason := approvals.reason(cmd.Action, cmd.Key, description, submits); reason != "" {
+					fmt.Fprintf(stdout, "⏸️ Waiting for approval to %s ID %d: %s\n", cmd.Action, cmd.ID, reason)
+					message := fmt.Sprintf("Approve %s on %s? (%s)", cmd.Action, description, reason)
+					if cmd.Action == "press" {
+						message = fmt.Sprintf("Approve pressing %s? (%s)", cmd.Key, reason)
+					}
+					reply, ok := askOperator(page, promptApproval, message, pauseTimeout)
+					switch {
+					case ok && reply.Decision == decisionApprove:
+						history.add(fmt.Sprintf("User approved %s ID %d.", cmd.Action, cmd.ID))
+					case ok && reply.Decision == decisionTakeOver:
+						history.add(fmt.Sprintf("User took over instead of %s ID %d. Note: %s", cmd.Action, cmd.ID, reply.Value))
+						break Commands
+					default:
+						history.add(fmt.Sprintf("Blocked: user did not approve %s ID %d. Do not retry it.", cmd.Action, cmd.ID))
+						stuck = newJobError(jobs.ErrBlockedByPolicy, fmt.Sprintf("%s was not approved: %s", cmd.Action, reason), map[string]string{"action": cmd.Action})
+						continue
+					}
+				}
+
 				// Execute
 				var execErr error
 				switch cmd.Action {
 				case "fill":
-					execErr = page.Fill(selector, cmd.Value)
+					execErr = page.Fill(selector, resolveSecrets(cmd.Value, payload.Secrets))
 				case "click":
 					execErr = page.Click(selector)
 				case "press":
@@ -312,19 +498,15 @@ Response:`, userRequest, historyStr, elementList, pageText)
 				}
 
 				if execErr != nil {
-					history = append(history, fmt.Sprintf("Failed to %s ID %d: %v", cmd.Action, cmd.ID, execErr))
+					history.add(fmt.Sprintf("Failed to %s ID %d: %v", cmd.Action, cmd.ID, execErr))
+					stuck = actionError(cmd.Action, selector, execErr)
 				} else {
-					history = append(history, fmt.Sprintf("Success: %s ID %d (%s)", cmd.Action, cmd.ID, selector))
+					stuck = nil
+					history.add(fmt.Sprintf("Success: %s ID %d (%s)", cmd.Action, cmd.ID, selector))
 
 					// LIVE STREAMING: Take a screenshot immediately after the action
 					// This makes the UI feel responsive, like a video stream
-					if interimShot, err := page.Screenshot(playwright.PageScreenshotOptions{Type: playwright.ScreenshotTypeJpeg, Quality: playwright.Int(50)}); err == nil {
-						interimBase64 := base64.StdEncoding.EncodeToString(interimShot)
-						updatePayload := map[string]string{"image": interimBase64}
-						if updateJSON, err := json.Marshal(updatePayload); err == nil {
-							fmt.Printf("\nJOB_UPDATE:%s\n", updateJSON)
-						}
-					}
+					emitFrame(page)
 
 					page.WaitForTimeout(1000) // Slight pause for visual clarity and page reaction
 				}
@@ -336,34 +518,37 @@ Response:`, userRequest, historyStr, elementList, pageText)
 				strings.Contains(strings.ToLower(page.URL()), "success") {
 				// We could force finish here, but better to let the AI decide in the next think step
 				// just adding a history hint
-				history = append(history, "System Note: URL contains 'dashboard' or 'success'. Task might be complete.")
+				history.add("System Note: URL contains 'dashboard' or 'success'. Task might be complete.")
 			}
 
 			// Wait after the batch is done
 			page.WaitForTimeout(1000)
 		} // End of maxIterations loop
 	EndLoop:
-		if !result.Success {
-			finalScreenshot, _ := page.Screenshot(playwright.PageScreenshotOptions{Type: playwright.ScreenshotTypeJpeg})
-			result.Image = base64.StdEncoding.EncodeToString(finalScreenshot)
-			result.Success = true
-			result.Data = fmt.Sprintf("Stopped after %d steps.\n\nHistory:\n%s", maxIterations, strings.Join(history, "\n"))
+		if !result.Success && result.Error == nil {
+			if finalScreenshot, err := screenshot(page, 0); err == nil {
+				result.attachScreenshot(finalScreenshot)
+			}
+			result.Success = stuck == nil
+			result.Error = stuck
+			result.Data = fmt.Sprintf("Stopped after %d steps.\n\nHistory:\n%s", maxIterations, history.String())
+		}
+		if payload.RecordVideo {
+			result.Timeline = history.timeline
 		}
 
 	case "describe":
 		if _, err = page.Goto(payload.URL); err != nil {
-			result.Error = fmt.Sprintf("could not goto: %v", err)
+			result.Error = navigationError(payload.URL, err)
 		} else {
 			// Fix for white screenshots: Wait for the page to actually load
 			page.WaitForLoadState(playwright.PageWaitForLoadStateOptions{State: playwright.LoadStateNetworkidle})
 			page.WaitForTimeout(2000)
 
 			// Take a screenshot
-			screenshot, err := page.Screenshot(playwright.PageScreenshotOptions{
-				Type: playwright.ScreenshotTypeJpeg,
-			})
+			shot, err := screenshot(page, 0)
 			if err != nil {
-				result.Error = fmt.Sprintf("could not take screenshot: %v", err)
+				result.Error = pageError("could not take screenshot", err)
 				break
 			}
 
@@ -384,7 +569,7 @@ Response:`, userRequest, historyStr, elementList, pageText)
 				return clone.innerText.replace(/\s+/g, ' ').trim();
 			}`)
 			if err != nil {
-				result.Error = fmt.Sprintf("could not clean page content: %v", err)
+				result.Error = pageError("could not clean page content", err)
 				break
 			}
 
@@ -401,7 +586,7 @@ Response:`, userRequest, historyStr, elementList, pageText)
 			}
 
 			// Prepare request to Ollama
-			encodedImage := base64.StdEncoding.EncodeToString(screenshot)
+			encodedImage := base64.StdEncoding.EncodeToString(shot)
 
 			userInstruction := payload.Target
 			if userInstruction == "" {
@@ -437,35 +622,89 @@ Response:`, textStr, userInstruction)
 
 			resp, err := http.Post(ollamaEndpoint+"/api/generate", "application/json", bytes.NewBuffer(reqBody))
 			if err != nil {
-				result.Error = fmt.Sprintf("could not contact ollama: %v", err)
+				result.Error = newJobError(jobs.ErrLLMUnavailable, fmt.Sprintf("could not contact ollama: %v", err), nil)
 				break
 			}
 			defer resp.Body.Close()
 
 			if resp.StatusCode != http.StatusOK {
 				body, _ := io.ReadAll(resp.Body)
-				result.Error = fmt.Sprintf("ollama returned status %d: %s", resp.StatusCode, string(body))
+				result.Error = ollamaStatusError(resp.StatusCode, string(body))
 				break
 			}
 
 			var ollamaResp map[string]interface{}
 			if err := json.NewDecoder(resp.Body).Decode(&ollamaResp); err != nil {
-				result.Error = fmt.Sprintf("could not decode ollama response: %v", err)
+				result.Error = newJobError(jobs.ErrLLMBadOutput, fmt.Sprintf("could not decode ollama response: %v", err), nil)
 				break
 			}
+			result.countTokens(ollamaResp)
 
 			if responseText, ok := ollamaResp["response"].(string); ok {
 				result.Success = true
 				result.Data = responseText
-				result.Image = encodedImage
+				result.attachScreenshot(shot)
 			} else {
-				result.Error = "ollama response missing 'response' field"
+				result.Error = newJobError(jobs.ErrLLMBadOutput, "ollama response missing 'response' field", nil)
 			}
 		}
 	default:
-		result.Error = fmt.Sprintf("unknown action: %s", payload.Action)
+		result.Error = newJobError(jobs.ErrInvalidRequest, fmt.Sprintf("unknown action: %s", payload.Action), nil)
+	}
+
+	if result.Success && len(payload.Extract) > 0 {
+		result.Fields = extractFields(page, payload.Extract)
+	}
+	// Describe and agent actions already end with a screenshot
+	if result.Success && payload.Screenshot && result.Screenshot == "" {
+		if shot, err := screenshot(page, 0); err != nil {
+			fmt.Fprintf(stdout, "Could not take screenshot: %v\n", err)
+		} else {
+			result.attachScreenshot(shot)
+		}
+	}
+
+	if payload.SavePDF {
+		// Only Chromium can print to PDF, and only headless
+		if pdf, err := page.PDF(); err != nil {
+			fmt.Fprintf(stdout, "Could not save PDF: %v\n", err)
+		} else if err := uploadArtifact("page.pdf", bytes.NewReader(pdf)); err != nil {
+			fmt.Fprintf(stdout, "Could not upload PDF: %v\n", err)
+		}
+	}
+
+	if payload.Profile != nil && payload.Profile.Save {
+		if state, err := captureProfile(browserContext, page, savedProfile); err != nil {
+			fmt.Fprintf(stdout, "Could not save profile %q: %v\n", payload.Profile.Name, err)
+		} else {
+			emitEvent("JOB_PROFILE", map[string]any{"name": payload.Profile.Name, "state": state})
+		}
+	}
+
+	if network != nil {
+		result.FailedRequests = network.finish()
+	}
+
+	// HAR files and videos are only written out when the context closes
+	artifactsWG.Wait()
+	if err := browserContext.Close(); err != nil {
+		fmt.Fprintf(stdout, "Could not close browser context: %v\n", err)
+	}
+	if payload.Network != nil && payload.Network.HAR {
+		if err := uploadArtifactFile("network.har", harPath); err != nil {
+			fmt.Fprintf(stdout, "Could not upload HAR: %v\n", err)
+		}
+	}
+	if payload.RecordVideo {
+		if name, err := uploadVideo(page); err != nil {
+			fmt.Fprintf(stdout, "Could not upload video: %v\n", err)
+		} else {
+			result.Video = name
+		}
 	}
 
-	output, _ := json.Marshal(result)
-	fmt.Printf("\nJOB_RESULT:%s\n", string(output))
+	result.Artifacts = uploadedArtifacts()
+	result.Console = consoleSummary()
+	redaction.result(&result)
+	emitEvent("JOB_RESULT", result)
 }
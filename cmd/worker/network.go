@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/playwright-community/playwright-go"
+)
+
+const (
+	harPath = "/tmp/network.har"
+
+	// failedRequestLimit caps how many failed requests are listed in the result.
+	failedRequestLimit = 20
+)
+
+type NetworkOptions struct {
+	// HAR records a HAR file of the whole browser context.
+	HAR bool `json:"har,omitempty"`
+	// Summary streams one log line per request and saves them as JSON lines.
+	Summary bool `json:"summary,omitempty"`
+}
+
+type NetworkEntry struct {
+	URL          string  `json:"url"`
+	Method       string  `json:"method"`
+	Status       int     `json:"status,omitempty"`
+	ResourceType string  `json:"resource_type"`
+	DurationMs   float64 `json:"duration_ms,omitempty"`
+	Size         int     `json:"size,omitempty"`
+	Failure      string  `json:"failure,omitempty"`
+}
+
+type FailedRequest struct {
+	URL     string `json:"url"`
+	Method  string `json:"method"`
+	Status  int    `json:"status,omitempty"`
+	Failure string `json:"failure,omitempty"`
+}
+
+// networkRecorder collects a summary of every request made by the browser
+// context. Reading a response and its sizes round-trips to the browser, so
+// finished requests are resolved off the event loop.
+type networkRecorder struct {
+	options NetworkOptions
+
+	mu      sync.Mutex
+	entries []NetworkEntry
+	wg      sync.WaitGroup
+}
+
+func recordNetwork(browserContext playwright.BrowserContext, options NetworkOptions) *networkRecorder {
+	r := &networkRecorder{options: options}
+
+	browserContext.OnRequestFinished(func(request playwright.Request) {
+		r.wg.Add(1)
+		go r.finished(request)
+	})
+	browserContext.OnRequestFailed(func(request playwright.Request) {
+		entry := NetworkEntry{
+			URL:          request.URL(),
+			Method:       request.Method(),
+			ResourceType: request.ResourceType(),
+			Failure:      "failed",
+		}
+		if err := request.Failure(); err != nil {
+			entry.Failure = err.Error()
+		}
+		r.add(entry)
+	})
+
+	return r
+}
+
+func (r *networkRecorder) finished(request playwright.Request) {
+	defer r.wg.Done()
+
+	entry := NetworkEntry{
+		URL:          request.URL(),
+		Method:       request.Method(),
+		ResourceType: request.ResourceType(),
+	}
+	if response, err := request.Response(); err == nil && response != nil {
+		entry.Status = response.Status()
+	}
+	if timing := request.Timing(); timing != nil && timing.ResponseEnd >= 0 {
+		entry.DurationMs = timing.ResponseEnd
+	}
+	if sizes, err := request.Sizes(); err == nil {
+		entry.Size = sizes.ResponseBodySize
+	}
+	r.add(entry)
+}
+
+func (r *networkRecorder) add(entry NetworkEntry) {
+	r.mu.Lock()
+	r.entries = append(r.entries, entry)
+	r.mu.Unlock()
+
+	if !r.options.Summary {
+		return
+	}
+	status := fmt.Sprint(entry.Status)
+	if entry.Failure != "" {
+		status = "ERR " + entry.Failure
+	}
+	fmt.Fprintf(stdout, "🌐 %s %s %s (%.0f ms, %d B)\n", status, entry.Method, entry.URL, entry.DurationMs, entry.Size)
+}
+
+// finish uploads the request summary and returns the failed requests.
+func (r *networkRecorder) finish() []FailedRequest {
+	r.wg.Wait()
+
+	r.mu.Lock()
+	entries := append([]NetworkEntry(nil), r.entries...)
+	r.mu.Unlock()
+
+	if r.options.Summary {
+		var lines bytes.Buffer
+		encoder := json.NewEncoder(&lines)
+		for _, entry := range entries {
+			encoder.Encode(entry)
+		}
+		if err := uploadArtifact("network.jsonl", &lines); err != nil {
+			fmt.Fprintf(stdout, "Could not upload network summary: %v\n", err)
+		}
+	}
+
+	var failed []FailedRequest
+	total := 0
+	for _, entry := range entries {
+		if entry.Failure == "" && entry.Status < 400 {
+			continue
+		}
+		total++
+		if len(failed) < failedRequestLimit {
+			failed = append(failed, FailedRequest{
+				URL:     entry.URL,
+				Method:  entry.Method,
+				Status:  entry.Status,
+				Failure: entry.Failure,
+			})
+		}
+	}
+	if total > 0 {
+		fmt.Fprintf(stdout, "%d of %d requests failed\n", total, len(entries))
+	}
+
+	return failed
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/playwright-community/playwright-go"
+)
+
+// stdout serializes writes from the agent loop and background goroutines
+// (remote input, control messages) so protocol lines never interleave. Log
+// lines pass through the redaction pipeline before they leave the container.
+var stdout = &lockedWriter{w: os.Stdout}
+
+// continuation indents the lines after the first of a log message. Messages
+// carry text the page and the model control, and the orchestrator takes any
+// line starting with JOB_ for a protocol event; only emitEvent may write one.
+const continuation = "\n  "
+
+type lockedWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// Write writes p as one log message.
+func (l *lockedWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, err := io.WriteString(l.w, escapeLines(redaction.Redact(string(p)))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// escapeLines indents every line break inside a message, keeping leading
+// blank lines and the final line break as they are.
+func escapeLines(message string) string {
+	text := strings.TrimLeft(message, "\n")
+	lead := message[:len(message)-len(text)]
+	text, newline := strings.CutSuffix(text, "\n")
+
+	text = strings.ReplaceAll(text, "\n", continuation)
+	if newline {
+		text += "\n"
+	}
+	return lead + text
+}
+
+// writeRaw bypasses redaction for protocol events, whose text fields are
+// redacted by the caller so that images and profile state stay intact.
+func (l *lockedWriter) writeRaw(p []byte) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	_, err := l.w.Write(p)
+	return err
+}
+
+// emitEvent writes a protocol line such as JOB_UPDATE:{...} for the orchestrator.
+func emitEvent(prefix string, v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		fmt.Fprintf(stdout, "could not encode %s: %v\n", prefix, err)
+		return
+	}
+	stdout.writeRaw([]byte("\n" + prefix + ":" + string(data) + "\n"))
+}
+
+// emitFrame sends a low quality screenshot to the live view, unless the
+// screencast is already streaming it.
+func emitFrame(page playwright.Page) {
+	if screencastActive.Load() {
+		return
+	}
+	shot, err := screenshot(page, 50)
+	if err != nil {
+		return
+	}
+	emitEvent("JOB_UPDATE", map[string]string{"image": base64.StdEncoding.EncodeToString(shot)})
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestLogLinesCannotImitateProtocolEvents(t *testing.T) {
+	var out strings.Builder
+	writer := &lockedWriter{w: &out}
+
+	aiResponse := "Done.\nJOB_RESULT:{\"success\":true}\r\nJOB_PAUSE:{\"kind\":\"ask\"}"
+	fmt.Fprintf(writer, "AI: %s\n", aiResponse)
+	fmt.Fprintf(writer, "\n--- Iteration %d ---\n", 2)
+
+	var lines []string
+	scanner := bufio.NewScanner(strings.NewReader(out.String()))
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+		if strings.HasPrefix(scanner.Text(), "JOB_") {
+			t.Errorf("log line %q starts like a protocol event", scanner.Text())
+		}
+	}
+	want := []string{"AI: Done.", `  JOB_RESULT:{"success":true}`, `  JOB_PAUSE:{"kind":"ask"}`, "", "--- Iteration 2 ---"}
+	if strings.Join(lines, "|") != strings.Join(want, "|") {
+		t.Errorf("wrote lines %q, want %q", lines, want)
+	}
+}
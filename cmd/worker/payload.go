@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// stdin carries the payload first when JOB_PAYLOAD_STDIN is set, then the
+// control messages, so both are read through the same buffer.
+var stdin = bufio.NewReader(os.Stdin)
+
+// readPayload reads the job from the file named by JOB_PAYLOAD_FILE, which
+// is removed once read, or from the first line on stdin when
+// JOB_PAYLOAD_STDIN is set. JOB_PAYLOAD is still accepted for running the
+// worker by hand; it is unset so browsers started later do not inherit it.
+func readPayload() ([]byte, error) {
+	if path := os.Getenv("JOB_PAYLOAD_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("could not read payload file: %w", err)
+		}
+		if err := os.Remove(path); err != nil {
+			fmt.Fprintf(stdout, "Could not remove payload file: %v\n", err)
+		}
+		return data, nil
+	}
+
+	if os.Getenv("JOB_PAYLOAD_STDIN") != "" {
+		line, err := stdin.ReadBytes('\n')
+		if err != nil && (err != io.EOF || len(line) == 0) {
+			return nil, fmt.Errorf("could not read payload from stdin: %w", err)
+		}
+		return line, nil
+	}
+
+	if payload := os.Getenv("JOB_PAYLOAD"); payload != "" {
+		os.Unsetenv("JOB_PAYLOAD")
+		return []byte(payload), nil
+	}
+	return nil, errors.New("no job payload: set JOB_PAYLOAD_FILE, JOB_PAYLOAD_STDIN or JOB_PAYLOAD")
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+
+	"github.com/playwright-community/playwright-go"
+)
+
+type ProfilePayload struct {
+	Name  string          `json:"name"`
+	State json.RawMessage `json:"state,omitempty"`
+	Save  bool            `json:"save,omitempty"`
+}
+
+// profileState is Playwright's storageState format. playwright-go does not
+// expose IndexedDB in storage state, so the worker dumps and restores it
+// itself and keeps it alongside localStorage for each origin.
+type profileState struct {
+	Cookies []playwright.OptionalCookie `json:"cookies"`
+	Origins []profileOrigin             `json:"origins"`
+}
+
+type profileOrigin struct {
+	Origin       string                 `json:"origin"`
+	LocalStorage []playwright.NameValue `json:"localStorage"`
+	IndexedDB    []any                  `json:"indexedDB,omitempty"`
+}
+
+const dumpIndexedDBScript = `async () => {
+	if (!indexedDB.databases) return [];
+	const request = (req) => new Promise((resolve, reject) => {
+		req.onsuccess = () => resolve(req.result);
+		req.onerror = () => reject(req.error);
+	});
+
+	const result = [];
+	for (const info of await indexedDB.databases()) {
+		const db = await request(indexedDB.open(info.name));
+		const stores = [];
+		for (const storeName of Array.from(db.objectStoreNames)) {
+			const store = db.transaction(storeName, 'readonly').objectStore(storeName);
+			const [keys, values] = await Promise.all([request(store.getAllKeys()), request(store.getAll())]);
+			stores.push({
+				name: storeName,
+				keyPath: store.keyPath,
+				autoIncrement: store.autoIncrement,
+				indexes: Array.from(store.indexNames).map(n => {
+					const index = store.index(n);
+					return { name: n, keyPath: index.keyPath, unique: index.unique, multiEntry: index.multiEntry };
+				}),
+				records: keys.map((key, i) => ({ key, value: values[i] })),
+			});
+		}
+		result.push({ name: info.name, version: db.version, stores });
+		db.close();
+	}
+	return JSON.parse(JSON.stringify(result));
+}`
+
+// restoreIndexedDBScript recreates saved databases the first time a page of
+// the matching origin loads. Existing databases are left untouched.
+const restoreIndexedDBScript = `(() => {
+	const saved = %s;
+	const databases = saved[location.origin];
+	if (!databases) return;
+	for (const db of databases) {
+		const req = indexedDB.open(db.name, db.version);
+		req.onupgradeneeded = () => {
+			const conn = req.result;
+			for (const s of db.stores) {
+				if (conn.objectStoreNames.contains(s.name)) continue;
+				const options = { autoIncrement: s.autoIncrement };
+				if (s.keyPath !== null) options.keyPath = s.keyPath;
+				const store = conn.createObjectStore(s.name, options);
+				for (const i of s.indexes) store.createIndex(i.name, i.keyPath, { unique: i.unique, multiEntry: i.multiEntry });
+				for (const r of s.records) {
+					if (s.keyPath !== null) store.put(r.value);
+					else store.put(r.value, r.key);
+				}
+			}
+		};
+		req.onsuccess = () => req.result.close();
+	}
+})();`
+
+func parseProfileState(raw json.RawMessage) (*profileState, error) {
+	var state profileState
+	if err := json.Unmarshal(raw, &state); err != nil {
+		return nil, fmt.Errorf("invalid profile state: %w", err)
+	}
+	return &state, nil
+}
+
+func (s *profileState) storageState() *playwright.OptionalStorageState {
+	origins := make([]playwright.Origin, 0, len(s.Origins))
+	for _, o := range s.Origins {
+		localStorage := o.LocalStorage
+		if localStorage == nil {
+			localStorage = []playwright.NameValue{}
+		}
+		origins = append(origins, playwright.Origin{Origin: o.Origin, LocalStorage: localStorage})
+	}
+	return &playwright.OptionalStorageState{
+		Cookies: s.Cookies,
+		Origins: origins,
+	}
+}
+
+func restoreIndexedDB(browserContext playwright.BrowserContext, s *profileState) error {
+	byOrigin := map[string][]any{}
+	for _, o := range s.Origins {
+		if len(o.IndexedDB) > 0 {
+			byOrigin[o.Origin] = o.IndexedDB
+		}
+	}
+	if len(byOrigin) == 0 {
+		return nil
+	}
+
+	data, err := json.Marshal(byOrigin)
+	if err != nil {
+		return err
+	}
+	script := fmt.Sprintf(restoreIndexedDBScript, data)
+	return browserContext.AddInitScript(playwright.Script{Content: &script})
+}
+
+// captureProfile reads the context's cookies and localStorage plus the
+// IndexedDB databases of the page's current origin. IndexedDB saved for other
+// origins is carried over from the loaded profile.
+func captureProfile(browserContext playwright.BrowserContext, page playwright.Page, previous *profileState) (*profileState, error) {
+	storage, err := browserContext.StorageState()
+	if err != nil {
+		return nil, fmt.Errorf("could not read storage state: %w", err)
+	}
+
+	state := &profileState{}
+	cookies, _ := json.Marshal(storage.Cookies)
+	if err := json.Unmarshal(cookies, &state.Cookies); err != nil {
+		return nil, err
+	}
+
+	origins := map[string]int{}
+	originIndex := func(origin string) int {
+		if i, ok := origins[origin]; ok {
+			return i
+		}
+		state.Origins = append(state.Origins, profileOrigin{Origin: origin})
+		origins[origin] = len(state.Origins) - 1
+		return origins[origin]
+	}
+	for _, o := range storage.Origins {
+		state.Origins[originIndex(o.Origin)].LocalStorage = o.LocalStorage
+	}
+	if previous != nil {
+		for _, o := range previous.Origins {
+			if len(o.IndexedDB) > 0 {
+				state.Origins[originIndex(o.Origin)].IndexedDB = o.IndexedDB
+			}
+		}
+	}
+
+	if u, err := url.Parse(page.URL()); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+		dump, err := page.Evaluate(dumpIndexedDBScript)
+		if err != nil {
+			fmt.Fprintf(stdout, "Could not read IndexedDB: %v\n", err)
+		} else if databases, ok := dump.([]any); ok && len(databases) > 0 {
+			state.Origins[originIndex(u.Scheme+"://"+u.Host)].IndexedDB = databases
+		}
+	}
+
+	return state, nil
+}
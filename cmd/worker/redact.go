@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"brian-nunez/bcode/internal/secrets"
+	"github.com/playwright-community/playwright-go"
+)
+
+const redactedText = "[REDACTED]"
+
+type RedactionPolicy struct {
+	Patterns      []string `json:"patterns,omitempty"`
+	InputTypes    []string `json:"input_types,omitempty"`
+	MaskPasswords bool     `json:"mask_passwords,omitempty"`
+	MaskSelectors []string `json:"mask_selectors,omitempty"`
+}
+
+// redactor is the worker's redaction pipeline: known secret values become
+// their placeholders, then configured patterns are blanked out. It runs over
+// every log line and over the text fields of protocol events; screenshots
+// are masked separately when they are taken.
+type redactor struct {
+	secrets    *strings.Replacer
+	patterns   []*regexp.Regexp
+	inputTypes []string
+	masks      []string
+}
+
+// redaction is configured once from the payload, before any goroutine that
+// writes output is started.
+var redaction = &redactor{
+	secrets:    strings.NewReplacer(),
+	inputTypes: []string{},
+}
+
+func (r *redactor) configure(values map[string]string, policy *RedactionPolicy) {
+	r.secrets = secrets.Redactor(values)
+	if policy == nil {
+		return
+	}
+
+	for _, p := range policy.Patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			fmt.Fprintf(stdout, "Ignoring invalid redaction pattern: %v\n", err)
+			continue
+		}
+		r.patterns = append(r.patterns, re)
+	}
+	for _, t := range policy.InputTypes {
+		r.inputTypes = append(r.inputTypes, strings.ToLower(t))
+	}
+	if policy.MaskPasswords {
+		r.masks = append(r.masks, `input[type="password"]`)
+	}
+	r.masks = append(r.masks, policy.MaskSelectors...)
+}
+
+func (r *redactor) Redact(s string) string {
+	s = r.secrets.Replace(s)
+	for _, re := range r.patterns {
+		s = re.ReplaceAllString(s, redactedText)
+	}
+	return s
+}
+
+func (r *redactor) result(result *JobResult) {
+	result.Data = r.Redact(result.Data)
+	for name, value := range result.Fields {
+		result.Fields[name] = r.Redact(value)
+	}
+	if result.Error != nil {
+		result.Error.Message = r.Redact(result.Error.Message)
+		for key, value := range result.Error.Details {
+			result.Error.Details[key] = r.Redact(value)
+		}
+	}
+	for i := range result.Timeline {
+		result.Timeline[i].Step = r.Redact(result.Timeline[i].Step)
+	}
+	for i := range result.FailedRequests {
+		result.FailedRequests[i].URL = r.Redact(result.FailedRequests[i].URL)
+	}
+}
+
+// screenshot takes a JPEG of the page with password fields and configured
+// selectors blacked out, so no frame leaves the container unmasked.
+func screenshot(page playwright.Page, quality int) ([]byte, error) {
+	options := playwright.PageScreenshotOptions{Type: playwright.ScreenshotTypeJpeg}
+	if quality > 0 {
+		options.Quality = playwright.Int(quality)
+	}
+	for _, selector := range redaction.masks {
+		options.Mask = append(options.Mask, page.Locator(selector))
+	}
+	if len(options.Mask) > 0 {
+		options.MaskColor = playwright.String("#000000")
+	}
+	return page.Screenshot(options)
+}
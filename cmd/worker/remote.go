@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/playwright-community/playwright-go"
+)
+
+// InputEvent is a mouse or keyboard event forwarded from the operator's live
+// view. Coordinates are in page pixels.
+type InputEvent struct {
+	Kind   string  `json:"kind"`
+	X      float64 `json:"x,omitempty"`
+	Y      float64 `json:"y,omitempty"`
+	Button string  `json:"button,omitempty"`
+	Key    string  `json:"key,omitempty"`
+	Text   string  `json:"text,omitempty"`
+	DeltaX float64 `json:"delta_x,omitempty"`
+	DeltaY float64 `json:"delta_y,omitempty"`
+}
+
+var (
+	inputEvents = make(chan InputEvent, 64)
+
+	// takeoverRequested is set when the operator grabs control from the live
+	// view; the agent loop pauses at its next step until control is handed back.
+	takeoverRequested atomic.Bool
+)
+
+func queueInput(ev InputEvent) {
+	select {
+	case inputEvents <- ev:
+	default:
+		fmt.Fprintf(stdout, "Dropping remote %s input, browser is busy.\n", ev.Kind)
+	}
+}
+
+// handleRemoteInput applies operator input to the page as it arrives and
+// streams a fresh frame after each event.
+func handleRemoteInput(page playwright.Page) {
+	go func() {
+		for ev := range inputEvents {
+			if err := applyInput(page, ev); err != nil {
+				fmt.Fprintf(stdout, "Remote %s failed: %v\n", ev.Kind, err)
+				continue
+			}
+			emitFrame(page)
+		}
+	}()
+}
+
+func applyInput(page playwright.Page, ev InputEvent) error {
+	switch ev.Kind {
+	case "click":
+		button := playwright.MouseButtonLeft
+		switch ev.Button {
+		case "right":
+			button = playwright.MouseButtonRight
+		case "middle":
+			button = playwright.MouseButtonMiddle
+		}
+		return page.Mouse().Click(ev.X, ev.Y, playwright.MouseClickOptions{Button: button})
+	case "move":
+		return page.Mouse().Move(ev.X, ev.Y)
+	case "wheel":
+		return page.Mouse().Wheel(ev.DeltaX, ev.DeltaY)
+	case "key":
+		return page.Keyboard().Press(ev.Key)
+	case "text":
+		return page.Keyboard().Type(ev.Text)
+	}
+	return fmt.Errorf("unknown input kind: %s", ev.Kind)
+}
+
+// checkTakeover pauses the agent while the operator has control of the
+// browser. It reports whether a take-over happened and the operator's note.
+func checkTakeover(page playwright.Page, timeout time.Duration) (bool, string) {
+	if !takeoverRequested.Swap(false) {
+		return false, ""
+	}
+
+	fmt.Fprintln(stdout, "👤 Operator took over the browser.")
+	defer emitEvent("JOB_RESUME", struct{}{})
+
+	reply, ok := waitForOperator(page, promptTakeover, "Operator has control. Resume to hand back to the agent.", timeout)
+	if !ok {
+		return true, ""
+	}
+	fmt.Fprintln(stdout, "🤖 Control handed back to the agent.")
+
+	return true, reply.Value
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/playwright-community/playwright-go"
+)
+
+const (
+	defaultScreencastFPS     = 10
+	defaultScreencastQuality = 60
+)
+
+type ScreencastOptions struct {
+	FPS     int `json:"fps,omitempty"`
+	Quality int `json:"quality,omitempty"`
+}
+
+// screencastActive replaces the per-action frames with the continuous stream.
+var screencastActive atomic.Bool
+
+// startScreencast streams frames with CDP Page.startScreencast. Frames above
+// the requested rate are skipped, and a frame that cannot be written before
+// the next one arrives is dropped, so a slow consumer never blocks the page.
+func startScreencast(browserContext playwright.BrowserContext, page playwright.Page, options ScreencastOptions) error {
+	fps := clamp(options.FPS, 1, 30, defaultScreencastFPS)
+	quality := clamp(options.Quality, 10, 100, defaultScreencastQuality)
+
+	session, err := browserContext.NewCDPSession(page)
+	if err != nil {
+		return fmt.Errorf("could not open CDP session: %w", err)
+	}
+
+	frames := make(chan string, 1)
+	go func() {
+		for frame := range frames {
+			stdout.writeRaw([]byte("\nJOB_FRAME:" + frame + "\n"))
+		}
+	}()
+
+	interval := time.Second / time.Duration(fps)
+	var last time.Time
+	session.On("Page.screencastFrame", func(params map[string]interface{}) {
+		// The browser stops sending frames until each one is acknowledged
+		go session.Send("Page.screencastFrameAck", map[string]interface{}{"sessionId": params["sessionId"]})
+
+		if time.Since(last) < interval {
+			return
+		}
+		last = time.Now()
+
+		data, _ := params["data"].(string)
+		select {
+		case frames <- data:
+		default:
+			// Replace the stale pending frame with the newest one
+			select {
+			case <-frames:
+			default:
+			}
+			select {
+			case frames <- data:
+			default:
+			}
+		}
+	})
+
+	params := map[string]interface{}{
+		"format":  "jpeg",
+		"quality": quality,
+	}
+	// Frames keep the viewport's size so live view clicks map 1:1 to the page
+	if size := page.ViewportSize(); size != nil {
+		params["maxWidth"] = size.Width
+		params["maxHeight"] = size.Height
+	}
+	if _, err := session.Send("Page.startScreencast", params); err != nil {
+		return fmt.Errorf("could not start screencast: %w", err)
+	}
+
+	screencastActive.Store(true)
+	fmt.Fprintf(stdout, "Screencast started (%d fps, quality %d)\n", fps, quality)
+	return nil
+}
+
+// maskScreencast hides masked elements with a stylesheet, since screencast
+// frames come straight from the compositor and cannot be masked like
+// screenshots.
+func maskScreencast(browserContext playwright.BrowserContext) error {
+	if len(redaction.masks) == 0 {
+		return nil
+	}
+
+	css, err := json.Marshal(strings.Join(redaction.masks, ", ") + " { filter: brightness(0) !important; }")
+	if err != nil {
+		return err
+	}
+	script := fmt.Sprintf(`(() => {
+		const style = document.createElement('style');
+		style.textContent = %s;
+		document.addEventListener('DOMContentLoaded', () => document.head.appendChild(style));
+	})();`, css)
+	return browserContext.AddInitScript(playwright.Script{Content: &script})
+}
+
+func clamp(value, min, max, fallback int) int {
+	if value == 0 {
+		return fallback
+	}
+	if value < min {
+		return min
+	}
+	if value > max {
+		return max
+	}
+	return value
+}
@@ -0,0 +1,16 @@
+package main
+
+import "brian-nunez/bcode/internal/secrets"
+
+// resolveSecrets swaps {{secret:name}} placeholders for their values. It is
+// only used for the value handed to page.Fill and for proxy credentials;
+// everything the model, the logs or the result see keeps the placeholder.
+func resolveSecrets(text string, values map[string]string) string {
+	return secrets.Placeholder.ReplaceAllStringFunc(text, func(match string) string {
+		name := secrets.Placeholder.FindStringSubmatch(match)[1]
+		if value, ok := values[name]; ok {
+			return value
+		}
+		return match
+	})
+}
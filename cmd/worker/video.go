@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/playwright-community/playwright-go"
+)
+
+const (
+	videoDir  = "/tmp/videos"
+	videoName = "session.webm"
+)
+
+func recordVideoOptions() *playwright.RecordVideo {
+	return &playwright.RecordVideo{
+		Dir: videoDir,
+		Size: &playwright.Size{
+			Width:  1280,
+			Height: 720,
+		},
+	}
+}
+
+// uploadVideo sends the page's recording. The file is only complete once the
+// browser context has been closed.
+func uploadVideo(page playwright.Page) (string, error) {
+	video := page.Video()
+	if video == nil {
+		return "", fmt.Errorf("page was not recorded")
+	}
+	path, err := video.Path()
+	if err != nil {
+		return "", err
+	}
+	if err := uploadArtifactFile(videoName, path); err != nil {
+		return "", err
+	}
+	return videoName, nil
+}
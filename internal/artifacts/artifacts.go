@@ -0,0 +1,162 @@
+package artifacts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"brian-nunez/bcode/internal/store"
+	"brian-nunez/bcode/internal/workspaces"
+)
+
+// MaxSize caps a single artifact uploaded by a worker.
+const MaxSize = 100 << 20
+
+const defaultRetention = 7 * 24 * time.Hour
+
+// ownerName holds a job's workspace next to its artifacts. Artifact names
+// cannot start with a dot, so no worker can overwrite it.
+const ownerName = ".workspace"
+
+var (
+	ErrInvalidName = errors.New("artifact names may only contain letters, digits, '.', '-' and '_' and must have an extension")
+	ErrInvalidJob  = errors.New("invalid job id")
+	ErrNotFound    = errors.New("artifact not found")
+	ErrTooLarge    = fmt.Errorf("artifact exceeds %d bytes", MaxSize)
+)
+
+var (
+	validName  = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9._-]{0,127}$`)
+	validJobID = regexp.MustCompile(`^[0-9a-f]{16}$`)
+)
+
+// Artifact describes a file produced by a job: screenshots, PDFs, HAR files
+// and downloads. The content type is derived from the name so a worker can
+// never make the server serve arbitrary content as HTML.
+type Artifact struct {
+	Name        string    `json:"name"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Store keeps artifacts grouped by job, along with the workspace that owns
+// them, since they outlive the job in the registry.
+type Store interface {
+	// SetOwner records the workspace a job's artifacts belong to.
+	SetOwner(ctx context.Context, jobID, workspace string) error
+	// Owner returns the workspace recorded by SetOwner, or ErrNotFound.
+	Owner(ctx context.Context, jobID string) (string, error)
+	Put(ctx context.Context, jobID, name string, r io.Reader, size int64) (Artifact, error)
+	Open(ctx context.Context, jobID, name string) (io.ReadCloser, Artifact, error)
+	List(ctx context.Context, jobID string) ([]Artifact, error)
+	// DeleteBefore removes every artifact created before the cutoff and
+	// returns how many were removed.
+	DeleteBefore(ctx context.Context, cutoff time.Time) (int, error)
+}
+
+var (
+	defaultStore Store
+	defaultErr   error
+	defaultOnce  sync.Once
+)
+
+// Default returns the store selected by ARTIFACT_STORE: "local" (the
+// default, under the data directory) or "s3".
+func Default() (Store, error) {
+	defaultOnce.Do(func() {
+		switch backend := os.Getenv("ARTIFACT_STORE"); backend {
+		case "", "local":
+			defaultStore, defaultErr = NewLocalStore(filepath.Join(store.DataDir(), "artifacts"))
+		case "s3":
+			defaultStore, defaultErr = NewS3StoreFromEnv()
+		default:
+			defaultErr = fmt.Errorf("unknown artifact store %q", backend)
+		}
+	})
+	return defaultStore, defaultErr
+}
+
+// Retention is how long artifacts are kept, from ARTIFACT_RETENTION (a Go
+// duration such as 72h). Zero disables cleanup.
+func Retention() time.Duration {
+	if value := os.Getenv("ARTIFACT_RETENTION"); value != "" {
+		if d, err := time.ParseDuration(value); err == nil {
+			return d
+		}
+		log.Printf("invalid ARTIFACT_RETENTION %q, using %s", value, defaultRetention)
+	}
+	return defaultRetention
+}
+
+// RunRetention deletes expired artifacts every interval until ctx is done.
+func RunRetention(ctx context.Context, s Store, retention, interval time.Duration) {
+	if retention <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		removed, err := s.DeleteBefore(ctx, time.Now().Add(-retention))
+		if err != nil {
+			log.Printf("artifact retention: %v", err)
+		} else if removed > 0 {
+			log.Printf("artifact retention: removed %d expired artifacts", removed)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func ValidateName(name string) error {
+	if !validName.MatchString(name) || path.Ext(name) == "" {
+		return ErrInvalidName
+	}
+	return nil
+}
+
+func validateOwner(jobID, workspace string) error {
+	if !validJobID.MatchString(jobID) {
+		return ErrInvalidJob
+	}
+	return workspaces.ValidateName(workspace)
+}
+
+func validate(jobID, name string) error {
+	if !validJobID.MatchString(jobID) {
+		return ErrInvalidJob
+	}
+	return ValidateName(name)
+}
+
+func ContentType(name string) string {
+	if ct := mime.TypeByExtension(strings.ToLower(path.Ext(name))); ct != "" {
+		return ct
+	}
+	return "application/octet-stream"
+}
+
+// Inline reports whether an artifact is safe to display in the browser
+// rather than download.
+func Inline(contentType string) bool {
+	return strings.HasPrefix(contentType, "image/") ||
+		strings.HasPrefix(contentType, "video/") ||
+		strings.HasPrefix(contentType, "application/pdf") ||
+		strings.HasPrefix(contentType, "application/json")
+}
@@ -0,0 +1,197 @@
+package artifacts
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"brian-nunez/bcode/internal/store"
+)
+
+// LocalStore keeps artifacts on disk as <dir>/<job id>/<name>, and the
+// owning workspace in <dir>/<job id>/.workspace.
+type LocalStore struct {
+	dir string
+}
+
+func NewLocalStore(dir string) (*LocalStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &LocalStore{dir: dir}, nil
+}
+
+func (s *LocalStore) path(jobID, name string) string {
+	return filepath.Join(s.dir, jobID, name)
+}
+
+func (s *LocalStore) SetOwner(ctx context.Context, jobID, workspace string) error {
+	if err := validateOwner(jobID, workspace); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Join(s.dir, jobID), 0o700); err != nil {
+		return err
+	}
+	return store.WriteFileAtomic(s.path(jobID, ownerName), []byte(workspace))
+}
+
+func (s *LocalStore) Owner(ctx context.Context, jobID string) (string, error) {
+	if !validJobID.MatchString(jobID) {
+		return "", ErrInvalidJob
+	}
+	data, err := os.ReadFile(s.path(jobID, ownerName))
+	if os.IsNotExist(err) {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func (s *LocalStore) Put(ctx context.Context, jobID, name string, r io.Reader, size int64) (Artifact, error) {
+	if err := validate(jobID, name); err != nil {
+		return Artifact{}, err
+	}
+	if err := os.MkdirAll(filepath.Join(s.dir, jobID), 0o700); err != nil {
+		return Artifact{}, err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Join(s.dir, jobID), name+".*.tmp")
+	if err != nil {
+		return Artifact{}, err
+	}
+	defer os.Remove(tmp.Name())
+
+	written, err := io.Copy(tmp, io.LimitReader(r, MaxSize+1))
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return Artifact{}, err
+	}
+	if written > MaxSize {
+		return Artifact{}, ErrTooLarge
+	}
+	if err := os.Rename(tmp.Name(), s.path(jobID, name)); err != nil {
+		return Artifact{}, err
+	}
+
+	return Artifact{
+		Name:        name,
+		ContentType: ContentType(name),
+		Size:        written,
+		CreatedAt:   time.Now(),
+	}, nil
+}
+
+func (s *LocalStore) Open(ctx context.Context, jobID, name string) (io.ReadCloser, Artifact, error) {
+	if err := validate(jobID, name); err != nil {
+		return nil, Artifact{}, err
+	}
+
+	f, err := os.Open(s.path(jobID, name))
+	if os.IsNotExist(err) {
+		return nil, Artifact{}, ErrNotFound
+	}
+	if err != nil {
+		return nil, Artifact{}, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, Artifact{}, err
+	}
+
+	return f, artifactFromInfo(info), nil
+}
+
+func (s *LocalStore) List(ctx context.Context, jobID string) ([]Artifact, error) {
+	if !validJobID.MatchString(jobID) {
+		return nil, ErrInvalidJob
+	}
+
+	entries, err := os.ReadDir(filepath.Join(s.dir, jobID))
+	if os.IsNotExist(err) {
+		return []Artifact{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	list := []Artifact{}
+	for _, entry := range entries {
+		if entry.IsDir() || ValidateName(entry.Name()) != nil || filepath.Ext(entry.Name()) == ".tmp" {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		list = append(list, artifactFromInfo(info))
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].CreatedAt.Before(list[j].CreatedAt)
+	})
+
+	return list, nil
+}
+
+func (s *LocalStore) DeleteBefore(ctx context.Context, cutoff time.Time) (int, error) {
+	jobs, err := os.ReadDir(s.dir)
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, job := range jobs {
+		if !job.IsDir() || !validJobID.MatchString(job.Name()) {
+			continue
+		}
+		dir := filepath.Join(s.dir, job.Name())
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+
+		// The owner goes with the job's last artifact
+		remaining := len(entries)
+		owned := false
+		for _, entry := range entries {
+			info, err := entry.Info()
+			if err != nil || !info.ModTime().Before(cutoff) {
+				continue
+			}
+			if entry.Name() == ownerName {
+				owned = true
+				continue
+			}
+			if os.Remove(filepath.Join(dir, entry.Name())) == nil {
+				removed++
+				remaining--
+			}
+		}
+		if owned && remaining == 1 {
+			os.Remove(filepath.Join(dir, ownerName))
+			remaining--
+		}
+		if remaining == 0 {
+			os.Remove(dir)
+		}
+	}
+
+	return removed, nil
+}
+
+func artifactFromInfo(info os.FileInfo) Artifact {
+	return Artifact{
+		Name:        info.Name(),
+		ContentType: ContentType(info.Name()),
+		Size:        info.Size(),
+		CreatedAt:   info.ModTime(),
+	}
+}
@@ -0,0 +1,81 @@
+package artifacts
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLocalStoreOwner(t *testing.T) {
+	store, err := NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	if _, err := store.Owner(ctx, testJobID); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Owner of a job without one returned %v, want ErrNotFound", err)
+	}
+	if err := store.SetOwner(ctx, testJobID, "acme"); err != nil {
+		t.Fatalf("SetOwner: %v", err)
+	}
+	if owner, err := store.Owner(ctx, testJobID); err != nil || owner != "acme" {
+		t.Errorf("Owner = %q, %v, want acme", owner, err)
+	}
+	if _, err := store.Put(ctx, testJobID, "page.png", strings.NewReader("png"), 3); err != nil {
+		t.Fatal(err)
+	}
+	list, err := store.List(ctx, testJobID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].Name != "page.png" {
+		t.Errorf("List returned %+v, want only page.png", list)
+	}
+}
+
+func TestLocalStoreDeleteBeforeKeepsOwner(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewLocalStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	cutoff := time.Now().Add(-24 * time.Hour)
+
+	age := func(name string, modified time.Time) {
+		t.Helper()
+		if err := os.Chtimes(store.path(testJobID, name), modified, modified); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.SetOwner(ctx, testJobID, "acme"); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"old.png", "new.png"} {
+		if _, err := store.Put(ctx, testJobID, name, strings.NewReader(name), int64(len(name))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	age(ownerName, cutoff.Add(-time.Hour))
+	age("old.png", cutoff.Add(-time.Minute))
+
+	if removed, err := store.DeleteBefore(ctx, cutoff); err != nil || removed != 1 {
+		t.Fatalf("DeleteBefore removed %d, %v, want 1", removed, err)
+	}
+	if owner, err := store.Owner(ctx, testJobID); err != nil || owner != "acme" {
+		t.Fatalf("the owner of a job with artifacts left was removed: %q, %v", owner, err)
+	}
+
+	age("new.png", cutoff.Add(-time.Minute))
+	if removed, err := store.DeleteBefore(ctx, cutoff); err != nil || removed != 1 {
+		t.Fatalf("DeleteBefore removed %d, %v, want 1", removed, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, testJobID)); !os.IsNotExist(err) {
+		t.Errorf("the job's directory is still there: %v", err)
+	}
+}
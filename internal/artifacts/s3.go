@@ -0,0 +1,420 @@
+package artifacts
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+// S3Store keeps artifacts in an S3-compatible bucket (AWS S3, MinIO, R2...)
+// as <prefix><job id>/<name>, with the owning workspace in
+// <prefix><job id>/.workspace. Requests use path-style addressing and are
+// signed with AWS Signature Version 4.
+type S3Store struct {
+	endpoint  *url.URL
+	bucket    string
+	prefix    string
+	region    string
+	accessKey string
+	secretKey string
+	client    *http.Client
+}
+
+type S3Config struct {
+	Endpoint  string
+	Bucket    string
+	Prefix    string
+	Region    string
+	AccessKey string
+	SecretKey string
+}
+
+// NewS3StoreFromEnv reads ARTIFACT_S3_ENDPOINT, ARTIFACT_S3_BUCKET,
+// ARTIFACT_S3_PREFIX, ARTIFACT_S3_REGION, ARTIFACT_S3_ACCESS_KEY and
+// ARTIFACT_S3_SECRET_KEY.
+func NewS3StoreFromEnv() (*S3Store, error) {
+	return NewS3Store(S3Config{
+		Endpoint:  os.Getenv("ARTIFACT_S3_ENDPOINT"),
+		Bucket:    os.Getenv("ARTIFACT_S3_BUCKET"),
+		Prefix:    os.Getenv("ARTIFACT_S3_PREFIX"),
+		Region:    os.Getenv("ARTIFACT_S3_REGION"),
+		AccessKey: os.Getenv("ARTIFACT_S3_ACCESS_KEY"),
+		SecretKey: os.Getenv("ARTIFACT_S3_SECRET_KEY"),
+	})
+}
+
+func NewS3Store(config S3Config) (*S3Store, error) {
+	if config.Endpoint == "" || config.Bucket == "" {
+		return nil, errors.New("s3 artifact store requires an endpoint and a bucket")
+	}
+	endpoint, err := url.Parse(strings.TrimSuffix(config.Endpoint, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid s3 endpoint: %w", err)
+	}
+	if config.Region == "" {
+		config.Region = "us-east-1"
+	}
+	if config.Prefix != "" && !strings.HasSuffix(config.Prefix, "/") {
+		config.Prefix += "/"
+	}
+
+	return &S3Store{
+		endpoint:  endpoint,
+		bucket:    config.Bucket,
+		prefix:    config.Prefix,
+		region:    config.Region,
+		accessKey: config.AccessKey,
+		secretKey: config.SecretKey,
+		client:    &http.Client{Timeout: 5 * time.Minute},
+	}, nil
+}
+
+func (s *S3Store) key(jobID, name string) string {
+	return s.prefix + jobID + "/" + name
+}
+
+func (s *S3Store) SetOwner(ctx context.Context, jobID, workspace string) error {
+	if err := validateOwner(jobID, workspace); err != nil {
+		return err
+	}
+	resp, err := s.do(ctx, http.MethodPut, s.key(jobID, ownerName), nil, strings.NewReader(workspace), int64(len(workspace)), "text/plain")
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *S3Store) Owner(ctx context.Context, jobID string) (string, error) {
+	if !validJobID.MatchString(jobID) {
+		return "", ErrInvalidJob
+	}
+	resp, err := s.do(ctx, http.MethodGet, s.key(jobID, ownerName), nil, nil, 0, "")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func (s *S3Store) Put(ctx context.Context, jobID, name string, r io.Reader, size int64) (Artifact, error) {
+	if err := validate(jobID, name); err != nil {
+		return Artifact{}, err
+	}
+	if size > MaxSize {
+		return Artifact{}, ErrTooLarge
+	}
+
+	contentType := ContentType(name)
+	resp, err := s.do(ctx, http.MethodPut, s.key(jobID, name), nil, r, size, contentType)
+	if err != nil {
+		return Artifact{}, err
+	}
+	resp.Body.Close()
+
+	return Artifact{
+		Name:        name,
+		ContentType: contentType,
+		Size:        size,
+		CreatedAt:   time.Now(),
+	}, nil
+}
+
+func (s *S3Store) Open(ctx context.Context, jobID, name string) (io.ReadCloser, Artifact, error) {
+	if err := validate(jobID, name); err != nil {
+		return nil, Artifact{}, err
+	}
+
+	resp, err := s.do(ctx, http.MethodGet, s.key(jobID, name), nil, nil, 0, "")
+	if err != nil {
+		return nil, Artifact{}, err
+	}
+
+	artifact := Artifact{
+		Name:        name,
+		ContentType: ContentType(name),
+		Size:        resp.ContentLength,
+	}
+	if modified, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		artifact.CreatedAt = modified
+	}
+
+	return resp.Body, artifact, nil
+}
+
+func (s *S3Store) List(ctx context.Context, jobID string) ([]Artifact, error) {
+	if !validJobID.MatchString(jobID) {
+		return nil, ErrInvalidJob
+	}
+
+	objects, err := s.list(ctx, s.prefix+jobID+"/")
+	if err != nil {
+		return nil, err
+	}
+
+	list := []Artifact{}
+	for _, object := range objects {
+		name := object.Key[strings.LastIndex(object.Key, "/")+1:]
+		if ValidateName(name) != nil {
+			continue
+		}
+		list = append(list, Artifact{
+			Name:        name,
+			ContentType: ContentType(name),
+			Size:        object.Size,
+			CreatedAt:   object.LastModified,
+		})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].CreatedAt.Before(list[j].CreatedAt)
+	})
+
+	return list, nil
+}
+
+func (s *S3Store) DeleteBefore(ctx context.Context, cutoff time.Time) (int, error) {
+	objects, err := s.list(ctx, s.prefix)
+	if err != nil {
+		return 0, err
+	}
+
+	// The owner goes with the job's last artifact
+	remaining := map[string]int{}
+	var owners []s3Object
+	removed := 0
+	for _, object := range objects {
+		dir := path.Dir(object.Key)
+		if path.Base(object.Key) == ownerName {
+			if object.LastModified.Before(cutoff) {
+				owners = append(owners, object)
+			}
+			continue
+		}
+		if !object.LastModified.Before(cutoff) {
+			remaining[dir]++
+			continue
+		}
+		resp, err := s.do(ctx, http.MethodDelete, object.Key, nil, nil, 0, "")
+		if err != nil {
+			return removed, err
+		}
+		resp.Body.Close()
+		removed++
+	}
+	for _, owner := range owners {
+		if remaining[path.Dir(owner.Key)] > 0 {
+			continue
+		}
+		resp, err := s.do(ctx, http.MethodDelete, owner.Key, nil, nil, 0, "")
+		if err != nil {
+			return removed, err
+		}
+		resp.Body.Close()
+	}
+
+	return removed, nil
+}
+
+type s3Object struct {
+	Key          string    `xml:"Key"`
+	Size         int64     `xml:"Size"`
+	LastModified time.Time `xml:"LastModified"`
+}
+
+type listBucketResult struct {
+	Contents              []s3Object `xml:"Contents"`
+	IsTruncated           bool       `xml:"IsTruncated"`
+	NextContinuationToken string     `xml:"NextContinuationToken"`
+}
+
+func (s *S3Store) list(ctx context.Context, prefix string) ([]s3Object, error) {
+	var objects []s3Object
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		resp, err := s.do(ctx, http.MethodGet, "", query, nil, 0, "")
+		if err != nil {
+			return nil, err
+		}
+		var page listBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("could not decode bucket listing: %w", err)
+		}
+
+		objects = append(objects, page.Contents...)
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return objects, nil
+		}
+		token = page.NextContinuationToken
+	}
+}
+
+// do sends a signed request for an object key (or the bucket itself when
+// key is empty) and turns error statuses into errors.
+func (s *S3Store) do(ctx context.Context, method, key string, query url.Values, body io.Reader, size int64, contentType string) (*http.Response, error) {
+	path := "/" + s.bucket
+	if key != "" {
+		path += "/" + key
+	}
+
+	u := *s.endpoint
+	u.Path = s.endpoint.Path + path
+	u.RawPath = s.endpoint.Path + encodePath(path)
+	u.RawQuery = canonicalQuery(query)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	s.sign(req, s.endpoint.Path+encodePath(path), query, time.Now().UTC())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound && key != "" {
+		resp.Body.Close()
+		return nil, ErrNotFound
+	}
+	if resp.StatusCode >= 300 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		return nil, fmt.Errorf("s3 %s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(detail)))
+	}
+
+	return resp, nil
+}
+
+// sign adds AWS Signature Version 4 headers. The payload is left unsigned so
+// uploads can stream without hashing the body first.
+func (s *S3Store) sign(req *http.Request, canonicalURI string, query url.Values, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", unsignedPayload)
+
+	headers := map[string]string{
+		"host":                 req.URL.Host,
+		"x-amz-content-sha256": unsignedPayload,
+		"x-amz-date":           amzDate,
+	}
+	if ct := req.Header.Get("Content-Type"); ct != "" {
+		headers["content-type"] = ct
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(headers[name]) + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		canonicalURI,
+		canonicalQuery(query),
+		canonicalHeaders.String(),
+		signedHeaders,
+		unsignedPayload,
+	}, "\n")
+
+	scope := date + "/" + s.region + "/s3/aws4_request"
+	hashed := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hex.EncodeToString(hashed[:]),
+	}, "\n")
+
+	signingKey := hmacSHA256([]byte("AWS4"+s.secretKey), date)
+	signingKey = hmacSHA256(signingKey, s.region)
+	signingKey = hmacSHA256(signingKey, "s3")
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, scope, signedHeaders, signature,
+	))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, key := range keys {
+		for _, value := range query[key] {
+			parts = append(parts, uriEncode(key, true)+"="+uriEncode(value, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func encodePath(path string) string {
+	return uriEncode(path, false)
+}
+
+// uriEncode percent-encodes everything except unreserved characters, as
+// required by Signature Version 4.
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
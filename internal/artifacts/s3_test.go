@@ -0,0 +1,374 @@
+package artifacts
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testJobID = "0123456789abcdef"
+
+type fakeObject struct {
+	data         []byte
+	contentType  string
+	lastModified time.Time
+}
+
+// fakeS3 is an in-memory bucket speaking just enough of the S3 API for
+// S3Store. It lists one object per page to exercise continuation tokens.
+type fakeS3 struct {
+	bucket string
+
+	mu       sync.Mutex
+	objects  map[string]*fakeObject
+	requests []*http.Request
+	now      time.Time
+}
+
+func newFakeS3(t *testing.T) (*fakeS3, *S3Store) {
+	t.Helper()
+
+	fake := &fakeS3{
+		bucket:  "artifacts",
+		objects: map[string]*fakeObject{},
+		now:     time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC),
+	}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	store, err := NewS3Store(S3Config{
+		Endpoint:  server.URL,
+		Bucket:    fake.bucket,
+		Prefix:    "jobs",
+		AccessKey: "AKIDEXAMPLE",
+		SecretKey: "secret",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fake, store
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.requests = append(f.requests, r)
+	if auth := r.Header.Get("Authorization"); !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/") {
+		http.Error(w, "missing signature", http.StatusForbidden)
+		return
+	}
+	if r.Header.Get("X-Amz-Date") == "" || r.Header.Get("X-Amz-Content-Sha256") != unsignedPayload {
+		http.Error(w, "missing signed headers", http.StatusForbidden)
+		return
+	}
+
+	bucketPath := "/" + f.bucket
+	if r.URL.Path == bucketPath && r.Method == http.MethodGet {
+		f.list(w, r.URL.Query())
+		return
+	}
+	key, ok := strings.CutPrefix(r.URL.Path, bucketPath+"/")
+	if !ok {
+		http.Error(w, "no such bucket", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.objects[key] = &fakeObject{data: data, contentType: r.Header.Get("Content-Type"), lastModified: f.now}
+	case http.MethodGet:
+		object, ok := f.objects[key]
+		if !ok {
+			http.Error(w, "no such key", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Last-Modified", object.lastModified.Format(http.TimeFormat))
+		w.Write(object.data)
+	case http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "unsupported", http.StatusMethodNotAllowed)
+	}
+}
+
+func (f *fakeS3) list(w http.ResponseWriter, query url.Values) {
+	if query.Get("list-type") != "2" {
+		http.Error(w, "only ListObjectsV2 is supported", http.StatusBadRequest)
+		return
+	}
+
+	var keys []string
+	for key := range f.objects {
+		if strings.HasPrefix(key, query.Get("prefix")) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	start := 0
+	if token := query.Get("continuation-token"); token != "" {
+		start = sort.SearchStrings(keys, token)
+	}
+	var page listBucketResult
+	if start < len(keys) {
+		key := keys[start]
+		page.Contents = []s3Object{{Key: key, Size: int64(len(f.objects[key].data)), LastModified: f.objects[key].lastModified}}
+	}
+	if start+1 < len(keys) {
+		page.IsTruncated = true
+		page.NextContinuationToken = keys[start+1]
+	}
+	xml.NewEncoder(w).Encode(page)
+}
+
+func (f *fakeS3) put(key string, data string, lastModified time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.objects[key] = &fakeObject{data: []byte(data), lastModified: lastModified}
+}
+
+func (f *fakeS3) keys() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var keys []string
+	for key := range f.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestS3StorePutAndOpen(t *testing.T) {
+	fake, store := newFakeS3(t)
+	ctx := context.Background()
+
+	artifact, err := store.Put(ctx, testJobID, "page.png", strings.NewReader("png data"), 8)
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if artifact.Name != "page.png" || artifact.ContentType != "image/png" || artifact.Size != 8 {
+		t.Errorf("Put returned %+v", artifact)
+	}
+
+	fake.mu.Lock()
+	object := fake.objects["jobs/"+testJobID+"/page.png"]
+	fake.mu.Unlock()
+	if object == nil {
+		t.Fatalf("object not stored under the prefix, have %v", fake.keys())
+	}
+	if object.contentType != "image/png" {
+		t.Errorf("stored content type %q, want image/png", object.contentType)
+	}
+
+	body, opened, err := store.Open(ctx, testJobID, "page.png")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer body.Close()
+	data, _ := io.ReadAll(body)
+	if string(data) != "png data" {
+		t.Errorf("Open read %q, want %q", data, "png data")
+	}
+	if opened.Size != 8 || !opened.CreatedAt.Equal(fake.now) {
+		t.Errorf("Open returned %+v", opened)
+	}
+}
+
+func TestS3StoreOpenMissing(t *testing.T) {
+	_, store := newFakeS3(t)
+
+	_, _, err := store.Open(context.Background(), testJobID, "missing.png")
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Open of a missing artifact returned %v, want ErrNotFound", err)
+	}
+}
+
+func TestS3StoreRejectsInvalidNames(t *testing.T) {
+	fake, store := newFakeS3(t)
+	ctx := context.Background()
+
+	if _, err := store.Put(ctx, testJobID, "../escape.png", strings.NewReader("x"), 1); !errors.Is(err, ErrInvalidName) {
+		t.Errorf("Put with a path in the name returned %v, want ErrInvalidName", err)
+	}
+	if _, err := store.List(ctx, "not-a-job"); !errors.Is(err, ErrInvalidJob) {
+		t.Errorf("List of an invalid job returned %v, want ErrInvalidJob", err)
+	}
+	if len(fake.requests) != 0 {
+		t.Errorf("invalid requests reached the bucket: %d", len(fake.requests))
+	}
+}
+
+func TestS3StoreList(t *testing.T) {
+	fake, store := newFakeS3(t)
+	older := fake.now.Add(-time.Hour)
+	fake.put("jobs/"+testJobID+"/b.pdf", "pdf", fake.now)
+	fake.put("jobs/"+testJobID+"/a.png", "png!", older)
+	fake.put("jobs/fedcba9876543210/other.png", "other job", fake.now)
+
+	list, err := store.List(context.Background(), testJobID)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+
+	var names []string
+	for _, artifact := range list {
+		names = append(names, artifact.Name)
+	}
+	// Oldest first, across listing pages, without the other job's artifacts
+	want := []string{"a.png", "b.pdf"}
+	if strings.Join(names, ",") != strings.Join(want, ",") {
+		t.Fatalf("List returned %v, want %v", names, want)
+	}
+	if list[0].Size != 4 || list[0].ContentType != "image/png" {
+		t.Errorf("List returned %+v for a.png", list[0])
+	}
+}
+
+func TestS3StoreDeleteBefore(t *testing.T) {
+	fake, store := newFakeS3(t)
+	cutoff := fake.now.Add(-24 * time.Hour)
+	fake.put("jobs/"+testJobID+"/old.png", "old", cutoff.Add(-time.Minute))
+	fake.put("jobs/"+testJobID+"/new.png", "new", cutoff.Add(time.Minute))
+	fake.put("jobs/"+testJobID+"/.workspace", "acme", cutoff.Add(-time.Hour))
+	fake.put("jobs/fedcba9876543210/old.pdf", "old", cutoff.Add(-time.Hour))
+	fake.put("jobs/fedcba9876543210/.workspace", "acme", cutoff.Add(-time.Hour))
+	fake.put("elsewhere/old.png", "not ours", cutoff.Add(-time.Hour))
+
+	removed, err := store.DeleteBefore(context.Background(), cutoff)
+	if err != nil {
+		t.Fatalf("DeleteBefore: %v", err)
+	}
+	if removed != 2 {
+		t.Errorf("DeleteBefore removed %d, want 2", removed)
+	}
+	// The owner stays as long as any of the job's artifacts
+	want := []string{"elsewhere/old.png", "jobs/" + testJobID + "/.workspace", "jobs/" + testJobID + "/new.png"}
+	if got := fake.keys(); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("bucket holds %v, want %v", got, want)
+	}
+}
+
+func TestS3StoreOwner(t *testing.T) {
+	fake, store := newFakeS3(t)
+	ctx := context.Background()
+
+	if _, err := store.Owner(ctx, testJobID); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Owner of a job without one returned %v, want ErrNotFound", err)
+	}
+	if err := store.SetOwner(ctx, testJobID, "../acme"); err == nil {
+		t.Error("SetOwner accepted an invalid workspace")
+	}
+	if err := store.SetOwner(ctx, testJobID, "acme"); err != nil {
+		t.Fatalf("SetOwner: %v", err)
+	}
+	if owner, err := store.Owner(ctx, testJobID); err != nil || owner != "acme" {
+		t.Errorf("Owner = %q, %v, want acme", owner, err)
+	}
+
+	fake.put("jobs/"+testJobID+"/page.png", "png", fake.now)
+	list, err := store.List(ctx, testJobID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].Name != "page.png" {
+		t.Errorf("List returned %+v, want only page.png", list)
+	}
+}
+
+func TestS3StoreReportsErrorStatuses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "<Error><Code>AccessDenied</Code></Error>", http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	store, err := NewS3Store(S3Config{Endpoint: server.URL, Bucket: "artifacts"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = store.Put(context.Background(), testJobID, "page.png", strings.NewReader("x"), 1)
+	if err == nil || !strings.Contains(err.Error(), "403") || !strings.Contains(err.Error(), "AccessDenied") {
+		t.Fatalf("Put returned %v, want the status and the error body", err)
+	}
+}
+
+// The expected signatures were computed independently of this package from
+// the Signature Version 4 specification.
+func TestS3StoreSign(t *testing.T) {
+	store, err := NewS3Store(S3Config{
+		Endpoint:  "https://s3.amazonaws.com",
+		Bucket:    "examplebucket",
+		AccessKey: "AKIDEXAMPLE",
+		SecretKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2013, 5, 24, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name          string
+		method        string
+		path          string
+		query         url.Values
+		contentType   string
+		signedHeaders string
+		signature     string
+	}{
+		{
+			name:          "object upload with an escaped key",
+			method:        http.MethodPut,
+			path:          "/examplebucket/jobs/" + testJobID + "/page one.png",
+			contentType:   "image/png",
+			signedHeaders: "content-type;host;x-amz-content-sha256;x-amz-date",
+			signature:     "1dedd60e4d14eea70189147760f3d695c5e41b872d9f56b5bc331c78d589013a",
+		},
+		{
+			name:          "bucket listing with an encoded query",
+			method:        http.MethodGet,
+			path:          "/examplebucket",
+			query:         url.Values{"prefix": {"jobs/" + testJobID + "/"}, "list-type": {"2"}},
+			signedHeaders: "host;x-amz-content-sha256;x-amz-date",
+			signature:     "96ae8dc181cf5e937af74337f58c3e7de68f58b418498a2de4bde80c9ed7e81a",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, "https://s3.amazonaws.com"+encodePath(tt.path), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			store.sign(req, encodePath(tt.path), tt.query, now)
+
+			want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20130524/us-east-1/s3/aws4_request, " +
+				"SignedHeaders=" + tt.signedHeaders + ", Signature=" + tt.signature
+			if got := req.Header.Get("Authorization"); got != want {
+				t.Errorf("Authorization = %q\nwant %q", got, want)
+			}
+			if got := req.Header.Get("X-Amz-Date"); got != "20130524T000000Z" {
+				t.Errorf("X-Amz-Date = %q", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,500 @@
+package auth
+
+import (
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"brian-nunez/bcode/internal/store"
+	"brian-nunez/bcode/internal/workspaces"
+)
+
+// Scopes an API key or user can hold. Admin implies the others.
+const (
+	ScopeSubmit = "submit"
+	ScopeRead   = "read"
+	ScopeAdmin  = "admin"
+)
+
+var Scopes = []string{ScopeSubmit, ScopeRead, ScopeAdmin}
+
+var (
+	ErrInvalidName        = errors.New("names may only contain letters, digits, spaces, '.', '-' and '_'")
+	ErrInvalidScope       = errors.New("unknown scope")
+	ErrNoScopes           = errors.New("at least one scope is required")
+	ErrInvalidKey         = errors.New("invalid API key")
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	ErrNotFound           = errors.New("API key or user not found")
+	ErrOtherWorkspace     = errors.New("only operators can act on other workspaces")
+)
+
+var validName = regexp.MustCompile(`^[A-Za-z0-9 ._-]{1,64}$`)
+
+const (
+	keyPrefix = "bc_"
+	// Enough of a key to tell keys apart in listings without revealing them
+	visiblePrefix = len(keyPrefix) + 6
+
+	passwordIterations = 600_000
+
+	// LastUsedAt is only written back this often, not on every request
+	lastUsedResolution = time.Minute
+)
+
+// APIKey is the metadata of a key. The key itself is only shown once, when
+// it is created; the store keeps its SHA-256 hash.
+type APIKey struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	Workspace  string     `json:"workspace"`
+	CreatedBy  string     `json:"created_by,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+type keyRecord struct {
+	APIKey
+	Hash string `json:"hash"`
+}
+
+// User signs in to the UI with a password.
+type User struct {
+	Name      string    `json:"name"`
+	Scopes    []string  `json:"scopes"`
+	Workspace string    `json:"workspace"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type userRecord struct {
+	User
+	Salt         string `json:"salt"`
+	Iterations   int    `json:"iterations"`
+	PasswordHash string `json:"password_hash"`
+}
+
+type records struct {
+	Keys  []*keyRecord  `json:"keys"`
+	Users []*userRecord `json:"users"`
+}
+
+// Principal is who a request is made by: a signed-in user or an API key.
+// It only sees what belongs to its workspace.
+type Principal struct {
+	Kind      string   `json:"kind"`
+	ID        string   `json:"id"`
+	Name      string   `json:"name"`
+	Scopes    []string `json:"scopes"`
+	Workspace string   `json:"workspace"`
+}
+
+// Subject identifies the principal in job attribution, e.g. "user:admin" or
+// "key:3f9a0c1d2b4e5f60".
+func (p Principal) Subject() string {
+	return p.Kind + ":" + p.ID
+}
+
+func (p Principal) Can(scope string) bool {
+	return slices.Contains(p.Scopes, ScopeAdmin) || slices.Contains(p.Scopes, scope)
+}
+
+// Operator reports whether the principal administers the deployment: admins
+// of the default workspace manage every workspace and see into all of them.
+func (p Principal) Operator() bool {
+	return p.Can(ScopeAdmin) && p.Workspace == workspaces.DefaultWorkspace
+}
+
+// Sees reports whether something owned by the workspace is visible to the
+// principal.
+func (p Principal) Sees(workspace string) bool {
+	return p.Operator() || p.Workspace == workspace
+}
+
+// TargetWorkspace is the workspace a request acts on: the principal's own,
+// or any existing one an operator names.
+func (p Principal) TargetWorkspace(requested string) (string, error) {
+	if requested == "" || requested == p.Workspace {
+		return p.Workspace, nil
+	}
+	if !p.Operator() {
+		return "", ErrOtherWorkspace
+	}
+	store, err := workspaces.Default()
+	if err != nil {
+		return "", err
+	}
+	if _, ok := store.Get(requested); !ok {
+		return "", fmt.Errorf("%w: %s", workspaces.ErrNotFound, requested)
+	}
+	return requested, nil
+}
+
+// workspaceOrDefault places keys and users stored before workspaces existed
+// in the default workspace.
+func workspaceOrDefault(workspace string) string {
+	if workspace == "" {
+		return workspaces.DefaultWorkspace
+	}
+	return workspace
+}
+
+// Store keeps API keys and users in auth.json in the data directory, and UI
+// sessions in memory.
+type Store struct {
+	path     string
+	mu       sync.Mutex
+	records  *records
+	sessions map[string]session
+	ttl      time.Duration
+}
+
+var (
+	defaultStore *Store
+	defaultErr   error
+	defaultOnce  sync.Once
+)
+
+// Default returns the store under the server's data directory. The first
+// time it finds no users it creates ADMIN_USERNAME (default "admin") with
+// ADMIN_PASSWORD, or a generated password it logs once.
+func Default() (*Store, error) {
+	defaultOnce.Do(func() {
+		defaultStore, defaultErr = NewStore(filepath.Join(store.DataDir(), "auth.json"))
+		if defaultErr != nil {
+			return
+		}
+		defaultErr = defaultStore.bootstrapAdmin()
+	})
+	return defaultStore, defaultErr
+}
+
+func NewStore(path string) (*Store, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+
+	s := &Store{
+		path:     path,
+		sessions: map[string]session{},
+		ttl:      SessionTTL(),
+	}
+	data, err := os.ReadFile(path)
+	switch {
+	case os.IsNotExist(err):
+		s.records = &records{}
+	case err != nil:
+		return nil, err
+	default:
+		s.records = &records{}
+		if err := json.Unmarshal(data, s.records); err != nil {
+			return nil, fmt.Errorf("invalid auth store %s: %w", path, err)
+		}
+	}
+	return s, nil
+}
+
+func (s *Store) bootstrapAdmin() error {
+	s.mu.Lock()
+	hasUsers := len(s.records.Users) > 0
+	s.mu.Unlock()
+	if hasUsers {
+		return nil
+	}
+
+	name := os.Getenv("ADMIN_USERNAME")
+	if name == "" {
+		name = "admin"
+	}
+	password := os.Getenv("ADMIN_PASSWORD")
+	generated := password == ""
+	if generated {
+		password = randomString(18)
+	}
+	if _, err := s.SetUser(name, password, []string{ScopeAdmin}); err != nil {
+		return fmt.Errorf("could not create admin user: %w", err)
+	}
+	if generated {
+		log.Printf("created UI user %q with password %s; set ADMIN_PASSWORD to choose your own", name, password)
+	}
+	return nil
+}
+
+// save writes the records; callers hold s.mu.
+func (s *Store) save() error {
+	data, err := json.MarshalIndent(s.records, "", "  ")
+	if err != nil {
+		return err
+	}
+	return store.WriteFileAtomic(s.path, data)
+}
+
+func validateScopes(scopes []string) ([]string, error) {
+	if len(scopes) == 0 {
+		return nil, ErrNoScopes
+	}
+	var valid []string
+	for _, scope := range scopes {
+		if !slices.Contains(Scopes, scope) {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidScope, scope)
+		}
+		if !slices.Contains(valid, scope) {
+			valid = append(valid, scope)
+		}
+	}
+	return valid, nil
+}
+
+// Keys lists every key, revoked ones included, newest first.
+func (s *Store) Keys() []APIKey {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := make([]APIKey, 0, len(s.records.Keys))
+	for _, record := range s.records.Keys {
+		key := record.APIKey
+		key.Workspace = workspaceOrDefault(key.Workspace)
+		list = append(list, key)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].CreatedAt.After(list[j].CreatedAt)
+	})
+	return list
+}
+
+// Key returns a key's metadata.
+func (s *Store) Key(id string) (APIKey, bool) {
+	for _, key := range s.Keys() {
+		if key.ID == id {
+			return key, true
+		}
+	}
+	return APIKey{}, false
+}
+
+// CreateKey returns the new key's metadata and the key itself. The caller
+// checks that the workspace exists.
+func (s *Store) CreateKey(name string, scopes []string, workspace, createdBy string) (APIKey, string, error) {
+	name = strings.TrimSpace(name)
+	if !validName.MatchString(name) {
+		return APIKey{}, "", ErrInvalidName
+	}
+	scopes, err := validateScopes(scopes)
+	if err != nil {
+		return APIKey{}, "", err
+	}
+
+	secret := keyPrefix + randomString(32)
+	record := &keyRecord{
+		APIKey: APIKey{
+			ID:        randomID(),
+			Name:      name,
+			Prefix:    secret[:visiblePrefix],
+			Scopes:    scopes,
+			Workspace: workspaceOrDefault(workspace),
+			CreatedBy: createdBy,
+			CreatedAt: time.Now(),
+		},
+		Hash: hashKey(secret),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.records.Keys = append(s.records.Keys, record)
+	if err := s.save(); err != nil {
+		s.records.Keys = s.records.Keys[:len(s.records.Keys)-1]
+		return APIKey{}, "", err
+	}
+	return record.APIKey, secret, nil
+}
+
+// RevokeKey keeps the key listed but stops it from authenticating.
+func (s *Store) RevokeKey(id string) (APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, record := range s.records.Keys {
+		if record.ID != id {
+			continue
+		}
+		if record.RevokedAt == nil {
+			now := time.Now()
+			record.RevokedAt = &now
+			if err := s.save(); err != nil {
+				record.RevokedAt = nil
+				return APIKey{}, err
+			}
+		}
+		return record.APIKey, nil
+	}
+	return APIKey{}, ErrNotFound
+}
+
+// AuthenticateKey returns the principal for a key that exists and has not
+// been revoked.
+func (s *Store) AuthenticateKey(secret string) (Principal, error) {
+	if !strings.HasPrefix(secret, keyPrefix) {
+		return Principal{}, ErrInvalidKey
+	}
+	hash := hashKey(secret)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, record := range s.records.Keys {
+		if subtle.ConstantTimeCompare([]byte(record.Hash), []byte(hash)) != 1 {
+			continue
+		}
+		if record.RevokedAt != nil {
+			return Principal{}, ErrInvalidKey
+		}
+
+		now := time.Now()
+		if record.LastUsedAt == nil || now.Sub(*record.LastUsedAt) > lastUsedResolution {
+			record.LastUsedAt = &now
+			if err := s.save(); err != nil {
+				log.Printf("could not record API key use: %v", err)
+			}
+		}
+		return Principal{Kind: "key", ID: record.ID, Name: record.Name, Scopes: record.Scopes, Workspace: workspaceOrDefault(record.Workspace)}, nil
+	}
+	return Principal{}, ErrInvalidKey
+}
+
+// Lookup returns the principal a subject such as "user:admin" or
+// "key:3f9a0c1d2b4e5f60" stands for now, with its current scopes. Revoked
+// keys and unknown users are ErrNotFound.
+func (s *Store) Lookup(subject string) (Principal, error) {
+	kind, id, _ := strings.Cut(subject, ":")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch kind {
+	case "key":
+		for _, record := range s.records.Keys {
+			if record.ID == id && record.RevokedAt == nil {
+				return Principal{Kind: "key", ID: record.ID, Name: record.Name, Scopes: record.Scopes, Workspace: workspaceOrDefault(record.Workspace)}, nil
+			}
+		}
+	case "user":
+		if i := s.userIndex(id); i >= 0 {
+			return s.records.Users[i].principal(), nil
+		}
+	case anonymous.Kind:
+		if Disabled() && id == anonymous.ID {
+			return anonymous, nil
+		}
+	}
+	return Principal{}, fmt.Errorf("%w: %s", ErrNotFound, subject)
+}
+
+// SetUser creates a user or replaces its password and scopes. New users
+// belong to the default workspace.
+func (s *Store) SetUser(name, password string, scopes []string) (User, error) {
+	if !validName.MatchString(name) {
+		return User{}, ErrInvalidName
+	}
+	if password == "" {
+		return User{}, errors.New("password is required")
+	}
+	scopes, err := validateScopes(scopes)
+	if err != nil {
+		return User{}, err
+	}
+
+	salt := make([]byte, 16)
+	rand.Read(salt)
+	hash, err := pbkdf2.Key(sha256.New, password, salt, passwordIterations, 32)
+	if err != nil {
+		return User{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record := &userRecord{User: User{Name: name, Workspace: workspaces.DefaultWorkspace, CreatedAt: time.Now()}}
+	if i := s.userIndex(name); i >= 0 {
+		record = s.records.Users[i]
+	} else {
+		s.records.Users = append(s.records.Users, record)
+	}
+	record.Scopes = scopes
+	record.Salt = base64.StdEncoding.EncodeToString(salt)
+	record.Iterations = passwordIterations
+	record.PasswordHash = base64.StdEncoding.EncodeToString(hash)
+
+	return record.User, s.save()
+}
+
+func (s *Store) userIndex(name string) int {
+	return slices.IndexFunc(s.records.Users, func(u *userRecord) bool {
+		return u.Name == name
+	})
+}
+
+// AuthenticateUser checks a user's password.
+func (s *Store) AuthenticateUser(name, password string) (Principal, error) {
+	s.mu.Lock()
+	var record userRecord
+	i := s.userIndex(name)
+	if i >= 0 {
+		record = *s.records.Users[i]
+	}
+	s.mu.Unlock()
+
+	// Unknown users cost as much as wrong passwords
+	salt, _ := base64.StdEncoding.DecodeString(record.Salt)
+	iterations := record.Iterations
+	if iterations == 0 {
+		iterations = passwordIterations
+	}
+	hash, err := pbkdf2.Key(sha256.New, password, salt, iterations, 32)
+	if err != nil {
+		return Principal{}, err
+	}
+	expected, _ := base64.StdEncoding.DecodeString(record.PasswordHash)
+	if i < 0 || subtle.ConstantTimeCompare(hash, expected) != 1 {
+		return Principal{}, ErrInvalidCredentials
+	}
+
+	return record.principal(), nil
+}
+
+func (u User) principal() Principal {
+	return Principal{Kind: "user", ID: u.Name, Name: u.Name, Scopes: u.Scopes, Workspace: workspaceOrDefault(u.Workspace)}
+}
+
+func hashKey(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func randomString(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)[:n]
+}
@@ -0,0 +1,505 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"brian-nunez/bcode/internal/workspaces"
+	"github.com/labstack/echo/v4"
+)
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "auth-test-")
+	if err != nil {
+		panic(err)
+	}
+	os.Setenv("DATA_DIR", dir)
+	os.Setenv("ADMIN_USERNAME", "admin")
+	os.Setenv("ADMIN_PASSWORD", "correct horse")
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func newTestStore(t *testing.T) *Store {
+	t.Helper()
+
+	store, err := NewStore(filepath.Join(t.TempDir(), "auth.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestKeys(t *testing.T) {
+	store := newTestStore(t)
+
+	key, secret, err := store.CreateKey(" deploy ", []string{ScopeSubmit, ScopeSubmit, ScopeRead}, "", "user:admin")
+	if err != nil {
+		t.Fatalf("CreateKey: %v", err)
+	}
+	if key.Name != "deploy" || key.Workspace != workspaces.DefaultWorkspace || strings.Join(key.Scopes, ",") != "submit,read" {
+		t.Errorf("CreateKey returned %+v", key)
+	}
+	if !strings.HasPrefix(secret, keyPrefix) || key.Prefix != secret[:visiblePrefix] {
+		t.Errorf("key %q does not start with its listed prefix %q", secret, key.Prefix)
+	}
+
+	// Only the hash is kept
+	data, err := os.ReadFile(store.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), secret) || !strings.Contains(string(data), hashKey(secret)) {
+		t.Errorf("auth.json holds the key itself or not its hash:\n%s", data)
+	}
+
+	principal, err := store.AuthenticateKey(secret)
+	if err != nil {
+		t.Fatalf("AuthenticateKey: %v", err)
+	}
+	want := Principal{Kind: "key", ID: key.ID, Name: "deploy", Scopes: []string{ScopeSubmit, ScopeRead}, Workspace: workspaces.DefaultWorkspace}
+	if principal.Subject() != "key:"+key.ID || strings.Join(principal.Scopes, ",") != "submit,read" || principal.Workspace != want.Workspace {
+		t.Errorf("AuthenticateKey = %+v, want %+v", principal, want)
+	}
+	if listed, _ := store.Key(key.ID); listed.LastUsedAt == nil {
+		t.Error("the key's use was not recorded")
+	}
+
+	// The store reads back what it wrote
+	reopened, err := NewStore(store.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reopened.AuthenticateKey(secret); err != nil {
+		t.Errorf("AuthenticateKey after reopening: %v", err)
+	}
+
+	for _, wrong := range []string{"", "bc_", secret + "x", strings.TrimPrefix(secret, keyPrefix)} {
+		if _, err := store.AuthenticateKey(wrong); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("AuthenticateKey(%q) returned %v, want ErrInvalidKey", wrong, err)
+		}
+	}
+
+	revoked, err := store.RevokeKey(key.ID)
+	if err != nil || revoked.RevokedAt == nil {
+		t.Fatalf("RevokeKey = %+v, %v", revoked, err)
+	}
+	if _, err := store.AuthenticateKey(secret); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("a revoked key authenticated: %v", err)
+	}
+	if keys := store.Keys(); len(keys) != 1 || keys[0].RevokedAt == nil {
+		t.Errorf("revoked keys stay listed, have %+v", keys)
+	}
+	if _, err := store.RevokeKey("missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("RevokeKey of an unknown key returned %v, want ErrNotFound", err)
+	}
+}
+
+func TestCreateKeyErrors(t *testing.T) {
+	store := newTestStore(t)
+
+	tests := []struct {
+		name    string
+		keyName string
+		scopes  []string
+		want    error
+	}{
+		{"no name", " ", []string{ScopeRead}, ErrInvalidName},
+		{"name with a slash", "a/b", []string{ScopeRead}, ErrInvalidName},
+		{"no scopes", "ci", nil, ErrNoScopes},
+		{"unknown scope", "ci", []string{"root"}, ErrInvalidScope},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := store.CreateKey(tt.keyName, tt.scopes, "", "user:admin"); !errors.Is(err, tt.want) {
+				t.Errorf("CreateKey returned %v, want %v", err, tt.want)
+			}
+		})
+	}
+	if keys := store.Keys(); len(keys) != 0 {
+		t.Errorf("refused keys were stored: %+v", keys)
+	}
+}
+
+func TestUsers(t *testing.T) {
+	store := newTestStore(t)
+
+	if _, err := store.SetUser("alice", "", []string{ScopeRead}); err == nil {
+		t.Error("SetUser accepted an empty password")
+	}
+	if _, err := store.SetUser("alice", "s3cret", []string{ScopeRead}); err != nil {
+		t.Fatalf("SetUser: %v", err)
+	}
+
+	data, err := os.ReadFile(store.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "s3cret") || !strings.Contains(string(data), `"iterations": 600000`) {
+		t.Errorf("auth.json does not hold a PBKDF2 hash of the password:\n%s", data)
+	}
+
+	principal, err := store.AuthenticateUser("alice", "s3cret")
+	if err != nil {
+		t.Fatalf("AuthenticateUser: %v", err)
+	}
+	if principal.Subject() != "user:alice" || !principal.Can(ScopeRead) || principal.Can(ScopeSubmit) {
+		t.Errorf("AuthenticateUser = %+v", principal)
+	}
+	for _, wrong := range [][2]string{{"alice", "S3cret"}, {"alice", ""}, {"bob", "s3cret"}} {
+		if _, err := store.AuthenticateUser(wrong[0], wrong[1]); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("AuthenticateUser(%q, %q) returned %v, want ErrInvalidCredentials", wrong[0], wrong[1], err)
+		}
+	}
+
+	// Setting a user again replaces the password and scopes
+	if _, err := store.SetUser("alice", "new password", []string{ScopeSubmit}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.AuthenticateUser("alice", "s3cret"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("the old password still works: %v", err)
+	}
+	if principal, err := store.AuthenticateUser("alice", "new password"); err != nil || !principal.Can(ScopeSubmit) {
+		t.Errorf("AuthenticateUser with the new password = %+v, %v", principal, err)
+	}
+}
+
+func TestSessions(t *testing.T) {
+	store := newTestStore(t)
+	if _, err := store.SetUser("alice", "s3cret", []string{ScopeRead}); err != nil {
+		t.Fatal(err)
+	}
+
+	token, expires := store.StartSession("alice")
+	if time.Until(expires) <= 0 {
+		t.Errorf("session expires at %s, in the past", expires)
+	}
+	principal, ok := store.Session(token)
+	if !ok || principal.Subject() != "user:alice" {
+		t.Fatalf("Session = %+v, %v", principal, ok)
+	}
+
+	// Scope changes apply to open sessions
+	if _, err := store.SetUser("alice", "s3cret", []string{ScopeAdmin}); err != nil {
+		t.Fatal(err)
+	}
+	if principal, _ := store.Session(token); !principal.Can(ScopeAdmin) {
+		t.Errorf("session principal %+v kept the old scopes", principal)
+	}
+
+	store.EndSession(token)
+	if _, ok := store.Session(token); ok {
+		t.Error("an ended session is still valid")
+	}
+	if _, ok := store.Session("unknown"); ok {
+		t.Error("an unknown token is a session")
+	}
+
+	store.ttl = -time.Second
+	expired, _ := store.StartSession("alice")
+	if _, ok := store.Session(expired); ok {
+		t.Error("an expired session is still valid")
+	}
+}
+
+func TestLookup(t *testing.T) {
+	store := newTestStore(t)
+	key, _, err := store.CreateKey("ci", []string{ScopeSubmit}, "acme", "user:admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	revoked, _, err := store.CreateKey("old", []string{ScopeSubmit}, "acme", "user:admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.RevokeKey(revoked.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.SetUser("alice", "s3cret", []string{ScopeRead}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		subject   string
+		disabled  bool
+		workspace string
+		scope     string
+		err       error
+	}{
+		{subject: "key:" + key.ID, workspace: "acme", scope: ScopeSubmit},
+		{subject: "user:alice", workspace: workspaces.DefaultWorkspace, scope: ScopeRead},
+		{subject: "key:" + revoked.ID, err: ErrNotFound},
+		{subject: "key:missing", err: ErrNotFound},
+		{subject: "user:bob", err: ErrNotFound},
+		{subject: "schedule:" + key.ID, err: ErrNotFound},
+		{subject: "anonymous:anonymous", err: ErrNotFound},
+		{subject: "anonymous:anonymous", disabled: true, workspace: workspaces.DefaultWorkspace, scope: ScopeAdmin},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.subject, func(t *testing.T) {
+			if tt.disabled {
+				t.Setenv("AUTH_DISABLED", "true")
+			}
+			principal, err := store.Lookup(tt.subject)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Errorf("Lookup returned %+v, %v, want %v", principal, err, tt.err)
+				}
+				return
+			}
+			if err != nil || principal.Subject() != tt.subject || principal.Workspace != tt.workspace || !principal.Can(tt.scope) {
+				t.Errorf("Lookup = %+v, %v, want %s in %s with %s", principal, err, tt.subject, tt.workspace, tt.scope)
+			}
+		})
+	}
+}
+
+func TestPrincipalScopes(t *testing.T) {
+	admin := Principal{Scopes: []string{ScopeAdmin}, Workspace: workspaces.DefaultWorkspace}
+	workspaceAdmin := Principal{Scopes: []string{ScopeAdmin}, Workspace: "acme"}
+	submitter := Principal{Scopes: []string{ScopeSubmit}, Workspace: workspaces.DefaultWorkspace}
+	reader := Principal{Scopes: []string{ScopeRead}, Workspace: "acme"}
+
+	tests := []struct {
+		name      string
+		principal Principal
+		can       []string
+		cannot    []string
+		operator  bool
+		sees      []string
+		hidden    []string
+	}{
+		{
+			name:      "operator",
+			principal: admin,
+			can:       []string{ScopeSubmit, ScopeRead, ScopeAdmin},
+			operator:  true,
+			sees:      []string{workspaces.DefaultWorkspace, "acme", "globex"},
+		},
+		{
+			name:      "workspace admin",
+			principal: workspaceAdmin,
+			can:       []string{ScopeSubmit, ScopeRead, ScopeAdmin},
+			sees:      []string{"acme"},
+			hidden:    []string{workspaces.DefaultWorkspace, "globex"},
+		},
+		{
+			name:      "submitter in the default workspace",
+			principal: submitter,
+			can:       []string{ScopeSubmit},
+			cannot:    []string{ScopeRead, ScopeAdmin},
+			sees:      []string{workspaces.DefaultWorkspace},
+			hidden:    []string{"acme"},
+		},
+		{
+			name:      "reader",
+			principal: reader,
+			can:       []string{ScopeRead},
+			cannot:    []string{ScopeSubmit, ScopeAdmin},
+			sees:      []string{"acme"},
+			hidden:    []string{workspaces.DefaultWorkspace},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, scope := range tt.can {
+				if !tt.principal.Can(scope) {
+					t.Errorf("cannot %s", scope)
+				}
+			}
+			for _, scope := range tt.cannot {
+				if tt.principal.Can(scope) {
+					t.Errorf("can %s", scope)
+				}
+			}
+			if tt.principal.Operator() != tt.operator {
+				t.Errorf("Operator() = %v, want %v", tt.principal.Operator(), tt.operator)
+			}
+			for _, workspace := range tt.sees {
+				if !tt.principal.Sees(workspace) {
+					t.Errorf("does not see %s", workspace)
+				}
+			}
+			for _, workspace := range tt.hidden {
+				if tt.principal.Sees(workspace) {
+					t.Errorf("sees %s", workspace)
+				}
+			}
+		})
+	}
+}
+
+func TestTargetWorkspace(t *testing.T) {
+	store, err := workspaces.Default()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Put("acme", workspaces.Quota{}); err != nil {
+		t.Fatal(err)
+	}
+
+	operator := Principal{Scopes: []string{ScopeAdmin}, Workspace: workspaces.DefaultWorkspace}
+	member := Principal{Scopes: []string{ScopeAdmin}, Workspace: "acme"}
+
+	tests := []struct {
+		name      string
+		principal Principal
+		requested string
+		want      string
+		err       error
+	}{
+		{"own workspace by default", member, "", "acme", nil},
+		{"own workspace by name", member, "acme", "acme", nil},
+		{"other workspace", member, workspaces.DefaultWorkspace, "", ErrOtherWorkspace},
+		{"operator in another workspace", operator, "acme", "acme", nil},
+		{"operator in a missing workspace", operator, "globex", "", workspaces.ErrNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.principal.TargetWorkspace(tt.requested)
+			if got != tt.want || !errors.Is(err, tt.err) {
+				t.Errorf("TargetWorkspace(%q) = %q, %v, want %q, %v", tt.requested, got, err, tt.want, tt.err)
+			}
+		})
+	}
+}
+
+func TestRequireScope(t *testing.T) {
+	store, err := Default()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, readKey, err := store.CreateKey("reader", []string{ScopeRead}, "", "user:admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, submitKey, err := store.CreateKey("submitter", []string{ScopeSubmit}, "", "user:admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, _ := store.StartSession("admin")
+
+	tests := []struct {
+		name   string
+		header map[string]string
+		cookie string
+		status int
+		who    string
+	}{
+		{name: "no credentials", status: http.StatusUnauthorized},
+		{name: "invalid key", header: map[string]string{"X-API-Key": "bc_nope"}, status: http.StatusUnauthorized},
+		{name: "key without the scope", header: map[string]string{"X-API-Key": readKey}, status: http.StatusForbidden},
+		{name: "key with the scope", header: map[string]string{"X-API-Key": submitKey}, status: http.StatusOK, who: "submitter"},
+		{name: "bearer key", header: map[string]string{"Authorization": "bearer " + submitKey}, status: http.StatusOK, who: "submitter"},
+		{name: "session", cookie: session, status: http.StatusOK, who: "admin"},
+		{name: "unknown session", cookie: "nope", status: http.StatusUnauthorized},
+		// A key takes precedence over a session
+		{name: "key and session", header: map[string]string{"X-API-Key": readKey}, cookie: session, status: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/jobs", nil)
+			for name, value := range tt.header {
+				req.Header.Set(name, value)
+			}
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: SessionCookie, Value: tt.cookie})
+			}
+			rec := httptest.NewRecorder()
+			c := echo.New().NewContext(req, rec)
+
+			var who string
+			err := RequireScope(ScopeSubmit)(func(c echo.Context) error {
+				principal, _ := PrincipalFrom(c)
+				who = principal.Name
+				return c.NoContent(http.StatusOK)
+			})(c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rec.Code != tt.status || who != tt.who {
+				t.Errorf("answered %d as %q, want %d as %q: %s", rec.Code, who, tt.status, tt.who, rec.Body)
+			}
+			if tt.status == http.StatusUnauthorized && tt.header == nil && tt.cookie == "" && rec.Header().Get(echo.HeaderWWWAuthenticate) != "Bearer" {
+				t.Error("401 without a WWW-Authenticate challenge")
+			}
+		})
+	}
+}
+
+func TestRequirePage(t *testing.T) {
+	store, err := Default()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.SetUser("viewer", "s3cret", []string{ScopeRead}); err != nil {
+		t.Fatal(err)
+	}
+	viewer, _ := store.StartSession("viewer")
+	admin, _ := store.StartSession("admin")
+
+	tests := []struct {
+		name     string
+		method   string
+		target   string
+		cookie   string
+		status   int
+		location string
+	}{
+		{name: "signed out", method: http.MethodGet, target: "/admin?tab=keys", status: http.StatusSeeOther, location: "/login?next=%2Fadmin%3Ftab%3Dkeys"},
+		{name: "signed out form post", method: http.MethodPost, target: "/admin/keys", status: http.StatusSeeOther, location: "/login?next=%2F"},
+		{name: "without the scope", method: http.MethodGet, target: "/admin", cookie: viewer, status: http.StatusForbidden},
+		{name: "with the scope", method: http.MethodGet, target: "/admin", cookie: admin, status: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, nil)
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: SessionCookie, Value: tt.cookie})
+			}
+			rec := httptest.NewRecorder()
+			c := echo.New().NewContext(req, rec)
+
+			err := RequirePage(ScopeAdmin)(func(c echo.Context) error {
+				return c.NoContent(http.StatusOK)
+			})(c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rec.Code != tt.status || rec.Header().Get(echo.HeaderLocation) != tt.location {
+				t.Errorf("answered %d to %q, want %d to %q", rec.Code, rec.Header().Get(echo.HeaderLocation), tt.status, tt.location)
+			}
+		})
+	}
+}
+
+func TestAuthDisabled(t *testing.T) {
+	t.Setenv("AUTH_DISABLED", "true")
+
+	req := httptest.NewRequest(http.MethodDelete, "/api/v1/keys/1", nil)
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+
+	var principal Principal
+	err := RequireScope(ScopeAdmin)(func(c echo.Context) error {
+		principal, _ = PrincipalFrom(c)
+		return c.NoContent(http.StatusNoContent)
+	})(c)
+	if err != nil || rec.Code != http.StatusNoContent || !principal.Operator() {
+		t.Errorf("answered %d as %+v, %v, want the anonymous operator through", rec.Code, principal, err)
+	}
+}
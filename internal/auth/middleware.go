@@ -0,0 +1,138 @@
+package auth
+
+import (
+	stderrors "errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"brian-nunez/bcode/internal/handlers/errors"
+	"brian-nunez/bcode/internal/workspaces"
+	"github.com/labstack/echo/v4"
+)
+
+// SessionCookie holds the UI session token.
+const SessionCookie = "bcode_session"
+
+const principalKey = "auth.principal"
+
+// Disabled reports whether AUTH_DISABLED turns authentication off, for local
+// development. Every request is then made by an anonymous admin.
+func Disabled() bool {
+	value := os.Getenv("AUTH_DISABLED")
+	return value == "true" || value == "1"
+}
+
+var anonymous = Principal{Kind: "anonymous", ID: "anonymous", Name: "anonymous", Scopes: []string{ScopeAdmin}, Workspace: workspaces.DefaultWorkspace}
+
+// authenticate finds who made a request: an API key in the Authorization
+// (Bearer) or X-API-Key header, or else a UI session cookie. ok is false
+// for anonymous requests.
+func authenticate(c echo.Context) (principal Principal, ok bool, err error) {
+	if Disabled() {
+		return anonymous, true, nil
+	}
+
+	store, err := Default()
+	if err != nil {
+		return Principal{}, false, err
+	}
+
+	if key := requestKey(c.Request()); key != "" {
+		principal, err := store.AuthenticateKey(key)
+		if err != nil {
+			return Principal{}, false, err
+		}
+		return principal, true, nil
+	}
+
+	if cookie, err := c.Cookie(SessionCookie); err == nil {
+		principal, ok := store.Session(cookie.Value)
+		return principal, ok, nil
+	}
+	return Principal{}, false, nil
+}
+
+func requestKey(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	if scheme, key, ok := strings.Cut(r.Header.Get(echo.HeaderAuthorization), " "); ok && strings.EqualFold(scheme, "Bearer") {
+		return strings.TrimSpace(key)
+	}
+	return ""
+}
+
+// RequireScope guards API routes. Requests without a valid key or session
+// get 401, those whose principal lacks the scope get 403.
+func RequireScope(scope string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			principal, ok, err := authenticate(c)
+			switch {
+			case stderrors.Is(err, ErrInvalidKey):
+				response := errors.Unauthorized().WithMessage("Invalid API key").Build()
+				return c.JSON(response.HTTPStatusCode, response)
+			case err != nil:
+				response := errors.InternalServerError().Build()
+				return c.JSON(response.HTTPStatusCode, response)
+			case !ok:
+				c.Response().Header().Set(echo.HeaderWWWAuthenticate, "Bearer")
+				response := errors.Unauthorized().WithMessage("An API key or session is required").Build()
+				return c.JSON(response.HTTPStatusCode, response)
+			case !principal.Can(scope):
+				return forbidden(c, scope)
+			}
+
+			c.Set(principalKey, principal)
+			return next(c)
+		}
+	}
+}
+
+// RequirePage guards UI pages, sending visitors who are not signed in to the
+// login page and back afterwards.
+func RequirePage(scope string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			principal, ok, err := authenticate(c)
+			if err != nil && !stderrors.Is(err, ErrInvalidKey) {
+				response := errors.InternalServerError().Build()
+				return c.JSON(response.HTTPStatusCode, response)
+			}
+			if !ok {
+				next := c.Request().URL.RequestURI()
+				if c.Request().Method != http.MethodGet {
+					next = "/"
+				}
+				return c.Redirect(http.StatusSeeOther, "/login?next="+url.QueryEscape(next))
+			}
+			if !principal.Can(scope) {
+				return forbidden(c, scope)
+			}
+
+			c.Set(principalKey, principal)
+			return next(c)
+		}
+	}
+}
+
+func forbidden(c echo.Context, scope string) error {
+	response := errors.Forbidden().WithMessage(fmt.Sprintf("This requires the %s scope", scope)).Build()
+	return c.JSON(response.HTTPStatusCode, response)
+}
+
+// SetPrincipal makes a request the server makes itself, such as a
+// scheduled job, act as principal.
+func SetPrincipal(c echo.Context, principal Principal) {
+	c.Set(principalKey, principal)
+}
+
+// PrincipalFrom returns who made a request that passed RequireScope or
+// RequirePage.
+func PrincipalFrom(c echo.Context) (Principal, bool) {
+	principal, ok := c.Get(principalKey).(Principal)
+	return principal, ok
+}
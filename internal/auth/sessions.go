@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"log"
+	"os"
+	"time"
+)
+
+const defaultSessionTTL = 12 * time.Hour
+
+type session struct {
+	user    string
+	expires time.Time
+}
+
+// SessionTTL is how long a UI sign-in lasts, from SESSION_TTL (a Go duration
+// such as 8h).
+func SessionTTL() time.Duration {
+	if value := os.Getenv("SESSION_TTL"); value != "" {
+		if d, err := time.ParseDuration(value); err == nil && d > 0 {
+			return d
+		}
+		log.Printf("invalid SESSION_TTL %q, using %s", value, defaultSessionTTL)
+	}
+	return defaultSessionTTL
+}
+
+// StartSession signs a user in. Sessions live in memory, so a restart signs
+// everybody out.
+func (s *Store) StartSession(user string) (string, time.Time) {
+	token := randomString(43)
+	expires := time.Now().Add(s.ttl)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for t, session := range s.sessions {
+		if time.Now().After(session.expires) {
+			delete(s.sessions, t)
+		}
+	}
+	s.sessions[token] = session{user: user, expires: expires}
+	return token, expires
+}
+
+// Session returns the principal of a live session. The user's scopes are
+// read fresh so changes apply to sessions already open.
+func (s *Store) Session(token string) (Principal, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[token]
+	if !ok {
+		return Principal{}, false
+	}
+	if time.Now().After(session.expires) {
+		delete(s.sessions, token)
+		return Principal{}, false
+	}
+	i := s.userIndex(session.user)
+	if i < 0 {
+		delete(s.sessions, token)
+		return Principal{}, false
+	}
+	return s.records.Users[i].principal(), true
+}
+
+func (s *Store) EndSession(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, token)
+}
@@ -1,21 +1,31 @@
 package errors
 
-import "net/http"
+import (
+	"net/http"
+
+	"brian-nunez/bcode/internal/jobs"
+)
 
 type ErrorType string
 
 const (
 	ErrInvalidRequest      ErrorType = "INVALID_REQUEST"
 	ErrUnauthorized        ErrorType = "UNAUTHORIZED"
+	ErrForbidden           ErrorType = "FORBIDDEN"
 	ErrNotFound            ErrorType = "NOT_FOUND"
 	ErrNotAllowed          ErrorType = "NOT_ALLOWED"
+	ErrQuotaExceeded       ErrorType = "QUOTA_EXCEEDED"
+	ErrRateLimited         ErrorType = "RATE_LIMITED"
+	ErrProfileInUse        ErrorType = "PROFILE_IN_USE"
 	ErrInternalServerError ErrorType = "INTERNAL_SERVER_ERROR"
 	ErrServiceUnavailable  ErrorType = "SERVICE_UNAVAILABLE"
 )
 
 type ErrorMessage struct {
-	ErrorCode    string `json:"error_code"`
-	ErrorMessage string `json:"error_message"`
+	ErrorCode    string            `json:"error_code"`
+	ErrorMessage string            `json:"error_message"`
+	Retryable    bool              `json:"retryable,omitempty"`
+	Details      map[string]string `json:"details,omitempty"`
 }
 
 type ErrorResponse struct {
@@ -27,6 +37,8 @@ type errorBuilder struct {
 	httpStatusCode int
 	errorCode      string
 	message        string
+	retryable      bool
+	details        map[string]string
 }
 
 func (b *errorBuilder) WithStatusCode(code int) *errorBuilder {
@@ -44,12 +56,24 @@ func (b *errorBuilder) WithErrorCode(errorCode string) *errorBuilder {
 	return b
 }
 
+func (b *errorBuilder) WithRetryable(retryable bool) *errorBuilder {
+	b.retryable = retryable
+	return b
+}
+
+func (b *errorBuilder) WithDetails(details map[string]string) *errorBuilder {
+	b.details = details
+	return b
+}
+
 func (b *errorBuilder) Build() *ErrorResponse {
 	return &ErrorResponse{
 		HTTPStatusCode: b.httpStatusCode,
 		ErrorMessage: ErrorMessage{
 			ErrorCode:    b.errorCode,
 			ErrorMessage: b.message,
+			Retryable:    b.retryable,
+			Details:      b.details,
 		},
 	}
 }
@@ -74,6 +98,14 @@ func Unauthorized() *errorBuilder {
 	}
 }
 
+func Forbidden() *errorBuilder {
+	return &errorBuilder{
+		httpStatusCode: http.StatusForbidden,
+		errorCode:      string(ErrForbidden),
+		message:        "Forbidden",
+	}
+}
+
 func NotFound() *errorBuilder {
 	return &errorBuilder{
 		httpStatusCode: http.StatusNotFound,
@@ -90,6 +122,36 @@ func NotAllowed() *errorBuilder {
 	}
 }
 
+// QuotaExceeded refuses a job because its workspace has used up a quota.
+func QuotaExceeded() *errorBuilder {
+	return &errorBuilder{
+		httpStatusCode: http.StatusTooManyRequests,
+		errorCode:      string(ErrQuotaExceeded),
+		message:        "Quota Exceeded",
+	}
+}
+
+// RateLimited asks a caller to slow down; it may retry later.
+func RateLimited() *errorBuilder {
+	return &errorBuilder{
+		httpStatusCode: http.StatusTooManyRequests,
+		errorCode:      string(ErrRateLimited),
+		message:        "Rate Limited",
+		retryable:      true,
+	}
+}
+
+// ProfileInUse refuses to use a browser profile another job holds; it can be
+// retried once that job finishes.
+func ProfileInUse() *errorBuilder {
+	return &errorBuilder{
+		httpStatusCode: http.StatusConflict,
+		errorCode:      string(ErrProfileInUse),
+		message:        "Profile In Use",
+		retryable:      true,
+	}
+}
+
 func InternalServerError() *errorBuilder {
 	return &errorBuilder{
 		httpStatusCode: http.StatusInternalServerError,
@@ -112,10 +174,14 @@ func GenerateByStatusCode(code int) *errorBuilder {
 		return InvalidRequest()
 	case http.StatusUnauthorized:
 		return Unauthorized()
+	case http.StatusForbidden:
+		return Forbidden()
 	case http.StatusNotFound:
 		return NotAllowed()
 	case http.StatusMethodNotAllowed:
 		return NotAllowed()
+	case http.StatusTooManyRequests:
+		return RateLimited()
 	case http.StatusInternalServerError:
 		return InternalServerError()
 	case http.StatusServiceUnavailable:
@@ -124,3 +190,37 @@ func GenerateByStatusCode(code int) *errorBuilder {
 
 	return InternalServerError()
 }
+
+// jobFailureStatus maps job error codes to the status of API responses that
+// report them.
+var jobFailureStatus = map[jobs.ErrorCode]int{
+	jobs.ErrNavigationFailed: http.StatusBadGateway,
+	jobs.ErrTimeout:          http.StatusGatewayTimeout,
+	jobs.ErrLLMUnavailable:   http.StatusServiceUnavailable,
+	jobs.ErrLLMBadOutput:     http.StatusBadGateway,
+	jobs.ErrSelectorNotFound: http.StatusUnprocessableEntity,
+	jobs.ErrBlockedByPolicy:  http.StatusForbidden,
+	jobs.ErrContainerOOM:     http.StatusInternalServerError,
+	jobs.ErrPageError:        http.StatusUnprocessableEntity,
+	jobs.ErrWorkerFailed:     http.StatusInternalServerError,
+	jobs.ErrInvalidRequest:   http.StatusBadRequest,
+	jobs.ErrInternal:         http.StatusInternalServerError,
+	jobs.ErrQuotaExceeded:    http.StatusTooManyRequests,
+	jobs.ErrProfileInUse:     http.StatusConflict,
+}
+
+// JobFailure reports a failed job with its own error code, message,
+// retryability and details.
+func JobFailure(err *jobs.JobError) *errorBuilder {
+	status, ok := jobFailureStatus[err.Code]
+	if !ok {
+		status = http.StatusInternalServerError
+	}
+	return &errorBuilder{
+		httpStatusCode: status,
+		errorCode:      string(err.Code),
+		message:        err.Message,
+		retryable:      err.Retryable,
+		details:        err.Details,
+	}
+}
@@ -0,0 +1,107 @@
+package v1
+
+import (
+	stderrors "errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"brian-nunez/bcode/internal/artifacts"
+	"brian-nunez/bcode/internal/auth"
+	"brian-nunez/bcode/internal/handlers/errors"
+	"brian-nunez/bcode/internal/jobs"
+	"github.com/labstack/echo/v4"
+)
+
+// Artifacts are served straight from the store so they outlive the in-memory
+// job registry until their retention expires. Jobs the registry no longer
+// knows are checked against the workspace stored with their artifacts, and
+// artifacts without one are not served at all.
+
+func ListArtifactsHandler(c echo.Context) error {
+	store, err := artifacts.Default()
+	if err != nil {
+		response := errors.InternalServerError().Build()
+		return c.JSON(response.HTTPStatusCode, response)
+	}
+	if err := requireJobAccess(c, store); err != nil {
+		return artifactError(c, err)
+	}
+
+	list, err := store.List(c.Request().Context(), c.Param("id"))
+	if err != nil {
+		return artifactError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, list)
+}
+
+func GetArtifactHandler(c echo.Context) error {
+	store, err := artifacts.Default()
+	if err != nil {
+		response := errors.InternalServerError().Build()
+		return c.JSON(response.HTTPStatusCode, response)
+	}
+	if err := requireJobAccess(c, store); err != nil {
+		return artifactError(c, err)
+	}
+
+	body, artifact, err := store.Open(c.Request().Context(), c.Param("id"), c.Param("name"))
+	if err != nil {
+		return artifactError(c, err)
+	}
+	defer body.Close()
+
+	disposition := "attachment"
+	if artifacts.Inline(artifact.ContentType) {
+		disposition = "inline"
+	}
+	header := c.Response().Header()
+	header.Set("Content-Disposition", fmt.Sprintf("%s; filename=%q", disposition, artifact.Name))
+	header.Set("X-Content-Type-Options", "nosniff")
+	header.Set(echo.HeaderContentType, artifact.ContentType)
+
+	// Seekable artifacts support range requests, which video players need
+	if seeker, ok := body.(io.ReadSeeker); ok {
+		http.ServeContent(c.Response(), c.Request(), artifact.Name, artifact.CreatedAt, seeker)
+		return nil
+	}
+	if artifact.Size > 0 {
+		header.Set(echo.HeaderContentLength, fmt.Sprint(artifact.Size))
+	}
+
+	return c.Stream(http.StatusOK, artifact.ContentType, body)
+}
+
+// requireJobAccess answers ErrNotFound unless the caller sees the workspace
+// that owns the job's artifacts.
+func requireJobAccess(c echo.Context, store artifacts.Store) error {
+	workspace := ""
+	if job, ok := jobs.Default.Get(c.Param("id")); ok {
+		workspace = job.Workspace
+	} else {
+		owner, err := store.Owner(c.Request().Context(), c.Param("id"))
+		if err != nil {
+			return err
+		}
+		workspace = owner
+	}
+	if principal, _ := auth.PrincipalFrom(c); !principal.Sees(workspace) {
+		return artifacts.ErrNotFound
+	}
+	return nil
+}
+
+func artifactError(c echo.Context, err error) error {
+	switch {
+	case stderrors.Is(err, artifacts.ErrInvalidName), stderrors.Is(err, artifacts.ErrInvalidJob):
+		response := errors.InvalidRequest().WithMessage(err.Error()).Build()
+		return c.JSON(response.HTTPStatusCode, response)
+	case stderrors.Is(err, artifacts.ErrNotFound):
+		response := errors.NotFound().WithMessage("Artifact not found").Build()
+		return c.JSON(response.HTTPStatusCode, response)
+	}
+
+	response := errors.ServiceNotAvailable().WithMessage(err.Error()).Build()
+	return c.JSON(response.HTTPStatusCode, response)
+}
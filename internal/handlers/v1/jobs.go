@@ -0,0 +1,178 @@
+package v1
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"brian-nunez/bcode/internal/auth"
+	"brian-nunez/bcode/internal/handlers/errors"
+	"brian-nunez/bcode/internal/jobs"
+	"github.com/labstack/echo/v4"
+	"golang.org/x/net/websocket"
+)
+
+type respondRequest struct {
+	Decision string `json:"decision" form:"decision"`
+	Value    string `json:"value" form:"value"`
+}
+
+func ListJobsHandler(c echo.Context) error {
+	principal, _ := auth.PrincipalFrom(c)
+	list := []jobs.Job{}
+	for _, job := range jobs.Default.List() {
+		if principal.Sees(job.Workspace) {
+			list = append(list, job)
+		}
+	}
+	return c.JSON(http.StatusOK, list)
+}
+
+// visibleJob returns a job owned by the caller's workspace. Jobs of other
+// workspaces are reported as not found.
+func visibleJob(c echo.Context, id string) (jobs.Job, bool) {
+	job, ok := jobs.Default.Get(id)
+	if !ok {
+		return jobs.Job{}, false
+	}
+	principal, _ := auth.PrincipalFrom(c)
+	return job, principal.Sees(job.Workspace)
+}
+
+func GetJobHandler(c echo.Context) error {
+	job, ok := visibleJob(c, c.Param("id"))
+	if !ok {
+		response := errors.NotFound().WithMessage("Job not found").Build()
+		return c.JSON(response.HTTPStatusCode, response)
+	}
+
+	return c.JSON(http.StatusOK, job)
+}
+
+// RespondJobHandler answers a job that paused for an operator: an ask_user
+// question, an approval for a risky action, or a take-over hand-back.
+func RespondJobHandler(c echo.Context) error {
+	var req respondRequest
+	if err := c.Bind(&req); err != nil {
+		response := errors.InvalidRequest().Build()
+		return c.JSON(response.HTTPStatusCode, response)
+	}
+
+	job, ok := visibleJob(c, c.Param("id"))
+	if !ok {
+		response := errors.NotFound().WithMessage("Job not found").Build()
+		return c.JSON(response.HTTPStatusCode, response)
+	}
+	// A failed job will never ask again; say why it failed
+	if job.Status == jobs.StatusFailed && job.Error != nil {
+		response := errors.JobFailure(job.Error).Build()
+		return c.JSON(response.HTTPStatusCode, response)
+	}
+	if job.Status != jobs.StatusPaused || job.Prompt == nil {
+		response := errors.Custom().
+			WithStatusCode(http.StatusConflict).
+			WithErrorCode(string(errors.ErrInvalidRequest)).
+			WithMessage("Job is not waiting for input").
+			Build()
+		return c.JSON(response.HTTPStatusCode, response)
+	}
+
+	allowed := jobs.AllowedDecisions(job.Prompt.Kind)
+	if !slices.Contains(allowed, req.Decision) {
+		response := errors.InvalidRequest().
+			WithMessage(fmt.Sprintf("decision must be one of: %s", strings.Join(allowed, ", "))).
+			Build()
+		return c.JSON(response.HTTPStatusCode, response)
+	}
+
+	job, err := jobs.Default.Respond(job.ID, req.Decision, req.Value)
+	if err != nil {
+		response := errors.ServiceNotAvailable().WithMessage(err.Error()).Build()
+		return c.JSON(response.HTTPStatusCode, response)
+	}
+
+	return c.JSON(http.StatusOK, job)
+}
+
+// JobScreencastHandler upgrades to a WebSocket that sends the job's
+// screencast as binary JPEG messages. Frames a slow client cannot keep up
+// with are dropped.
+func JobScreencastHandler(c echo.Context) error {
+	if _, ok := visibleJob(c, c.Param("id")); !ok {
+		response := errors.NotFound().WithMessage("Job not found").Build()
+		return c.JSON(response.HTTPStatusCode, response)
+	}
+	frames, unwatch, err := jobs.Default.Watch(c.Param("id"))
+	if err != nil {
+		response := errors.NotFound().WithMessage("Job not found").Build()
+		return c.JSON(response.HTTPStatusCode, response)
+	}
+	defer unwatch()
+
+	websocket.Handler(func(ws *websocket.Conn) {
+		defer ws.Close()
+
+		// The client never sends anything; a read only returns once it goes away
+		closed := make(chan struct{})
+		go func() {
+			var discard []byte
+			for websocket.Message.Receive(ws, &discard) == nil {
+			}
+			close(closed)
+		}()
+
+		for {
+			select {
+			case <-closed:
+				return
+			case frame, ok := <-frames:
+				if !ok {
+					return
+				}
+				if err := websocket.Message.Send(ws, frame); err != nil {
+					return
+				}
+			}
+		}
+	}).ServeHTTP(c.Response(), c.Request())
+
+	return nil
+}
+
+// JobControlHandler upgrades to a WebSocket that forwards the operator's live
+// view input (mouse, keyboard, take-over requests) to the running worker.
+func JobControlHandler(c echo.Context) error {
+	id := c.Param("id")
+	if _, ok := visibleJob(c, id); !ok {
+		response := errors.NotFound().WithMessage("Job not found").Build()
+		return c.JSON(response.HTTPStatusCode, response)
+	}
+
+	websocket.Handler(func(ws *websocket.Conn) {
+		defer ws.Close()
+		for {
+			var msg jobs.ControlMessage
+			if err := websocket.JSON.Receive(ws, &msg); err != nil {
+				return
+			}
+
+			switch msg.Type {
+			case jobs.ControlInput:
+				if msg.Input == nil {
+					continue
+				}
+			case jobs.ControlTakeOver:
+			default:
+				continue
+			}
+
+			if err := jobs.Default.Send(id, msg); err != nil {
+				websocket.JSON.Send(ws, map[string]string{"error": err.Error()})
+				return
+			}
+		}
+	}).ServeHTTP(c.Response(), c.Request())
+
+	return nil
+}
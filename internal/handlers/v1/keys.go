@@ -0,0 +1,109 @@
+package v1
+
+import (
+	stderrors "errors"
+	"net/http"
+
+	"brian-nunez/bcode/internal/auth"
+	"brian-nunez/bcode/internal/handlers/errors"
+	"brian-nunez/bcode/internal/workspaces"
+	"github.com/labstack/echo/v4"
+)
+
+type createKeyRequest struct {
+	Name   string   `json:"name" form:"name"`
+	Scopes []string `json:"scopes" form:"scope"`
+	// Workspace defaults to the caller's; only operators can name another
+	Workspace string `json:"workspace" form:"workspace"`
+}
+
+type createKeyResponse struct {
+	auth.APIKey
+	// Key is only returned here; the server keeps its hash.
+	Key string `json:"key"`
+}
+
+func ListKeysHandler(c echo.Context) error {
+	store, err := auth.Default()
+	if err != nil {
+		response := errors.InternalServerError().Build()
+		return c.JSON(response.HTTPStatusCode, response)
+	}
+
+	principal, _ := auth.PrincipalFrom(c)
+	list := []auth.APIKey{}
+	for _, key := range store.Keys() {
+		if principal.Sees(key.Workspace) {
+			list = append(list, key)
+		}
+	}
+
+	return c.JSON(http.StatusOK, list)
+}
+
+func CreateKeyHandler(c echo.Context) error {
+	var req createKeyRequest
+	if err := c.Bind(&req); err != nil {
+		response := errors.InvalidRequest().Build()
+		return c.JSON(response.HTTPStatusCode, response)
+	}
+
+	store, err := auth.Default()
+	if err != nil {
+		response := errors.InternalServerError().Build()
+		return c.JSON(response.HTTPStatusCode, response)
+	}
+
+	principal, _ := auth.PrincipalFrom(c)
+	workspace, err := principal.TargetWorkspace(req.Workspace)
+	switch {
+	case stderrors.Is(err, auth.ErrOtherWorkspace):
+		response := errors.Forbidden().WithMessage(err.Error()).Build()
+		return c.JSON(response.HTTPStatusCode, response)
+	case stderrors.Is(err, workspaces.ErrNotFound):
+		response := errors.InvalidRequest().WithMessage(err.Error()).Build()
+		return c.JSON(response.HTTPStatusCode, response)
+	case err != nil:
+		response := errors.InternalServerError().Build()
+		return c.JSON(response.HTTPStatusCode, response)
+	}
+
+	key, secret, err := store.CreateKey(req.Name, req.Scopes, workspace, principal.Subject())
+	switch {
+	case err == nil:
+		return c.JSON(http.StatusCreated, createKeyResponse{APIKey: key, Key: secret})
+	case stderrors.Is(err, auth.ErrInvalidName), stderrors.Is(err, auth.ErrInvalidScope), stderrors.Is(err, auth.ErrNoScopes):
+		response := errors.InvalidRequest().WithMessage(err.Error()).Build()
+		return c.JSON(response.HTTPStatusCode, response)
+	}
+
+	response := errors.InternalServerError().Build()
+	return c.JSON(response.HTTPStatusCode, response)
+}
+
+// RevokeKeyHandler revokes a key. Revoked keys stay listed.
+func RevokeKeyHandler(c echo.Context) error {
+	store, err := auth.Default()
+	if err != nil {
+		response := errors.InternalServerError().Build()
+		return c.JSON(response.HTTPStatusCode, response)
+	}
+
+	principal, _ := auth.PrincipalFrom(c)
+	if key, ok := store.Key(c.Param("id")); !ok || !principal.Sees(key.Workspace) {
+		response := errors.NotFound().WithMessage("API key not found").Build()
+		return c.JSON(response.HTTPStatusCode, response)
+	}
+
+	_, err = store.RevokeKey(c.Param("id"))
+	switch {
+	case err == nil:
+		return c.NoContent(http.StatusNoContent)
+	case stderrors.Is(err, auth.ErrNotFound):
+		response := errors.NotFound().WithMessage("API key not found").Build()
+		return c.JSON(response.HTTPStatusCode, response)
+	}
+
+	response := errors.InternalServerError().Build()
+	return c.JSON(response.HTTPStatusCode, response)
+}
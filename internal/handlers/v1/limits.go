@@ -0,0 +1,54 @@
+package v1
+
+import (
+	"net/http"
+
+	"brian-nunez/bcode/internal/handlers/errors"
+	"brian-nunez/bcode/internal/orchestrator"
+	"brian-nunez/bcode/internal/ratelimit"
+	"github.com/labstack/echo/v4"
+)
+
+type limitsResponse struct {
+	Config      ratelimit.Config        `json:"config"`
+	Submissions []ratelimit.BucketState `json:"submissions"`
+	Sites       []ratelimit.SiteState   `json:"sites"`
+	Queued      int                     `json:"queued"`
+}
+
+// LimitsHandler shows the rate limits in force, the submission buckets of
+// recent callers, how busy recently used sites are and how many jobs are
+// queued.
+func LimitsHandler(c echo.Context) error {
+	if response := requireOperator(c); response != nil {
+		return c.JSON(response.HTTPStatusCode, response)
+	}
+
+	config, err := ratelimit.DefaultConfig()
+	if err != nil {
+		response := errors.InternalServerError().WithMessage(err.Error()).Build()
+		return c.JSON(response.HTTPStatusCode, response)
+	}
+	submissions, err := ratelimit.DefaultSubmissions()
+	if err != nil {
+		response := errors.InternalServerError().Build()
+		return c.JSON(response.HTTPStatusCode, response)
+	}
+	sites, err := ratelimit.DefaultSites()
+	if err != nil {
+		response := errors.InternalServerError().Build()
+		return c.JSON(response.HTTPStatusCode, response)
+	}
+	queue, err := orchestrator.DefaultQueue()
+	if err != nil {
+		response := errors.InternalServerError().Build()
+		return c.JSON(response.HTTPStatusCode, response)
+	}
+
+	return c.JSON(http.StatusOK, limitsResponse{
+		Config:      config,
+		Submissions: submissions.State(),
+		Sites:       sites.State(),
+		Queued:      queue.Waiting(),
+	})
+}
@@ -0,0 +1,167 @@
+package v1
+
+import (
+	stderrors "errors"
+	"net/http"
+
+	"brian-nunez/bcode/internal/auth"
+	"brian-nunez/bcode/internal/handlers/errors"
+	"brian-nunez/bcode/internal/monitors"
+	"brian-nunez/bcode/internal/scheduler"
+	"github.com/labstack/echo/v4"
+)
+
+func ListMonitorsHandler(c echo.Context) error {
+	store, err := monitors.Default()
+	if err != nil {
+		response := errors.InternalServerError().Build()
+		return c.JSON(response.HTTPStatusCode, response)
+	}
+
+	principal, _ := auth.PrincipalFrom(c)
+	list := []monitors.Monitor{}
+	for _, monitor := range store.List() {
+		if principal.Sees(monitor.Workspace) {
+			list = append(list, monitor)
+		}
+	}
+
+	return c.JSON(http.StatusOK, list)
+}
+
+// CreateMonitorHandler adds a monitor of one of the workspace's schedules to
+// the caller's workspace, or to the one an operator names in the workspace
+// query parameter.
+func CreateMonitorHandler(c echo.Context) error {
+	var spec monitors.Spec
+	if err := c.Bind(&spec); err != nil {
+		response := errors.InvalidRequest().Build()
+		return c.JSON(response.HTTPStatusCode, response)
+	}
+
+	workspace, failure := requestWorkspace(c)
+	if failure != nil {
+		return c.JSON(failure.HTTPStatusCode, failure)
+	}
+	if failure := checkMonitoredSchedule(workspace, spec.ScheduleID); failure != nil {
+		return c.JSON(failure.HTTPStatusCode, failure)
+	}
+	store, err := monitors.Default()
+	if err != nil {
+		response := errors.InternalServerError().Build()
+		return c.JSON(response.HTTPStatusCode, response)
+	}
+
+	principal, _ := auth.PrincipalFrom(c)
+	monitor, err := store.Create(workspace, principal.Subject(), spec)
+	if err != nil {
+		return monitorFailure(c, err)
+	}
+	return c.JSON(http.StatusCreated, monitor)
+}
+
+func GetMonitorHandler(c echo.Context) error {
+	_, monitor, failure := visibleMonitor(c)
+	if failure != nil {
+		return c.JSON(failure.HTTPStatusCode, failure)
+	}
+
+	return c.JSON(http.StatusOK, monitor)
+}
+
+// UpdateMonitorHandler replaces a monitor's settings. Its changes are kept,
+// but changing what it compares makes the next run a new baseline.
+func UpdateMonitorHandler(c echo.Context) error {
+	var spec monitors.Spec
+	if err := c.Bind(&spec); err != nil {
+		response := errors.InvalidRequest().Build()
+		return c.JSON(response.HTTPStatusCode, response)
+	}
+
+	store, monitor, failure := visibleMonitor(c)
+	if failure != nil {
+		return c.JSON(failure.HTTPStatusCode, failure)
+	}
+	if failure := checkMonitoredSchedule(monitor.Workspace, spec.ScheduleID); failure != nil {
+		return c.JSON(failure.HTTPStatusCode, failure)
+	}
+
+	monitor, err := store.Update(monitor.ID, spec)
+	if err != nil {
+		return monitorFailure(c, err)
+	}
+	return c.JSON(http.StatusOK, monitor)
+}
+
+func DeleteMonitorHandler(c echo.Context) error {
+	store, monitor, failure := visibleMonitor(c)
+	if failure != nil {
+		return c.JSON(failure.HTTPStatusCode, failure)
+	}
+
+	if err := store.Delete(monitor.ID); err != nil {
+		return monitorFailure(c, err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// ListMonitorChangesHandler returns the changes a monitor detected, newest
+// first, with their diffs.
+func ListMonitorChangesHandler(c echo.Context) error {
+	store, monitor, failure := visibleMonitor(c)
+	if failure != nil {
+		return c.JSON(failure.HTTPStatusCode, failure)
+	}
+
+	changes, err := store.Changes(monitor.ID)
+	if err != nil {
+		return monitorFailure(c, err)
+	}
+	return c.JSON(http.StatusOK, changes)
+}
+
+// checkMonitoredSchedule checks that a monitor's schedule is in the
+// monitor's workspace.
+func checkMonitoredSchedule(workspace, scheduleID string) *errors.ErrorResponse {
+	schedules, err := scheduler.Default()
+	if err != nil {
+		return errors.InternalServerError().Build()
+	}
+	schedule, err := schedules.Get(scheduleID)
+	if err != nil || schedule.Workspace != workspace {
+		return errors.InvalidRequest().WithMessage("The schedule to monitor was not found in the workspace").Build()
+	}
+	return nil
+}
+
+// visibleMonitor finds the monitor named by the id parameter, answering 404
+// for those of other workspaces.
+func visibleMonitor(c echo.Context) (*monitors.Store, monitors.Monitor, *errors.ErrorResponse) {
+	store, err := monitors.Default()
+	if err != nil {
+		return nil, monitors.Monitor{}, errors.InternalServerError().Build()
+	}
+
+	monitor, err := store.Get(c.Param("id"))
+	principal, _ := auth.PrincipalFrom(c)
+	if err != nil || !principal.Sees(monitor.Workspace) {
+		return nil, monitors.Monitor{}, errors.NotFound().WithMessage("Monitor not found").Build()
+	}
+	return store, monitor, nil
+}
+
+func monitorFailure(c echo.Context, err error) error {
+	switch {
+	case stderrors.Is(err, monitors.ErrNotFound):
+		response := errors.NotFound().WithMessage("Monitor not found").Build()
+		return c.JSON(response.HTTPStatusCode, response)
+	case stderrors.Is(err, monitors.ErrInvalidName), stderrors.Is(err, monitors.ErrInvalidCompare),
+		stderrors.Is(err, monitors.ErrInvalidFields), stderrors.Is(err, monitors.ErrInvalidThreshold),
+		stderrors.Is(err, monitors.ErrInvalidNotifyURL):
+		response := errors.InvalidRequest().WithMessage(err.Error()).Build()
+		return c.JSON(response.HTTPStatusCode, response)
+	}
+
+	response := errors.InternalServerError().Build()
+	return c.JSON(response.HTTPStatusCode, response)
+}
@@ -0,0 +1,60 @@
+package v1
+
+import (
+	stderrors "errors"
+	"net/http"
+
+	"brian-nunez/bcode/internal/handlers/errors"
+	"brian-nunez/bcode/internal/profiles"
+	"github.com/labstack/echo/v4"
+)
+
+func ListProfilesHandler(c echo.Context) error {
+	workspace, failure := requestWorkspace(c)
+	if failure != nil {
+		return c.JSON(failure.HTTPStatusCode, failure)
+	}
+	store, err := profiles.ForWorkspace(workspace)
+	if err != nil {
+		response := errors.InternalServerError().Build()
+		return c.JSON(response.HTTPStatusCode, response)
+	}
+
+	list, err := store.List()
+	if err != nil {
+		response := errors.InternalServerError().Build()
+		return c.JSON(response.HTTPStatusCode, response)
+	}
+
+	return c.JSON(http.StatusOK, list)
+}
+
+func DeleteProfileHandler(c echo.Context) error {
+	workspace, failure := requestWorkspace(c)
+	if failure != nil {
+		return c.JSON(failure.HTTPStatusCode, failure)
+	}
+	store, err := profiles.ForWorkspace(workspace)
+	if err != nil {
+		response := errors.InternalServerError().Build()
+		return c.JSON(response.HTTPStatusCode, response)
+	}
+
+	err = store.Delete(c.Param("name"))
+	switch {
+	case err == nil:
+		return c.NoContent(http.StatusNoContent)
+	case stderrors.Is(err, profiles.ErrInvalidName):
+		response := errors.InvalidRequest().WithMessage(err.Error()).Build()
+		return c.JSON(response.HTTPStatusCode, response)
+	case stderrors.Is(err, profiles.ErrNotFound):
+		response := errors.NotFound().WithMessage("Profile not found").Build()
+		return c.JSON(response.HTTPStatusCode, response)
+	case stderrors.Is(err, profiles.ErrLocked):
+		response := errors.ProfileInUse().WithMessage(err.Error()).Build()
+		return c.JSON(response.HTTPStatusCode, response)
+	}
+
+	response := errors.InternalServerError().Build()
+	return c.JSON(response.HTTPStatusCode, response)
+}
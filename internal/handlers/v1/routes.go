@@ -1,17 +1,78 @@
 package v1
 
 import (
+	"brian-nunez/bcode/internal/auth"
 	uihandlers "brian-nunez/bcode/internal/handlers/v1/ui"
+	"brian-nunez/bcode/internal/ratelimit"
 	"github.com/labstack/echo/v4"
 )
 
 func RegisterRoutes(e *echo.Echo) {
-	e.GET("/", uihandlers.HomeHandler)
-	e.GET("/scrape", uihandlers.ScrapePageHandler)
-	e.GET("/describe", uihandlers.DescribePageHandler)
-	e.GET("/ai-actions", uihandlers.AIActionsPageHandler)
-	e.POST("/execute", uihandlers.ExecuteJobHandler)
+	// Pages send visitors to the login page; everything else answers 401/403
+	page := auth.RequirePage
+	scope := auth.RequireScope
+
+	e.GET("/login", uihandlers.LoginPageHandler)
+	e.POST("/login", uihandlers.LoginHandler)
+	e.POST("/logout", uihandlers.LogoutHandler)
+
+	e.GET("/", uihandlers.HomeHandler, page(auth.ScopeRead))
+	e.GET("/scrape", uihandlers.ScrapePageHandler, page(auth.ScopeRead))
+	e.GET("/describe", uihandlers.DescribePageHandler, page(auth.ScopeRead))
+	e.GET("/ai-actions", uihandlers.AIActionsPageHandler, page(auth.ScopeRead))
+	e.POST("/execute", uihandlers.ExecuteJobHandler, scope(auth.ScopeSubmit), ratelimit.LimitSubmissions())
+	e.GET("/schedules", uihandlers.SchedulesPageHandler, page(auth.ScopeRead))
+	e.POST("/schedules", uihandlers.CreateScheduleHandler, page(auth.ScopeSubmit))
+	e.POST("/schedules/:id/pause", uihandlers.PauseScheduleHandler, page(auth.ScopeSubmit))
+	e.POST("/schedules/:id/resume", uihandlers.ResumeScheduleHandler, page(auth.ScopeSubmit))
+	e.POST("/schedules/:id/delete", uihandlers.DeleteScheduleHandler, page(auth.ScopeSubmit))
+	e.GET("/monitors", uihandlers.MonitorsPageHandler, page(auth.ScopeRead))
+	e.POST("/monitors", uihandlers.CreateMonitorHandler, page(auth.ScopeSubmit))
+	e.POST("/monitors/:id/delete", uihandlers.DeleteMonitorHandler, page(auth.ScopeSubmit))
+	e.GET("/secrets", uihandlers.SecretsPageHandler, page(auth.ScopeAdmin))
+	e.POST("/secrets", uihandlers.SaveSecretHandler, page(auth.ScopeAdmin))
+	e.POST("/secrets/:name/delete", uihandlers.DeleteSecretHandler, page(auth.ScopeAdmin))
+	e.GET("/admin", uihandlers.AdminPageHandler, page(auth.ScopeAdmin))
+	e.POST("/admin/keys", uihandlers.CreateKeyHandler, page(auth.ScopeAdmin))
+	e.POST("/admin/keys/:id/revoke", uihandlers.RevokeKeyHandler, page(auth.ScopeAdmin))
 
 	v1Group := e.Group("/api/v1")
 	v1Group.GET("/health", HealthHandler)
+	v1Group.GET("/jobs", ListJobsHandler, scope(auth.ScopeRead))
+	v1Group.GET("/jobs/:id", GetJobHandler, scope(auth.ScopeRead))
+	v1Group.POST("/jobs/:id/respond", RespondJobHandler, scope(auth.ScopeSubmit))
+	v1Group.GET("/jobs/:id/control", JobControlHandler, scope(auth.ScopeSubmit))
+	v1Group.GET("/jobs/:id/screencast", JobScreencastHandler, scope(auth.ScopeRead))
+	v1Group.GET("/jobs/:id/artifacts", ListArtifactsHandler, scope(auth.ScopeRead))
+	v1Group.GET("/jobs/:id/artifacts/:name", GetArtifactHandler, scope(auth.ScopeRead))
+	v1Group.GET("/profiles", ListProfilesHandler, scope(auth.ScopeRead))
+	v1Group.DELETE("/profiles/:name", DeleteProfileHandler, scope(auth.ScopeAdmin))
+	v1Group.GET("/secrets", ListSecretsHandler, scope(auth.ScopeAdmin))
+	v1Group.PUT("/secrets/:name", PutSecretHandler, scope(auth.ScopeAdmin))
+	v1Group.DELETE("/secrets/:name", DeleteSecretHandler, scope(auth.ScopeAdmin))
+	v1Group.GET("/keys", ListKeysHandler, scope(auth.ScopeAdmin))
+	v1Group.POST("/keys", CreateKeyHandler, scope(auth.ScopeAdmin))
+	v1Group.DELETE("/keys/:id", RevokeKeyHandler, scope(auth.ScopeAdmin))
+	v1Group.GET("/workspaces", ListWorkspacesHandler, scope(auth.ScopeRead))
+	v1Group.PUT("/workspaces/:name", PutWorkspaceHandler, scope(auth.ScopeAdmin))
+	v1Group.DELETE("/workspaces/:name", DeleteWorkspaceHandler, scope(auth.ScopeAdmin))
+	v1Group.GET("/limits", LimitsHandler, scope(auth.ScopeAdmin))
+	v1Group.GET("/schedules", ListSchedulesHandler, scope(auth.ScopeRead))
+	v1Group.POST("/schedules", CreateScheduleHandler, scope(auth.ScopeSubmit))
+	v1Group.GET("/schedules/:id", GetScheduleHandler, scope(auth.ScopeRead))
+	v1Group.PUT("/schedules/:id", UpdateScheduleHandler, scope(auth.ScopeSubmit))
+	v1Group.DELETE("/schedules/:id", DeleteScheduleHandler, scope(auth.ScopeSubmit))
+	v1Group.POST("/schedules/:id/pause", PauseScheduleHandler, scope(auth.ScopeSubmit))
+	v1Group.POST("/schedules/:id/resume", ResumeScheduleHandler, scope(auth.ScopeSubmit))
+	v1Group.GET("/schedules/:id/runs", ListScheduleRunsHandler, scope(auth.ScopeRead))
+	v1Group.GET("/monitors", ListMonitorsHandler, scope(auth.ScopeRead))
+	v1Group.POST("/monitors", CreateMonitorHandler, scope(auth.ScopeSubmit))
+	v1Group.GET("/monitors/:id", GetMonitorHandler, scope(auth.ScopeRead))
+	v1Group.PUT("/monitors/:id", UpdateMonitorHandler, scope(auth.ScopeSubmit))
+	v1Group.DELETE("/monitors/:id", DeleteMonitorHandler, scope(auth.ScopeSubmit))
+	v1Group.GET("/monitors/:id/changes", ListMonitorChangesHandler, scope(auth.ScopeRead))
+	v1Group.GET("/webhooks", ListWebhooksHandler, scope(auth.ScopeAdmin))
+	v1Group.POST("/webhooks", CreateWebhookHandler, scope(auth.ScopeAdmin))
+	v1Group.GET("/webhooks/deliveries", ListWebhookDeliveriesHandler, scope(auth.ScopeAdmin))
+	v1Group.DELETE("/webhooks/:id", DeleteWebhookHandler, scope(auth.ScopeAdmin))
 }
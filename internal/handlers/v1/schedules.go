@@ -0,0 +1,194 @@
+package v1
+
+import (
+	stderrors "errors"
+	"net/http"
+
+	"brian-nunez/bcode/internal/auth"
+	"brian-nunez/bcode/internal/handlers/errors"
+	"brian-nunez/bcode/internal/scheduler"
+	"github.com/labstack/echo/v4"
+)
+
+type scheduleRequest struct {
+	Name       string            `json:"name"`
+	Cron       string            `json:"cron"`
+	TimeZone   string            `json:"time_zone"`
+	Template   map[string]string `json:"template"`
+	MissedRuns string            `json:"missed_runs"`
+	Overlap    string            `json:"overlap"`
+	// Enabled defaults to true for new schedules and to unchanged on updates
+	Enabled *bool `json:"enabled"`
+}
+
+func (r scheduleRequest) spec(enabled bool) scheduler.Spec {
+	if r.Enabled != nil {
+		enabled = *r.Enabled
+	}
+	return scheduler.Spec{
+		Name:       r.Name,
+		Cron:       r.Cron,
+		TimeZone:   r.TimeZone,
+		Template:   r.Template,
+		Enabled:    enabled,
+		MissedRuns: r.MissedRuns,
+		Overlap:    r.Overlap,
+	}
+}
+
+func ListSchedulesHandler(c echo.Context) error {
+	store, err := scheduler.Default()
+	if err != nil {
+		response := errors.InternalServerError().Build()
+		return c.JSON(response.HTTPStatusCode, response)
+	}
+
+	principal, _ := auth.PrincipalFrom(c)
+	list := []scheduler.Schedule{}
+	for _, schedule := range store.List() {
+		if principal.Sees(schedule.Workspace) {
+			list = append(list, schedule)
+		}
+	}
+
+	return c.JSON(http.StatusOK, list)
+}
+
+// CreateScheduleHandler adds a schedule to the caller's workspace, or to the
+// one an operator names in the workspace query parameter.
+func CreateScheduleHandler(c echo.Context) error {
+	var req scheduleRequest
+	if err := c.Bind(&req); err != nil {
+		response := errors.InvalidRequest().Build()
+		return c.JSON(response.HTTPStatusCode, response)
+	}
+
+	workspace, failure := requestWorkspace(c)
+	if failure != nil {
+		return c.JSON(failure.HTTPStatusCode, failure)
+	}
+	store, err := scheduler.Default()
+	if err != nil {
+		response := errors.InternalServerError().Build()
+		return c.JSON(response.HTTPStatusCode, response)
+	}
+
+	principal, _ := auth.PrincipalFrom(c)
+	schedule, err := store.Create(workspace, principal.Subject(), req.spec(true))
+	if err != nil {
+		return scheduleFailure(c, err)
+	}
+	return c.JSON(http.StatusCreated, schedule)
+}
+
+func GetScheduleHandler(c echo.Context) error {
+	_, schedule, failure := visibleSchedule(c)
+	if failure != nil {
+		return c.JSON(failure.HTTPStatusCode, failure)
+	}
+
+	return c.JSON(http.StatusOK, schedule)
+}
+
+// UpdateScheduleHandler replaces a schedule's settings. Its history is kept.
+func UpdateScheduleHandler(c echo.Context) error {
+	var req scheduleRequest
+	if err := c.Bind(&req); err != nil {
+		response := errors.InvalidRequest().Build()
+		return c.JSON(response.HTTPStatusCode, response)
+	}
+
+	store, schedule, failure := visibleSchedule(c)
+	if failure != nil {
+		return c.JSON(failure.HTTPStatusCode, failure)
+	}
+
+	schedule, err := store.Update(schedule.ID, req.spec(schedule.Enabled))
+	if err != nil {
+		return scheduleFailure(c, err)
+	}
+	return c.JSON(http.StatusOK, schedule)
+}
+
+func PauseScheduleHandler(c echo.Context) error {
+	return setScheduleEnabled(c, false)
+}
+
+// ResumeScheduleHandler enables a schedule from its next run on; runs due
+// while it was paused are not made up for.
+func ResumeScheduleHandler(c echo.Context) error {
+	return setScheduleEnabled(c, true)
+}
+
+func setScheduleEnabled(c echo.Context, enabled bool) error {
+	store, schedule, failure := visibleSchedule(c)
+	if failure != nil {
+		return c.JSON(failure.HTTPStatusCode, failure)
+	}
+
+	schedule, err := store.SetEnabled(schedule.ID, enabled)
+	if err != nil {
+		return scheduleFailure(c, err)
+	}
+	return c.JSON(http.StatusOK, schedule)
+}
+
+// DeleteScheduleHandler deletes a schedule and its history. Jobs it started
+// are kept.
+func DeleteScheduleHandler(c echo.Context) error {
+	store, schedule, failure := visibleSchedule(c)
+	if failure != nil {
+		return c.JSON(failure.HTTPStatusCode, failure)
+	}
+
+	if err := store.Delete(schedule.ID); err != nil {
+		return scheduleFailure(c, err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// ListScheduleRunsHandler returns a schedule's runs, newest first, with the
+// IDs of the jobs they started.
+func ListScheduleRunsHandler(c echo.Context) error {
+	store, schedule, failure := visibleSchedule(c)
+	if failure != nil {
+		return c.JSON(failure.HTTPStatusCode, failure)
+	}
+
+	runs, err := store.Runs(schedule.ID)
+	if err != nil {
+		return scheduleFailure(c, err)
+	}
+	return c.JSON(http.StatusOK, runs)
+}
+
+// visibleSchedule finds the schedule named by the id parameter, answering
+// 404 for those of other workspaces.
+func visibleSchedule(c echo.Context) (*scheduler.Store, scheduler.Schedule, *errors.ErrorResponse) {
+	store, err := scheduler.Default()
+	if err != nil {
+		return nil, scheduler.Schedule{}, errors.InternalServerError().Build()
+	}
+
+	schedule, err := store.Get(c.Param("id"))
+	principal, _ := auth.PrincipalFrom(c)
+	if err != nil || !principal.Sees(schedule.Workspace) {
+		return nil, scheduler.Schedule{}, errors.NotFound().WithMessage("Schedule not found").Build()
+	}
+	return store, schedule, nil
+}
+
+func scheduleFailure(c echo.Context, err error) error {
+	switch {
+	case stderrors.Is(err, scheduler.ErrNotFound):
+		response := errors.NotFound().WithMessage("Schedule not found").Build()
+		return c.JSON(response.HTTPStatusCode, response)
+	case stderrors.Is(err, scheduler.ErrInvalidName), stderrors.Is(err, scheduler.ErrInvalidCron),
+		stderrors.Is(err, scheduler.ErrInvalidTemplate), stderrors.Is(err, scheduler.ErrInvalidPolicy):
+		response := errors.InvalidRequest().WithMessage(err.Error()).Build()
+		return c.JSON(response.HTTPStatusCode, response)
+	}
+
+	response := errors.InternalServerError().Build()
+	return c.JSON(response.HTTPStatusCode, response)
+}
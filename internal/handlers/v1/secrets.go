@@ -0,0 +1,89 @@
+package v1
+
+import (
+	stderrors "errors"
+	"net/http"
+
+	"brian-nunez/bcode/internal/handlers/errors"
+	"brian-nunez/bcode/internal/secrets"
+	"github.com/labstack/echo/v4"
+)
+
+type secretRequest struct {
+	Value string `json:"value" form:"value"`
+}
+
+func ListSecretsHandler(c echo.Context) error {
+	workspace, failure := requestWorkspace(c)
+	if failure != nil {
+		return c.JSON(failure.HTTPStatusCode, failure)
+	}
+	store, err := secrets.ForWorkspace(workspace)
+	if err != nil {
+		response := errors.InternalServerError().Build()
+		return c.JSON(response.HTTPStatusCode, response)
+	}
+
+	list, err := store.List()
+	if err != nil {
+		response := errors.InternalServerError().Build()
+		return c.JSON(response.HTTPStatusCode, response)
+	}
+
+	return c.JSON(http.StatusOK, list)
+}
+
+// PutSecretHandler creates or replaces a secret. The value is never returned.
+func PutSecretHandler(c echo.Context) error {
+	var req secretRequest
+	if err := c.Bind(&req); err != nil {
+		response := errors.InvalidRequest().Build()
+		return c.JSON(response.HTTPStatusCode, response)
+	}
+
+	workspace, failure := requestWorkspace(c)
+	if failure != nil {
+		return c.JSON(failure.HTTPStatusCode, failure)
+	}
+	store, err := secrets.ForWorkspace(workspace)
+	if err != nil {
+		response := errors.InternalServerError().Build()
+		return c.JSON(response.HTTPStatusCode, response)
+	}
+
+	secret, err := store.Set(c.Param("name"), req.Value)
+	switch {
+	case err == nil:
+		return c.JSON(http.StatusOK, secret)
+	case stderrors.Is(err, secrets.ErrInvalidName), stderrors.Is(err, secrets.ErrEmptyValue):
+		response := errors.InvalidRequest().WithMessage(err.Error()).Build()
+		return c.JSON(response.HTTPStatusCode, response)
+	}
+
+	response := errors.InternalServerError().Build()
+	return c.JSON(response.HTTPStatusCode, response)
+}
+
+func DeleteSecretHandler(c echo.Context) error {
+	workspace, failure := requestWorkspace(c)
+	if failure != nil {
+		return c.JSON(failure.HTTPStatusCode, failure)
+	}
+	store, err := secrets.ForWorkspace(workspace)
+	if err != nil {
+		response := errors.InternalServerError().Build()
+		return c.JSON(response.HTTPStatusCode, response)
+	}
+
+	err = store.Delete(c.Param("name"))
+	switch {
+	case err == nil:
+		return c.NoContent(http.StatusNoContent)
+	case stderrors.Is(err, secrets.ErrNotFound):
+		response := errors.NotFound().WithMessage("Secret not found").Build()
+		return c.JSON(response.HTTPStatusCode, response)
+	}
+
+	response := errors.InternalServerError().Build()
+	return c.JSON(response.HTTPStatusCode, response)
+}
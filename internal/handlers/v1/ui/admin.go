@@ -0,0 +1,100 @@
+package uihandlers
+
+import (
+	"context"
+	"net/http"
+
+	"brian-nunez/bcode/internal/auth"
+	"brian-nunez/bcode/internal/workspaces"
+	"brian-nunez/bcode/views/pages"
+	"github.com/labstack/echo/v4"
+)
+
+func AdminPageHandler(c echo.Context) error {
+	return renderAdminPage(c, http.StatusOK, nil, "")
+}
+
+func CreateKeyHandler(c echo.Context) error {
+	store, err := auth.Default()
+	if err != nil {
+		return renderAdminPage(c, http.StatusInternalServerError, nil, err.Error())
+	}
+
+	form, err := c.FormParams()
+	if err != nil {
+		return renderAdminPage(c, http.StatusBadRequest, nil, err.Error())
+	}
+
+	principal, _ := auth.PrincipalFrom(c)
+	workspace, err := principal.TargetWorkspace(form.Get("workspace"))
+	if err != nil {
+		return renderAdminPage(c, http.StatusBadRequest, nil, err.Error())
+	}
+	key, secret, err := store.CreateKey(form.Get("name"), form["scope"], workspace, principal.Subject())
+	if err != nil {
+		return renderAdminPage(c, http.StatusBadRequest, nil, err.Error())
+	}
+
+	// The key is only ever shown here, so render instead of redirecting
+	return renderAdminPage(c, http.StatusCreated, &pages.NewAPIKey{Name: key.Name, Secret: secret}, "")
+}
+
+func RevokeKeyHandler(c echo.Context) error {
+	store, err := auth.Default()
+	if err != nil {
+		return renderAdminPage(c, http.StatusInternalServerError, nil, err.Error())
+	}
+
+	principal, _ := auth.PrincipalFrom(c)
+	if key, ok := store.Key(c.Param("id")); !ok || !principal.Sees(key.Workspace) {
+		return renderAdminPage(c, http.StatusNotFound, nil, auth.ErrNotFound.Error())
+	}
+	if _, err := store.RevokeKey(c.Param("id")); err != nil {
+		return renderAdminPage(c, http.StatusInternalServerError, nil, err.Error())
+	}
+
+	return c.Redirect(http.StatusSeeOther, "/admin")
+}
+
+func renderAdminPage(c echo.Context, status int, created *pages.NewAPIKey, errorMessage string) error {
+	principal, _ := auth.PrincipalFrom(c)
+
+	var rows []pages.APIKeyRow
+	if store, err := auth.Default(); err != nil {
+		errorMessage = err.Error()
+	} else {
+		for _, key := range store.Keys() {
+			if !principal.Sees(key.Workspace) {
+				continue
+			}
+			row := pages.APIKeyRow{
+				ID:        key.ID,
+				Name:      key.Name,
+				Prefix:    key.Prefix,
+				Scopes:    key.Scopes,
+				Workspace: key.Workspace,
+				CreatedBy: key.CreatedBy,
+				CreatedAt: key.CreatedAt.Format("2006-01-02 15:04"),
+				LastUsed:  "never",
+				Revoked:   key.RevokedAt != nil,
+			}
+			if key.LastUsedAt != nil {
+				row.LastUsed = key.LastUsedAt.Format("2006-01-02 15:04")
+			}
+			rows = append(rows, row)
+		}
+	}
+
+	var names []string
+	if principal.Operator() {
+		if store, err := workspaces.Default(); err == nil {
+			for _, workspace := range store.List() {
+				names = append(names, workspace.Name)
+			}
+		}
+	}
+
+	c.Response().Header().Set(echo.HeaderContentType, echo.MIMETextHTMLCharsetUTF8)
+	c.Response().WriteHeader(status)
+	return pages.AdminPage(rows, auth.Scopes, names, created, errorMessage).Render(context.Background(), c.Response().Writer)
+}
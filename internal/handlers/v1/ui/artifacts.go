@@ -0,0 +1,185 @@
+package uihandlers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"brian-nunez/bcode/internal/artifacts"
+	"brian-nunez/bcode/internal/jobs"
+	"brian-nunez/bcode/views/execution"
+)
+
+type artifactChunk struct {
+	Name  string `json:"name"`
+	Data  []byte `json:"data"`
+	Final bool   `json:"final"`
+}
+
+// artifactReceiver reassembles the JOB_ARTIFACT chunks a worker streams on
+// stdout into temporary files and hands each finished file to the store.
+type artifactReceiver struct {
+	jobID   string
+	store   artifacts.Store
+	pending map[string]*os.File
+}
+
+func newArtifactReceiver(jobID string, store artifacts.Store) *artifactReceiver {
+	return &artifactReceiver{
+		jobID:   jobID,
+		store:   store,
+		pending: make(map[string]*os.File),
+	}
+}
+
+// receive appends a chunk and returns the stored artifact once the final
+// chunk has arrived.
+func (r *artifactReceiver) receive(ctx context.Context, chunk artifactChunk) (*artifacts.Artifact, error) {
+	if err := artifacts.ValidateName(chunk.Name); err != nil {
+		return nil, err
+	}
+
+	f, ok := r.pending[chunk.Name]
+	if !ok {
+		var err error
+		if f, err = os.CreateTemp("", "artifact-*"); err != nil {
+			return nil, err
+		}
+		r.pending[chunk.Name] = f
+	}
+
+	fail := func(err error) (*artifacts.Artifact, error) {
+		r.discard(chunk.Name)
+		return nil, err
+	}
+
+	if _, err := f.Write(chunk.Data); err != nil {
+		return fail(err)
+	}
+	size, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return fail(err)
+	}
+	if size > artifacts.MaxSize {
+		return fail(artifacts.ErrTooLarge)
+	}
+	if !chunk.Final {
+		return nil, nil
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return fail(err)
+	}
+	artifact, err := r.store.Put(ctx, r.jobID, chunk.Name, f, size)
+	r.discard(chunk.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &artifact, nil
+}
+
+func (r *artifactReceiver) discard(name string) {
+	if f, ok := r.pending[name]; ok {
+		f.Close()
+		os.Remove(f.Name())
+		delete(r.pending, name)
+	}
+}
+
+// close drops artifacts the worker never finished sending.
+func (r *artifactReceiver) close() {
+	for name := range r.pending {
+		r.discard(name)
+	}
+}
+
+// storeResult saves the job's (already redacted) result data.
+func (s *jobStream) storeResult(data string) {
+	artifact, err := s.received.store.Put(s.ctx, s.jobID, jobs.ResultArtifact, strings.NewReader(data), int64(len(data)))
+	if err != nil {
+		s.logError(fmt.Sprintf("Could not store the result: %v", err))
+		return
+	}
+	jobs.Default.Update(s.jobID, func(j *jobs.Job) {
+		j.Artifacts = append(j.Artifacts, artifact)
+	})
+}
+
+func artifactURL(jobID, name string) string {
+	return fmt.Sprintf("/api/v1/jobs/%s/artifacts/%s", url.PathEscape(jobID), url.PathEscape(name))
+}
+
+// optionalArtifactURL links an artifact the result refers to, if any.
+func optionalArtifactURL(jobID, name string) string {
+	if name == "" {
+		return ""
+	}
+	return artifactURL(jobID, name)
+}
+
+// artifactLinks lists the artifacts the server stored for a job.
+func artifactLinks(jobID string) []execution.ArtifactLink {
+	job, _ := jobs.Default.Get(jobID)
+
+	links := make([]execution.ArtifactLink, 0, len(job.Artifacts))
+	for _, a := range job.Artifacts {
+		links = append(links, execution.ArtifactLink{
+			Name: a.Name,
+			URL:  artifactURL(jobID, a.Name),
+			Size: formatSize(a.Size),
+		})
+	}
+	return links
+}
+
+func timelineSteps(timeline []jobs.TimelineEntry) []execution.TimelineStep {
+	steps := make([]execution.TimelineStep, 0, len(timeline))
+	for _, entry := range timeline {
+		seconds := int(entry.OffsetSeconds)
+		steps = append(steps, execution.TimelineStep{
+			Offset: strconv.FormatFloat(entry.OffsetSeconds, 'f', 2, 64),
+			Label:  fmt.Sprintf("%02d:%02d", seconds/60, seconds%60),
+			Step:   entry.Step,
+		})
+	}
+	return steps
+}
+
+func failedRequestRows(failed []jobs.FailedRequest) []execution.FailedRequestRow {
+	rows := make([]execution.FailedRequestRow, 0, len(failed))
+	for _, f := range failed {
+		status := f.Failure
+		if f.Status != 0 {
+			status = fmt.Sprint(f.Status)
+		}
+		rows = append(rows, execution.FailedRequestRow{
+			Method: f.Method,
+			URL:    f.URL,
+			Status: status,
+		})
+	}
+	return rows
+}
+
+func consoleSummary(totals *jobs.ConsoleTotals) string {
+	if totals == nil {
+		return ""
+	}
+	return fmt.Sprintf("%d errors, %d warnings, %d info, %d debug", totals.Error, totals.Warning, totals.Info, totals.Debug)
+}
+
+func formatSize(size int64) string {
+	switch {
+	case size >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(size)/(1<<20))
+	case size >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(size)/(1<<10))
+	default:
+		return fmt.Sprintf("%d B", size)
+	}
+}
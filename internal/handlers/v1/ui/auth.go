@@ -0,0 +1,71 @@
+package uihandlers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"brian-nunez/bcode/internal/auth"
+	"brian-nunez/bcode/views/pages"
+	"github.com/labstack/echo/v4"
+)
+
+func LoginPageHandler(c echo.Context) error {
+	return renderLoginPage(c, http.StatusOK, safeNext(c.QueryParam("next")), "")
+}
+
+func LoginHandler(c echo.Context) error {
+	next := safeNext(c.FormValue("next"))
+
+	store, err := auth.Default()
+	if err != nil {
+		return renderLoginPage(c, http.StatusInternalServerError, next, err.Error())
+	}
+
+	principal, err := store.AuthenticateUser(c.FormValue("username"), c.FormValue("password"))
+	if err != nil {
+		return renderLoginPage(c, http.StatusUnauthorized, next, err.Error())
+	}
+
+	token, expires := store.StartSession(principal.ID)
+	c.SetCookie(&http.Cookie{
+		Name:     auth.SessionCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   c.Scheme() == "https",
+		// Lax keeps the cookie off cross-site form posts and WebSockets
+		SameSite: http.SameSiteLaxMode,
+	})
+	return c.Redirect(http.StatusSeeOther, next)
+}
+
+func LogoutHandler(c echo.Context) error {
+	if cookie, err := c.Cookie(auth.SessionCookie); err == nil {
+		if store, err := auth.Default(); err == nil {
+			store.EndSession(cookie.Value)
+		}
+	}
+	c.SetCookie(&http.Cookie{
+		Name:     auth.SessionCookie,
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+	return c.Redirect(http.StatusSeeOther, "/login")
+}
+
+// safeNext only allows redirects back into this site.
+func safeNext(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	return next
+}
+
+func renderLoginPage(c echo.Context, status int, next, errorMessage string) error {
+	c.Response().Header().Set(echo.HeaderContentType, echo.MIMETextHTMLCharsetUTF8)
+	c.Response().WriteHeader(status)
+	return pages.LoginPage(next, errorMessage).Render(context.Background(), c.Response().Writer)
+}
@@ -1,17 +1,28 @@
 package uihandlers
 
 import (
-	"bufio"
-	"bytes"
 	"context"
-	"encoding/binary"
 	"encoding/json"
+	stderrors "errors"
 	"fmt"
-	"io"
 	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
+	"brian-nunez/bcode/internal/artifacts"
+	"brian-nunez/bcode/internal/auth"
+	"brian-nunez/bcode/internal/handlers/errors"
+	"brian-nunez/bcode/internal/jobs"
 	"brian-nunez/bcode/internal/orchestrator"
+	"brian-nunez/bcode/internal/profiles"
+	"brian-nunez/bcode/internal/ratelimit"
+	"brian-nunez/bcode/internal/redaction"
+	"brian-nunez/bcode/internal/secrets"
+	"brian-nunez/bcode/internal/webhooks"
+	"brian-nunez/bcode/internal/workspaces"
 	"brian-nunez/bcode/views/execution"
 	"github.com/labstack/echo/v4"
 )
@@ -31,114 +42,455 @@ func AIActionsPageHandler(c echo.Context) error {
 	return execution.AIActionsPage().Render(context.Background(), c.Response().Writer)
 }
 
+// JobIDHeader carries the ID of the job /execute created, on the stream and
+// on failures alike.
+const JobIDHeader = "X-Job-ID"
+
 func ExecuteJobHandler(c echo.Context) error {
-	url := c.FormValue("url")
-	action := c.FormValue("action")
-	instruction := c.FormValue("instruction")
+	fields, err := c.FormParams()
+	if err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+	principal, _ := auth.PrincipalFrom(c)
 
-	// Escape quotes in the instruction to prevent JSON breakage
-	// In a real app, we should use a proper JSON struct marshal
-	reqBody := map[string]string{
-		"url":    url,
-		"action": action,
-		"target": instruction,
+	_, err = executeJob(c.Request().Context(), principal, fields, responseOutput{c: c}, func(jobID string) {
+		c.Response().Header().Set(JobIDHeader, jobID)
+	})
+	var refused *jobRefusal
+	if stderrors.As(err, &refused) {
+		return refused.respond(c)
+	}
+	return err
+}
+
+// RunJob runs a job outside of any request, from the same form fields as
+// /execute, and returns its ID once it has finished. started is called with
+// the ID as soon as the job has been created. Like /execute, it is subject to
+// the principal's submission rate limit.
+func RunJob(ctx context.Context, principal auth.Principal, fields url.Values, started func(jobID string)) (string, error) {
+	limiter, err := ratelimit.DefaultSubmissions()
+	if err != nil {
+		return "", err
 	}
-	jsonPayload, _ := json.Marshal(reqBody)
+	if ok, wait := limiter.Allow(principal); !ok {
+		return "", fmt.Errorf("too many submissions, try again in %s", wait.Round(time.Second))
+	}
+	return executeJob(ctx, principal, fields, discardOutput{}, started)
+}
 
-	payload := orchestrator.JobRequest{
-		Payload: string(jsonPayload),
+// executeJob runs a job from the form fields of /execute to its end, streaming
+// it to out, and returns its ID. A job that fails before its stream starts
+// is returned as a *jobRefusal.
+func executeJob(ctx context.Context, principal auth.Principal, fields url.Values, out jobOutput, started func(jobID string)) (string, error) {
+	jobPayload := jobs.Payload{
+		URL:         fields.Get("url"),
+		Action:      fields.Get("action"),
+		Target:      fields.Get("instruction"),
+		SavePDF:     fields.Get("save_pdf") != "",
+		Screenshot:  fields.Get("screenshot") != "",
+		RecordVideo: fields.Get("record_video") != "",
 	}
 
-	logs, err := orchestrator.RunJob(c.Request().Context(), payload)
+	extract, err := parseExtract(fields.Get("extract"))
 	if err != nil {
-		return c.String(http.StatusInternalServerError, fmt.Sprintf("Failed to run job: %v", err))
+		return "", &jobRefusal{status: http.StatusBadRequest, message: err.Error()}
 	}
-	defer logs.Close()
+	jobPayload.Extract = extract
 
-	c.Response().Header().Set(echo.HeaderContentType, "text/html; charset=utf-8")
-	c.Response().WriteHeader(http.StatusOK)
+	approvalPatterns := splitList(fields.Get("approval_patterns"))
+	approveSubmit := fields.Get("approve_submit") != ""
+	if len(approvalPatterns) > 0 || approveSubmit {
+		jobPayload.Approval = &jobs.ApprovalPolicy{
+			Patterns:   approvalPatterns,
+			FormSubmit: approveSubmit,
+		}
+	}
 
-	// Stream logs line by line
-	pr, pw := io.Pipe()
+	recordHAR := fields.Get("record_har") != ""
+	networkSummary := fields.Get("network_summary") != ""
+	if recordHAR || networkSummary {
+		jobPayload.Network = &jobs.NetworkOptions{
+			HAR:     recordHAR,
+			Summary: networkSummary,
+		}
+	}
 
-	// Start a goroutine to strip Docker headers and write clean logs to the pipe
-	go func() {
-		defer pw.Close()
-		header := make([]byte, 8)
-		for {
-			_, err := io.ReadFull(logs, header)
-			if err != nil {
-				return // EOF or error
-			}
+	browser := fields.Get("browser")
+	if browser != "" && !slices.Contains(jobs.Browsers, browser) {
+		return "", &jobRefusal{status: http.StatusBadRequest, message: fmt.Sprintf("Unknown browser %q", browser)}
+	}
+	jobPayload.Browser = browser
 
-			// Parse payload size (bytes 4-7, big endian)
-			size := binary.BigEndian.Uint32(header[4:8])
+	launch := jobs.LaunchOptions{
+		Headed:    fields.Get("headed") != "",
+		Args:      strings.Fields(fields.Get("launch_args")),
+		UserAgent: strings.TrimSpace(fields.Get("user_agent")),
+	}
+	if server := strings.TrimSpace(fields.Get("proxy_server")); server != "" {
+		if err := orchestrator.ValidateProxy(orchestrator.Proxy{Server: server}); err != nil {
+			return "", &jobRefusal{status: http.StatusBadRequest, message: err.Error()}
+		}
+		launch.Proxy = &jobs.ProxyOptions{
+			Server:   server,
+			Bypass:   strings.TrimSpace(fields.Get("proxy_bypass")),
+			Username: fields.Get("proxy_username"),
+			Password: fields.Get("proxy_password"),
+		}
+	}
 
-			// Copy the payload to the pipe
-			if _, err := io.CopyN(pw, logs, int64(size)); err != nil {
-				return
-			}
+	var proxyPool *orchestrator.ProxyPool
+	if name := strings.TrimSpace(fields.Get("proxy_pool")); name != "" {
+		if launch.Proxy != nil {
+			return "", &jobRefusal{status: http.StatusBadRequest, message: "Choose either a proxy or a proxy pool, not both"}
+		}
+		pools, err := orchestrator.DefaultProxyPools()
+		if err != nil {
+			return "", &jobRefusal{status: http.StatusInternalServerError, message: fmt.Sprintf("Failed to run job: %v", err)}
+		}
+		if proxyPool, err = pools.Get(name); err != nil {
+			return "", &jobRefusal{status: http.StatusBadRequest, message: err.Error()}
+		}
+	}
+	if launch.Headed || len(launch.Args) > 0 || launch.UserAgent != "" || launch.Proxy != nil || proxyPool != nil {
+		jobPayload.Launch = &launch
+	}
+
+	if fields.Get("screencast") != "" {
+		fps, _ := strconv.Atoi(fields.Get("screencast_fps"))
+		quality, _ := strconv.Atoi(fields.Get("screencast_quality"))
+		jobPayload.Screencast = &jobs.ScreencastOptions{
+			FPS:     fps,
+			Quality: quality,
+		}
+	}
+
+	profileName := strings.TrimSpace(fields.Get("profile"))
+	saveProfile := fields.Get("save_profile") != ""
+	if profileName != "" {
+		jobPayload.Profile = &jobs.ProfileOptions{
+			Name: profileName,
+			Save: saveProfile,
 		}
-	}()
+	}
+
+	policy, err := redaction.Default()
+	if err != nil {
+		return "", &jobRefusal{status: http.StatusInternalServerError, message: fmt.Sprintf("Failed to run job: %v", err)}
+	}
+	policy = policy.WithSelectors(splitList(fields.Get("mask_selectors")))
+	jobPayload.Redaction = &policy
+
+	retryPolicies, err := orchestrator.DefaultRetryPolicies()
+	if err != nil {
+		return "", &jobRefusal{status: http.StatusInternalServerError, message: fmt.Sprintf("Failed to run job: %v", err)}
+	}
+	retryPolicy := retryPolicies.For(jobPayload.Action)
 
-	scanner := bufio.NewScanner(pr)
-	// Increase buffer size to handle large base64 images (5MB)
-	const maxCapacity = 5 * 1024 * 1024
-	buf := make([]byte, maxCapacity)
-	scanner.Buffer(buf, maxCapacity)
+	job := jobs.Default.Create(jobPayload, principal.Workspace, principal.Subject())
+	if started != nil {
+		started(job.ID)
+	}
 
-	for scanner.Scan() {
-		line := scanner.Text()
+	failJob := func(status int, code jobs.ErrorCode, err error) (string, error) {
+		jobs.Default.Update(job.ID, func(j *jobs.Job) {
+			j.Status = jobs.StatusFailed
+			j.Error = jobs.NewError(code, err.Error())
+		})
+		return job.ID, &jobRefusal{status: status, message: fmt.Sprintf("Failed to run job: %v", err)}
+	}
 
-		// 1. Check for Live Updates (Screenshots)
-		const updatePrefix = "JOB_UPDATE:"
-		if idx := strings.Index(line, updatePrefix); idx != -1 {
-			jsonPart := line[idx+len(updatePrefix):]
-			var update struct {
-				Image string `json:"image"`
-			}
-			if err := json.Unmarshal([]byte(jsonPart), &update); err == nil && update.Image != "" {
-				// Protocol: IMG: <data>
-				fmt.Fprintf(c.Response().Writer, "IMG: %s\n", update.Image)
-				c.Response().Flush()
-				continue
+	// Events of this job alone, signed with the caller's secret
+	if webhookURL := fields.Get("webhook_url"); webhookURL != "" {
+		store, err := webhooks.Default()
+		if err != nil {
+			return failJob(http.StatusInternalServerError, jobs.ErrInternal, err)
+		}
+		if err := store.SubscribeJob(job.ID, webhookURL, splitList(fields.Get("webhook_events")), fields.Get("webhook_secret")); err != nil {
+			return failJob(http.StatusBadRequest, jobs.ErrInvalidRequest, err)
+		}
+	}
+
+	var profileStore *profiles.Store
+	if jobPayload.Profile != nil {
+		var err error
+		if profileStore, err = profiles.ForWorkspace(job.Workspace); err != nil {
+			return failJob(http.StatusInternalServerError, jobs.ErrInternal, err)
+		}
+
+		// Only jobs that write the profile back need exclusive access
+		if saveProfile {
+			if err := profileStore.Lock(profileName, job.ID); err != nil {
+				return failJob(http.StatusConflict, jobs.ErrProfileInUse, err)
 			}
+			defer profileStore.Unlock(profileName, job.ID)
+		}
+
+		state, err := profileStore.Load(profileName)
+		if err != nil {
+			return failJob(http.StatusBadRequest, jobs.ErrInvalidRequest, err)
+		}
+		jobPayload.Profile.State = state
+	}
+
+	// Jobs on a pool start on the proxy picked by its rotation; the rest are
+	// only used when the proxy before them fails.
+	proxies := []*jobs.ProxyOptions{launch.Proxy}
+	if proxyPool != nil {
+		proxies = proxies[:0]
+		for _, proxy := range proxyPool.Candidates(jobPayload.URL) {
+			proxies = append(proxies, &jobs.ProxyOptions{
+				Server:   proxy.Server,
+				Bypass:   proxy.Bypass,
+				Username: proxy.Username,
+				Password: proxy.Password,
+			})
+		}
+	}
+
+	references := []string{jobPayload.Target}
+	for _, proxy := range proxies {
+		if proxy != nil {
+			references = append(references, proxy.Username, proxy.Password)
+		}
+	}
+	if names := secrets.References(references...); len(names) > 0 {
+		secretStore, err := secrets.ForWorkspace(job.Workspace)
+		if err != nil {
+			return failJob(http.StatusInternalServerError, jobs.ErrInternal, err)
+		}
+		if jobPayload.Secrets, err = secretStore.Resolve(names); err != nil {
+			return failJob(http.StatusBadRequest, jobs.ErrInvalidRequest, err)
 		}
+	}
+	redact := redaction.New(policy, secrets.Redactor(jobPayload.Secrets))
+
+	artifactStore, err := artifacts.Default()
+	if err == nil {
+		err = artifactStore.SetOwner(ctx, job.ID, job.Workspace)
+	}
+	if err != nil {
+		return failJob(http.StatusInternalServerError, jobs.ErrInternal, err)
+	}
+
+	runner, err := orchestrator.Default()
+	if err != nil {
+		return failJob(http.StatusInternalServerError, jobs.ErrInternal, err)
+	}
+	queue, err := orchestrator.DefaultQueue()
+	if err != nil {
+		return failJob(http.StatusInternalServerError, jobs.ErrInternal, err)
+	}
+	received := newArtifactReceiver(job.ID, artifactStore)
+	defer received.close()
+
+	stream := &jobStream{
+		ctx:          ctx,
+		out:          out,
+		jobID:        job.ID,
+		redact:       redact,
+		received:     received,
+		profileStore: profileStore,
+		profileName:  profileName,
+		saveProfile:  saveProfile,
+		saveResult:   fields.Get("save_result") != "",
+	}
+	defer jobs.Default.EndFrames(job.ID)
 
-		// 2. Check for Final Result
-		const resultPrefix = "JOB_RESULT:"
-		if idx := strings.Index(line, resultPrefix); idx != -1 {
-			jsonPart := line[idx+len(resultPrefix):]
-			var attemptResult struct {
-				Success bool   `json:"success"`
-				Data    string `json:"data"`
-				Image   string `json:"image"`
-				Error   string `json:"error"`
+	ticket := &orchestrator.Ticket{JobID: job.ID, Workspace: job.Workspace, URL: jobPayload.URL}
+	release, err := queue.Acquire(ctx, ticket, func() {
+		stream.start()
+		stream.logInfo(fmt.Sprintf("Queued until the limits of workspace %s and of the target site let this job start", job.Workspace))
+	})
+	if err != nil {
+		return job.ID, refuseJob(stream, job.ID, err)
+	}
+	var usage jobs.Usage
+	defer func() { release(usage) }()
+
+	// Proxy failover does not count against the retry policy's attempts
+	var result *workerResult
+	proxyIndex, policyAttempts := 0, 1
+Attempts:
+	for number := 1; ; number++ {
+		proxy := proxies[proxyIndex]
+		var server string
+		if proxy != nil {
+			jobPayload.Launch.Proxy = proxy
+			server = orchestrator.Proxy{Server: proxy.Server}.Redacted()
+		}
+		jobs.Default.Update(job.ID, func(j *jobs.Job) {
+			j.Status = jobs.StatusRunning
+			j.Proxy = server
+			j.Attempts = append(j.Attempts, jobs.Attempt{
+				Number:    number,
+				Proxy:     server,
+				StartedAt: time.Now(),
+			})
+		})
+
+		jsonPayload, _ := json.Marshal(jobPayload)
+
+		payload := orchestrator.JobRequest{
+			JobID:   job.ID,
+			Payload: string(jsonPayload),
+			Browser: jobPayload.Browser,
+		}
+
+		attemptStarted := time.Now()
+		run, err := runner.Run(ctx, payload)
+		if err != nil {
+			jobErr := jobs.NewError(jobs.ErrWorkerFailed, err.Error())
+			finishAttempt(job.ID, jobErr, "")
+			if !stream.started {
+				return failJob(http.StatusInternalServerError, jobs.ErrWorkerFailed, err)
 			}
+			// The stream started while the job was queued, or for an earlier
+			// attempt, so the failure is reported in it
+			stream.logError(fmt.Sprintf("Could not start attempt %d: %v", number, err))
+			result = &workerResult{Error: jobErr}
+			break
+		}
+
+		stream.start()
+		result = stream.relay(run)
+		usage.BrowserSeconds += time.Since(attemptStarted).Seconds()
+		if result.Usage != nil {
+			usage.LLMTokens += result.Usage.LLMTokens
+		}
 
-			if err := json.Unmarshal([]byte(jsonPart), &attemptResult); err == nil {
-				// Render the result component to a buffer/string
-				resultBuf := bytes.NewBuffer(nil)
-				execution.JobResultView(attemptResult.Data, attemptResult.Image).Render(context.Background(), resultBuf)
+		if result.Success {
+			finishAttempt(job.ID, nil, "")
+			break
+		}
+		if result.Error == nil {
+			result.Error = jobs.NewError(jobs.ErrWorkerFailed, "worker reported a failure without an error")
+		}
+		class := orchestrator.ClassifyJobError(result.Error)
+		finishAttempt(job.ID, result.Error, class)
 
-				// Protocol: END: <html>
-				cleanHTML := strings.ReplaceAll(resultBuf.String(), "\n", " ")
-				fmt.Fprintf(c.Response().Writer, "END: %s\n", cleanHTML)
-				c.Response().Flush()
-				continue
+		switch {
+		case orchestrator.IsProxyError(result.Error.Message) && proxyIndex < len(proxies)-1:
+			proxyIndex++
+			stream.logError(fmt.Sprintf("Proxy %s failed, retrying on the next proxy in the pool", server))
+		case retryPolicy.Retryable(class) && policyAttempts < retryPolicy.MaxAttempts:
+			delay := retryPolicy.Backoff(policyAttempts)
+			policyAttempts++
+			stream.logError(fmt.Sprintf("Attempt %d failed (%s), retrying in %s", number, class, delay))
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				break Attempts
 			}
+		default:
+			break Attempts
 		}
+	}
+
+	if proxyPool != nil && result != nil && result.Success {
+		proxyPool.Succeeded(jobPayload.URL, jobPayload.Launch.Proxy.Server)
+	}
+	jobs.Default.Update(job.ID, func(j *jobs.Job) {
+		j.Usage = &usage
+	})
+	stream.finish(result)
+
+	return job.ID, nil
+}
+
+// refuseJob fails a job the queue would not start. Exhausted quotas are
+// reported with their own error code, in the stream if the job had to wait
+// and as a refusal otherwise.
+func refuseJob(stream *jobStream, jobID string, err error) error {
+	jobErr := jobs.NewError(jobs.ErrInternal, err.Error())
+	var quotaErr *workspaces.QuotaError
+	switch {
+	case stderrors.As(err, &quotaErr):
+		jobErr = jobs.NewError(jobs.ErrQuotaExceeded, err.Error())
+		jobErr.Details = quotaErr.Details()
+	case stderrors.Is(err, workspaces.ErrNotFound):
+		jobErr = jobs.NewError(jobs.ErrInvalidRequest, err.Error())
+	}
 
-		// Otherwise just print the line as a log
-		// Protocol: LOG: <html>
-		fmt.Fprintf(c.Response().Writer, "LOG: <div class='text-xs text-gray-400 font-mono'>%s</div>\n", line)
-		c.Response().Flush()
+	if stream.started {
+		stream.finish(&workerResult{Error: jobErr})
+		return nil
 	}
 
-	if err := scanner.Err(); err != nil {
-		fmt.Fprintf(c.Response().Writer, "LOG: <div class='text-red-500'>Error reading logs: %v</div>\n", err)
-		c.Response().Flush()
+	jobs.Default.Update(jobID, func(j *jobs.Job) {
+		j.Status = jobs.StatusFailed
+		j.Error = jobErr
+	})
+	return &jobRefusal{jobErr: jobErr}
+}
+
+// jobRefusal is a job that failed before its stream started. /execute
+// answers it with status and message, or as an API error for jobErr.
+type jobRefusal struct {
+	status  int
+	message string
+	jobErr  *jobs.JobError
+}
+
+func (r *jobRefusal) Error() string {
+	if r.jobErr != nil {
+		return r.jobErr.Message
 	}
+	return r.message
+}
 
-	return nil
+func (r *jobRefusal) respond(c echo.Context) error {
+	if r.jobErr == nil {
+		return c.String(r.status, r.message)
+	}
+	response := errors.JobFailure(r.jobErr).Build()
+	if r.jobErr.Code == jobs.ErrQuotaExceeded {
+		response = errors.QuotaExceeded().WithMessage(r.jobErr.Message).WithDetails(r.jobErr.Details).Build()
+	}
+	return c.JSON(response.HTTPStatusCode, response)
+}
+
+// finishAttempt closes the job's latest attempt.
+func finishAttempt(jobID string, jobErr *jobs.JobError, class string) {
+	jobs.Default.Update(jobID, func(j *jobs.Job) {
+		if len(j.Attempts) == 0 {
+			return
+		}
+		attempt := &j.Attempts[len(j.Attempts)-1]
+		attempt.Error = jobErr
+		attempt.ErrorClass = class
+		attempt.FinishedAt = time.Now()
+	})
+}
+
+// parseExtract reads the fields to extract, one "name=selector" per line.
+// Selectors may contain commas, so unlike other lists this one is only split
+// on newlines.
+func parseExtract(value string) (map[string]string, error) {
+	var fields map[string]string
+	for _, line := range strings.Split(value, "\n") {
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		}
+		name, selector, ok := strings.Cut(line, "=")
+		name, selector = strings.TrimSpace(name), strings.TrimSpace(selector)
+		if !ok || name == "" || selector == "" {
+			return nil, fmt.Errorf("fields to extract must be name=selector, got %q", line)
+		}
+		if fields == nil {
+			fields = map[string]string{}
+		}
+		fields[name] = selector
+	}
+	return fields, nil
+}
+
+// splitList splits a comma or newline separated form value.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == '\n' }) {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
 }
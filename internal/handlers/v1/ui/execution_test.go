@@ -0,0 +1,476 @@
+package uihandlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"brian-nunez/bcode/internal/auth"
+	"brian-nunez/bcode/internal/jobs"
+	"brian-nunez/bcode/internal/orchestrator"
+	"brian-nunez/bcode/internal/workspaces"
+	"github.com/labstack/echo/v4"
+)
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "uihandlers")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	// Retries wait milliseconds instead of seconds
+	retry := `{"default": {"max_attempts": 3, "backoff_seconds": 0.01, "retry_on": ["network", "timeout"]}}`
+	if err := os.WriteFile(filepath.Join(dir, "retry.json"), []byte(retry), 0o600); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	// The API key "limited" may submit a single job
+	limits := `{"keys": {"limited": {"per_minute": 0.001, "burst": 1}}}`
+	if err := os.WriteFile(filepath.Join(dir, "ratelimits.json"), []byte(limits), 0o600); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	os.Setenv("DATA_DIR", dir)
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// jobRun is a run of ExecuteJobHandler.
+type jobRun struct {
+	recorder *httptest.ResponseRecorder
+	err      error
+	done     chan struct{}
+}
+
+// startJob runs ExecuteJobHandler on runner in the background, as a user
+// named after the test, so the test can find its job with findJob.
+func startJob(t *testing.T, runner orchestrator.Runner, workspace string, form url.Values) *jobRun {
+	t.Helper()
+	orchestrator.SetDefault(runner)
+
+	if form == nil {
+		form = url.Values{}
+	}
+	if !form.Has("url") {
+		form.Set("url", "https://example.com")
+	}
+	if !form.Has("action") {
+		form.Set("action", "scrape")
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/execute", strings.NewReader(form.Encode()))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+	run := &jobRun{recorder: httptest.NewRecorder(), done: make(chan struct{})}
+	c := echo.New().NewContext(req, run.recorder)
+	auth.SetPrincipal(c, auth.Principal{
+		Kind:      "user",
+		ID:        t.Name(),
+		Scopes:    []string{auth.ScopeSubmit},
+		Workspace: workspace,
+	})
+
+	go func() {
+		defer close(run.done)
+		run.err = ExecuteJobHandler(c)
+	}()
+	return run
+}
+
+// wait returns the response once the handler is done.
+func (r *jobRun) wait(t *testing.T) string {
+	t.Helper()
+
+	select {
+	case <-r.done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("the handler did not finish")
+	}
+	if r.err != nil {
+		t.Fatalf("ExecuteJobHandler: %v", r.err)
+	}
+	return r.recorder.Body.String()
+}
+
+// runJob runs a job to the end and returns the response and the job.
+func runJob(t *testing.T, runner orchestrator.Runner, form url.Values) (string, jobs.Job) {
+	t.Helper()
+
+	body := startJob(t, runner, workspaces.DefaultWorkspace, form).wait(t)
+	job, ok := findJob(t)
+	if !ok {
+		t.Fatal("no job was created")
+	}
+	return body, job
+}
+
+// findJob returns the job the test's user created last.
+func findJob(t *testing.T) (jobs.Job, bool) {
+	for _, job := range jobs.Default.List() {
+		if job.CreatedBy == "user:"+t.Name() {
+			return job, true
+		}
+	}
+	return jobs.Job{}, false
+}
+
+// eventually waits for cond to hold.
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func result(payload map[string]any) string {
+	return orchestrator.FakeEvent("JOB_RESULT", payload)
+}
+
+func TestExecuteJobSucceeds(t *testing.T) {
+	runner := &orchestrator.FakeRunner{}
+
+	body, job := runJob(t, runner, nil)
+
+	if job.Status != jobs.StatusSucceeded {
+		t.Fatalf("job ended %s (%+v), want succeeded", job.Status, job.Error)
+	}
+	if !strings.HasPrefix(body, "JOB: "+job.ID+"\n") {
+		t.Errorf("the stream does not start with the job ID:\n%s", body)
+	}
+	if !strings.Contains(body, "Fake worker started") || !strings.Contains(body, "END: ") || !strings.Contains(body, "Fake result") {
+		t.Errorf("the stream lacks the worker's log or result:\n%s", body)
+	}
+	if len(job.Attempts) != 1 || job.Attempts[0].Error != nil {
+		t.Errorf("attempts = %+v, want one successful attempt", job.Attempts)
+	}
+	if requests := runner.Requests(); len(requests) != 1 || requests[0].JobID != job.ID {
+		t.Errorf("runner was asked to run %+v", requests)
+	}
+}
+
+func TestExecuteJobPausesForOperator(t *testing.T) {
+	runner := &orchestrator.FakeRunner{
+		Script: func(orchestrator.JobRequest) []string {
+			return []string{
+				orchestrator.FakeEvent("JOB_PAUSE", jobs.Prompt{ID: "prompt-1", Kind: jobs.PromptQuestion, Message: "Enter the code"}),
+				orchestrator.FakeAwaitControl,
+				orchestrator.FakeEvent("JOB_RESUME", map[string]any{}),
+				result(map[string]any{"success": true, "data": "Signed in"}),
+			}
+		},
+	}
+
+	run := startJob(t, runner, workspaces.DefaultWorkspace, nil)
+
+	var paused jobs.Job
+	eventually(t, "the job to pause", func() bool {
+		var ok bool
+		paused, ok = findJob(t)
+		return ok && paused.Status == jobs.StatusPaused
+	})
+	if paused.Prompt == nil || paused.Prompt.ID != "prompt-1" || paused.Prompt.Message != "Enter the code" {
+		t.Fatalf("paused with prompt %+v", paused.Prompt)
+	}
+
+	if _, err := jobs.Default.Respond(paused.ID, jobs.DecisionAnswer, "123456"); err != nil {
+		t.Fatalf("Respond: %v", err)
+	}
+	// The prompt is answered once; a second operator is told so
+	if _, err := jobs.Default.Respond(paused.ID, jobs.DecisionAnswer, "654321"); !errors.Is(err, jobs.ErrNotPaused) {
+		t.Errorf("second Respond returned %v, want ErrNotPaused", err)
+	}
+
+	body := run.wait(t)
+	job, _ := findJob(t)
+	if job.Status != jobs.StatusSucceeded || job.Prompt != nil {
+		t.Errorf("job ended %s with prompt %+v, want succeeded without one", job.Status, job.Prompt)
+	}
+	if !strings.Contains(body, "ASK: <") || !strings.Contains(body, "ASK: \n") {
+		t.Errorf("the stream did not show and clear the prompt:\n%s", body)
+	}
+
+	control := runner.Control()
+	if strings.Count(control, "\n") != 1 || !strings.Contains(control, `"prompt_id":"prompt-1"`) || !strings.Contains(control, `"value":"123456"`) {
+		t.Errorf("worker received %q, want the one answer tagged with its prompt", control)
+	}
+}
+
+func TestExecuteJobReportsCrashes(t *testing.T) {
+	tests := []struct {
+		name    string
+		exit    orchestrator.ExitStatus
+		code    jobs.ErrorCode
+		details map[string]string
+	}{
+		{
+			name:    "crash",
+			exit:    orchestrator.ExitStatus{Code: 2},
+			code:    jobs.ErrWorkerFailed,
+			details: map[string]string{"exit_code": "2"},
+		},
+		{
+			name:    "out of memory",
+			exit:    orchestrator.ExitStatus{Code: 137, OOMKilled: true},
+			code:    jobs.ErrContainerOOM,
+			details: map[string]string{"exit_code": "137", "oom_killed": "true"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner := &orchestrator.FakeRunner{
+				Script: func(orchestrator.JobRequest) []string {
+					return []string{"Loading the page", "fatal error: runtime: out of memory"}
+				},
+				Exit: tt.exit,
+			}
+
+			body, job := runJob(t, runner, nil)
+
+			if job.Status != jobs.StatusFailed || job.Error == nil || job.Error.Code != tt.code {
+				t.Fatalf("job ended %s with %+v, want %s", job.Status, job.Error, tt.code)
+			}
+			for key, value := range tt.details {
+				if job.Error.Details[key] != value {
+					t.Errorf("details[%s] = %q, want %q", key, job.Error.Details[key], value)
+				}
+			}
+			if !strings.Contains(job.Error.Details["log_tail"], "fatal error") {
+				t.Errorf("log tail %q lacks the worker's last lines", job.Error.Details["log_tail"])
+			}
+			// A crash is not a transient failure the retry policy covers
+			if len(runner.Requests()) != 1 {
+				t.Errorf("the worker ran %d times, want once", len(runner.Requests()))
+			}
+			if !strings.Contains(body, string(tt.code)) {
+				t.Errorf("the result does not show %s:\n%s", tt.code, body)
+			}
+		})
+	}
+}
+
+func TestExecuteJobRetriesTransientFailures(t *testing.T) {
+	var attempts atomic.Int32
+	runner := &orchestrator.FakeRunner{
+		Script: func(orchestrator.JobRequest) []string {
+			if attempts.Add(1) == 1 {
+				return []string{result(map[string]any{
+					"success": false,
+					"error":   jobs.NewError(jobs.ErrNavigationFailed, "net::ERR_CONNECTION_RESET at https://example.com"),
+				})}
+			}
+			return []string{result(map[string]any{"success": true, "data": "Second time lucky"})}
+		},
+	}
+
+	body, job := runJob(t, runner, nil)
+
+	if job.Status != jobs.StatusSucceeded {
+		t.Fatalf("job ended %s (%+v), want succeeded", job.Status, job.Error)
+	}
+	if len(job.Attempts) != 2 {
+		t.Fatalf("attempts = %+v, want two", job.Attempts)
+	}
+	if first := job.Attempts[0]; first.Error == nil || first.ErrorClass != orchestrator.ErrorClassNetwork {
+		t.Errorf("first attempt = %+v, want a network failure", first)
+	}
+	if job.Attempts[1].Error != nil {
+		t.Errorf("second attempt failed: %+v", job.Attempts[1].Error)
+	}
+	if !strings.Contains(body, "Attempt 1 failed (network), retrying") || !strings.Contains(body, "Second time lucky") {
+		t.Errorf("the stream does not show the retry and its result:\n%s", body)
+	}
+}
+
+func TestExecuteJobGivesUpAfterMaxAttempts(t *testing.T) {
+	runner := &orchestrator.FakeRunner{
+		Script: func(orchestrator.JobRequest) []string {
+			return []string{result(map[string]any{
+				"success": false,
+				"error":   jobs.NewError(jobs.ErrTimeout, "Timeout 30000ms exceeded"),
+			})}
+		},
+	}
+
+	_, job := runJob(t, runner, nil)
+
+	if job.Status != jobs.StatusFailed || job.Error == nil || job.Error.Code != jobs.ErrTimeout {
+		t.Fatalf("job ended %s with %+v, want a timeout failure", job.Status, job.Error)
+	}
+	if len(job.Attempts) != 3 || len(runner.Requests()) != 3 {
+		t.Errorf("ran %d attempts (%d workers), want 3", len(job.Attempts), len(runner.Requests()))
+	}
+}
+
+// unstartableRunner cannot start any worker.
+type unstartableRunner struct{}
+
+func (unstartableRunner) Run(ctx context.Context, req orchestrator.JobRequest) (*orchestrator.Execution, error) {
+	return nil, errors.New("no capacity left")
+}
+
+func TestExecuteJobFailsWithoutWorker(t *testing.T) {
+	body, job := runJob(t, unstartableRunner{}, nil)
+
+	if job.Status != jobs.StatusFailed || job.Error == nil || job.Error.Code != jobs.ErrWorkerFailed {
+		t.Fatalf("job ended %s with %+v, want WORKER_FAILED", job.Status, job.Error)
+	}
+	if !strings.Contains(body, "no capacity left") {
+		t.Errorf("response %q does not say why", body)
+	}
+}
+
+func TestExecuteJobRejectsInvalidProxies(t *testing.T) {
+	tests := []struct {
+		server string
+		want   string
+	}{
+		{"ftp://proxy.example.com:21", `unsupported proxy scheme "ftp"`},
+		{"proxy.example.com:8080", "invalid proxy server"},
+		{"http://", "invalid proxy server"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.server, func(t *testing.T) {
+			runner := &orchestrator.FakeRunner{}
+			run := startJob(t, runner, workspaces.DefaultWorkspace, url.Values{"proxy_server": {tt.server}})
+			body := run.wait(t)
+
+			if run.recorder.Code != http.StatusBadRequest || !strings.Contains(body, tt.want) {
+				t.Errorf("answered %d %q, want 400 about %s", run.recorder.Code, body, tt.want)
+			}
+			if _, created := findJob(t); created || len(runner.Requests()) > 0 {
+				t.Error("a job with an invalid proxy was created")
+			}
+		})
+	}
+}
+
+// A queued job starts its stream while it waits, so a worker that then
+// cannot start is reported in the stream.
+func TestExecuteJobFailsAfterQueueing(t *testing.T) {
+	store, err := workspaces.Default()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Put("queued", workspaces.Quota{MaxConcurrentJobs: 1}); err != nil {
+		t.Fatal(err)
+	}
+	queue, err := orchestrator.DefaultQueue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	release, err := queue.Acquire(context.Background(), &orchestrator.Ticket{JobID: "holder", Workspace: "queued"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	run := startJob(t, unstartableRunner{}, "queued", nil)
+	eventually(t, "the job to queue", func() bool { return queue.Waiting() == 1 })
+	release(jobs.Usage{})
+
+	body := run.wait(t)
+	job, _ := findJob(t)
+	if job.Status != jobs.StatusFailed || job.Error == nil || job.Error.Code != jobs.ErrWorkerFailed {
+		t.Fatalf("job ended %s with %+v, want WORKER_FAILED", job.Status, job.Error)
+	}
+	if !strings.Contains(body, "Queued until") || !strings.Contains(body, "Could not start attempt 1") || !strings.Contains(body, "END: ") {
+		t.Errorf("the stream does not report the failure:\n%s", body)
+	}
+}
+
+func TestRunJobRunsInTheBackground(t *testing.T) {
+	runner := &orchestrator.FakeRunner{}
+	orchestrator.SetDefault(runner)
+
+	var startedID string
+	principal := auth.Principal{Kind: "user", ID: t.Name(), Scopes: []string{auth.ScopeSubmit}, Workspace: workspaces.DefaultWorkspace}
+	fields := url.Values{"url": {"https://example.com"}, "action": {"scrape"}}
+	jobID, err := RunJob(context.Background(), principal, fields, func(jobID string) {
+		startedID = jobID
+	})
+	if err != nil {
+		t.Fatalf("RunJob: %v", err)
+	}
+
+	job, ok := jobs.Default.Get(jobID)
+	if !ok || job.Status != jobs.StatusSucceeded {
+		t.Fatalf("job %q ended %s (%+v), want succeeded", jobID, job.Status, job.Error)
+	}
+	if startedID != jobID {
+		t.Errorf("started was called with %q, want %q", startedID, jobID)
+	}
+	if requests := runner.Requests(); len(requests) != 1 || requests[0].JobID != jobID {
+		t.Errorf("runner was asked to run %+v", requests)
+	}
+}
+
+func TestRunJobRefusals(t *testing.T) {
+	orchestrator.SetDefault(&orchestrator.FakeRunner{})
+
+	tests := []struct {
+		name      string
+		principal auth.Principal
+		fields    url.Values
+		created   bool
+		want      string
+	}{
+		{
+			name:      "invalid job",
+			principal: auth.Principal{Kind: "user", ID: "refused", Workspace: workspaces.DefaultWorkspace},
+			fields:    url.Values{"url": {"https://example.com"}, "browser": {"netscape"}},
+			want:      `Unknown browser "netscape"`,
+		},
+		{
+			name:      "missing workspace",
+			principal: auth.Principal{Kind: "user", ID: "refused", Workspace: "missing"},
+			fields:    url.Values{"url": {"https://example.com"}},
+			created:   true,
+			want:      "missing",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jobID, err := RunJob(context.Background(), tt.principal, tt.fields, nil)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("RunJob returned %v, want an error about %s", err, tt.want)
+			}
+			if created := jobID != ""; created != tt.created {
+				t.Errorf("RunJob returned job %q, want one created: %v", jobID, tt.created)
+			}
+		})
+	}
+}
+
+// Jobs run outside of /execute are held to the same submission limits.
+func TestRunJobIsRateLimited(t *testing.T) {
+	orchestrator.SetDefault(&orchestrator.FakeRunner{})
+	principal := auth.Principal{Kind: "key", ID: "limited", Scopes: []string{auth.ScopeSubmit}, Workspace: workspaces.DefaultWorkspace}
+	fields := url.Values{"url": {"https://example.com"}, "action": {"scrape"}}
+
+	if _, err := RunJob(context.Background(), principal, fields, nil); err != nil {
+		t.Fatalf("first RunJob: %v", err)
+	}
+	jobID, err := RunJob(context.Background(), principal, fields, func(string) {
+		t.Error("a job was created over the limit")
+	})
+	if err == nil || !strings.Contains(err.Error(), "too many submissions") || jobID != "" {
+		t.Errorf("second RunJob returned %q, %v, want it refused", jobID, err)
+	}
+}
@@ -0,0 +1,138 @@
+package uihandlers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"brian-nunez/bcode/internal/auth"
+	"brian-nunez/bcode/internal/monitors"
+	"brian-nunez/bcode/internal/scheduler"
+	"brian-nunez/bcode/views/pages"
+	"github.com/labstack/echo/v4"
+)
+
+// Changes shown per monitor; the API has the full history.
+const monitorChangesShown = 3
+
+func MonitorsPageHandler(c echo.Context) error {
+	return renderMonitorsPage(c, http.StatusOK, "")
+}
+
+func CreateMonitorHandler(c echo.Context) error {
+	store, err := monitors.Default()
+	if err != nil {
+		return renderMonitorsPage(c, http.StatusInternalServerError, err.Error())
+	}
+
+	spec := monitors.Spec{
+		Name:       c.FormValue("name"),
+		ScheduleID: c.FormValue("schedule_id"),
+		Compare:    c.FormValue("compare"),
+		NotifyURL:  c.FormValue("notify_url"),
+	}
+	if spec.Fields, err = parseExtract(c.FormValue("fields")); err != nil {
+		return renderMonitorsPage(c, http.StatusBadRequest, err.Error())
+	}
+	if value := strings.TrimSpace(c.FormValue("threshold")); value != "" {
+		threshold, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return renderMonitorsPage(c, http.StatusBadRequest, monitors.ErrInvalidThreshold.Error())
+		}
+		spec.Threshold = &threshold
+	}
+
+	workspace := principalWorkspace(c)
+	if schedules, err := scheduler.Default(); err != nil {
+		return renderMonitorsPage(c, http.StatusInternalServerError, err.Error())
+	} else if schedule, err := schedules.Get(spec.ScheduleID); err != nil || schedule.Workspace != workspace {
+		return renderMonitorsPage(c, http.StatusBadRequest, scheduler.ErrNotFound.Error())
+	}
+
+	principal, _ := auth.PrincipalFrom(c)
+	if _, err := store.Create(workspace, principal.Subject(), spec); err != nil {
+		return renderMonitorsPage(c, http.StatusBadRequest, err.Error())
+	}
+
+	return c.Redirect(http.StatusSeeOther, "/monitors")
+}
+
+func DeleteMonitorHandler(c echo.Context) error {
+	store, err := monitors.Default()
+	if err != nil {
+		return renderMonitorsPage(c, http.StatusInternalServerError, err.Error())
+	}
+	monitor, err := store.Get(c.Param("id"))
+	if err != nil || monitor.Workspace != principalWorkspace(c) {
+		return renderMonitorsPage(c, http.StatusNotFound, monitors.ErrNotFound.Error())
+	}
+
+	if err := store.Delete(monitor.ID); err != nil {
+		return renderMonitorsPage(c, http.StatusInternalServerError, err.Error())
+	}
+
+	return c.Redirect(http.StatusSeeOther, "/monitors")
+}
+
+func renderMonitorsPage(c echo.Context, status int, errorMessage string) error {
+	workspace := principalWorkspace(c)
+
+	var options []pages.MonitorScheduleOption
+	scheduleNames := map[string]string{}
+	if schedules, err := scheduler.Default(); err != nil {
+		errorMessage = err.Error()
+	} else {
+		for _, schedule := range schedules.List() {
+			if schedule.Workspace == workspace {
+				options = append(options, pages.MonitorScheduleOption{ID: schedule.ID, Name: schedule.Name})
+				scheduleNames[schedule.ID] = schedule.Name
+			}
+		}
+	}
+
+	var rows []pages.MonitorRow
+	if store, err := monitors.Default(); err != nil {
+		errorMessage = err.Error()
+	} else {
+		for _, monitor := range store.List() {
+			if monitor.Workspace != workspace {
+				continue
+			}
+			row := pages.MonitorRow{
+				ID:        monitor.ID,
+				Name:      monitor.Name,
+				Schedule:  scheduleNames[monitor.ScheduleID],
+				Compare:   monitor.Compare,
+				Threshold: strconv.FormatFloat(monitor.Threshold, 'f', -1, 64),
+				NotifyURL: monitor.NotifyURL,
+			}
+			if row.Schedule == "" {
+				row.Schedule = "a deleted schedule"
+			}
+			if monitor.LastCheckedAt != nil {
+				row.LastCheckedAt = monitor.LastCheckedAt.UTC().Format("2006-01-02 15:04 MST")
+			}
+			changes, _ := store.Changes(monitor.ID)
+			for _, change := range changes[:min(len(changes), monitorChangesShown)] {
+				changeRow := pages.MonitorChangeRow{
+					DetectedAt:    change.DetectedAt.UTC().Format("2006-01-02 15:04 MST"),
+					Summary:       change.Summary,
+					JobID:         change.JobID,
+					PreviousJobID: change.PreviousJobID,
+					Diff:          change.Diff,
+					NotifyError:   change.NotifyError,
+				}
+				if change.Highlight != nil {
+					changeRow.HighlightURL = artifactURL(change.Highlight.JobID, change.Highlight.Name)
+				}
+				row.Changes = append(row.Changes, changeRow)
+			}
+			rows = append(rows, row)
+		}
+	}
+
+	c.Response().Header().Set(echo.HeaderContentType, echo.MIMETextHTMLCharsetUTF8)
+	c.Response().WriteHeader(status)
+	return pages.MonitorsPage(rows, options, errorMessage).Render(context.Background(), c.Response().Writer)
+}
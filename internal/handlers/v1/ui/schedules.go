@@ -0,0 +1,145 @@
+package uihandlers
+
+import (
+	"context"
+	"net/http"
+
+	"brian-nunez/bcode/internal/auth"
+	"brian-nunez/bcode/internal/scheduler"
+	"brian-nunez/bcode/views/pages"
+	"github.com/labstack/echo/v4"
+)
+
+// Runs shown per schedule; the API has the full history.
+const scheduleRunsShown = 5
+
+func SchedulesPageHandler(c echo.Context) error {
+	return renderSchedulesPage(c, http.StatusOK, "")
+}
+
+func CreateScheduleHandler(c echo.Context) error {
+	store, err := scheduler.Default()
+	if err != nil {
+		return renderSchedulesPage(c, http.StatusInternalServerError, err.Error())
+	}
+
+	template := map[string]string{
+		"action": c.FormValue("action"),
+		"url":    c.FormValue("url"),
+	}
+	if instruction := c.FormValue("instruction"); instruction != "" {
+		template["instruction"] = instruction
+	}
+
+	principal, _ := auth.PrincipalFrom(c)
+	_, err = store.Create(principal.Workspace, principal.Subject(), scheduler.Spec{
+		Name:       c.FormValue("name"),
+		Cron:       c.FormValue("cron"),
+		TimeZone:   c.FormValue("time_zone"),
+		Template:   template,
+		Enabled:    true,
+		MissedRuns: c.FormValue("missed_runs"),
+		Overlap:    c.FormValue("overlap"),
+	})
+	if err != nil {
+		return renderSchedulesPage(c, http.StatusBadRequest, err.Error())
+	}
+
+	return c.Redirect(http.StatusSeeOther, "/schedules")
+}
+
+func PauseScheduleHandler(c echo.Context) error {
+	return setScheduleEnabled(c, false)
+}
+
+func ResumeScheduleHandler(c echo.Context) error {
+	return setScheduleEnabled(c, true)
+}
+
+func setScheduleEnabled(c echo.Context, enabled bool) error {
+	store, err := ownSchedule(c)
+	if err != nil {
+		return renderSchedulesPage(c, http.StatusNotFound, err.Error())
+	}
+
+	if _, err := store.SetEnabled(c.Param("id"), enabled); err != nil {
+		return renderSchedulesPage(c, http.StatusInternalServerError, err.Error())
+	}
+
+	return c.Redirect(http.StatusSeeOther, "/schedules")
+}
+
+func DeleteScheduleHandler(c echo.Context) error {
+	store, err := ownSchedule(c)
+	if err != nil {
+		return renderSchedulesPage(c, http.StatusNotFound, err.Error())
+	}
+
+	if err := store.Delete(c.Param("id")); err != nil {
+		return renderSchedulesPage(c, http.StatusInternalServerError, err.Error())
+	}
+
+	return c.Redirect(http.StatusSeeOther, "/schedules")
+}
+
+// ownSchedule checks that the schedule in the id parameter belongs to the
+// signed-in user's workspace.
+func ownSchedule(c echo.Context) (*scheduler.Store, error) {
+	store, err := scheduler.Default()
+	if err != nil {
+		return nil, err
+	}
+	schedule, err := store.Get(c.Param("id"))
+	if err != nil {
+		return nil, err
+	}
+	if schedule.Workspace != principalWorkspace(c) {
+		return nil, scheduler.ErrNotFound
+	}
+	return store, nil
+}
+
+func renderSchedulesPage(c echo.Context, status int, errorMessage string) error {
+	var rows []pages.ScheduleRow
+	if store, err := scheduler.Default(); err != nil {
+		errorMessage = err.Error()
+	} else {
+		workspace := principalWorkspace(c)
+		for _, schedule := range store.List() {
+			if schedule.Workspace != workspace {
+				continue
+			}
+			row := pages.ScheduleRow{
+				ID:         schedule.ID,
+				Name:       schedule.Name,
+				Cron:       schedule.Cron,
+				TimeZone:   schedule.TimeZone,
+				Action:     schedule.Template["action"],
+				URL:        schedule.Template["url"],
+				Enabled:    schedule.Enabled,
+				MissedRuns: schedule.MissedRuns,
+				Overlap:    schedule.Overlap,
+			}
+			if row.TimeZone == "" {
+				row.TimeZone = "UTC"
+			}
+			if schedule.NextRunAt != nil {
+				row.NextRunAt = schedule.NextRunAt.UTC().Format("2006-01-02 15:04 MST")
+			}
+			runs, _ := store.Runs(schedule.ID)
+			for _, run := range runs[:min(len(runs), scheduleRunsShown)] {
+				row.Runs = append(row.Runs, pages.ScheduleRunRow{
+					ScheduledFor: run.ScheduledFor.UTC().Format("2006-01-02 15:04 MST"),
+					Status:       run.Status,
+					JobID:        run.JobID,
+					Reason:       run.Reason,
+				})
+			}
+			rows = append(rows, row)
+		}
+	}
+
+	c.Response().Header().Set(echo.HeaderContentType, echo.MIMETextHTMLCharsetUTF8)
+	c.Response().WriteHeader(status)
+	return pages.SchedulesPage(rows, errorMessage).Render(context.Background(), c.Response().Writer)
+}
@@ -0,0 +1,67 @@
+package uihandlers
+
+import (
+	"context"
+	"net/http"
+
+	"brian-nunez/bcode/internal/auth"
+	"brian-nunez/bcode/internal/secrets"
+	"brian-nunez/bcode/views/pages"
+	"github.com/labstack/echo/v4"
+)
+
+func SecretsPageHandler(c echo.Context) error {
+	return renderSecretsPage(c, http.StatusOK, "")
+}
+
+func SaveSecretHandler(c echo.Context) error {
+	store, err := secrets.ForWorkspace(principalWorkspace(c))
+	if err != nil {
+		return renderSecretsPage(c, http.StatusInternalServerError, err.Error())
+	}
+
+	if _, err := store.Set(c.FormValue("name"), c.FormValue("value")); err != nil {
+		return renderSecretsPage(c, http.StatusBadRequest, err.Error())
+	}
+
+	return c.Redirect(http.StatusSeeOther, "/secrets")
+}
+
+func DeleteSecretHandler(c echo.Context) error {
+	store, err := secrets.ForWorkspace(principalWorkspace(c))
+	if err != nil {
+		return renderSecretsPage(c, http.StatusInternalServerError, err.Error())
+	}
+
+	if err := store.Delete(c.Param("name")); err != nil {
+		return renderSecretsPage(c, http.StatusNotFound, err.Error())
+	}
+
+	return c.Redirect(http.StatusSeeOther, "/secrets")
+}
+
+// principalWorkspace is the workspace of the signed-in user.
+func principalWorkspace(c echo.Context) string {
+	principal, _ := auth.PrincipalFrom(c)
+	return principal.Workspace
+}
+
+func renderSecretsPage(c echo.Context, status int, errorMessage string) error {
+	var rows []pages.SecretRow
+	if store, err := secrets.ForWorkspace(principalWorkspace(c)); err != nil {
+		errorMessage = err.Error()
+	} else if list, err := store.List(); err != nil {
+		errorMessage = err.Error()
+	} else {
+		for _, secret := range list {
+			rows = append(rows, pages.SecretRow{
+				Name:      secret.Name,
+				UpdatedAt: secret.UpdatedAt.Format("2006-01-02 15:04"),
+			})
+		}
+	}
+
+	c.Response().Header().Set(echo.HeaderContentType, echo.MIMETextHTMLCharsetUTF8)
+	c.Response().WriteHeader(status)
+	return pages.SecretsPage(rows, errorMessage).Render(context.Background(), c.Response().Writer)
+}
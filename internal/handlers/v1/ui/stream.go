@@ -0,0 +1,407 @@
+package uihandlers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"brian-nunez/bcode/internal/jobs"
+	"brian-nunez/bcode/internal/orchestrator"
+	"brian-nunez/bcode/internal/profiles"
+	"brian-nunez/bcode/internal/redaction"
+	"brian-nunez/bcode/views/execution"
+	"github.com/labstack/echo/v4"
+)
+
+// workerResult is the JOB_RESULT a worker reports before it exits.
+type workerResult struct {
+	Success        bool                 `json:"success"`
+	Data           string               `json:"data"`
+	Fields         map[string]string    `json:"fields"`
+	Screenshot     string               `json:"screenshot"`
+	Artifacts      []string             `json:"artifacts"`
+	FailedRequests []jobs.FailedRequest `json:"failed_requests"`
+	Console        *jobs.ConsoleTotals  `json:"console"`
+	Video          string               `json:"video"`
+	Timeline       []jobs.TimelineEntry `json:"timeline"`
+	Error          *jobs.JobError       `json:"error"`
+	Usage          *jobs.Usage          `json:"usage"`
+}
+
+// jobStream relays a job's worker output to the execution monitor. A job may
+// run several workers in turn (one per attempt) over the same stream.
+type jobStream struct {
+	ctx          context.Context
+	out          jobOutput
+	jobID        string
+	redact       *redaction.Redactor
+	received     *artifactReceiver
+	profileStore *profiles.Store
+	profileName  string
+	saveProfile  bool
+	saveResult   bool
+	started      bool
+}
+
+// jobOutput is where a job's stream goes: the /execute response, or nowhere
+// for jobs run in the background.
+type jobOutput interface {
+	io.Writer
+	// Start is called once, before the first write.
+	Start()
+	Flush()
+}
+
+// responseOutput streams a job to the client of /execute.
+type responseOutput struct {
+	c echo.Context
+}
+
+func (o responseOutput) Start() {
+	o.c.Response().Header().Set(echo.HeaderContentType, "text/html; charset=utf-8")
+	o.c.Response().WriteHeader(http.StatusOK)
+}
+
+func (o responseOutput) Write(p []byte) (int, error) {
+	return o.c.Response().Write(p)
+}
+
+func (o responseOutput) Flush() {
+	o.c.Response().Flush()
+}
+
+// discardOutput drops the stream of a job nobody is watching.
+type discardOutput struct{}
+
+func (discardOutput) Start()                      {}
+func (discardOutput) Write(p []byte) (int, error) { return len(p), nil }
+func (discardOutput) Flush()                      {}
+
+// start sends the response headers and the job ID once, before the first
+// worker's output.
+func (s *jobStream) start() {
+	if s.started {
+		return
+	}
+	s.started = true
+	s.out.Start()
+
+	// Protocol: JOB: <id>
+	fmt.Fprintf(s.out, "JOB: %s\n", s.jobID)
+	s.out.Flush()
+}
+
+func (s *jobStream) logInfo(message string) {
+	fmt.Fprintf(s.out, "LOG: <div class='text-xs text-gray-400 font-mono'>%s</div>\n", html.EscapeString(message))
+	s.out.Flush()
+}
+
+func (s *jobStream) logError(message string) {
+	fmt.Fprintf(s.out, "LOG: <div class='text-red-500'>%s</div>\n", html.EscapeString(message))
+	s.out.Flush()
+}
+
+// logTailLines is how many of the worker's last log lines are attached to a
+// failure synthesized for a worker that crashed.
+const logTailLines = 20
+
+// relay streams one worker's output until it exits and returns its result.
+// When the worker exits without one (a panic, an OOM kill), the result is a
+// failure built from its exit status and last log lines.
+func (s *jobStream) relay(run *orchestrator.Execution) *workerResult {
+	defer run.Close()
+
+	jobs.Default.SetControl(s.jobID, run.Control)
+	defer jobs.Default.SetControl(s.jobID, nil)
+
+	w := s.out
+	redact := s.redact
+
+	// Stream logs line by line
+	scanner := bufio.NewScanner(run.Logs)
+	// Increase buffer size to handle large base64 images (5MB)
+	const maxCapacity = 5 * 1024 * 1024
+	buf := make([]byte, maxCapacity)
+	scanner.Buffer(buf, maxCapacity)
+
+	var result *workerResult
+	var tail []string
+	for scanner.Scan() {
+		raw := scanner.Text()
+
+		// 1. Check for Live Updates (Screenshots)
+		const updatePrefix = "JOB_UPDATE:"
+		if jsonPart, ok := strings.CutPrefix(raw, updatePrefix); ok {
+			var update struct {
+				Image string `json:"image"`
+			}
+			if err := json.Unmarshal([]byte(jsonPart), &update); err == nil && update.Image != "" {
+				// Protocol: IMG: <data>
+				fmt.Fprintf(w, "IMG: %s\n", update.Image)
+				s.out.Flush()
+				continue
+			}
+		}
+
+		// Screencast frames go to the job's WebSocket viewers, not this stream
+		const framePrefix = "JOB_FRAME:"
+		if encoded, ok := strings.CutPrefix(raw, framePrefix); ok {
+			if frame, err := base64.StdEncoding.DecodeString(encoded); err == nil {
+				jobs.Default.PublishFrame(s.jobID, frame)
+			}
+			continue
+		}
+
+		// 2. Check for Artifact Uploads (screenshots, PDFs, downloads)
+		const artifactPrefix = "JOB_ARTIFACT:"
+		if jsonPart, ok := strings.CutPrefix(raw, artifactPrefix); ok {
+			var chunk artifactChunk
+			if err := json.Unmarshal([]byte(jsonPart), &chunk); err == nil {
+				artifact, err := s.received.receive(s.ctx, chunk)
+				switch {
+				case err != nil:
+					fmt.Fprintf(w, "LOG: <div class='text-red-500'>Could not store artifact %s: %s</div>\n", html.EscapeString(chunk.Name), html.EscapeString(err.Error()))
+				case artifact != nil:
+					jobs.Default.Update(s.jobID, func(j *jobs.Job) {
+						j.Artifacts = append(j.Artifacts, *artifact)
+					})
+					fmt.Fprintf(w, "LOG: <div class='text-xs text-gray-400 font-mono'>Stored artifact %s (%d bytes)</div>\n", html.EscapeString(artifact.Name), artifact.Size)
+				}
+				s.out.Flush()
+				continue
+			}
+		}
+
+		// The worker already redacts its output; this guards against any secret
+		// that slips through. Patterns only apply to text, never to image data.
+		line := redact.Secrets(raw)
+
+		// 3. Check for Operator Prompts (ask_user, risky action approval, take-over)
+		const pausePrefix = "JOB_PAUSE:"
+		if jsonPart, ok := strings.CutPrefix(line, pausePrefix); ok {
+			var prompt jobs.Prompt
+			if err := json.Unmarshal([]byte(jsonPart), &prompt); err == nil {
+				prompt.Message = redact.Redact(prompt.Message)
+				jobs.Default.Update(s.jobID, func(j *jobs.Job) {
+					j.Status = jobs.StatusPaused
+					j.Prompt = &prompt
+				})
+
+				if prompt.Image != "" {
+					fmt.Fprintf(w, "IMG: %s\n", prompt.Image)
+				}
+
+				promptBuf := bytes.NewBuffer(nil)
+				execution.OperatorPrompt(s.jobID, prompt.Kind, prompt.Message).Render(context.Background(), promptBuf)
+
+				// Protocol: ASK: <html>
+				cleanHTML := strings.ReplaceAll(promptBuf.String(), "\n", " ")
+				fmt.Fprintf(w, "ASK: %s\n", cleanHTML)
+				s.out.Flush()
+				continue
+			}
+		}
+
+		const resumePrefix = "JOB_RESUME:"
+		if strings.HasPrefix(line, resumePrefix) {
+			jobs.Default.Update(s.jobID, func(j *jobs.Job) {
+				j.Status = jobs.StatusRunning
+				j.Prompt = nil
+			})
+
+			// Protocol: ASK: <empty> clears the prompt
+			fmt.Fprint(w, "ASK: \n")
+			s.out.Flush()
+			continue
+		}
+
+		// 4. Check for Browser Console Events
+		const consolePrefix = "JOB_CONSOLE:"
+		if jsonPart, ok := strings.CutPrefix(line, consolePrefix); ok {
+			var event jobs.ConsoleEvent
+			if err := json.Unmarshal([]byte(jsonPart), &event); err == nil {
+				consoleBuf := bytes.NewBuffer(nil)
+				execution.ConsoleEntry(event.Level, event.Type, redact.Redact(event.Text), redact.Redact(event.Location)).Render(context.Background(), consoleBuf)
+
+				// Protocol: CON: <html>
+				cleanHTML := strings.ReplaceAll(consoleBuf.String(), "\n", " ")
+				fmt.Fprintf(w, "CON: %s\n", cleanHTML)
+				s.out.Flush()
+				continue
+			}
+		}
+
+		// 5. Check for Browser Profile Save-back
+		const profilePrefix = "JOB_PROFILE:"
+		if jsonPart, ok := strings.CutPrefix(line, profilePrefix); ok {
+			var saved struct {
+				Name  string          `json:"name"`
+				State json.RawMessage `json:"state"`
+			}
+			if err := json.Unmarshal([]byte(jsonPart), &saved); err == nil {
+				switch {
+				case s.profileStore == nil || !s.saveProfile || saved.Name != s.profileName:
+					fmt.Fprintf(w, "LOG: <div class='text-red-500'>Ignoring unrequested save to profile %s</div>\n", html.EscapeString(saved.Name))
+				case s.profileStore.Save(saved.Name, saved.State) != nil:
+					fmt.Fprintf(w, "LOG: <div class='text-red-500'>Could not save profile %s</div>\n", html.EscapeString(saved.Name))
+				default:
+					fmt.Fprintf(w, "LOG: <div class='text-xs text-gray-400 font-mono'>Saved browser profile %s</div>\n", html.EscapeString(saved.Name))
+				}
+				s.out.Flush()
+				continue
+			}
+		}
+
+		// 6. Check for Final Result. It is rendered by finish once no more
+		// attempts will follow.
+		const resultPrefix = "JOB_RESULT:"
+		if jsonPart, ok := strings.CutPrefix(line, resultPrefix); ok {
+			var attemptResult workerResult
+			if err := json.Unmarshal([]byte(jsonPart), &attemptResult); err == nil {
+				attemptResult.Data = redact.Redact(attemptResult.Data)
+				for name, value := range attemptResult.Fields {
+					attemptResult.Fields[name] = redact.Redact(value)
+				}
+				if attemptResult.Error != nil {
+					attemptResult.Error.Message = redact.Redact(attemptResult.Error.Message)
+					for key, value := range attemptResult.Error.Details {
+						attemptResult.Error.Details[key] = redact.Redact(value)
+					}
+				}
+				for i := range attemptResult.Timeline {
+					attemptResult.Timeline[i].Step = redact.Redact(attemptResult.Timeline[i].Step)
+				}
+				for i := range attemptResult.FailedRequests {
+					attemptResult.FailedRequests[i].URL = redact.Redact(attemptResult.FailedRequests[i].URL)
+				}
+				result = &attemptResult
+				continue
+			}
+		}
+
+		// Otherwise just print the line as a log
+		// Protocol: LOG: <html>
+		line = redact.Redact(line)
+		if tail = append(tail, line); len(tail) > logTailLines {
+			tail = tail[1:]
+		}
+		fmt.Fprintf(w, "LOG: <div class='text-xs text-gray-400 font-mono'>%s</div>\n", line)
+		s.out.Flush()
+	}
+
+	if err := scanner.Err(); err != nil {
+		fmt.Fprintf(w, "LOG: <div class='text-red-500'>Error reading logs: %v</div>\n", err)
+		s.out.Flush()
+	}
+
+	if result == nil {
+		result = exitResult(run.Wait(), tail)
+	}
+	return result
+}
+
+// exitResult is the failure reported for a worker that exited without a
+// JOB_RESULT.
+func exitResult(status orchestrator.ExitStatus, tail []string) *workerResult {
+	jobErr := jobs.NewError(jobs.ErrWorkerFailed, "worker exited without a result ("+status.String()+")")
+	if status.OOMKilled {
+		jobErr = jobs.NewError(jobs.ErrContainerOOM, "worker was killed after running out of memory")
+	}
+	jobErr.Details = map[string]string{
+		"exit_code": strconv.FormatInt(status.Code, 10),
+		"log_tail":  strings.Join(tail, "\n"),
+	}
+	if status.OOMKilled {
+		jobErr.Details["oom_killed"] = "true"
+	}
+
+	return &workerResult{
+		Data:  strings.Join(tail, "\n"),
+		Error: jobErr,
+	}
+}
+
+// finish records the job's final result and renders it. A job that never
+// got a result, such as one cancelled before its first worker ran, fails.
+func (s *jobStream) finish(result *workerResult) {
+	if result == nil {
+		result = &workerResult{Error: jobs.NewError(jobs.ErrWorkerFailed, "the job ended without a result")}
+	}
+	jobs.Default.Update(s.jobID, func(j *jobs.Job) {
+		j.Status = jobs.StatusSucceeded
+		if !result.Success {
+			j.Status = jobs.StatusFailed
+			j.Error = result.Error
+		}
+		j.Prompt = nil
+		j.FailedRequests = result.FailedRequests
+		j.Console = result.Console
+		j.Fields = result.Fields
+		j.Screenshot = result.Screenshot
+	})
+	if s.saveResult && result.Success && result.Data != "" {
+		s.storeResult(result.Data)
+	}
+
+	job, _ := jobs.Default.Get(s.jobID)
+
+	// Render the result component to a buffer/string
+	resultBuf := bytes.NewBuffer(nil)
+	execution.JobResultView(execution.ResultProps{
+		Data:           result.Data,
+		ScreenshotURL:  optionalArtifactURL(s.jobID, result.Screenshot),
+		VideoURL:       optionalArtifactURL(s.jobID, result.Video),
+		Timeline:       timelineSteps(result.Timeline),
+		Artifacts:      artifactLinks(s.jobID),
+		FailedRequests: failedRequestRows(result.FailedRequests),
+		ConsoleSummary: consoleSummary(result.Console),
+		Attempts:       len(job.Attempts),
+		Error:          errorInfo(job.Error),
+	}).Render(context.Background(), resultBuf)
+
+	// Protocol: END: <html>
+	cleanHTML := strings.ReplaceAll(resultBuf.String(), "\n", " ")
+	fmt.Fprintf(s.out, "END: %s\n", cleanHTML)
+	s.out.Flush()
+}
+
+var errorTitles = map[jobs.ErrorCode]struct{ title, hint string }{
+	jobs.ErrNavigationFailed: {"The page could not be loaded", "Check the URL, and that the site and any proxy are reachable from the worker."},
+	jobs.ErrTimeout:          {"The page took too long", "The site may be slow or blocking automated browsers."},
+	jobs.ErrLLMUnavailable:   {"The model is unavailable", "Check that Ollama is running and OLLAMA_MODEL is pulled."},
+	jobs.ErrLLMBadOutput:     {"The model returned an unusable answer", "Try rephrasing the instruction or another model."},
+	jobs.ErrSelectorNotFound: {"An element could not be found", "The page may have changed, or the element only appears after another step."},
+	jobs.ErrBlockedByPolicy:  {"Blocked by policy", "An action was not approved, or the job is not allowed to run right now."},
+	jobs.ErrContainerOOM:     {"The worker ran out of memory", "Heavy pages may need a larger worker memory limit."},
+	jobs.ErrPageError:        {"The page could not be processed", ""},
+	jobs.ErrWorkerFailed:     {"The worker failed", "See the execution logs for details."},
+	jobs.ErrInvalidRequest:   {"The job is invalid", ""},
+	jobs.ErrInternal:         {"Internal error", ""},
+	jobs.ErrQuotaExceeded:    {"The workspace is over its quota", "Wait for the quota to reset or ask an operator to raise it."},
+	jobs.ErrProfileInUse:     {"The browser profile is in use", "Another job is saving this profile; run again once it has finished."},
+}
+
+// errorInfo describes a job error for the result view.
+func errorInfo(jobErr *jobs.JobError) *execution.ErrorInfo {
+	if jobErr == nil {
+		return nil
+	}
+	info := &execution.ErrorInfo{
+		Code:      string(jobErr.Code),
+		Title:     "The job failed",
+		Message:   jobErr.Message,
+		Retryable: jobErr.Retryable,
+	}
+	if text, ok := errorTitles[jobErr.Code]; ok {
+		info.Title, info.Hint = text.title, text.hint
+	}
+	return info
+}
@@ -0,0 +1,124 @@
+package v1
+
+import (
+	stderrors "errors"
+	"net/http"
+
+	"brian-nunez/bcode/internal/auth"
+	"brian-nunez/bcode/internal/handlers/errors"
+	"brian-nunez/bcode/internal/webhooks"
+	"github.com/labstack/echo/v4"
+)
+
+type createWebhookRequest struct {
+	URL string `json:"url"`
+	// Events defaults to every event
+	Events []string `json:"events"`
+	// Secret is generated when empty
+	Secret string `json:"secret"`
+}
+
+type createWebhookResponse struct {
+	webhooks.Webhook
+	// Secret is only returned here; deliveries are signed with it.
+	Secret string `json:"secret"`
+}
+
+func ListWebhooksHandler(c echo.Context) error {
+	store, err := webhooks.Default()
+	if err != nil {
+		response := errors.InternalServerError().Build()
+		return c.JSON(response.HTTPStatusCode, response)
+	}
+
+	principal, _ := auth.PrincipalFrom(c)
+	list := []webhooks.Webhook{}
+	for _, webhook := range store.List() {
+		if principal.Sees(webhook.Workspace) {
+			list = append(list, webhook)
+		}
+	}
+
+	return c.JSON(http.StatusOK, list)
+}
+
+// CreateWebhookHandler subscribes a URL to the events of every job in the
+// caller's workspace, or in the one an operator names in the workspace query
+// parameter.
+func CreateWebhookHandler(c echo.Context) error {
+	var req createWebhookRequest
+	if err := c.Bind(&req); err != nil {
+		response := errors.InvalidRequest().Build()
+		return c.JSON(response.HTTPStatusCode, response)
+	}
+
+	workspace, failure := requestWorkspace(c)
+	if failure != nil {
+		return c.JSON(failure.HTTPStatusCode, failure)
+	}
+	store, err := webhooks.Default()
+	if err != nil {
+		response := errors.InternalServerError().Build()
+		return c.JSON(response.HTTPStatusCode, response)
+	}
+
+	principal, _ := auth.PrincipalFrom(c)
+	webhook, secret, err := store.Create(workspace, req.URL, req.Events, req.Secret, principal.Subject())
+	switch {
+	case err == nil:
+		return c.JSON(http.StatusCreated, createWebhookResponse{Webhook: webhook, Secret: secret})
+	case stderrors.Is(err, webhooks.ErrInvalidURL), stderrors.Is(err, webhooks.ErrInvalidEvents), stderrors.Is(err, webhooks.ErrInvalidSecret):
+		response := errors.InvalidRequest().WithMessage(err.Error()).Build()
+		return c.JSON(response.HTTPStatusCode, response)
+	}
+
+	response := errors.InternalServerError().Build()
+	return c.JSON(response.HTTPStatusCode, response)
+}
+
+// DeleteWebhookHandler stops a webhook's deliveries. Those already logged are
+// kept.
+func DeleteWebhookHandler(c echo.Context) error {
+	store, err := webhooks.Default()
+	if err != nil {
+		response := errors.InternalServerError().Build()
+		return c.JSON(response.HTTPStatusCode, response)
+	}
+
+	principal, _ := auth.PrincipalFrom(c)
+	webhook, err := store.Get(c.Param("id"))
+	if err != nil || !principal.Sees(webhook.Workspace) {
+		response := errors.NotFound().WithMessage("Webhook not found").Build()
+		return c.JSON(response.HTTPStatusCode, response)
+	}
+
+	if err := store.Delete(webhook.ID); err != nil {
+		response := errors.InternalServerError().Build()
+		return c.JSON(response.HTTPStatusCode, response)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// ListWebhookDeliveriesHandler returns the workspace's delivery log, newest
+// first, optionally only for the job_id or webhook_id query parameters.
+func ListWebhookDeliveriesHandler(c echo.Context) error {
+	workspace, failure := requestWorkspace(c)
+	if failure != nil {
+		return c.JSON(failure.HTTPStatusCode, failure)
+	}
+	store, err := webhooks.Default()
+	if err != nil {
+		response := errors.InternalServerError().Build()
+		return c.JSON(response.HTTPStatusCode, response)
+	}
+
+	jobID, webhookID := c.QueryParam("job_id"), c.QueryParam("webhook_id")
+	list := []webhooks.Delivery{}
+	for _, delivery := range store.Deliveries(workspace) {
+		if (jobID == "" || delivery.JobID == jobID) && (webhookID == "" || delivery.WebhookID == webhookID) {
+			list = append(list, delivery)
+		}
+	}
+
+	return c.JSON(http.StatusOK, list)
+}
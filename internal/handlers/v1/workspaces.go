@@ -0,0 +1,120 @@
+package v1
+
+import (
+	stderrors "errors"
+	"net/http"
+
+	"brian-nunez/bcode/internal/auth"
+	"brian-nunez/bcode/internal/handlers/errors"
+	"brian-nunez/bcode/internal/workspaces"
+	"github.com/labstack/echo/v4"
+)
+
+type workspaceResponse struct {
+	workspaces.Workspace
+	Usage workspaces.Usage `json:"usage"`
+}
+
+// ListWorkspacesHandler lists the caller's workspace, or every workspace for
+// operators, with its quota and current usage.
+func ListWorkspacesHandler(c echo.Context) error {
+	store, err := workspaces.Default()
+	if err != nil {
+		response := errors.InternalServerError().Build()
+		return c.JSON(response.HTTPStatusCode, response)
+	}
+
+	principal, _ := auth.PrincipalFrom(c)
+	list := []workspaceResponse{}
+	for _, workspace := range store.List() {
+		if principal.Sees(workspace.Name) {
+			list = append(list, workspaceResponse{Workspace: workspace, Usage: store.Usage(workspace.Name)})
+		}
+	}
+
+	return c.JSON(http.StatusOK, list)
+}
+
+// PutWorkspaceHandler creates a workspace or replaces its quota.
+func PutWorkspaceHandler(c echo.Context) error {
+	if response := requireOperator(c); response != nil {
+		return c.JSON(response.HTTPStatusCode, response)
+	}
+
+	var quota workspaces.Quota
+	if err := c.Bind(&quota); err != nil {
+		response := errors.InvalidRequest().Build()
+		return c.JSON(response.HTTPStatusCode, response)
+	}
+
+	store, err := workspaces.Default()
+	if err != nil {
+		response := errors.InternalServerError().Build()
+		return c.JSON(response.HTTPStatusCode, response)
+	}
+
+	workspace, err := store.Put(c.Param("name"), quota)
+	switch {
+	case err == nil:
+		return c.JSON(http.StatusOK, workspaceResponse{Workspace: workspace, Usage: store.Usage(workspace.Name)})
+	case stderrors.Is(err, workspaces.ErrInvalidName), stderrors.Is(err, workspaces.ErrInvalidQuota):
+		response := errors.InvalidRequest().WithMessage(err.Error()).Build()
+		return c.JSON(response.HTTPStatusCode, response)
+	}
+
+	response := errors.InternalServerError().Build()
+	return c.JSON(response.HTTPStatusCode, response)
+}
+
+// DeleteWorkspaceHandler deletes a workspace. What it owned is kept on disk.
+func DeleteWorkspaceHandler(c echo.Context) error {
+	if response := requireOperator(c); response != nil {
+		return c.JSON(response.HTTPStatusCode, response)
+	}
+
+	store, err := workspaces.Default()
+	if err != nil {
+		response := errors.InternalServerError().Build()
+		return c.JSON(response.HTTPStatusCode, response)
+	}
+
+	err = store.Delete(c.Param("name"))
+	switch {
+	case err == nil:
+		return c.NoContent(http.StatusNoContent)
+	case stderrors.Is(err, workspaces.ErrDefault):
+		response := errors.InvalidRequest().WithMessage(err.Error()).Build()
+		return c.JSON(response.HTTPStatusCode, response)
+	case stderrors.Is(err, workspaces.ErrNotFound):
+		response := errors.NotFound().WithMessage("Workspace not found").Build()
+		return c.JSON(response.HTTPStatusCode, response)
+	}
+
+	response := errors.InternalServerError().Build()
+	return c.JSON(response.HTTPStatusCode, response)
+}
+
+// requireOperator refuses callers that are not admins of the default
+// workspace.
+func requireOperator(c echo.Context) *errors.ErrorResponse {
+	if principal, _ := auth.PrincipalFrom(c); !principal.Operator() {
+		return errors.Forbidden().WithMessage("Only admins of the default workspace can manage workspaces").Build()
+	}
+	return nil
+}
+
+// requestWorkspace is the workspace a request acts on: the caller's own, or
+// the one an operator names in the workspace query parameter.
+func requestWorkspace(c echo.Context) (string, *errors.ErrorResponse) {
+	principal, _ := auth.PrincipalFrom(c)
+	workspace, err := principal.TargetWorkspace(c.QueryParam("workspace"))
+	switch {
+	case err == nil:
+		return workspace, nil
+	case stderrors.Is(err, auth.ErrOtherWorkspace):
+		return "", errors.Forbidden().WithMessage(err.Error()).Build()
+	case stderrors.Is(err, workspaces.ErrNotFound):
+		return "", errors.NotFound().WithMessage("Workspace not found").Build()
+	}
+	return "", errors.InternalServerError().Build()
+}
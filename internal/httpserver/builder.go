@@ -1,6 +1,9 @@
 package httpserver
 
 import (
+	"os"
+	"strings"
+
 	"brian-nunez/bcode/internal/handlers/errors"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
@@ -19,12 +22,29 @@ func New() *ServerBuilder {
 func (b *ServerBuilder) WithDefaultMiddleware() *ServerBuilder {
 	b.e.Use(middleware.Recover())
 	b.e.Use(middleware.RequestID())
-	b.e.Use(middleware.CORS())
+	// Cross-origin requests are refused unless their origin is listed in
+	// CORS_ALLOWED_ORIGINS (comma separated)
+	if origins := allowedOrigins(); len(origins) > 0 {
+		b.e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
+			AllowOrigins: origins,
+			AllowHeaders: []string{echo.HeaderAuthorization, echo.HeaderContentType, "X-API-Key"},
+		}))
+	}
 	b.e.Use(middleware.Logger())
 
 	return b
 }
 
+func allowedOrigins() []string {
+	var origins []string
+	for _, origin := range strings.Split(os.Getenv("CORS_ALLOWED_ORIGINS"), ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			origins = append(origins, origin)
+		}
+	}
+	return origins
+}
+
 func (b *ServerBuilder) WithRoutes(register func(e *echo.Echo)) *ServerBuilder {
 	register(b.e)
 	return b
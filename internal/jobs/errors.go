@@ -0,0 +1,56 @@
+package jobs
+
+import "fmt"
+
+type ErrorCode string
+
+// Error codes a job can fail with. Workers report all but CONTAINER_OOM,
+// which the server synthesizes when the container is killed.
+const (
+	ErrNavigationFailed ErrorCode = "NAVIGATION_FAILED"
+	ErrTimeout          ErrorCode = "TIMEOUT"
+	ErrLLMUnavailable   ErrorCode = "LLM_UNAVAILABLE"
+	ErrLLMBadOutput     ErrorCode = "LLM_BAD_OUTPUT"
+	ErrSelectorNotFound ErrorCode = "SELECTOR_NOT_FOUND"
+	ErrBlockedByPolicy  ErrorCode = "BLOCKED_BY_POLICY"
+	ErrContainerOOM     ErrorCode = "CONTAINER_OOM"
+	ErrPageError        ErrorCode = "PAGE_ERROR"
+	ErrWorkerFailed     ErrorCode = "WORKER_FAILED"
+
+	// Jobs refused or broken before a worker ran share the API's codes
+	ErrInvalidRequest ErrorCode = "INVALID_REQUEST"
+	ErrInternal       ErrorCode = "INTERNAL_SERVER_ERROR"
+	ErrQuotaExceeded  ErrorCode = "QUOTA_EXCEEDED"
+	ErrProfileInUse   ErrorCode = "PROFILE_IN_USE"
+)
+
+// JobError is why a job, or one of its attempts, failed. Its fields are named
+// like the error in API error responses.
+type JobError struct {
+	Code      ErrorCode         `json:"error_code"`
+	Message   string            `json:"error_message"`
+	Retryable bool              `json:"retryable"`
+	Details   map[string]string `json:"details,omitempty"`
+}
+
+// NewError builds an error with the code's default retryability.
+func NewError(code ErrorCode, message string) *JobError {
+	return &JobError{
+		Code:      code,
+		Message:   message,
+		Retryable: code.Retryable(),
+	}
+}
+
+func (e *JobError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// Retryable reports whether failures with this code are usually transient.
+func (c ErrorCode) Retryable() bool {
+	switch c {
+	case ErrNavigationFailed, ErrTimeout, ErrLLMUnavailable, ErrWorkerFailed, ErrProfileInUse:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,65 @@
+package jobs
+
+// frameBuffer is how many frames may queue for a viewer. A viewer that falls
+// further behind misses frames instead of slowing down the job.
+const frameBuffer = 2
+
+// Watch subscribes to a job's screencast frames. The channel is closed when
+// the job's stream ends; call the returned function to unsubscribe earlier.
+func (r *Registry) Watch(id string) (<-chan []byte, func(), error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	e, ok := r.jobs[id]
+	if !ok {
+		return nil, nil, ErrNotFound
+	}
+	if e.viewers == nil {
+		e.viewers = make(map[chan []byte]struct{})
+	}
+
+	frames := make(chan []byte, frameBuffer)
+	e.viewers[frames] = struct{}{}
+
+	unwatch := func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if _, ok := e.viewers[frames]; ok {
+			delete(e.viewers, frames)
+			close(frames)
+		}
+	}
+	return frames, unwatch, nil
+}
+
+// PublishFrame hands a frame to every viewer without blocking.
+func (r *Registry) PublishFrame(id string, frame []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	e, ok := r.jobs[id]
+	if !ok {
+		return
+	}
+	for viewer := range e.viewers {
+		select {
+		case viewer <- frame:
+		default:
+		}
+	}
+}
+
+// EndFrames closes every viewer once the job stops streaming.
+func (r *Registry) EndFrames(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	e, ok := r.jobs[id]
+	if !ok {
+		return
+	}
+	for viewer := range e.viewers {
+		delete(e.viewers, viewer)
+		close(viewer)
+	}
+}
@@ -0,0 +1,508 @@
+package jobs
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"brian-nunez/bcode/internal/artifacts"
+	"brian-nunez/bcode/internal/redaction"
+)
+
+type Status string
+
+const (
+	// StatusQueued jobs wait in the orchestrator queue for their workspace's
+	// limits to let them start.
+	StatusQueued    Status = "queued"
+	StatusRunning   Status = "running"
+	StatusPaused    Status = "paused"
+	StatusSucceeded Status = "succeeded"
+	StatusFailed    Status = "failed"
+)
+
+// Prompt kinds emitted by the worker when it pauses for an operator.
+const (
+	PromptQuestion = "question"
+	PromptApproval = "approval"
+	PromptTakeover = "takeover"
+)
+
+// Operator decisions accepted by a paused job.
+const (
+	DecisionAnswer   = "answer"
+	DecisionApprove  = "approve"
+	DecisionReject   = "reject"
+	DecisionTakeOver = "take_over"
+	DecisionResume   = "resume"
+)
+
+var (
+	ErrNotFound  = errors.New("job not found")
+	ErrNotPaused = errors.New("job is not waiting for input")
+	ErrNoControl = errors.New("job has no control channel")
+)
+
+// Payload is the job specification handed to the worker, on its stdin or in
+// a private file (see orchestrator.JobRequest).
+type Payload struct {
+	Action              string          `json:"action"`
+	URL                 string          `json:"url"`
+	Target              string          `json:"target,omitempty"`
+	Approval            *ApprovalPolicy `json:"approval,omitempty"`
+	PauseTimeoutSeconds int             `json:"pause_timeout_seconds,omitempty"`
+	Profile             *ProfileOptions `json:"profile,omitempty"`
+	// Secrets holds the values of the {{secret:name}} placeholders used in the
+	// goal. The worker only substitutes them into fill actions.
+	Secrets   map[string]string `json:"secrets,omitempty"`
+	Redaction *redaction.Policy `json:"redaction,omitempty"`
+	SavePDF   bool              `json:"save_pdf,omitempty"`
+	// Screenshot asks for a final screenshot from actions that take none
+	Screenshot bool `json:"screenshot,omitempty"`
+	// Extract names CSS selectors whose text the worker reports as fields
+	Extract     map[string]string  `json:"extract,omitempty"`
+	Network     *NetworkOptions    `json:"network,omitempty"`
+	RecordVideo bool               `json:"record_video,omitempty"`
+	Screencast  *ScreencastOptions `json:"screencast,omitempty"`
+	Browser     string             `json:"browser,omitempty"`
+	Launch      *LaunchOptions     `json:"launch,omitempty"`
+}
+
+// Browsers are the engines a job can run on.
+var Browsers = []string{"chromium", "firefox", "webkit"}
+
+// LaunchOptions configure how the worker starts the browser.
+type LaunchOptions struct {
+	// Headed runs the browser with a window on a virtual X display.
+	Headed    bool          `json:"headed,omitempty"`
+	Args      []string      `json:"args,omitempty"`
+	UserAgent string        `json:"user_agent,omitempty"`
+	Proxy     *ProxyOptions `json:"proxy,omitempty"`
+}
+
+// ProxyOptions route the browser's traffic through an HTTP or SOCKS5 proxy.
+// Username and Password may be {{secret:name}} placeholders.
+type ProxyOptions struct {
+	Server   string `json:"server"`
+	Bypass   string `json:"bypass,omitempty"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+// ScreencastOptions streams the live view continuously over CDP instead of
+// sending a screenshot after each action.
+type ScreencastOptions struct {
+	FPS     int `json:"fps,omitempty"`
+	Quality int `json:"quality,omitempty"`
+}
+
+// NetworkOptions opts a job into network capture: a HAR file, a streamed
+// per-request summary, or both. Either adds failed requests to the result.
+type NetworkOptions struct {
+	HAR     bool `json:"har,omitempty"`
+	Summary bool `json:"summary,omitempty"`
+}
+
+// ConsoleEvent is a browser console message, uncaught page error, failed
+// request or dialog reported by the worker.
+type ConsoleEvent struct {
+	Type     string `json:"type"`
+	Level    string `json:"level"`
+	Text     string `json:"text"`
+	Location string `json:"location,omitempty"`
+}
+
+// ConsoleTotals counts a job's console events by level.
+type ConsoleTotals struct {
+	Debug   int `json:"debug"`
+	Info    int `json:"info"`
+	Warning int `json:"warning"`
+	Error   int `json:"error"`
+}
+
+// TimelineEntry places an agent history step on the session video.
+type TimelineEntry struct {
+	OffsetSeconds float64 `json:"offset_seconds"`
+	Step          string  `json:"step"`
+}
+
+type FailedRequest struct {
+	URL     string `json:"url"`
+	Method  string `json:"method"`
+	Status  int    `json:"status,omitempty"`
+	Failure string `json:"failure,omitempty"`
+}
+
+// ProfileOptions loads a stored browser profile into the job and optionally
+// saves the browser's storage state back to it when the job ends.
+type ProfileOptions struct {
+	Name  string          `json:"name"`
+	State json.RawMessage `json:"state,omitempty"`
+	Save  bool            `json:"save,omitempty"`
+}
+
+// ApprovalPolicy lists the agent actions that must be approved by an operator
+// before the worker performs them.
+type ApprovalPolicy struct {
+	Patterns   []string `json:"patterns,omitempty"`
+	FormSubmit bool     `json:"form_submit,omitempty"`
+}
+
+type Prompt struct {
+	// ID ties the operator's response to this prompt, so that a response
+	// arriving after the worker gave up waiting is not taken as the answer
+	// to a later one.
+	ID      string `json:"id,omitempty"`
+	Kind    string `json:"kind"`
+	Message string `json:"message"`
+	Image   string `json:"image,omitempty"`
+}
+
+// Control message types understood by the worker.
+const (
+	ControlRespond  = "respond"
+	ControlInput    = "input"
+	ControlTakeOver = "take_over"
+)
+
+// ControlMessage is written as a JSON line to the worker's stdin.
+type ControlMessage struct {
+	Type     string      `json:"type"`
+	PromptID string      `json:"prompt_id,omitempty"`
+	Decision string      `json:"decision,omitempty"`
+	Value    string      `json:"value,omitempty"`
+	Input    *InputEvent `json:"input,omitempty"`
+}
+
+// InputEvent is a mouse or keyboard event from the live view, in page pixels.
+type InputEvent struct {
+	Kind   string  `json:"kind"`
+	X      float64 `json:"x,omitempty"`
+	Y      float64 `json:"y,omitempty"`
+	Button string  `json:"button,omitempty"`
+	Key    string  `json:"key,omitempty"`
+	Text   string  `json:"text,omitempty"`
+	DeltaX float64 `json:"delta_x,omitempty"`
+	DeltaY float64 `json:"delta_y,omitempty"`
+}
+
+type Job struct {
+	ID             string               `json:"id"`
+	Action         string               `json:"action"`
+	URL            string               `json:"url"`
+	Browser        string               `json:"browser,omitempty"`
+	Proxy          string               `json:"proxy,omitempty"`
+	Status         Status               `json:"status"`
+	Prompt         *Prompt              `json:"prompt,omitempty"`
+	Profile        string               `json:"profile,omitempty"`
+	Fields         map[string]string    `json:"fields,omitempty"`
+	Screenshot     string               `json:"screenshot,omitempty"`
+	Artifacts      []artifacts.Artifact `json:"artifacts,omitempty"`
+	FailedRequests []FailedRequest      `json:"failed_requests,omitempty"`
+	Console        *ConsoleTotals       `json:"console,omitempty"`
+	Attempts       []Attempt            `json:"attempts,omitempty"`
+	Error          *JobError            `json:"error,omitempty"`
+	Usage          *Usage               `json:"usage,omitempty"`
+	Workspace      string               `json:"workspace"`
+	CreatedBy      string               `json:"created_by,omitempty"`
+	CreatedAt      time.Time            `json:"created_at"`
+	UpdatedAt      time.Time            `json:"updated_at"`
+}
+
+// ResultArtifact holds a job's result data when the job asked for it to be
+// saved, so that it can be read once the stream is gone.
+const ResultArtifact = "result.txt"
+
+// Usage is what a job consumed over all its attempts, charged to its
+// workspace's quotas.
+type Usage struct {
+	BrowserSeconds float64 `json:"browser_seconds"`
+	LLMTokens      int64   `json:"llm_tokens"`
+}
+
+// Attempt is one run of a job's worker. Jobs are run again on another proxy
+// or under their retry policy, all under the same job ID.
+type Attempt struct {
+	Number     int       `json:"number"`
+	Proxy      string    `json:"proxy,omitempty"`
+	Error      *JobError `json:"error,omitempty"`
+	ErrorClass string    `json:"error_class,omitempty"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+}
+
+type entry struct {
+	job     Job
+	control io.Writer
+	viewers map[chan []byte]struct{}
+
+	// sending serializes writes to control, which happen without r.mu held
+	// so that a stalled worker cannot block the registry.
+	sending sync.Mutex
+	// answering is the ID of the prompt a Respond is delivering.
+	answering string
+}
+
+// Registry tracks the jobs known to this server instance. Finished jobs are
+// forgotten once they are older than the retention, or the oldest of them
+// when more than maxFinished have piled up.
+type Registry struct {
+	mu             sync.Mutex
+	jobs           map[string]*entry
+	statusWatchers []func(job Job, previous Status)
+	retention      time.Duration
+	maxFinished    int
+}
+
+const (
+	defaultRetention   = 24 * time.Hour
+	defaultMaxFinished = 1000
+)
+
+var Default = NewRegistry()
+
+func NewRegistry() *Registry {
+	return &Registry{
+		jobs:        make(map[string]*entry),
+		retention:   Retention(),
+		maxFinished: defaultMaxFinished,
+	}
+}
+
+// Retention is how long finished jobs are kept, from JOB_RETENTION (a Go
+// duration such as 24h).
+func Retention() time.Duration {
+	if value := os.Getenv("JOB_RETENTION"); value != "" {
+		if d, err := time.ParseDuration(value); err == nil && d > 0 {
+			return d
+		}
+		log.Printf("invalid JOB_RETENTION %q, using %s", value, defaultRetention)
+	}
+	return defaultRetention
+}
+
+func (s Status) finished() bool {
+	return s == StatusSucceeded || s == StatusFailed
+}
+
+// evict forgets expired finished jobs, and the least recently updated ones
+// beyond maxFinished; callers hold r.mu. Jobs still attached to a worker or
+// watched by viewers are kept.
+func (r *Registry) evict(now time.Time) {
+	var finished []*entry
+	for id, e := range r.jobs {
+		if !e.job.Status.finished() || e.control != nil || len(e.viewers) > 0 {
+			continue
+		}
+		if now.Sub(e.job.UpdatedAt) > r.retention {
+			delete(r.jobs, id)
+			continue
+		}
+		finished = append(finished, e)
+	}
+	if len(finished) <= r.maxFinished {
+		return
+	}
+
+	sort.Slice(finished, func(i, j int) bool {
+		return finished[i].job.UpdatedAt.Before(finished[j].job.UpdatedAt)
+	})
+	for _, e := range finished[:len(finished)-r.maxFinished] {
+		delete(r.jobs, e.job.ID)
+	}
+}
+
+func NewID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (r *Registry) Create(payload Payload, workspace, createdBy string) Job {
+	now := time.Now()
+	job := Job{
+		ID:        NewID(),
+		Action:    payload.Action,
+		URL:       payload.URL,
+		Browser:   payload.Browser,
+		Status:    StatusQueued,
+		Workspace: workspace,
+		CreatedBy: createdBy,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if payload.Profile != nil {
+		job.Profile = payload.Profile.Name
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.evict(now)
+	r.jobs[job.ID] = &entry{job: job}
+
+	return job
+}
+
+func (r *Registry) Get(id string) (Job, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	e, ok := r.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return e.job, true
+}
+
+func (r *Registry) List() []Job {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	list := make([]Job, 0, len(r.jobs))
+	for _, e := range r.jobs {
+		list = append(list, e.job)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].CreatedAt.After(list[j].CreatedAt)
+	})
+
+	return list
+}
+
+func (r *Registry) Update(id string, fn func(job *Job)) (Job, error) {
+	r.mu.Lock()
+	e, ok := r.jobs[id]
+	if !ok {
+		r.mu.Unlock()
+		return Job{}, ErrNotFound
+	}
+	previous := e.job.Status
+	fn(&e.job)
+	e.job.UpdatedAt = time.Now()
+	job, watchers := e.job, r.statusWatchers
+	r.mu.Unlock()
+
+	notify(watchers, job, previous)
+	return job, nil
+}
+
+func notify(watchers []func(job Job, previous Status), job Job, previous Status) {
+	if job.Status == previous {
+		return
+	}
+	for _, watch := range watchers {
+		watch(job, previous)
+	}
+}
+
+// OnStatusChange calls fn whenever a job's status changes, with the job as changed
+// and its previous status. fn runs in the goroutine that changed the job,
+// after the registry is unlocked, so it must not block.
+func (r *Registry) OnStatusChange(fn func(job Job, previous Status)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.statusWatchers = append(r.statusWatchers, fn)
+}
+
+// SetControl attaches the writer used to deliver control messages to the
+// running worker. Passing nil detaches it once the job has finished.
+func (r *Registry) SetControl(id string, w io.Writer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if e, ok := r.jobs[id]; ok {
+		e.control = w
+	}
+}
+
+func (r *Registry) Send(id string, msg ControlMessage) error {
+	r.mu.Lock()
+	e, ok := r.jobs[id]
+	if !ok {
+		r.mu.Unlock()
+		return ErrNotFound
+	}
+	control := e.control
+	r.mu.Unlock()
+
+	return e.send(control, msg)
+}
+
+// send writes a control message to the worker; callers must not hold r.mu.
+func (e *entry) send(control io.Writer, msg ControlMessage) error {
+	if control == nil {
+		return ErrNoControl
+	}
+
+	line, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	e.sending.Lock()
+	defer e.sending.Unlock()
+	_, err = control.Write(append(line, '\n'))
+	return err
+}
+
+// Respond delivers an operator decision to a paused job. The job goes back to
+// running unless the operator took over, in which case it stays paused until
+// control is handed back with a resume. The prompt is answered at most once,
+// however many operators respond to it at the same time.
+func (r *Registry) Respond(id, decision, value string) (Job, error) {
+	r.mu.Lock()
+	e, ok := r.jobs[id]
+	if !ok {
+		r.mu.Unlock()
+		return Job{}, ErrNotFound
+	}
+	if e.job.Status != StatusPaused || e.job.Prompt == nil || e.answering == e.job.Prompt.ID {
+		r.mu.Unlock()
+		return Job{}, ErrNotPaused
+	}
+	promptID, control := e.job.Prompt.ID, e.control
+	e.answering = promptID
+	r.mu.Unlock()
+
+	err := e.send(control, ControlMessage{Type: ControlRespond, PromptID: promptID, Decision: decision, Value: value})
+
+	r.mu.Lock()
+	e.answering = ""
+	if err != nil {
+		r.mu.Unlock()
+		return Job{}, err
+	}
+	// The worker may have moved on while the answer was being written
+	previous := e.job.Status
+	if decision != DecisionTakeOver && e.job.Prompt != nil && e.job.Prompt.ID == promptID {
+		e.job.Status = StatusRunning
+		e.job.Prompt = nil
+	}
+	e.job.UpdatedAt = time.Now()
+	job, watchers := e.job, r.statusWatchers
+	r.mu.Unlock()
+
+	notify(watchers, job, previous)
+	return job, nil
+}
+
+// AllowedDecisions returns the decisions that answer a prompt of the given kind.
+func AllowedDecisions(kind string) []string {
+	switch kind {
+	case PromptQuestion:
+		return []string{DecisionAnswer, DecisionReject, DecisionTakeOver}
+	case PromptApproval:
+		return []string{DecisionApprove, DecisionReject, DecisionTakeOver}
+	case PromptTakeover:
+		return []string{DecisionResume}
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package jobs
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stalledWorker is a control stream that accepts nothing until released.
+type stalledWorker struct {
+	release chan struct{}
+
+	mu     sync.Mutex
+	writes int
+}
+
+func (w *stalledWorker) Write(p []byte) (int, error) {
+	<-w.release
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.writes++
+	return len(p), nil
+}
+
+func TestStalledWorkerDoesNotBlockRegistry(t *testing.T) {
+	r := NewRegistry()
+	stalled := r.Create(Payload{URL: "https://example.com"}, "default", "user:admin")
+	other := r.Create(Payload{URL: "https://example.org"}, "default", "user:admin")
+
+	worker := &stalledWorker{release: make(chan struct{})}
+	r.SetControl(stalled.ID, worker)
+	sent := make(chan error, 1)
+	go func() {
+		sent <- r.Send(stalled.ID, ControlMessage{Type: ControlRespond})
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		r.Get(stalled.ID)
+		r.List()
+		r.Update(other.ID, func(job *Job) { job.Status = StatusRunning })
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the registry is blocked by a worker that does not read its control stream")
+	}
+
+	close(worker.release)
+	if err := <-sent; err != nil {
+		t.Errorf("Send: %v", err)
+	}
+}
+
+func TestRespondAnswersOnce(t *testing.T) {
+	r := NewRegistry()
+	job := r.Create(Payload{URL: "https://example.com"}, "default", "user:admin")
+	r.Update(job.ID, func(job *Job) {
+		job.Status = StatusPaused
+		job.Prompt = &Prompt{ID: "prompt-1", Kind: PromptQuestion}
+	})
+	worker := &stalledWorker{release: make(chan struct{})}
+	r.SetControl(job.ID, worker)
+
+	first := make(chan error, 1)
+	go func() {
+		_, err := r.Respond(job.ID, DecisionAnswer, "first")
+		first <- err
+	}()
+	// Wait for the first answer to be on its way to the worker
+	for {
+		r.mu.Lock()
+		answering := r.jobs[job.ID].answering
+		r.mu.Unlock()
+		if answering != "" {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if _, err := r.Respond(job.ID, DecisionAnswer, "second"); !errors.Is(err, ErrNotPaused) {
+		t.Errorf("second Respond returned %v, want ErrNotPaused", err)
+	}
+	close(worker.release)
+	if err := <-first; err != nil {
+		t.Fatalf("first Respond: %v", err)
+	}
+
+	answered, _ := r.Get(job.ID)
+	if answered.Status != StatusRunning || answered.Prompt != nil {
+		t.Errorf("job is %s with prompt %+v, want running without one", answered.Status, answered.Prompt)
+	}
+	if worker.writes != 1 {
+		t.Errorf("worker received %d answers, want 1", worker.writes)
+	}
+}
@@ -0,0 +1,356 @@
+package monitors
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	_ "image/png"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// pageText returns the visible text of an HTML page, a line per block, or
+// the lines of anything else.
+func pageText(data string) []string {
+	head := strings.ToLower(data[:min(len(data), 1024)])
+	if !strings.Contains(head, "<html") && !strings.Contains(head, "<body") && !strings.Contains(head, "<!doctype html") {
+		var lines []string
+		for _, line := range strings.Split(data, "\n") {
+			if line = strings.Join(strings.Fields(line), " "); line != "" {
+				lines = append(lines, line)
+			}
+		}
+		return lines
+	}
+
+	var lines []string
+	var line strings.Builder
+	flush := func() {
+		if text := strings.Join(strings.Fields(line.String()), " "); text != "" {
+			lines = append(lines, text)
+		}
+		line.Reset()
+	}
+
+	z := html.NewTokenizer(strings.NewReader(data))
+	hidden := 0
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			flush()
+			return lines
+		case html.TextToken:
+			if hidden == 0 {
+				line.Write(z.Text())
+			}
+		case html.StartTagToken:
+			name, _ := z.TagName()
+			if invisibleTags[string(name)] {
+				hidden++
+			}
+			if blockTags[string(name)] {
+				flush()
+			}
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			if invisibleTags[string(name)] && hidden > 0 {
+				hidden--
+			}
+			if blockTags[string(name)] {
+				flush()
+			}
+		case html.SelfClosingTagToken:
+			if name, _ := z.TagName(); blockTags[string(name)] {
+				flush()
+			}
+		}
+	}
+}
+
+var invisibleTags = map[string]bool{
+	"head": true, "script": true, "style": true, "noscript": true, "template": true, "svg": true,
+}
+
+var blockTags = map[string]bool{
+	"address": true, "article": true, "aside": true, "blockquote": true, "br": true, "dd": true,
+	"div": true, "dl": true, "dt": true, "fieldset": true, "figcaption": true, "figure": true,
+	"footer": true, "form": true, "h1": true, "h2": true, "h3": true, "h4": true, "h5": true,
+	"h6": true, "header": true, "hr": true, "li": true, "main": true, "nav": true, "ol": true,
+	"p": true, "pre": true, "section": true, "table": true, "td": true, "th": true, "tr": true,
+	"ul": true,
+}
+
+// diffLine is a line of a diff: ' ' kept, '-' removed or '+' added.
+type diffLine struct {
+	op   byte
+	text string
+}
+
+// Beyond this many cells the lines between the common start and end are
+// reported as replaced wholesale rather than diffed.
+const maxDiffCells = 4 << 20
+
+// diffLines returns the shortest edit turning a into b.
+func diffLines(a, b []string) []diffLine {
+	var prefix, suffix []diffLine
+	for len(a) > 0 && len(b) > 0 && a[0] == b[0] {
+		prefix = append(prefix, diffLine{' ', a[0]})
+		a, b = a[1:], b[1:]
+	}
+	for len(a) > 0 && len(b) > 0 && a[len(a)-1] == b[len(b)-1] {
+		suffix = append([]diffLine{{' ', a[len(a)-1]}}, suffix...)
+		a, b = a[:len(a)-1], b[:len(b)-1]
+	}
+
+	middle := make([]diffLine, 0, len(a)+len(b))
+	if (len(a)+1)*(len(b)+1) > maxDiffCells {
+		for _, line := range a {
+			middle = append(middle, diffLine{'-', line})
+		}
+		for _, line := range b {
+			middle = append(middle, diffLine{'+', line})
+		}
+	} else {
+		// lcs[i][j] is the longest common subsequence of a[i:] and b[j:]
+		width := len(b) + 1
+		lcs := make([]int32, (len(a)+1)*width)
+		for i := len(a) - 1; i >= 0; i-- {
+			for j := len(b) - 1; j >= 0; j-- {
+				if a[i] == b[j] {
+					lcs[i*width+j] = lcs[(i+1)*width+j+1] + 1
+				} else {
+					lcs[i*width+j] = max(lcs[(i+1)*width+j], lcs[i*width+j+1])
+				}
+			}
+		}
+		i, j := 0, 0
+		for i < len(a) || j < len(b) {
+			switch {
+			case i < len(a) && j < len(b) && a[i] == b[j]:
+				middle = append(middle, diffLine{' ', a[i]})
+				i, j = i+1, j+1
+			case i < len(a) && (j == len(b) || lcs[(i+1)*width+j] >= lcs[i*width+j+1]):
+				middle = append(middle, diffLine{'-', a[i]})
+				i++
+			default:
+				middle = append(middle, diffLine{'+', b[j]})
+				j++
+			}
+		}
+	}
+
+	return append(append(prefix, middle...), suffix...)
+}
+
+// diffContext is how many unchanged lines surround each change.
+const diffContext = 2
+
+// renderDiff writes the changes as unified diff hunks.
+func renderDiff(lines []diffLine) string {
+	// Where each line falls in the old and the new text
+	oldAt, newAt := make([]int, len(lines)), make([]int, len(lines))
+	o, n := 0, 0
+	for k, line := range lines {
+		oldAt[k], newAt[k] = o, n
+		if line.op != '+' {
+			o++
+		}
+		if line.op != '-' {
+			n++
+		}
+	}
+
+	var out strings.Builder
+	for i := 0; i < len(lines); {
+		for i < len(lines) && lines[i].op == ' ' {
+			i++
+		}
+		if i == len(lines) {
+			break
+		}
+
+		// Changes close enough together share a hunk
+		last := i
+		for {
+			next := last + 1
+			for next < len(lines) && lines[next].op == ' ' {
+				next++
+			}
+			if next == len(lines) || next-last-1 > 2*diffContext {
+				break
+			}
+			last = next
+		}
+
+		start, stop := max(i-diffContext, 0), min(last+diffContext+1, len(lines))
+		oldCount, newCount := 0, 0
+		for _, line := range lines[start:stop] {
+			if line.op != '+' {
+				oldCount++
+			}
+			if line.op != '-' {
+				newCount++
+			}
+		}
+		fmt.Fprintf(&out, "@@ -%d,%d +%d,%d @@\n", oldAt[start]+1, oldCount, newAt[start]+1, newCount)
+		for _, line := range lines[start:stop] {
+			out.WriteByte(line.op)
+			out.WriteString(line.text)
+			out.WriteByte('\n')
+		}
+		i = stop
+	}
+	return out.String()
+}
+
+// compareText scores the share of lines added or removed.
+func compareText(previous, current []string) (float64, string) {
+	lines := diffLines(previous, current)
+	changed := 0
+	for _, line := range lines {
+		if line.op != ' ' {
+			changed++
+		}
+	}
+	if changed == 0 {
+		return 0, ""
+	}
+	return float64(changed) / float64(len(previous)+len(current)), renderDiff(lines)
+}
+
+// compareFields scores the share of fields whose text changed, and lists
+// them as "name: old → new".
+func compareFields(previous, current map[string]string) (float64, string) {
+	names := map[string]bool{}
+	for name := range previous {
+		names[name] = true
+	}
+	for name := range current {
+		names[name] = true
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	var out strings.Builder
+	changed := 0
+	for _, name := range sorted {
+		before, hadBefore := previous[name]
+		after, hasAfter := current[name]
+		if before == after && hadBefore == hasAfter {
+			continue
+		}
+		changed++
+		fmt.Fprintf(&out, "%s: %q → %q\n", name, before, after)
+	}
+	if changed == 0 {
+		return 0, ""
+	}
+	return float64(changed) / float64(len(sorted)), out.String()
+}
+
+// Screenshots are compared as a grid of average luminances, which ignores
+// JPEG noise and small rendering differences but not changed content.
+const (
+	gridSize = 64
+	// Cells whose luminance moved by more than this (out of 255) changed
+	cellTolerance = 24
+)
+
+// fingerprint reduces a screenshot to its luminance grid, base64 encoded.
+func fingerprint(data []byte) (string, error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return "", fmt.Errorf("could not decode screenshot: %w", err)
+	}
+	return base64.StdEncoding.EncodeToString(luminanceGrid(img)), nil
+}
+
+func luminanceGrid(img image.Image) []byte {
+	bounds := img.Bounds()
+	grid := make([]byte, gridSize*gridSize)
+	for gy := 0; gy < gridSize; gy++ {
+		y0 := bounds.Min.Y + gy*bounds.Dy()/gridSize
+		y1 := max(bounds.Min.Y+(gy+1)*bounds.Dy()/gridSize, y0+1)
+		for gx := 0; gx < gridSize; gx++ {
+			x0 := bounds.Min.X + gx*bounds.Dx()/gridSize
+			x1 := max(bounds.Min.X+(gx+1)*bounds.Dx()/gridSize, x0+1)
+
+			var sum, count float64
+			for y := y0; y < y1 && y < bounds.Max.Y; y++ {
+				for x := x0; x < x1 && x < bounds.Max.X; x++ {
+					gray := color.GrayModel.Convert(img.At(x, y)).(color.Gray)
+					sum += float64(gray.Y)
+					count++
+				}
+			}
+			if count > 0 {
+				grid[gy*gridSize+gx] = byte(sum / count)
+			}
+		}
+	}
+	return grid
+}
+
+// changedCells lists the grid cells that differ between two fingerprints.
+func changedCells(previous, current string) ([]int, error) {
+	before, err := base64.StdEncoding.DecodeString(previous)
+	if err != nil {
+		return nil, err
+	}
+	after, err := base64.StdEncoding.DecodeString(current)
+	if err != nil {
+		return nil, err
+	}
+	if len(before) != len(after) {
+		return nil, fmt.Errorf("fingerprints of different sizes")
+	}
+
+	var cells []int
+	for i := range before {
+		diff := int(before[i]) - int(after[i])
+		if diff > cellTolerance || -diff > cellTolerance {
+			cells = append(cells, i)
+		}
+	}
+	return cells, nil
+}
+
+var highlight = color.RGBA{R: 220, G: 38, B: 38, A: 110}
+
+// highlightCells draws the changed grid cells over the new screenshot.
+func highlightCells(data []byte, cells []int) ([]byte, error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	bounds := img.Bounds()
+	out := image.NewRGBA(bounds)
+	draw.Draw(out, bounds, img, bounds.Min, draw.Src)
+
+	overlay := image.NewUniform(highlight)
+	for _, cell := range cells {
+		gx, gy := cell%gridSize, cell/gridSize
+		rect := image.Rect(
+			bounds.Min.X+gx*bounds.Dx()/gridSize,
+			bounds.Min.Y+gy*bounds.Dy()/gridSize,
+			bounds.Min.X+(gx+1)*bounds.Dx()/gridSize,
+			bounds.Min.Y+(gy+1)*bounds.Dy()/gridSize,
+		)
+		draw.Draw(out, rect, overlay, image.Point{}, draw.Over)
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, out, &jpeg.Options{Quality: 80}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,241 @@
+package monitors
+
+import (
+	"bytes"
+	"encoding/base64"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestPageText(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want []string
+	}{
+		{
+			name: "plain text",
+			data: "  hello   world \n\n second\tline ",
+			want: []string{"hello world", "second line"},
+		},
+		{
+			name: "HTML blocks",
+			data: `<!doctype html><html><head><title>Shop</title><style>p { color: red }</style></head>
+<body><h1>Prices</h1><p>Widget <b>now</b>
+  only $10</p><ul><li>One</li><li>Two</li></ul>Call<br>us</body></html>`,
+			want: []string{"Prices", "Widget now only $10", "One", "Two", "Call", "us"},
+		},
+		{
+			name: "invisible elements",
+			data: `<body><script>var price = 12</script><template><p>hidden</p></template><svg><text>logo</text></svg><p>shown</p></body>`,
+			want: []string{"shown"},
+		},
+		{
+			name: "self-closing break",
+			data: `<body>first<br/>second<hr/>third</body>`,
+			want: []string{"first", "second", "third"},
+		},
+		{
+			name: "empty",
+			data: "",
+			want: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := pageText(tt.data); !slices.Equal(got, tt.want) {
+				t.Errorf("pageText = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func lines(n int, change map[int]string) []string {
+	var out []string
+	for i := 1; i <= n; i++ {
+		if line, ok := change[i]; ok {
+			out = append(out, line)
+			continue
+		}
+		out = append(out, "l"+string(rune('a'+i-1)))
+	}
+	return out
+}
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b []string
+		want string
+	}{
+		{
+			name: "unchanged",
+			a:    []string{"a", "b"},
+			b:    []string{"a", "b"},
+			want: "",
+		},
+		{
+			name: "line replaced",
+			a:    []string{"a", "b", "c"},
+			b:    []string{"a", "x", "c"},
+			want: "@@ -1,3 +1,3 @@\n a\n-b\n+x\n c\n",
+		},
+		{
+			name: "line added at the end",
+			a:    []string{"a"},
+			b:    []string{"a", "b"},
+			want: "@@ -1,1 +1,2 @@\n a\n+b\n",
+		},
+		{
+			name: "everything new",
+			a:    nil,
+			b:    []string{"a", "b"},
+			want: "@@ -1,0 +1,2 @@\n+a\n+b\n",
+		},
+		{
+			name: "changes within twice the context share a hunk",
+			a:    lines(12, nil),
+			b:    lines(12, map[int]string{2: "X", 7: "Y"}),
+			want: "@@ -1,9 +1,9 @@\n la\n-lb\n+X\n lc\n ld\n le\n lf\n-lg\n+Y\n lh\n li\n",
+		},
+		{
+			name: "changes further apart get their own hunks",
+			a:    lines(12, nil),
+			b:    lines(12, map[int]string{2: "X", 10: "Z"}),
+			want: "@@ -1,4 +1,4 @@\n la\n-lb\n+X\n lc\n ld\n" +
+				"@@ -8,5 +8,5 @@\n lh\n li\n-lj\n+Z\n lk\n ll\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := renderDiff(diffLines(tt.a, tt.b)); got != tt.want {
+				t.Errorf("diff =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffLinesIsMinimal(t *testing.T) {
+	a := []string{"a", "b", "c", "d", "e"}
+	b := []string{"b", "c", "x", "e", "f"}
+
+	var kept, changed int
+	var rebuiltA, rebuiltB []string
+	for _, line := range diffLines(a, b) {
+		switch line.op {
+		case ' ':
+			kept++
+			rebuiltA = append(rebuiltA, line.text)
+			rebuiltB = append(rebuiltB, line.text)
+		case '-':
+			changed++
+			rebuiltA = append(rebuiltA, line.text)
+		case '+':
+			changed++
+			rebuiltB = append(rebuiltB, line.text)
+		}
+	}
+	if !slices.Equal(rebuiltA, a) || !slices.Equal(rebuiltB, b) {
+		t.Fatalf("the diff does not rebuild both sides: %q, %q", rebuiltA, rebuiltB)
+	}
+	// b, c and e are common to both
+	if kept != 3 || changed != 4 {
+		t.Errorf("the diff kept %d and changed %d lines, want 3 and 4", kept, changed)
+	}
+}
+
+func TestCompareText(t *testing.T) {
+	score, diff := compareText([]string{"a", "b", "c", "d"}, []string{"a", "b", "c", "x"})
+	if score != 0.25 || !strings.Contains(diff, "-d\n+x\n") {
+		t.Errorf("compareText = %v, %q, want 0.25 with d replaced by x", score, diff)
+	}
+	if score, diff := compareText([]string{"a"}, []string{"a"}); score != 0 || diff != "" {
+		t.Errorf("compareText of the same text = %v, %q", score, diff)
+	}
+}
+
+func TestCompareFields(t *testing.T) {
+	tests := []struct {
+		name              string
+		previous, current map[string]string
+		score             float64
+		diff              string
+	}{
+		{
+			name:     "unchanged",
+			previous: map[string]string{"price": "$10", "stock": "5"},
+			current:  map[string]string{"price": "$10", "stock": "5"},
+		},
+		{
+			name:     "one of two",
+			previous: map[string]string{"price": "$10", "stock": "5"},
+			current:  map[string]string{"price": "$12", "stock": "5"},
+			score:    0.5,
+			diff:     "price: \"$10\" → \"$12\"\n",
+		},
+		{
+			name:     "all, in name order",
+			previous: map[string]string{"stock": "5", "price": "$10"},
+			current:  map[string]string{"stock": "0", "price": "$12"},
+			score:    1,
+			diff:     "price: \"$10\" → \"$12\"\nstock: \"5\" → \"0\"\n",
+		},
+		{
+			name:     "field added",
+			previous: map[string]string{"price": "$10"},
+			current:  map[string]string{"price": "$10", "sale": "yes"},
+			score:    0.5,
+			diff:     "sale: \"\" → \"yes\"\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			score, diff := compareFields(tt.previous, tt.current)
+			if score != tt.score || diff != tt.diff {
+				t.Errorf("compareFields = %v, %q, want %v, %q", score, diff, tt.score, tt.diff)
+			}
+		})
+	}
+}
+
+// grid returns a fingerprint of mid-grey cells with the given cells moved by
+// delta.
+func grid(delta int, cells ...int) string {
+	g := bytes.Repeat([]byte{128}, gridSize*gridSize)
+	for _, cell := range cells {
+		g[cell] = byte(128 + delta)
+	}
+	return base64.StdEncoding.EncodeToString(g)
+}
+
+func TestChangedCells(t *testing.T) {
+	tests := []struct {
+		name     string
+		current  string
+		want     []int
+		wantsErr bool
+	}{
+		{name: "unchanged", current: grid(0)},
+		{name: "within the tolerance", current: grid(cellTolerance, 1, 2, 3)},
+		{name: "lighter", current: grid(cellTolerance+1, 5, 70), want: []int{5, 70}},
+		{name: "darker", current: grid(-cellTolerance-1, 4095), want: []int{4095}},
+		{name: "not base64", current: "!!", wantsErr: true},
+		{name: "different size", current: base64.StdEncoding.EncodeToString([]byte{1, 2, 3}), wantsErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cells, err := changedCells(grid(0), tt.current)
+			if (err != nil) != tt.wantsErr {
+				t.Fatalf("changedCells returned %v", err)
+			}
+			if !slices.Equal(cells, tt.want) {
+				t.Errorf("changedCells = %v, want %v", cells, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,472 @@
+package monitors
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"brian-nunez/bcode/internal/store"
+)
+
+// What a monitor compares between runs.
+const (
+	// CompareText diffs the visible text of the page the job returned
+	CompareText = "text"
+	// CompareFields diffs the text of named CSS selectors
+	CompareFields = "fields"
+	// CompareScreenshot compares the final screenshots
+	CompareScreenshot = "screenshot"
+)
+
+var Comparisons = []string{CompareText, CompareFields, CompareScreenshot}
+
+// Screenshots always differ a little, so by default a change has to cover
+// more than this share of the page.
+const defaultScreenshotThreshold = 0.01
+
+var (
+	ErrNotFound         = errors.New("monitor not found")
+	ErrInvalidName      = errors.New("monitor names must be 1 to 100 characters")
+	ErrInvalidCompare   = errors.New("monitors compare text, fields or screenshot")
+	ErrInvalidFields    = errors.New("field monitors need fields of name and CSS selector")
+	ErrInvalidThreshold = errors.New("the threshold must be between 0 and 1")
+	ErrInvalidNotifyURL = errors.New("the notify URL must be an http or https URL")
+)
+
+// Changes kept per monitor, newest first.
+const maxChanges = 100
+
+// Monitor watches the runs of a schedule and records a change whenever a
+// run's result differs from the previous run's by more than the threshold.
+type Monitor struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	Workspace  string `json:"workspace"`
+	ScheduleID string `json:"schedule_id"`
+	Compare    string `json:"compare"`
+	// Fields maps field names to CSS selectors for CompareFields
+	Fields map[string]string `json:"fields,omitempty"`
+	// Threshold is the share of the result, from 0 to 1, that has to change
+	// for the change to count
+	Threshold     float64    `json:"threshold"`
+	NotifyURL     string     `json:"notify_url,omitempty"`
+	LastCheckedAt *time.Time `json:"last_checked_at,omitempty"`
+	LastChangeAt  *time.Time `json:"last_change_at,omitempty"`
+	CreatedBy     string     `json:"created_by,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+// Spec is what callers set on a monitor. A nil threshold takes the default
+// for the comparison.
+type Spec struct {
+	Name       string            `json:"name"`
+	ScheduleID string            `json:"schedule_id"`
+	Compare    string            `json:"compare"`
+	Fields     map[string]string `json:"fields"`
+	Threshold  *float64          `json:"threshold"`
+	NotifyURL  string            `json:"notify_url"`
+}
+
+// ArtifactRef names an artifact of a job.
+type ArtifactRef struct {
+	JobID string `json:"job_id"`
+	Name  string `json:"name"`
+}
+
+// Change is a run whose result differed meaningfully from the previous
+// run's.
+type Change struct {
+	ID            string    `json:"id"`
+	MonitorID     string    `json:"monitor_id"`
+	JobID         string    `json:"job_id"`
+	PreviousJobID string    `json:"previous_job_id"`
+	DetectedAt    time.Time `json:"detected_at"`
+	// Score is the share of the result that changed, from 0 to 1
+	Score   float64 `json:"score"`
+	Summary string  `json:"summary"`
+	// Diff is a unified diff of the text, or a line per changed field
+	Diff string `json:"diff,omitempty"`
+	// The screenshots compared, and the new one with the changes marked
+	Before      *ArtifactRef `json:"before,omitempty"`
+	After       *ArtifactRef `json:"after,omitempty"`
+	Highlight   *ArtifactRef `json:"highlight,omitempty"`
+	NotifiedAt  *time.Time   `json:"notified_at,omitempty"`
+	NotifyError string       `json:"notify_error,omitempty"`
+}
+
+// snapshot is what a monitor compares the next run with.
+type snapshot struct {
+	JobID   string            `json:"job_id"`
+	TakenAt time.Time         `json:"taken_at"`
+	Text    []string          `json:"text,omitempty"`
+	Fields  map[string]string `json:"fields,omitempty"`
+	// Grid is the screenshot's fingerprint
+	Grid       string `json:"grid,omitempty"`
+	Screenshot string `json:"screenshot,omitempty"`
+}
+
+// Store keeps monitors and their changes in monitors.json in the data
+// directory, and the latest snapshot of each in the monitors directory next
+// to it.
+type Store struct {
+	path string
+	dir  string
+
+	mu       sync.Mutex
+	monitors map[string]*Monitor
+	changes  map[string][]*Change
+	now      func() time.Time
+}
+
+type records struct {
+	Monitors []*Monitor           `json:"monitors"`
+	Changes  map[string][]*Change `json:"changes"`
+}
+
+var (
+	defaultStore *Store
+	defaultErr   error
+	defaultOnce  sync.Once
+)
+
+// Default returns the store under the server's data directory.
+func Default() (*Store, error) {
+	defaultOnce.Do(func() {
+		defaultStore, defaultErr = NewStore(filepath.Join(store.DataDir(), "monitors.json"))
+	})
+	return defaultStore, defaultErr
+}
+
+func NewStore(path string) (*Store, error) {
+	dir := strings.TrimSuffix(path, filepath.Ext(path))
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	s := &Store{
+		path:     path,
+		dir:      dir,
+		monitors: map[string]*Monitor{},
+		changes:  map[string][]*Change{},
+		now:      time.Now,
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var saved records
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, fmt.Errorf("invalid monitors %s: %w", path, err)
+	}
+	for _, monitor := range saved.Monitors {
+		s.monitors[monitor.ID] = monitor
+	}
+	for id, changes := range saved.Changes {
+		s.changes[id] = changes
+	}
+
+	return s, nil
+}
+
+// save writes the store; callers hold s.mu.
+func (s *Store) save() error {
+	saved := records{Monitors: []*Monitor{}, Changes: s.changes}
+	for _, monitor := range s.monitors {
+		saved.Monitors = append(saved.Monitors, monitor)
+	}
+	sort.Slice(saved.Monitors, func(i, j int) bool {
+		return saved.Monitors[i].ID < saved.Monitors[j].ID
+	})
+	data, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		return err
+	}
+	return store.WriteFileAtomic(s.path, data)
+}
+
+// validate checks a spec and fills in its defaults.
+func (spec *Spec) validate() error {
+	spec.Name = strings.TrimSpace(spec.Name)
+	if spec.Name == "" || len(spec.Name) > 100 {
+		return ErrInvalidName
+	}
+	if !slices.Contains(Comparisons, spec.Compare) {
+		return ErrInvalidCompare
+	}
+
+	if spec.Compare != CompareFields {
+		spec.Fields = nil
+	} else if len(spec.Fields) == 0 {
+		return ErrInvalidFields
+	}
+	for name, selector := range spec.Fields {
+		// Fields reach the worker as name=selector lines
+		if strings.TrimSpace(name) != name || name == "" || strings.ContainsAny(name, "=\n") ||
+			strings.TrimSpace(selector) == "" || strings.Contains(selector, "\n") {
+			return fmt.Errorf("%w: %q", ErrInvalidFields, name)
+		}
+	}
+
+	if spec.Threshold == nil {
+		threshold := 0.0
+		if spec.Compare == CompareScreenshot {
+			threshold = defaultScreenshotThreshold
+		}
+		spec.Threshold = &threshold
+	}
+	if *spec.Threshold < 0 || *spec.Threshold >= 1 {
+		return ErrInvalidThreshold
+	}
+
+	spec.NotifyURL = strings.TrimSpace(spec.NotifyURL)
+	if spec.NotifyURL != "" {
+		u, err := url.Parse(spec.NotifyURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return ErrInvalidNotifyURL
+		}
+	}
+	return nil
+}
+
+// Create adds a monitor of a schedule to a workspace. Callers check that
+// the schedule is in the workspace.
+func (s *Store) Create(workspace, createdBy string, spec Spec) (Monitor, error) {
+	if err := spec.validate(); err != nil {
+		return Monitor{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	monitor := &Monitor{
+		ID:        randomID(),
+		Workspace: workspace,
+		CreatedBy: createdBy,
+		CreatedAt: now,
+	}
+	monitor.apply(spec, now)
+	s.monitors[monitor.ID] = monitor
+	if err := s.save(); err != nil {
+		delete(s.monitors, monitor.ID)
+		return Monitor{}, err
+	}
+	return *monitor, nil
+}
+
+// Update replaces what a monitor compares. Changing the comparison drops the
+// snapshot, so the next run starts over as a baseline.
+func (s *Store) Update(id string, spec Spec) (Monitor, error) {
+	if err := spec.validate(); err != nil {
+		return Monitor{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	monitor, ok := s.monitors[id]
+	if !ok {
+		return Monitor{}, ErrNotFound
+	}
+	previous := *monitor
+	monitor.apply(spec, s.now())
+	if err := s.save(); err != nil {
+		*monitor = previous
+		return Monitor{}, err
+	}
+	if monitor.ScheduleID != previous.ScheduleID || monitor.Compare != previous.Compare ||
+		!maps.Equal(monitor.Fields, previous.Fields) {
+		s.removeSnapshot(id)
+	}
+	return *monitor, nil
+}
+
+// apply sets a validated spec.
+func (m *Monitor) apply(spec Spec, now time.Time) {
+	m.Name = spec.Name
+	m.ScheduleID = spec.ScheduleID
+	m.Compare = spec.Compare
+	m.Fields = spec.Fields
+	m.Threshold = *spec.Threshold
+	m.NotifyURL = spec.NotifyURL
+	m.UpdatedAt = now
+}
+
+// Delete removes a monitor, its changes and its snapshot.
+func (s *Store) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	monitor, ok := s.monitors[id]
+	if !ok {
+		return ErrNotFound
+	}
+	changes := s.changes[id]
+	delete(s.monitors, id)
+	delete(s.changes, id)
+	if err := s.save(); err != nil {
+		s.monitors[id] = monitor
+		s.changes[id] = changes
+		return err
+	}
+	s.removeSnapshot(id)
+	return nil
+}
+
+func (s *Store) Get(id string) (Monitor, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	monitor, ok := s.monitors[id]
+	if !ok {
+		return Monitor{}, ErrNotFound
+	}
+	return *monitor, nil
+}
+
+// List returns every monitor, by name.
+func (s *Store) List() []Monitor {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := make([]Monitor, 0, len(s.monitors))
+	for _, monitor := range s.monitors {
+		list = append(list, *monitor)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Name != list[j].Name {
+			return list[i].Name < list[j].Name
+		}
+		return list[i].ID < list[j].ID
+	})
+	return list
+}
+
+// OfSchedule returns the monitors watching a schedule.
+func (s *Store) OfSchedule(scheduleID string) []Monitor {
+	var list []Monitor
+	for _, monitor := range s.List() {
+		if monitor.ScheduleID == scheduleID {
+			list = append(list, monitor)
+		}
+	}
+	return list
+}
+
+// Changes returns a monitor's changes, newest first.
+func (s *Store) Changes(id string) ([]Change, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.monitors[id]; !ok {
+		return nil, ErrNotFound
+	}
+	list := make([]Change, 0, len(s.changes[id]))
+	for _, change := range s.changes[id] {
+		list = append(list, *change)
+	}
+	return list, nil
+}
+
+// checked records that a run was compared, and the change it made if any.
+func (s *Store) checked(id string, at time.Time, change *Change) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	monitor, ok := s.monitors[id]
+	if !ok {
+		return
+	}
+	monitor.LastCheckedAt = &at
+	if change != nil {
+		change.ID = randomID()
+		change.MonitorID = id
+		monitor.LastChangeAt = &at
+		changes := append([]*Change{change}, s.changes[id]...)
+		if len(changes) > maxChanges {
+			changes = changes[:maxChanges]
+		}
+		s.changes[id] = changes
+	}
+	s.saveOrLog()
+}
+
+// updateChange changes a change still in the history.
+func (s *Store) updateChange(monitorID, changeID string, fn func(change *Change)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, change := range s.changes[monitorID] {
+		if change.ID == changeID {
+			fn(change)
+			s.saveOrLog()
+			return
+		}
+	}
+}
+
+func (s *Store) snapshotPath(id string) string {
+	return filepath.Join(s.dir, id+".json")
+}
+
+// snapshot returns the monitor's latest snapshot, or nil before its first
+// run.
+func (s *Store) snapshot(id string) (*snapshot, error) {
+	data, err := os.ReadFile(s.snapshotPath(id))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var snap snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, err
+	}
+	return &snap, nil
+}
+
+func (s *Store) saveSnapshot(id string, snap *snapshot) error {
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	return store.WriteFileAtomic(s.snapshotPath(id), data)
+}
+
+func (s *Store) removeSnapshot(id string) {
+	if err := os.Remove(s.snapshotPath(id)); err != nil && !os.IsNotExist(err) {
+		log.Printf("could not remove the snapshot of monitor %s: %v", id, err)
+	}
+}
+
+// saveOrLog saves for the watcher, which has nobody to report a failure to;
+// callers hold s.mu.
+func (s *Store) saveOrLog() {
+	if err := s.save(); err != nil {
+		log.Printf("could not save monitors: %v", err)
+	}
+}
+
+func randomID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,255 @@
+package monitors
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"brian-nunez/bcode/internal/artifacts"
+	"brian-nunez/bcode/internal/jobs"
+	"brian-nunez/bcode/internal/outbound"
+	"brian-nunez/bcode/internal/scheduler"
+)
+
+// Results larger than this are compared by their start only.
+const maxResultSize = 10 << 20
+
+const notifyTimeout = 10 * time.Second
+
+// Watcher compares the runs of monitored schedules as the scheduler
+// finishes them.
+type Watcher struct {
+	store     *Store
+	artifacts artifacts.Store
+	client    *http.Client
+	now       func() time.Time
+}
+
+func NewWatcher(store *Store, artifactStore artifacts.Store) *Watcher {
+	return &Watcher{
+		store:     store,
+		artifacts: artifactStore,
+		client:    outbound.Client(notifyTimeout),
+		now:       time.Now,
+	}
+}
+
+// Prepare asks the schedule's job for what its monitors compare.
+func (w *Watcher) Prepare(schedule scheduler.Schedule, fields url.Values) {
+	for _, monitor := range w.store.OfSchedule(schedule.ID) {
+		switch monitor.Compare {
+		case CompareText:
+			fields.Set("save_result", "on")
+		case CompareScreenshot:
+			fields.Set("screenshot", "on")
+		case CompareFields:
+			// Monitors of one schedule share its fields by name
+			lines := []string{fields.Get("extract")}
+			for _, name := range sortedKeys(monitor.Fields) {
+				lines = append(lines, name+"="+monitor.Fields[name])
+			}
+			fields.Set("extract", strings.TrimSpace(strings.Join(lines, "\n")))
+		}
+	}
+}
+
+// Observe compares a succeeded run with the previous one for each monitor
+// of its schedule. The first run of a monitor is its baseline.
+func (w *Watcher) Observe(ctx context.Context, schedule scheduler.Schedule, run scheduler.Run) {
+	if run.Status != scheduler.RunSucceeded || run.JobID == "" {
+		return
+	}
+	job, ok := jobs.Default.Get(run.JobID)
+	if !ok {
+		return
+	}
+
+	for _, monitor := range w.store.OfSchedule(schedule.ID) {
+		if err := w.check(ctx, monitor, job); err != nil {
+			log.Printf("monitor %s (%s): could not check job %s: %v", monitor.Name, monitor.ID, job.ID, err)
+		}
+	}
+}
+
+func (w *Watcher) check(ctx context.Context, monitor Monitor, job jobs.Job) error {
+	current, err := w.snapshot(ctx, monitor, job)
+	if err != nil {
+		return err
+	}
+	previous, err := w.store.snapshot(monitor.ID)
+	if err != nil {
+		return err
+	}
+	if err := w.store.saveSnapshot(monitor.ID, current); err != nil {
+		return err
+	}
+
+	var change *Change
+	if previous != nil {
+		change, err = w.compare(ctx, monitor, previous, current)
+		if err != nil {
+			return err
+		}
+	}
+	w.store.checked(monitor.ID, current.TakenAt, change)
+
+	if change != nil && monitor.NotifyURL != "" {
+		notifiedAt, err := w.notify(ctx, monitor, *change)
+		w.store.updateChange(monitor.ID, change.ID, func(saved *Change) {
+			if err != nil {
+				saved.NotifyError = err.Error()
+			} else {
+				saved.NotifiedAt = &notifiedAt
+			}
+		})
+	}
+	return nil
+}
+
+// snapshot takes what the monitor compares from a finished job.
+func (w *Watcher) snapshot(ctx context.Context, monitor Monitor, job jobs.Job) (*snapshot, error) {
+	snap := &snapshot{JobID: job.ID, TakenAt: w.now()}
+
+	switch monitor.Compare {
+	case CompareText:
+		data, err := w.read(ctx, job.ID, jobs.ResultArtifact)
+		if err != nil {
+			return nil, fmt.Errorf("could not read the result: %w", err)
+		}
+		snap.Text = pageText(string(data))
+	case CompareFields:
+		snap.Fields = map[string]string{}
+		for name := range monitor.Fields {
+			snap.Fields[name] = job.Fields[name]
+		}
+	case CompareScreenshot:
+		if job.Screenshot == "" {
+			return nil, fmt.Errorf("the job took no screenshot")
+		}
+		data, err := w.read(ctx, job.ID, job.Screenshot)
+		if err != nil {
+			return nil, fmt.Errorf("could not read the screenshot: %w", err)
+		}
+		if snap.Grid, err = fingerprint(data); err != nil {
+			return nil, err
+		}
+		snap.Screenshot = job.Screenshot
+	}
+	return snap, nil
+}
+
+// compare returns the change between two snapshots, or nil if it is within
+// the monitor's threshold.
+func (w *Watcher) compare(ctx context.Context, monitor Monitor, previous, current *snapshot) (*Change, error) {
+	change := &Change{
+		JobID:         current.JobID,
+		PreviousJobID: previous.JobID,
+		DetectedAt:    current.TakenAt,
+	}
+
+	switch monitor.Compare {
+	case CompareText:
+		change.Score, change.Diff = compareText(previous.Text, current.Text)
+		change.Summary = fmt.Sprintf("%.1f%% of the text changed", change.Score*100)
+	case CompareFields:
+		change.Score, change.Diff = compareFields(previous.Fields, current.Fields)
+		change.Summary = fmt.Sprintf("%d of %d fields changed", strings.Count(change.Diff, "\n"), len(monitor.Fields))
+	case CompareScreenshot:
+		cells, err := changedCells(previous.Grid, current.Grid)
+		if err != nil {
+			return nil, err
+		}
+		change.Score = float64(len(cells)) / float64(gridSize*gridSize)
+		change.Summary = fmt.Sprintf("%.1f%% of the screenshot changed", change.Score*100)
+		if change.Score > 0 && change.Score > monitor.Threshold {
+			change.Before = &ArtifactRef{JobID: previous.JobID, Name: previous.Screenshot}
+			change.After = &ArtifactRef{JobID: current.JobID, Name: current.Screenshot}
+			change.Highlight = w.highlight(ctx, monitor, current, cells)
+		}
+	}
+
+	if change.Score == 0 || change.Score <= monitor.Threshold {
+		return nil, nil
+	}
+	return change, nil
+}
+
+// highlight saves the new screenshot with its changes marked as an artifact
+// of the new job. Without it the change is still recorded.
+func (w *Watcher) highlight(ctx context.Context, monitor Monitor, current *snapshot, cells []int) *ArtifactRef {
+	data, err := w.read(ctx, current.JobID, current.Screenshot)
+	if err == nil {
+		data, err = highlightCells(data, cells)
+	}
+	var artifact artifacts.Artifact
+	name := "monitor-" + monitor.ID + "-diff.jpg"
+	if err == nil {
+		artifact, err = w.artifacts.Put(ctx, current.JobID, name, bytes.NewReader(data), int64(len(data)))
+	}
+	if err != nil {
+		log.Printf("monitor %s (%s): could not save the highlighted screenshot: %v", monitor.Name, monitor.ID, err)
+		return nil
+	}
+
+	jobs.Default.Update(current.JobID, func(j *jobs.Job) {
+		j.Artifacts = append(j.Artifacts, artifact)
+	})
+	return &ArtifactRef{JobID: current.JobID, Name: name}
+}
+
+func (w *Watcher) read(ctx context.Context, jobID, name string) ([]byte, error) {
+	r, _, err := w.artifacts.Open(ctx, jobID, name)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(io.LimitReader(r, maxResultSize))
+}
+
+// notification is what a monitor's notify URL receives.
+type notification struct {
+	Event   string  `json:"event"`
+	Monitor Monitor `json:"monitor"`
+	Change  Change  `json:"change"`
+}
+
+// notify posts a change to the monitor's notify URL.
+func (w *Watcher) notify(ctx context.Context, monitor Monitor, change Change) (time.Time, error) {
+	body, err := json.Marshal(notification{Event: "monitor.changed", Monitor: monitor, Change: change})
+	if err != nil {
+		return time.Time{}, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, monitor.NotifyURL, bytes.NewReader(body))
+	if err != nil {
+		return time.Time{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := w.client.Do(req)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(res.Body, 4<<10))
+	if res.StatusCode >= 300 {
+		return time.Time{}, fmt.Errorf("the notify URL answered %s", res.Status)
+	}
+	return w.now(), nil
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
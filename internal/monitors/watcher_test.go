@@ -0,0 +1,275 @@
+package monitors
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"brian-nunez/bcode/internal/artifacts"
+	"brian-nunez/bcode/internal/jobs"
+)
+
+// receiver records the notifications posted to it.
+type receiver struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	status   int
+	received []notification
+}
+
+func newReceiver(t *testing.T) *receiver {
+	r := &receiver{status: http.StatusNoContent}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var n notification
+		if err := json.NewDecoder(req.Body).Decode(&n); err != nil {
+			t.Errorf("could not decode the notification: %v", err)
+		}
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.received = append(r.received, n)
+		w.WriteHeader(r.status)
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+func (r *receiver) notifications() []notification {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]notification(nil), r.received...)
+}
+
+func newTestWatcher(t *testing.T, client *http.Client) (*Watcher, *artifacts.LocalStore) {
+	t.Helper()
+
+	dir := t.TempDir()
+	store, err := NewStore(filepath.Join(dir, "monitors.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	artifactStore, err := artifacts.NewLocalStore(filepath.Join(dir, "artifacts"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	// The receiver listens on loopback, which the outbound client refuses
+	return &Watcher{store: store, artifacts: artifactStore, client: client, now: func() time.Time { return now }}, artifactStore
+}
+
+// finishedJob stores a job's result the way the worker does.
+func finishedJob(t *testing.T, artifactStore artifacts.Store, result string) jobs.Job {
+	t.Helper()
+
+	job := jobs.Job{ID: jobs.NewID()}
+	if _, err := artifactStore.Put(context.Background(), job.ID, jobs.ResultArtifact, strings.NewReader(result), int64(len(result))); err != nil {
+		t.Fatal(err)
+	}
+	return job
+}
+
+func TestWatcherCheck(t *testing.T) {
+	ctx := context.Background()
+	receiver := newReceiver(t)
+	watcher, artifactStore := newTestWatcher(t, receiver.Client())
+
+	monitor, err := watcher.store.Create("default", "user:admin", Spec{
+		Name:       "prices",
+		ScheduleID: "schedule",
+		Compare:    CompareText,
+		NotifyURL:  receiver.URL,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The first run is the baseline
+	baseline := finishedJob(t, artifactStore, "<html><body><h1>Widget</h1><p>Price: $10</p></body></html>")
+	if err := watcher.check(ctx, monitor, baseline); err != nil {
+		t.Fatal(err)
+	}
+	if changes, _ := watcher.store.Changes(monitor.ID); len(changes) != 0 {
+		t.Errorf("the baseline run recorded changes: %+v", changes)
+	}
+	if got, _ := watcher.store.Get(monitor.ID); got.LastCheckedAt == nil || got.LastChangeAt != nil {
+		t.Errorf("after the baseline the monitor is %+v, want checked without a change", got)
+	}
+	if len(receiver.notifications()) != 0 {
+		t.Errorf("the baseline run notified: %+v", receiver.notifications())
+	}
+
+	// An unchanged page is no change
+	same := finishedJob(t, artifactStore, "<html><body><h1>Widget</h1><p>Price:   $10</p></body></html>")
+	if err := watcher.check(ctx, monitor, same); err != nil {
+		t.Fatal(err)
+	}
+	if changes, _ := watcher.store.Changes(monitor.ID); len(changes) != 0 {
+		t.Errorf("an unchanged page recorded changes: %+v", changes)
+	}
+
+	changed := finishedJob(t, artifactStore, "<html><body><h1>Widget</h1><p>Price: $12</p></body></html>")
+	if err := watcher.check(ctx, monitor, changed); err != nil {
+		t.Fatal(err)
+	}
+	changes, _ := watcher.store.Changes(monitor.ID)
+	if len(changes) != 1 {
+		t.Fatalf("recorded %d changes, want 1", len(changes))
+	}
+	change := changes[0]
+	if change.JobID != changed.ID || change.PreviousJobID != same.ID || change.Score != 0.5 {
+		t.Errorf("change = %+v, want half of %s changed from %s", change, changed.ID, same.ID)
+	}
+	if want := "@@ -1,2 +1,2 @@\n Widget\n-Price: $10\n+Price: $12\n"; change.Diff != want {
+		t.Errorf("Diff = %q, want %q", change.Diff, want)
+	}
+	if change.NotifiedAt == nil || !change.NotifiedAt.Equal(watcher.now()) || change.NotifyError != "" {
+		t.Errorf("change = %+v, want it notified", change)
+	}
+
+	notifications := receiver.notifications()
+	if len(notifications) != 1 {
+		t.Fatalf("received %d notifications, want 1", len(notifications))
+	}
+	if n := notifications[0]; n.Event != "monitor.changed" || n.Monitor.ID != monitor.ID || n.Change.ID != change.ID || n.Change.Diff != change.Diff {
+		t.Errorf("notification = %+v", n)
+	}
+
+	// A receiver that fails is recorded on the change
+	receiver.mu.Lock()
+	receiver.status = http.StatusBadGateway
+	receiver.mu.Unlock()
+	reverted := finishedJob(t, artifactStore, "<html><body><h1>Widget</h1><p>Price: $10</p></body></html>")
+	if err := watcher.check(ctx, monitor, reverted); err != nil {
+		t.Fatal(err)
+	}
+	changes, _ = watcher.store.Changes(monitor.ID)
+	if len(changes) != 2 || changes[0].JobID != reverted.ID || changes[0].NotifiedAt != nil || !strings.Contains(changes[0].NotifyError, "502") {
+		t.Errorf("changes = %+v, want the newest first with the failed notification", changes)
+	}
+}
+
+func TestWatcherCheckWithoutResult(t *testing.T) {
+	watcher, _ := newTestWatcher(t, http.DefaultClient)
+	monitor, err := watcher.store.Create("default", "user:admin", Spec{Name: "prices", ScheduleID: "schedule", Compare: CompareText})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := watcher.check(context.Background(), monitor, jobs.Job{ID: jobs.NewID()}); err == nil {
+		t.Error("a job without a result was checked")
+	}
+	if got, _ := watcher.store.Get(monitor.ID); got.LastCheckedAt != nil {
+		t.Errorf("a failed check was recorded: %+v", got)
+	}
+}
+
+// screenshot returns a PNG of a grid-sized image, and its fingerprint.
+func screenshot(t *testing.T, changed int) ([]byte, string) {
+	t.Helper()
+
+	img := image.NewGray(image.Rect(0, 0, gridSize, gridSize))
+	for i := range img.Pix {
+		img.Pix[i] = 128
+		if i < changed {
+			img.Pix[i] = 255
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	grid, err := fingerprint(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes(), grid
+}
+
+func TestCompareScreenshotThreshold(t *testing.T) {
+	ctx := context.Background()
+	_, before := screenshot(t, 0)
+
+	// The default threshold is 1% of the 4096 cells
+	tests := []struct {
+		name      string
+		changed   int
+		threshold float64
+		want      bool
+	}{
+		{name: "unchanged", changed: 0, threshold: 0, want: false},
+		{name: "under the threshold", changed: 40, threshold: defaultScreenshotThreshold, want: false},
+		{name: "over the threshold", changed: 41, threshold: defaultScreenshotThreshold, want: true},
+		{name: "any change without a threshold", changed: 1, threshold: 0, want: true},
+		{name: "under a high threshold", changed: 2048, threshold: 0.5, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			watcher, artifactStore := newTestWatcher(t, http.DefaultClient)
+			data, after := screenshot(t, tt.changed)
+			current := &snapshot{JobID: jobs.NewID(), Grid: after, Screenshot: "final.png"}
+			if _, err := artifactStore.Put(ctx, current.JobID, current.Screenshot, bytes.NewReader(data), int64(len(data))); err != nil {
+				t.Fatal(err)
+			}
+			previous := &snapshot{JobID: jobs.NewID(), Grid: before, Screenshot: "final.png"}
+			monitor := Monitor{ID: "m1", Compare: CompareScreenshot, Threshold: tt.threshold}
+
+			change, err := watcher.compare(ctx, monitor, previous, current)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (change != nil) != tt.want {
+				t.Fatalf("compare = %+v, want a change: %v", change, tt.want)
+			}
+			if change == nil {
+				return
+			}
+			if change.Score != float64(tt.changed)/(gridSize*gridSize) {
+				t.Errorf("Score = %v with %d cells changed", change.Score, tt.changed)
+			}
+			if change.Before == nil || change.Before.JobID != previous.JobID || change.After == nil || change.After.JobID != current.JobID {
+				t.Errorf("change = %+v, want both screenshots", change)
+			}
+			if change.Highlight == nil {
+				t.Fatal("the change has no highlighted screenshot")
+			}
+			if _, _, err := artifactStore.Open(ctx, current.JobID, change.Highlight.Name); err != nil {
+				t.Errorf("the highlighted screenshot was not saved: %v", err)
+			}
+		})
+	}
+}
+
+func TestScreenshotFingerprint(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 2*gridSize, 2*gridSize))
+	for y := 0; y < 2*gridSize; y++ {
+		for x := 0; x < 2*gridSize; x++ {
+			img.Set(x, y, color.White)
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	white, err := fingerprint(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cells, _ := changedCells(white, grid(0)); len(cells) != gridSize*gridSize {
+		t.Errorf("white against grey changed %d cells, want all", len(cells))
+	}
+
+	if _, err := fingerprint([]byte("not an image")); err == nil {
+		t.Error("fingerprint accepted something that is not an image")
+	}
+}
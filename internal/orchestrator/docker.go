@@ -2,46 +2,101 @@ package orchestrator
 
 import (
 	"context"
+	"errors"
 	"io"
-	"os"
+	"sync"
+	"time"
 
+	"brian-nunez/bcode/internal/jobs"
+
+	"github.com/moby/moby/api/pkg/stdcopy"
 	"github.com/moby/moby/api/types/container"
 	"github.com/moby/moby/client"
 )
 
-type JobRequest struct {
-	Payload string
+type hijackedWriter struct {
+	resp client.ContainerAttachResult
+}
+
+func (w *hijackedWriter) Write(p []byte) (int, error) {
+	return w.resp.Conn.Write(p)
+}
+
+func (w *hijackedWriter) Close() error {
+	w.resp.Close()
+	return nil
+}
+
+// DockerRunner runs each worker in a container of the worker image. It owns
+// one Docker client for the life of the server and labels every container
+// with its job and server instance, so containers left behind by a crash can
+// be found again by Reconcile.
+type DockerRunner struct {
+	client *client.Client
+	// Instance is the server instance the runner labels its containers
+	// with. Reconcile only touches containers of its own instance.
+	Instance string
+	// Tracked reports whether a job is still in progress. Containers of other
+	// jobs that the runner is not relaying are orphans.
+	Tracked func(jobID string) bool
+
+	mu     sync.Mutex
+	active map[string]string // container ID -> job ID
 }
 
-func RunJob(ctx context.Context, req JobRequest) (io.ReadCloser, error) {
+func NewDockerRunner() (*DockerRunner, error) {
 	cli, err := client.NewClientWithOpts(client.FromEnv)
 	if err != nil {
 		return nil, err
 	}
+	return &DockerRunner{
+		client:   cli,
+		Instance: InstanceID(),
+		Tracked:  jobInProgress,
+		active:   map[string]string{},
+	}, nil
+}
 
-	workerImage := os.Getenv("WORKER_IMAGE")
-	if workerImage == "" {
-		workerImage = "bbaas-worker:latest"
+func newDockerDefault() (Runner, error) {
+	runner, err := NewDockerRunner()
+	if err != nil {
+		return nil, err
 	}
+	return runner, nil
+}
 
-	containerEnv := []string{
-		"JOB_PAYLOAD=" + req.Payload,
-	}
+func (r *DockerRunner) Close() error {
+	return r.client.Close()
+}
 
-	if ollamaModel := os.Getenv("OLLAMA_MODEL"); ollamaModel != "" {
-		containerEnv = append(containerEnv, "OLLAMA_MODEL="+ollamaModel)
-	}
-	if ollamaEndpoint := os.Getenv("OLLAMA_ENDPOINT"); ollamaEndpoint != "" {
-		containerEnv = append(containerEnv, "OLLAMA_ENDPOINT="+ollamaEndpoint)
-	}
+func jobInProgress(jobID string) bool {
+	job, ok := jobs.Default.Get(jobID)
+	return ok && (job.Status == jobs.StatusRunning || job.Status == jobs.StatusPaused)
+}
+
+func (r *DockerRunner) Run(ctx context.Context, req JobRequest) (*Execution, error) {
+	cli := r.client
 
 	config := &container.Config{
-		Image: workerImage,
-		Env:   containerEnv,
+		Image: workerImage(req),
+		Labels: map[string]string{
+			workerJobIDLabel:    req.JobID,
+			workerInstanceLabel: r.Instance,
+		},
+		Env: workerEnv(payloadOnStdin),
+		// Stdin carries the payload, then stays open so the server can send
+		// control messages (operator answers, approvals) to the worker.
+		OpenStdin:   true,
+		AttachStdin: true,
+		StdinOnce:   true,
 	}
 
-	hostConfig := &container.HostConfig{
-		AutoRemove: true,
+	// The container is removed once its logs have been read and its exit
+	// status inspected; AutoRemove would lose both the last log lines and
+	// whether it was OOM-killed.
+	hostConfig := &container.HostConfig{}
+	if limit := workerMemoryMB(); limit > 0 {
+		hostConfig.Memory = limit * 1024 * 1024
 	}
 
 	resp, err := cli.ContainerCreate(ctx, client.ContainerCreateOptions{
@@ -51,21 +106,155 @@ func RunJob(ctx context.Context, req JobRequest) (io.ReadCloser, error) {
 	if err != nil {
 		return nil, err
 	}
+	r.track(resp.ID, req.JobID)
+
+	attach, err := cli.ContainerAttach(ctx, resp.ID, client.ContainerAttachOptions{
+		Stream: true,
+		Stdin:  true,
+	})
+	if err != nil {
+		r.remove(resp.ID)
+		return nil, err
+	}
+
+	// Waiting for the next exit has to start before the container does
+	wait := cli.ContainerWait(ctx, resp.ID, client.ContainerWaitOptions{
+		Condition: container.WaitConditionNextExit,
+	})
 
 	if _, err := cli.ContainerStart(ctx, resp.ID, client.ContainerStartOptions{}); err != nil {
+		attach.Close()
+		r.remove(resp.ID)
 		return nil, err
 	}
+	control := &hijackedWriter{resp: attach}
+	if err := sendPayload(control, req); err != nil {
+		control.Close()
+		r.remove(resp.ID)
+		return nil, err
+	}
+
+	exit := make(chan ExitStatus, 1)
+	go func() {
+		status := ExitStatus{Code: -1}
+		select {
+		case result := <-wait.Result:
+			status.Code = result.StatusCode
+			if result.Error != nil {
+				status.Error = result.Error.Message
+			}
+		case err := <-wait.Error:
+			status.Error = err.Error()
+		}
+
+		inspect, err := cli.ContainerInspect(context.Background(), resp.ID, client.ContainerInspectOptions{})
+		if err == nil && inspect.Container.State != nil {
+			status.OOMKilled = inspect.Container.State.OOMKilled
+			if status.Code == -1 {
+				status.Code = int64(inspect.Container.State.ExitCode)
+			}
+		}
+		exit <- status
+	}()
 
 	// Monitor context cancellation to kill container on client disconnect
 	go func() {
 		<-ctx.Done()
-		// Use a background context because the original 'ctx' is already dead
-		cli.ContainerRemove(context.Background(), resp.ID, client.ContainerRemoveOptions{Force: true})
+		attach.Close()
+		r.remove(resp.ID)
 	}()
 
-	return cli.ContainerLogs(ctx, resp.ID, client.ContainerLogsOptions{
+	logs, err := cli.ContainerLogs(ctx, resp.ID, client.ContainerLogsOptions{
 		ShowStdout: true,
 		ShowStderr: true,
 		Follow:     true,
 	})
+	if err != nil {
+		// Nobody will relay this worker, so it must not keep running
+		attach.Close()
+		r.remove(resp.ID)
+		return nil, err
+	}
+
+	// The log stream can still hold the last lines, often the result, after
+	// the container has exited, so it is removed only once they are read
+	execution := newExecution(demux(logs), control, exit)
+	execution.release = func() { r.remove(resp.ID) }
+	return execution, nil
+}
+
+func (r *DockerRunner) track(containerID, jobID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.active[containerID] = jobID
+}
+
+// remove force-removes a container and stops tracking it. It uses a
+// background context because the job's own may already be done.
+func (r *DockerRunner) remove(containerID string) error {
+	r.mu.Lock()
+	delete(r.active, containerID)
+	r.mu.Unlock()
+
+	_, err := r.client.ContainerRemove(context.Background(), containerID, client.ContainerRemoveOptions{Force: true})
+	return err
+}
+
+// Reconcile removes this instance's worker containers that the runner is not
+// relaying and whose job is no longer in progress, such as those left running
+// when the server crashed.
+func (r *DockerRunner) Reconcile(ctx context.Context) ([]Orphan, error) {
+	list, err := r.client.ContainerList(ctx, client.ContainerListOptions{
+		All:     true,
+		Filters: make(client.Filters).Add("label", workerInstanceLabel+"="+r.Instance),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var orphans []Orphan
+	var errs []error
+	for _, summary := range list.Items {
+		jobID := summary.Labels[workerJobIDLabel]
+
+		r.mu.Lock()
+		_, active := r.active[summary.ID]
+		r.mu.Unlock()
+		if active || (jobID != "" && r.Tracked != nil && r.Tracked(jobID)) {
+			continue
+		}
+
+		if err := r.remove(summary.ID); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		orphans = append(orphans, Orphan{
+			ID:      summary.ID,
+			JobID:   jobID,
+			State:   string(summary.State),
+			Created: time.Unix(summary.Created, 0),
+		})
+	}
+	return orphans, errors.Join(errs...)
+}
+
+// demux strips the stream headers Docker puts in front of each chunk of a
+// non-TTY container's stdout and stderr.
+func demux(logs io.ReadCloser) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		_, err := stdcopy.StdCopy(pw, pw, logs)
+		pw.CloseWithError(err)
+	}()
+	return &demuxedLogs{PipeReader: pr, logs: logs}
+}
+
+type demuxedLogs struct {
+	*io.PipeReader
+	logs io.ReadCloser
+}
+
+func (d *demuxedLogs) Close() error {
+	d.PipeReader.Close()
+	return d.logs.Close()
 }
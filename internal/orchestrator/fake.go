@@ -0,0 +1,120 @@
+package orchestrator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// FakeAwaitControl, as a line of a FakeRunner script, makes the fake worker
+// wait for the next control message on its stdin before printing the rest,
+// like a worker waiting for an operator.
+const FakeAwaitControl = "\x00await control"
+
+// FakeRunner replays scripted worker output instead of running a worker.
+// It records every request and everything written to the workers' stdin.
+type FakeRunner struct {
+	// Script returns the lines the worker prints for a request. The default
+	// script reports a successful result straight away.
+	Script func(req JobRequest) []string
+	// Exit is the status every fake worker exits with.
+	Exit ExitStatus
+
+	mu       sync.Mutex
+	requests []JobRequest
+	control  strings.Builder
+}
+
+func (r *FakeRunner) Run(ctx context.Context, req JobRequest) (*Execution, error) {
+	r.mu.Lock()
+	r.requests = append(r.requests, req)
+	r.mu.Unlock()
+
+	script := r.Script
+	if script == nil {
+		script = func(JobRequest) []string {
+			return []string{
+				"Fake worker started",
+				FakeEvent("JOB_RESULT", map[string]any{"success": true, "data": "Fake result"}),
+			}
+		}
+	}
+
+	logs, output := io.Pipe()
+	exit := make(chan ExitStatus, 1)
+	stdin := &fakeStdin{mu: &r.mu, stdin: &r.control, received: make(chan struct{}, 16)}
+	go func() {
+		defer func() { exit <- r.Exit }()
+		defer output.Close()
+		for _, line := range script(req) {
+			if line == FakeAwaitControl {
+				select {
+				case <-stdin.received:
+					continue
+				case <-ctx.Done():
+					return
+				}
+			}
+			select {
+			case <-ctx.Done():
+				return
+			default:
+			}
+			if _, err := fmt.Fprintln(output, line); err != nil {
+				return
+			}
+		}
+	}()
+
+	return newExecution(logs, stdin, exit), nil
+}
+
+// Requests returns the requests the runner has been asked to run.
+func (r *FakeRunner) Requests() []JobRequest {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]JobRequest(nil), r.requests...)
+}
+
+// Control returns everything written to the fake workers' stdin.
+func (r *FakeRunner) Control() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.control.String()
+}
+
+// FakeEvent formats a worker protocol line such as JOB_RESULT:{...}.
+func FakeEvent(event string, payload any) string {
+	data, _ := json.Marshal(payload)
+	return event + ":" + string(data)
+}
+
+// fakeStdin collects what is written to a fake worker's stdin, signalling
+// received, if set, for every line.
+type fakeStdin struct {
+	mu       *sync.Mutex
+	stdin    *strings.Builder
+	received chan struct{}
+}
+
+func (s *fakeStdin) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.received != nil {
+		for range strings.Count(string(p), "\n") {
+			select {
+			case s.received <- struct{}{}:
+			default:
+			}
+		}
+	}
+	return s.stdin.Write(p)
+}
+
+func (s *fakeStdin) Close() error {
+	return nil
+}
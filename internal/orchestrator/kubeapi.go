@@ -0,0 +1,94 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+const serviceAccountNamespace = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// KubernetesConfigFromEnv connects to the API server at KUBERNETES_API_URL
+// (for example a local `kubectl proxy`, with KUBERNETES_TOKEN if it needs
+// one) or, inside a cluster, to the one the pod's service account belongs
+// to. It also returns the namespace to use by default.
+func KubernetesConfigFromEnv() (*rest.Config, string, error) {
+	if apiURL := os.Getenv("KUBERNETES_API_URL"); apiURL != "" {
+		return &rest.Config{
+			Host:        strings.TrimSuffix(apiURL, "/"),
+			BearerToken: os.Getenv("KUBERNETES_TOKEN"),
+		}, "default", nil
+	}
+
+	config, err := rest.InClusterConfig()
+	if errors.Is(err, rest.ErrNotInCluster) {
+		return nil, "", errors.New("not running in a Kubernetes cluster and KUBERNETES_API_URL is not set")
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	namespace := "default"
+	if data, err := os.ReadFile(serviceAccountNamespace); err == nil {
+		namespace = strings.TrimSpace(string(data))
+	}
+	return config, namespace, nil
+}
+
+// StdinAttacher attaches to the stdin of a running container.
+type StdinAttacher interface {
+	AttachStdin(ctx context.Context, namespace, pod, container string) (io.WriteCloser, error)
+}
+
+// RemoteAttacher attaches like `kubectl attach`, over WebSockets where the
+// API server supports them and SPDY otherwise.
+type RemoteAttacher struct {
+	Client kubernetes.Interface
+	Config *rest.Config
+}
+
+// AttachStdin returns a writer to the container's stdin. The connection is
+// made in the background, so a failure to attach is returned by the first
+// write.
+func (a *RemoteAttacher) AttachStdin(ctx context.Context, namespace, pod, container string) (io.WriteCloser, error) {
+	req := a.Client.CoreV1().RESTClient().Post().
+		Namespace(namespace).
+		Resource("pods").
+		Name(pod).
+		SubResource("attach").
+		VersionedParams(&corev1.PodAttachOptions{Container: container, Stdin: true}, scheme.ParameterCodec)
+
+	websocket, err := remotecommand.NewWebSocketExecutor(a.Config, "GET", req.URL().String())
+	if err != nil {
+		return nil, err
+	}
+	spdy, err := remotecommand.NewSPDYExecutor(a.Config, "POST", req.URL())
+	if err != nil {
+		return nil, err
+	}
+	executor, err := remotecommand.NewFallbackExecutor(websocket, spdy, func(err error) bool {
+		return httpstream.IsUpgradeFailure(err) || httpstream.IsHTTPSProxyError(err)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	stdin, writer := io.Pipe()
+	go func() {
+		err := executor.StreamWithContext(ctx, remotecommand.StreamOptions{Stdin: stdin})
+		if err == nil {
+			err = io.ErrClosedPipe
+		}
+		stdin.CloseWithError(err)
+	}()
+	return writer, nil
+}
@@ -0,0 +1,342 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	kubeWorkerContainer = "worker"
+	kubeJobNameLabel    = "job-name"
+)
+
+// Waiting reasons that mean a worker pod will never start
+var kubeFatalWaitReasons = []string{
+	"ErrImagePull",
+	"ImagePullBackOff",
+	"InvalidImageName",
+	"CreateContainerConfigError",
+	"CreateContainerError",
+}
+
+// KubernetesRunner runs each worker as a Kubernetes Job with a single pod.
+// Retries are the server's business, so the Job itself never restarts the
+// pod.
+type KubernetesRunner struct {
+	Client kubernetes.Interface
+	// Attacher connects to the worker's stdin.
+	Attacher  StdinAttacher
+	Namespace string
+
+	// Resources for the worker container, as Kubernetes quantities. Empty
+	// ones are left unset.
+	CPURequest    string
+	CPULimit      string
+	MemoryRequest string
+	MemoryLimit   string
+
+	// TTLSecondsAfterFinished lets the cluster delete finished Jobs.
+	TTLSecondsAfterFinished int32
+	// StartTimeout bounds how long a pod may stay pending.
+	StartTimeout time.Duration
+	// PollInterval is how often pod status is read while waiting.
+	PollInterval time.Duration
+	// Labels are added to every Job and pod.
+	Labels map[string]string
+}
+
+// NewKubernetesRunnerFromEnv runs workers in the cluster the server runs in,
+// in KUBERNETES_NAMESPACE (the server's own namespace by default).
+// WORKER_CPU_REQUEST, WORKER_CPU_LIMIT and WORKER_MEMORY_MB size the worker
+// pods and WORKER_TTL_SECONDS is how long finished Jobs are kept.
+func NewKubernetesRunnerFromEnv() (*KubernetesRunner, error) {
+	config, namespace, err := KubernetesConfigFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	if ns := os.Getenv("KUBERNETES_NAMESPACE"); ns != "" {
+		namespace = ns
+	}
+
+	runner := &KubernetesRunner{
+		Client:                  client,
+		Attacher:                &RemoteAttacher{Client: client, Config: config},
+		Namespace:               namespace,
+		CPURequest:              os.Getenv("WORKER_CPU_REQUEST"),
+		CPULimit:                os.Getenv("WORKER_CPU_LIMIT"),
+		TTLSecondsAfterFinished: 300,
+		StartTimeout:            5 * time.Minute,
+		PollInterval:            time.Second,
+	}
+	if runner.CPURequest == "" {
+		runner.CPURequest = "500m"
+	}
+	if limit := workerMemoryMB(); limit > 0 {
+		runner.MemoryRequest = fmt.Sprintf("%dMi", limit)
+		runner.MemoryLimit = runner.MemoryRequest
+	}
+	if ttl, err := strconv.ParseInt(os.Getenv("WORKER_TTL_SECONDS"), 10, 32); err == nil && ttl >= 0 {
+		runner.TTLSecondsAfterFinished = int32(ttl)
+	}
+	return runner, nil
+}
+
+func newKubernetesDefault() (Runner, error) {
+	runner, err := NewKubernetesRunnerFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	return runner, nil
+}
+
+func (r *KubernetesRunner) Run(ctx context.Context, req JobRequest) (*Execution, error) {
+	if r.Client == nil || r.Attacher == nil {
+		return nil, errors.New("kubernetes runner has no client")
+	}
+
+	manifest, err := r.jobManifest(req)
+	if err != nil {
+		return nil, err
+	}
+	jobs := r.Client.BatchV1().Jobs(r.Namespace)
+	job, err := jobs.Create(ctx, manifest, metav1.CreateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("could not create worker job: %w", err)
+	}
+	name := job.Name
+	// Deleting the Job deletes its pod, which stops the worker
+	deleteJob := func() {
+		background := metav1.DeletePropagationBackground
+		jobs.Delete(context.Background(), name, metav1.DeleteOptions{PropagationPolicy: &background})
+	}
+
+	pod, err := r.waitForStart(ctx, name)
+	if err != nil {
+		deleteJob()
+		return nil, err
+	}
+
+	logs, err := r.Client.CoreV1().Pods(r.Namespace).
+		GetLogs(pod.Name, &corev1.PodLogOptions{Container: kubeWorkerContainer, Follow: true}).
+		Stream(ctx)
+	if err != nil {
+		deleteJob()
+		return nil, fmt.Errorf("could not stream worker logs: %w", err)
+	}
+
+	// The worker waits for its payload on stdin, so it can only have exited
+	// already if it crashed on startup; its exit status tells why.
+	var control io.WriteCloser = closedStdin{errors.New("worker is not running")}
+	if pod.Status.Phase == corev1.PodRunning {
+		stdin, err := r.Attacher.AttachStdin(ctx, r.Namespace, pod.Name, kubeWorkerContainer)
+		if err == nil {
+			err = sendPayload(stdin, req)
+		}
+		if err != nil {
+			logs.Close()
+			deleteJob()
+			return nil, fmt.Errorf("could not attach to worker: %w", err)
+		}
+		control = stdin
+	}
+
+	// waitForExit returns as soon as ctx is cancelled, which is how a client
+	// disconnect reaches the pod: deleting the Job stops the worker.
+	exit := make(chan ExitStatus, 1)
+	go func() {
+		status := r.waitForExit(ctx, pod.Name)
+		if ctx.Err() != nil {
+			control.Close()
+			deleteJob()
+		}
+		exit <- status
+	}()
+
+	return newExecution(logs, control, exit), nil
+}
+
+func (r *KubernetesRunner) jobManifest(req JobRequest) (*batchv1.Job, error) {
+	labels := map[string]string{"app.kubernetes.io/name": "bbaas-worker"}
+	for key, value := range r.Labels {
+		labels[key] = value
+	}
+	labels[workerInstanceLabel] = InstanceID()
+	if req.JobID != "" {
+		labels[workerJobIDLabel] = req.JobID
+	}
+
+	var env []corev1.EnvVar
+	for _, variable := range workerEnv(payloadOnStdin) {
+		name, value, _ := strings.Cut(variable, "=")
+		env = append(env, corev1.EnvVar{Name: name, Value: value})
+	}
+
+	resources := corev1.ResourceRequirements{}
+	for _, q := range []struct {
+		list     *corev1.ResourceList
+		name     corev1.ResourceName
+		quantity string
+	}{
+		{&resources.Requests, corev1.ResourceCPU, r.CPURequest},
+		{&resources.Requests, corev1.ResourceMemory, r.MemoryRequest},
+		{&resources.Limits, corev1.ResourceCPU, r.CPULimit},
+		{&resources.Limits, corev1.ResourceMemory, r.MemoryLimit},
+	} {
+		if q.quantity == "" {
+			continue
+		}
+		quantity, err := resource.ParseQuantity(q.quantity)
+		if err != nil {
+			return nil, fmt.Errorf("invalid worker %s quantity %q: %w", q.name, q.quantity, err)
+		}
+		if *q.list == nil {
+			*q.list = corev1.ResourceList{}
+		}
+		(*q.list)[q.name] = quantity
+	}
+
+	backoffLimit := int32(0)
+	ttl := r.TTLSecondsAfterFinished
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: "bbaas-worker-",
+			Namespace:    r.Namespace,
+			Labels:       labels,
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit:            &backoffLimit,
+			TTLSecondsAfterFinished: &ttl,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: corev1.PodSpec{
+					RestartPolicy: corev1.RestartPolicyNever,
+					Containers: []corev1.Container{{
+						Name:  kubeWorkerContainer,
+						Image: workerImage(req),
+						Env:   env,
+						// Stdin carries the payload, then control messages
+						// (operator answers, approvals) for the worker.
+						Stdin:     true,
+						StdinOnce: true,
+						Resources: resources,
+					}},
+				},
+			},
+		},
+	}, nil
+}
+
+// waitForStart waits until the Job's pod has left Pending.
+func (r *KubernetesRunner) waitForStart(ctx context.Context, jobName string) (*corev1.Pod, error) {
+	timeout := r.StartTimeout
+	if timeout <= 0 {
+		timeout = 5 * time.Minute
+	}
+	deadline := time.Now().Add(timeout)
+
+	for {
+		pods, err := r.Client.CoreV1().Pods(r.Namespace).List(ctx, metav1.ListOptions{LabelSelector: kubeJobNameLabel + "=" + jobName})
+		if err != nil {
+			return nil, fmt.Errorf("could not read worker pod: %w", err)
+		}
+		for _, pod := range pods.Items {
+			if pod.Status.Phase != corev1.PodPending {
+				return &pod, nil
+			}
+			if waiting := workerStatus(&pod).State.Waiting; waiting != nil && slices.Contains(kubeFatalWaitReasons, waiting.Reason) {
+				return nil, fmt.Errorf("worker pod cannot start: %s: %s", waiting.Reason, waiting.Message)
+			}
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("worker pod did not start within %s", timeout)
+		}
+		if err := r.sleep(ctx); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// waitForExit waits until the worker container has terminated. A pod that
+// disappears, or fails without the worker reporting an exit (when it is
+// evicted, for example), ends the attempt too.
+func (r *KubernetesRunner) waitForExit(ctx context.Context, podName string) ExitStatus {
+	for {
+		pod, err := r.Client.CoreV1().Pods(r.Namespace).Get(ctx, podName, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return ExitStatus{Code: -1, Error: "worker pod was deleted"}
+		}
+		if err == nil {
+			if terminated := workerStatus(pod).State.Terminated; terminated != nil {
+				status := ExitStatus{
+					Code:      int64(terminated.ExitCode),
+					OOMKilled: terminated.Reason == "OOMKilled",
+				}
+				if terminated.Reason != "" && terminated.Reason != "Completed" && !status.OOMKilled {
+					status.Error = strings.TrimSpace(terminated.Reason + " " + terminated.Message)
+				}
+				return status
+			}
+			if pod.Status.Phase == corev1.PodFailed {
+				return ExitStatus{Code: -1, Error: strings.TrimSpace("worker pod failed: " + pod.Status.Reason + " " + pod.Status.Message)}
+			}
+		}
+		if err := r.sleep(ctx); err != nil {
+			return ExitStatus{Code: -1, Error: err.Error()}
+		}
+	}
+}
+
+func (r *KubernetesRunner) sleep(ctx context.Context) error {
+	interval := r.PollInterval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(interval):
+		return nil
+	}
+}
+
+// workerStatus is the status of the worker container, or an empty one before
+// the kubelet has reported it.
+func workerStatus(pod *corev1.Pod) corev1.ContainerStatus {
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.Name == kubeWorkerContainer {
+			return status
+		}
+	}
+	return corev1.ContainerStatus{}
+}
+
+// closedStdin stands in for a worker's stdin that could not be attached.
+type closedStdin struct {
+	err error
+}
+
+func (s closedStdin) Write(p []byte) (int, error) {
+	return 0, s.err
+}
+
+func (s closedStdin) Close() error {
+	return nil
+}
@@ -0,0 +1,379 @@
+package orchestrator
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// fakeAttacher records what is written to the workers' stdin.
+type fakeAttacher struct {
+	mu       sync.Mutex
+	stdin    strings.Builder
+	attached []string
+	closed   bool
+}
+
+func (a *fakeAttacher) AttachStdin(ctx context.Context, namespace, pod, container string) (io.WriteCloser, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.attached = append(a.attached, namespace+"/"+pod+"/"+container)
+	return a, nil
+}
+
+func (a *fakeAttacher) Write(p []byte) (int, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.stdin.Write(p)
+}
+
+func (a *fakeAttacher) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.closed = true
+	return nil
+}
+
+func (a *fakeAttacher) state() (string, []string, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.stdin.String(), append([]string(nil), a.attached...), a.closed
+}
+
+// newKubernetesTest returns a runner on a fake cluster. Like the Job
+// controller, the cluster gives every Job a pod, with the given status.
+func newKubernetesTest(t *testing.T, status corev1.PodStatus) (*fake.Clientset, *KubernetesRunner, *fakeAttacher) {
+	t.Helper()
+
+	client := fake.NewClientset()
+	created := 0
+	client.PrependReactor("create", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		job := action.(k8stesting.CreateAction).GetObject().(*batchv1.Job)
+		created++
+		if job.Name == "" {
+			job.Name = fmt.Sprintf("%s%05d", job.GenerateName, created)
+		}
+
+		labels := map[string]string{kubeJobNameLabel: job.Name}
+		for key, value := range job.Spec.Template.Labels {
+			labels[key] = value
+		}
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: job.Name + "-pod", Namespace: action.GetNamespace(), Labels: labels},
+			Spec:       job.Spec.Template.Spec,
+			Status:     status,
+		}
+		// The tracker still stores the Job itself
+		return false, nil, client.Tracker().Add(pod)
+	})
+
+	attacher := &fakeAttacher{}
+	runner := &KubernetesRunner{
+		Client:                  client,
+		Attacher:                attacher,
+		Namespace:               "workers",
+		CPURequest:              "500m",
+		MemoryRequest:           "512Mi",
+		MemoryLimit:             "512Mi",
+		TTLSecondsAfterFinished: 300,
+		StartTimeout:            time.Second,
+		PollInterval:            time.Millisecond,
+	}
+	return client, runner, attacher
+}
+
+var runningPod = corev1.PodStatus{Phase: corev1.PodRunning}
+
+// terminate ends a pod's worker container like the kubelet would.
+func terminate(t *testing.T, client *fake.Clientset, podName string, terminated corev1.ContainerStateTerminated) {
+	t.Helper()
+
+	pods := client.CoreV1().Pods("workers")
+	pod, err := pods.Get(context.Background(), podName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pod.Status.Phase = corev1.PodSucceeded
+	if terminated.ExitCode != 0 {
+		pod.Status.Phase = corev1.PodFailed
+	}
+	pod.Status.ContainerStatuses = []corev1.ContainerStatus{{
+		Name:  kubeWorkerContainer,
+		State: corev1.ContainerState{Terminated: &terminated},
+	}}
+	if _, err := pods.UpdateStatus(context.Background(), pod, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func listJobs(t *testing.T, client *fake.Clientset) []batchv1.Job {
+	t.Helper()
+
+	jobs, err := client.BatchV1().Jobs("workers").List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return jobs.Items
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestKubernetesRunnerCreatesWorkerJob(t *testing.T) {
+	client, runner, attacher := newKubernetesTest(t, runningPod)
+
+	run, err := runner.Run(context.Background(), JobRequest{JobID: "0123456789abcdef", Payload: `{"url":"https://example.com"}`})
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	defer run.Close()
+
+	jobs := listJobs(t, client)
+	if len(jobs) != 1 {
+		t.Fatalf("created %d Jobs, want 1", len(jobs))
+	}
+	job := jobs[0]
+	if job.Labels[workerJobIDLabel] != "0123456789abcdef" || job.Labels[workerInstanceLabel] == "" {
+		t.Errorf("Job labels = %v", job.Labels)
+	}
+	if job.Spec.BackoffLimit == nil || *job.Spec.BackoffLimit != 0 {
+		t.Errorf("backoffLimit = %v, want 0", job.Spec.BackoffLimit)
+	}
+	if job.Spec.TTLSecondsAfterFinished == nil || *job.Spec.TTLSecondsAfterFinished != 300 {
+		t.Errorf("ttlSecondsAfterFinished = %v, want 300", job.Spec.TTLSecondsAfterFinished)
+	}
+
+	pod := job.Spec.Template.Spec
+	if pod.RestartPolicy != corev1.RestartPolicyNever || len(pod.Containers) != 1 {
+		t.Fatalf("pod spec = %+v", pod)
+	}
+	worker := pod.Containers[0]
+	if !worker.Stdin || !worker.StdinOnce {
+		t.Errorf("worker stdin = %v, stdinOnce = %v, want both", worker.Stdin, worker.StdinOnce)
+	}
+	if len(worker.Env) == 0 || worker.Env[0] != (corev1.EnvVar{Name: "JOB_PAYLOAD_STDIN", Value: "1"}) {
+		t.Errorf("worker env = %v, want the payload on stdin", worker.Env)
+	}
+	if cpu := worker.Resources.Requests[corev1.ResourceCPU]; cpu.String() != "500m" {
+		t.Errorf("cpu request = %s, want 500m", cpu.String())
+	}
+	if memory := worker.Resources.Limits[corev1.ResourceMemory]; memory.String() != "512Mi" {
+		t.Errorf("memory limit = %s, want 512Mi", memory.String())
+	}
+
+	stdin, attached, _ := attacher.state()
+	if want := []string{"workers/" + job.Name + "-pod/worker"}; strings.Join(attached, ",") != strings.Join(want, ",") {
+		t.Errorf("attached to %v, want %v", attached, want)
+	}
+	if stdin != `{"url":"https://example.com"}`+"\n" {
+		t.Errorf("stdin = %q, want the payload line", stdin)
+	}
+}
+
+func TestKubernetesRunnerStreamsLogs(t *testing.T) {
+	client, runner, _ := newKubernetesTest(t, runningPod)
+
+	run, err := runner.Run(context.Background(), JobRequest{JobID: "0123456789abcdef"})
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	defer run.Close()
+
+	// The fake clientset serves every log as "fake logs"
+	logs, err := io.ReadAll(run.Logs)
+	if err != nil || string(logs) != "fake logs" {
+		t.Fatalf("logs = %q, %v", logs, err)
+	}
+
+	var options *corev1.PodLogOptions
+	for _, action := range client.Actions() {
+		if action.GetResource().Resource == "pods" && action.GetSubresource() == "log" {
+			options, _ = action.(k8stesting.GenericAction).GetValue().(*corev1.PodLogOptions)
+		}
+	}
+	if options == nil || !options.Follow || options.Container != kubeWorkerContainer {
+		t.Errorf("logs were read with %+v, want the worker's followed", options)
+	}
+}
+
+func TestKubernetesRunnerReportsExitStatus(t *testing.T) {
+	tests := []struct {
+		name       string
+		terminated corev1.ContainerStateTerminated
+		want       ExitStatus
+	}{
+		{
+			name:       "completed",
+			terminated: corev1.ContainerStateTerminated{ExitCode: 0, Reason: "Completed"},
+			want:       ExitStatus{},
+		},
+		{
+			name:       "crashed",
+			terminated: corev1.ContainerStateTerminated{ExitCode: 2, Reason: "Error", Message: "panic"},
+			want:       ExitStatus{Code: 2, Error: "Error panic"},
+		},
+		{
+			name:       "out of memory",
+			terminated: corev1.ContainerStateTerminated{ExitCode: 137, Reason: "OOMKilled"},
+			want:       ExitStatus{Code: 137, OOMKilled: true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, runner, _ := newKubernetesTest(t, runningPod)
+
+			run, err := runner.Run(context.Background(), JobRequest{JobID: "0123456789abcdef"})
+			if err != nil {
+				t.Fatalf("Run: %v", err)
+			}
+			defer run.Close()
+
+			terminate(t, client, listJobs(t, client)[0].Name+"-pod", tt.terminated)
+			if got := run.Wait(); got != tt.want {
+				t.Errorf("Wait() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKubernetesRunnerDeletesJobOnCancel(t *testing.T) {
+	client, runner, attacher := newKubernetesTest(t, runningPod)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	run, err := runner.Run(ctx, JobRequest{JobID: "0123456789abcdef"})
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	defer run.Close()
+	name := listJobs(t, client)[0].Name
+
+	cancel()
+	waitFor(t, "the Job to be deleted", func() bool { return len(listJobs(t, client)) == 0 })
+
+	var deleted *k8stesting.DeleteActionImpl
+	for _, action := range client.Actions() {
+		if action, ok := action.(k8stesting.DeleteActionImpl); ok && action.GetResource().Resource == "jobs" {
+			deleted = &action
+		}
+	}
+	if deleted == nil || deleted.GetName() != name {
+		t.Fatalf("Job %s was not deleted: %v", name, client.Actions())
+	}
+	if policy := deleted.DeleteOptions.PropagationPolicy; policy == nil || *policy != metav1.DeletePropagationBackground {
+		t.Errorf("deleted with propagation %v, want background so the pod goes too", policy)
+	}
+	if _, _, closed := attacher.state(); !closed {
+		t.Error("the worker's stdin was not closed")
+	}
+	if status := run.Wait(); status.Code != -1 || status.Error == "" {
+		t.Errorf("Wait() = %+v, want a cancelled status", status)
+	}
+}
+
+func TestKubernetesRunnerFailsPodsThatCannotStart(t *testing.T) {
+	client, runner, attacher := newKubernetesTest(t, corev1.PodStatus{
+		Phase: corev1.PodPending,
+		ContainerStatuses: []corev1.ContainerStatus{{
+			Name: kubeWorkerContainer,
+			State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{
+				Reason:  "ErrImagePull",
+				Message: "image not found",
+			}},
+		}},
+	})
+
+	_, err := runner.Run(context.Background(), JobRequest{JobID: "0123456789abcdef"})
+	if err == nil || !strings.Contains(err.Error(), "ErrImagePull") {
+		t.Fatalf("Run returned %v, want the image pull failure", err)
+	}
+	if jobs := listJobs(t, client); len(jobs) != 0 {
+		t.Errorf("the failed Job was left behind: %v", jobs)
+	}
+	if _, attached, _ := attacher.state(); len(attached) != 0 {
+		t.Errorf("attached to a pod that never started: %v", attached)
+	}
+}
+
+func TestKubernetesRunnerRejectsInvalidResources(t *testing.T) {
+	client, runner, _ := newKubernetesTest(t, runningPod)
+	runner.CPULimit = "two cores"
+
+	if _, err := runner.Run(context.Background(), JobRequest{JobID: "0123456789abcdef"}); err == nil {
+		t.Fatal("Run accepted an invalid CPU limit")
+	}
+	if jobs := listJobs(t, client); len(jobs) != 0 {
+		t.Errorf("created Jobs with an invalid CPU limit: %v", jobs)
+	}
+}
+
+func TestKubernetesRunnerFailsWhenPodIsGone(t *testing.T) {
+	tests := []struct {
+		name string
+		end  func(t *testing.T, client *fake.Clientset, podName string)
+		want string
+	}{
+		{
+			name: "deleted",
+			end: func(t *testing.T, client *fake.Clientset, podName string) {
+				if err := client.CoreV1().Pods("workers").Delete(context.Background(), podName, metav1.DeleteOptions{}); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: "worker pod was deleted",
+		},
+		{
+			name: "evicted",
+			end: func(t *testing.T, client *fake.Clientset, podName string) {
+				pods := client.CoreV1().Pods("workers")
+				pod, err := pods.Get(context.Background(), podName, metav1.GetOptions{})
+				if err != nil {
+					t.Fatal(err)
+				}
+				pod.Status = corev1.PodStatus{Phase: corev1.PodFailed, Reason: "Evicted", Message: "The node was low on resource: memory."}
+				if _, err := pods.UpdateStatus(context.Background(), pod, metav1.UpdateOptions{}); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: "worker pod failed: Evicted The node was low on resource: memory.",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, runner, _ := newKubernetesTest(t, runningPod)
+
+			run, err := runner.Run(context.Background(), JobRequest{JobID: "0123456789abcdef"})
+			if err != nil {
+				t.Fatalf("Run: %v", err)
+			}
+			defer run.Close()
+
+			tt.end(t, client, listJobs(t, client)[0].Name+"-pod")
+			if got := run.Wait(); got.Code != -1 || got.Error != tt.want {
+				t.Errorf("Wait() = %+v, want a failure with %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+)
+
+// LocalRunner runs the worker binary as a subprocess of the server, so the
+// whole stack runs without Docker. The worker uses whatever browsers
+// Playwright has installed on the machine.
+type LocalRunner struct {
+	// Command is the worker binary and any arguments before it.
+	Command []string
+	// Env is added to the server's own environment.
+	Env []string
+}
+
+// NewLocalRunnerFromEnv runs WORKER_BINARY, or "go run ./cmd/worker" from the
+// working directory when it is unset. Either way, cancelling a job kills the
+// worker's whole process group.
+func NewLocalRunnerFromEnv() *LocalRunner {
+	if binary := os.Getenv("WORKER_BINARY"); binary != "" {
+		return &LocalRunner{Command: []string{binary}}
+	}
+	return &LocalRunner{Command: []string{"go", "run", "./cmd/worker"}}
+}
+
+func (r *LocalRunner) Run(ctx context.Context, req JobRequest) (*Execution, error) {
+	if len(r.Command) == 0 {
+		return nil, errors.New("local runner has no worker command")
+	}
+
+	// The payload goes in a file only this user can read. The worker removes
+	// it once read; the directory goes when the worker exits.
+	dir, err := os.MkdirTemp("", "bbaas-job-")
+	if err != nil {
+		return nil, err
+	}
+	payloadFile := filepath.Join(dir, "payload.json")
+	if err := os.WriteFile(payloadFile, []byte(req.Payload), 0o600); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	cmd := exec.CommandContext(ctx, r.Command[0], r.Command[1:]...)
+	cmd.Env = append(append(os.Environ(), r.Env...), workerEnv("JOB_PAYLOAD_FILE="+payloadFile)...)
+	// Browsers started by the worker can hold its output open after it exits
+	cmd.WaitDelay = 5 * time.Second
+	killProcessGroup(cmd)
+
+	logs, output := io.Pipe()
+	cmd.Stdout = output
+	cmd.Stderr = output
+
+	control, err := cmd.StdinPipe()
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	exit := make(chan ExitStatus, 1)
+	go func() {
+		err := cmd.Wait()
+		output.Close()
+		os.RemoveAll(dir)
+
+		status := ExitStatus{Code: int64(cmd.ProcessState.ExitCode())}
+		var exitErr *exec.ExitError
+		if err != nil && !errors.As(err, &exitErr) {
+			status.Error = err.Error()
+		}
+		exit <- status
+	}()
+
+	return newExecution(logs, control, exit), nil
+}
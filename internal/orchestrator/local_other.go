@@ -0,0 +1,9 @@
+//go:build !unix
+
+package orchestrator
+
+import "os/exec"
+
+// killProcessGroup leaves cancelling to kill only the worker process where
+// there are no process groups.
+func killProcessGroup(cmd *exec.Cmd) {}
@@ -0,0 +1,18 @@
+//go:build unix
+
+package orchestrator
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// killProcessGroup runs the worker in a process group of its own and has
+// cancelling kill the whole group: "go run" and the binary it built, and the
+// browsers the worker started, not just the process the runner started.
+func killProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
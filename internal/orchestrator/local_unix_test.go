@@ -0,0 +1,60 @@
+//go:build unix
+
+package orchestrator
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// running reports whether a process exists and has not exited; an exited
+// child of a process that was killed may linger as a zombie.
+func running(pid int) bool {
+	stat, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return false
+	}
+	fields := strings.Fields(string(stat[strings.LastIndexByte(string(stat), ')')+1:]))
+	return len(fields) > 0 && fields[0] != "Z"
+}
+
+func TestLocalRunnerCancelKillsChildren(t *testing.T) {
+	if _, err := os.Stat("/proc/self/stat"); err != nil {
+		t.Skip("needs /proc")
+	}
+
+	// The shell stands in for "go run", the sleep for the worker it starts
+	runner := &LocalRunner{Command: []string{"sh", "-c", "sleep 60 & echo $!; wait"}}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	run, err := runner.Run(ctx, JobRequest{JobID: "0123456789abcdef", Payload: "{}"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer run.Close()
+
+	line, err := bufio.NewReader(run.Logs).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	child, err := strconv.Atoi(strings.TrimSpace(line))
+	if err != nil {
+		t.Fatalf("read %q, want the child's pid", line)
+	}
+
+	cancel()
+	run.Wait()
+	deadline := time.Now().Add(5 * time.Second)
+	for running(child) {
+		if time.Now().After(deadline) {
+			t.Fatal("the worker's child outlived the cancelled job")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,227 @@
+package orchestrator
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"brian-nunez/bcode/internal/store"
+	"golang.org/x/net/publicsuffix"
+)
+
+const (
+	// RotationRoundRobin hands each job the next proxy in the pool.
+	RotationRoundRobin = "round_robin"
+	// RotationSticky keeps every job for the same site on the same proxy
+	// until it fails.
+	RotationSticky = "sticky"
+)
+
+var ErrUnknownProxyPool = errors.New("unknown proxy pool")
+
+// Proxy is an upstream HTTP or SOCKS5 proxy. Username and Password may be
+// {{secret:name}} placeholders; they are resolved inside the worker.
+type Proxy struct {
+	Server   string `json:"server"`
+	Bypass   string `json:"bypass,omitempty"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+// ProxyPool is a named set of proxies jobs are spread across.
+type ProxyPool struct {
+	Name     string  `json:"name"`
+	Rotation string  `json:"rotation,omitempty"`
+	Proxies  []Proxy `json:"proxies"`
+
+	mu     sync.Mutex
+	next   int
+	sticky map[string]int
+}
+
+type ProxyPools struct {
+	pools map[string]*ProxyPool
+}
+
+var (
+	defaultPools     *ProxyPools
+	defaultPoolsErr  error
+	defaultPoolsOnce sync.Once
+)
+
+// DefaultProxyPools returns the pools configured in PROXY_POOLS_CONFIG, or
+// proxies.json in the data directory. A missing file means no pools.
+func DefaultProxyPools() (*ProxyPools, error) {
+	defaultPoolsOnce.Do(func() {
+		path := os.Getenv("PROXY_POOLS_CONFIG")
+		if path == "" {
+			path = filepath.Join(store.DataDir(), "proxies.json")
+		}
+		defaultPools, defaultPoolsErr = LoadProxyPools(path)
+	})
+	return defaultPools, defaultPoolsErr
+}
+
+func LoadProxyPools(path string) (*ProxyPools, error) {
+	pools := &ProxyPools{pools: map[string]*ProxyPool{}}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return pools, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var config struct {
+		Pools []*ProxyPool `json:"pools"`
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("invalid proxy pool config %s: %w", path, err)
+	}
+
+	for _, pool := range config.Pools {
+		if pool.Name == "" {
+			return nil, errors.New("proxy pools need a name")
+		}
+		if _, ok := pools.pools[pool.Name]; ok {
+			return nil, fmt.Errorf("duplicate proxy pool %q", pool.Name)
+		}
+		if len(pool.Proxies) == 0 {
+			return nil, fmt.Errorf("proxy pool %q has no proxies", pool.Name)
+		}
+		switch pool.Rotation {
+		case "":
+			pool.Rotation = RotationRoundRobin
+		case RotationRoundRobin, RotationSticky:
+		default:
+			return nil, fmt.Errorf("proxy pool %q: unknown rotation %q", pool.Name, pool.Rotation)
+		}
+		for _, proxy := range pool.Proxies {
+			if err := ValidateProxy(proxy); err != nil {
+				return nil, fmt.Errorf("proxy pool %q: %w", pool.Name, err)
+			}
+		}
+		pool.sticky = map[string]int{}
+		pools.pools[pool.Name] = pool
+	}
+
+	return pools, nil
+}
+
+// ValidateProxy accepts http, https and socks5 proxy servers.
+func ValidateProxy(proxy Proxy) error {
+	u, err := url.Parse(proxy.Server)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("invalid proxy server %q", proxy.Server)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+		return nil
+	default:
+		return fmt.Errorf("unsupported proxy scheme %q", u.Scheme)
+	}
+}
+
+func (p *ProxyPools) Names() []string {
+	names := make([]string, 0, len(p.pools))
+	for name := range p.pools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (p *ProxyPools) Get(name string) (*ProxyPool, error) {
+	pool, ok := p.pools[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownProxyPool, name)
+	}
+	return pool, nil
+}
+
+// Candidates returns every proxy in the pool in the order a job for
+// targetURL should try them: the one picked by the pool's rotation first,
+// then the rest as fallbacks.
+func (p *ProxyPool) Candidates(targetURL string) []Proxy {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var start int
+	site := siteOf(targetURL)
+	if index, ok := p.sticky[site]; ok && p.Rotation == RotationSticky {
+		start = index
+	} else {
+		start = p.next
+		p.next = (p.next + 1) % len(p.Proxies)
+		if p.Rotation == RotationSticky {
+			p.sticky[site] = start
+		}
+	}
+
+	candidates := make([]Proxy, 0, len(p.Proxies))
+	for i := range p.Proxies {
+		candidates = append(candidates, p.Proxies[(start+i)%len(p.Proxies)])
+	}
+	return candidates
+}
+
+// Succeeded pins targetURL's site to server in sticky pools, so a site moves
+// to the fallback proxy that worked after its assigned one failed.
+func (p *ProxyPool) Succeeded(targetURL, server string) {
+	if p.Rotation != RotationSticky {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for i, proxy := range p.Proxies {
+		if proxy.Server == server {
+			p.sticky[siteOf(targetURL)] = i
+			return
+		}
+	}
+}
+
+// siteOf reduces a URL to its registrable domain (example.co.uk for
+// shop.example.co.uk) so subdomains share a sticky proxy.
+func siteOf(targetURL string) string {
+	u, err := url.Parse(targetURL)
+	if err != nil || u.Hostname() == "" {
+		return targetURL
+	}
+	host := strings.ToLower(u.Hostname())
+	if site, err := publicsuffix.EffectiveTLDPlusOne(host); err == nil {
+		return site
+	}
+	return host
+}
+
+// proxyErrors match the navigation errors Chromium, Firefox and WebKit
+// report when the proxy itself, rather than the site, is at fault.
+var proxyErrors = regexp.MustCompile(`ERR_(PROXY|TUNNEL|SOCKS)_|ERR_NO_SUPPORTED_PROXIES|NS_ERROR_(UNKNOWN_)?PROXY|(?i)proxy (connection|authentication|server)`)
+
+// IsProxyError reports whether a job error was caused by its proxy, in
+// which case the job is worth retrying on another one.
+func IsProxyError(message string) bool {
+	return proxyErrors.MatchString(message)
+}
+
+// Redacted is the proxy server without any credentials embedded in the URL,
+// safe to record on the job.
+func (p Proxy) Redacted() string {
+	u, err := url.Parse(p.Server)
+	if err != nil {
+		return ""
+	}
+	u.User = nil
+	return u.String()
+}
@@ -0,0 +1,249 @@
+package orchestrator
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"brian-nunez/bcode/internal/jobs"
+	"brian-nunez/bcode/internal/ratelimit"
+	"brian-nunez/bcode/internal/workspaces"
+)
+
+// Ticket is a job waiting in the queue for a runner.
+type Ticket struct {
+	JobID     string
+	Workspace string
+	URL       string
+}
+
+// Limit decides when queued jobs may start. The queue calls it under its own
+// lock: Check must not change anything, Start counts a job Check let
+// through, and Finish is called once that job is done.
+type Limit interface {
+	// Check returns an error to refuse the job, or false to keep it waiting
+	// until another job finishes or, if retry is positive, that long.
+	Check(t *Ticket) (ok bool, retry time.Duration, err error)
+	Start(t *Ticket)
+	Finish(t *Ticket, usage jobs.Usage)
+}
+
+// Queue holds jobs until every limit lets them start. Jobs are considered in
+// the order they arrived, but a job held back by its own workspace's limits
+// does not hold up jobs of other workspaces.
+type Queue struct {
+	limits []Limit
+
+	mu      sync.Mutex
+	waiting []*queued
+	// timer checks again on jobs held back by a rate
+	timer *time.Timer
+}
+
+type queued struct {
+	ticket *Ticket
+	// started receives nil once the job may start, or why it was refused
+	started chan error
+}
+
+func NewQueue(limits ...Limit) *Queue {
+	return &Queue{limits: limits}
+}
+
+var (
+	defaultQueue     *Queue
+	defaultQueueErr  error
+	defaultQueueOnce sync.Once
+)
+
+// DefaultQueue enforces the quotas of the server's workspaces and the
+// configured site limits.
+func DefaultQueue() (*Queue, error) {
+	defaultQueueOnce.Do(func() {
+		store, err := workspaces.Default()
+		if err != nil {
+			defaultQueueErr = err
+			return
+		}
+		sites, err := ratelimit.DefaultSites()
+		if err != nil {
+			defaultQueueErr = err
+			return
+		}
+		defaultQueue = NewQueue(WorkspaceLimit{Store: store}, SiteLimit{Sites: sites})
+	})
+	return defaultQueue, defaultQueueErr
+}
+
+// Acquire waits until the job may start and returns the function to call
+// with its usage once it is done. onWait, if set, is called when the job
+// cannot start straight away.
+func (q *Queue) Acquire(ctx context.Context, t *Ticket, onWait func()) (func(jobs.Usage), error) {
+	entry := &queued{ticket: t, started: make(chan error, 1)}
+
+	q.mu.Lock()
+	q.waiting = append(q.waiting, entry)
+	q.dispatch()
+	q.mu.Unlock()
+
+	var err error
+	select {
+	case err = <-entry.started:
+	default:
+		if onWait != nil {
+			onWait()
+		}
+		select {
+		case err = <-entry.started:
+		case <-ctx.Done():
+			q.mu.Lock()
+			waiting := q.remove(entry)
+			q.mu.Unlock()
+			// The job may have been let through just as it was cancelled
+			if !waiting {
+				if <-entry.started == nil {
+					q.release(t, jobs.Usage{})
+				}
+			}
+			return nil, ctx.Err()
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var once sync.Once
+	return func(usage jobs.Usage) {
+		once.Do(func() { q.release(t, usage) })
+	}, nil
+}
+
+// Waiting is how many jobs are queued.
+func (q *Queue) Waiting() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.waiting)
+}
+
+func (q *Queue) release(t *Ticket, usage jobs.Usage) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, limit := range q.limits {
+		limit.Finish(t, usage)
+	}
+	q.dispatch()
+}
+
+// dispatch starts or refuses every queued job the limits have decided on;
+// callers hold q.mu.
+func (q *Queue) dispatch() {
+	var next time.Duration
+	remaining := q.waiting[:0]
+	for _, entry := range q.waiting {
+		ok, retry, err := q.check(entry.ticket)
+		switch {
+		case err != nil:
+			entry.started <- err
+		case ok:
+			for _, limit := range q.limits {
+				limit.Start(entry.ticket)
+			}
+			entry.started <- nil
+		default:
+			remaining = append(remaining, entry)
+			if retry > 0 && (next == 0 || retry < next) {
+				next = retry
+			}
+		}
+	}
+	clear(q.waiting[len(remaining):])
+	q.waiting = remaining
+
+	if q.timer != nil {
+		q.timer.Stop()
+		q.timer = nil
+	}
+	if next > 0 {
+		q.timer = time.AfterFunc(next, func() {
+			q.mu.Lock()
+			defer q.mu.Unlock()
+			q.dispatch()
+		})
+	}
+}
+
+// check refuses a job if any limit does, and lets it through only if all do.
+// retry is the soonest a limit holding the job back asked to be asked again.
+func (q *Queue) check(t *Ticket) (bool, time.Duration, error) {
+	admitted := true
+	var next time.Duration
+	for _, limit := range q.limits {
+		ok, retry, err := limit.Check(t)
+		if err != nil {
+			return false, 0, err
+		}
+		if !ok {
+			admitted = false
+			if retry > 0 && (next == 0 || retry < next) {
+				next = retry
+			}
+		}
+	}
+	return admitted, next, nil
+}
+
+func (q *Queue) remove(entry *queued) bool {
+	for i, e := range q.waiting {
+		if e == entry {
+			q.waiting = append(q.waiting[:i], q.waiting[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// WorkspaceLimit enforces workspace quotas and charges finished jobs to them.
+type WorkspaceLimit struct {
+	Store *workspaces.Store
+}
+
+func (l WorkspaceLimit) Check(t *Ticket) (bool, time.Duration, error) {
+	ok, err := l.Store.Check(t.Workspace)
+	return ok, 0, err
+}
+
+func (l WorkspaceLimit) Start(t *Ticket) {
+	l.Store.Start(t.Workspace)
+}
+
+func (l WorkspaceLimit) Finish(t *Ticket, usage jobs.Usage) {
+	browserTime := time.Duration(usage.BrowserSeconds * float64(time.Second))
+	l.Store.Finish(t.Workspace, browserTime, usage.LLMTokens)
+}
+
+// SiteLimit spaces out and caps the jobs run against each site, so that no
+// third-party site gets hammered.
+type SiteLimit struct {
+	Sites *ratelimit.Sites
+}
+
+func (l SiteLimit) Check(t *Ticket) (bool, time.Duration, error) {
+	if t.URL == "" {
+		return true, 0, nil
+	}
+	ok, retry := l.Sites.Check(siteOf(t.URL))
+	return ok, retry, nil
+}
+
+func (l SiteLimit) Start(t *Ticket) {
+	if t.URL != "" {
+		l.Sites.Start(siteOf(t.URL))
+	}
+}
+
+func (l SiteLimit) Finish(t *Ticket, usage jobs.Usage) {
+	if t.URL != "" {
+		l.Sites.Finish(siteOf(t.URL))
+	}
+}
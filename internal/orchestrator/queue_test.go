@@ -0,0 +1,143 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"brian-nunez/bcode/internal/jobs"
+	"brian-nunez/bcode/internal/workspaces"
+)
+
+func newTestQueue(t *testing.T, quota workspaces.Quota) (*Queue, *workspaces.Store) {
+	t.Helper()
+
+	dir := t.TempDir()
+	store, err := workspaces.NewStore(filepath.Join(dir, "workspaces.json"), filepath.Join(dir, "usage.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Put("acme", quota); err != nil {
+		t.Fatal(err)
+	}
+	return NewQueue(WorkspaceLimit{Store: store}), store
+}
+
+// acquire starts Acquire in the background and reports when it returns.
+func acquire(ctx context.Context, q *Queue, t *Ticket) (waiting <-chan struct{}, done <-chan error, release *func(jobs.Usage)) {
+	waited := make(chan struct{})
+	result := make(chan error, 1)
+	release = new(func(jobs.Usage))
+	go func() {
+		r, err := q.Acquire(ctx, t, func() { close(waited) })
+		*release = r
+		result <- err
+	}()
+	return waited, result, release
+}
+
+func TestQueueRefusesExhaustedQuota(t *testing.T) {
+	q, store := newTestQueue(t, workspaces.Quota{JobsPerDay: 1})
+
+	release, err := q.Acquire(context.Background(), &Ticket{JobID: "1", Workspace: "acme"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release(jobs.Usage{BrowserSeconds: 90, LLMTokens: 10})
+	// Releasing twice charges the job once
+	release(jobs.Usage{BrowserSeconds: 90, LLMTokens: 10})
+	if usage := store.Usage("acme"); usage.Running != 0 || usage.BrowserMinutes != 1.5 || usage.LLMTokens != 10 {
+		t.Errorf("Usage = %+v, want one job charged", usage)
+	}
+
+	waited := false
+	_, err = q.Acquire(context.Background(), &Ticket{JobID: "2", Workspace: "acme"}, func() { waited = true })
+	var quotaErr *workspaces.QuotaError
+	if !errors.As(err, &quotaErr) || quotaErr.Quota != "jobs_per_day" {
+		t.Fatalf("Acquire returned %v, want the daily quota refused", err)
+	}
+	if waited || q.Waiting() != 0 {
+		t.Errorf("a refused job was queued")
+	}
+}
+
+func TestQueueHoldsJobsUntilOthersFinish(t *testing.T) {
+	q, store := newTestQueue(t, workspaces.Quota{MaxConcurrentJobs: 1})
+
+	first, err := q.Acquire(context.Background(), &Ticket{JobID: "1", Workspace: "acme"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waiting, done, release := acquire(context.Background(), q, &Ticket{JobID: "2", Workspace: "acme"})
+	<-waiting
+
+	// Jobs of other workspaces go ahead of it
+	other, err := q.Acquire(context.Background(), &Ticket{JobID: "3", Workspace: workspaces.DefaultWorkspace}, func() {
+		t.Error("a job of another workspace waited")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other(jobs.Usage{})
+
+	select {
+	case err := <-done:
+		t.Fatalf("the second job started while the first ran: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if q.Waiting() != 1 {
+		t.Errorf("Waiting() = %d, want 1", q.Waiting())
+	}
+
+	first(jobs.Usage{})
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the second job did not start once the first finished")
+	}
+	if usage := store.Usage("acme"); usage.Running != 1 || usage.JobsToday != 2 {
+		t.Errorf("Usage = %+v, want the second job running", usage)
+	}
+	(*release)(jobs.Usage{})
+	if usage := store.Usage("acme"); usage.Running != 0 {
+		t.Errorf("Usage = %+v, want nothing running", usage)
+	}
+}
+
+func TestQueueCancelWhileWaiting(t *testing.T) {
+	q, store := newTestQueue(t, workspaces.Quota{MaxConcurrentJobs: 1})
+
+	first, err := q.Acquire(context.Background(), &Ticket{JobID: "1", Workspace: "acme"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	waiting, done, _ := acquire(ctx, q, &Ticket{JobID: "2", Workspace: "acme"})
+	<-waiting
+
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("Acquire returned %v, want context.Canceled", err)
+	}
+	if q.Waiting() != 0 {
+		t.Errorf("Waiting() = %d after the job was cancelled, want 0", q.Waiting())
+	}
+
+	// The cancelled job neither ran nor holds a slot
+	first(jobs.Usage{})
+	if usage := store.Usage("acme"); usage.Running != 0 || usage.JobsToday != 1 {
+		t.Errorf("Usage = %+v, want only the first job counted", usage)
+	}
+	release, err := q.Acquire(context.Background(), &Ticket{JobID: "3", Workspace: "acme"}, func() {
+		t.Error("a job waited with the workspace idle")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	release(jobs.Usage{})
+}
@@ -0,0 +1,229 @@
+package orchestrator
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"brian-nunez/bcode/internal/jobs"
+	"brian-nunez/bcode/internal/store"
+)
+
+// Error classes a retry policy can treat as transient.
+const (
+	ErrorClassNetwork          = "network"
+	ErrorClassTimeout          = "timeout"
+	ErrorClassProvider5xx      = "provider_5xx"
+	ErrorClassProvider4xx      = "provider_4xx"
+	ErrorClassSelectorNotFound = "selector_not_found"
+)
+
+var ErrorClasses = []string{
+	ErrorClassNetwork,
+	ErrorClassTimeout,
+	ErrorClassProvider5xx,
+	ErrorClassProvider4xx,
+	ErrorClassSelectorNotFound,
+}
+
+// RetryPolicy decides whether a failed job is run again and how long to wait
+// first. Attempt n waits BackoffSeconds * Multiplier^(n-2), capped at
+// MaxBackoffSeconds.
+type RetryPolicy struct {
+	MaxAttempts       int      `json:"max_attempts"`
+	BackoffSeconds    float64  `json:"backoff_seconds,omitempty"`
+	MaxBackoffSeconds float64  `json:"max_backoff_seconds,omitempty"`
+	Multiplier        float64  `json:"multiplier,omitempty"`
+	RetryOn           []string `json:"retry_on,omitempty"`
+}
+
+// RetryPolicies holds the policy for each action, falling back to Default.
+type RetryPolicies struct {
+	Default RetryPolicy            `json:"default"`
+	Actions map[string]RetryPolicy `json:"actions,omitempty"`
+}
+
+// BuiltinRetryPolicies retry transient failures of read-only actions three
+// times. AI actions get a single retry since the agent may already have
+// changed something on the site before failing.
+func BuiltinRetryPolicies() RetryPolicies {
+	transient := []string{ErrorClassNetwork, ErrorClassTimeout, ErrorClassProvider5xx}
+	return RetryPolicies{
+		Default: RetryPolicy{
+			MaxAttempts:       3,
+			BackoffSeconds:    2,
+			MaxBackoffSeconds: 30,
+			Multiplier:        2,
+			RetryOn:           transient,
+		},
+		Actions: map[string]RetryPolicy{
+			"ai_action": {
+				MaxAttempts:       2,
+				BackoffSeconds:    5,
+				MaxBackoffSeconds: 30,
+				Multiplier:        2,
+				RetryOn:           transient,
+			},
+		},
+	}
+}
+
+var (
+	defaultRetry     RetryPolicies
+	defaultRetryErr  error
+	defaultRetryOnce sync.Once
+)
+
+// DefaultRetryPolicies returns the policies in RETRY_CONFIG, or retry.json in
+// the data directory, on top of the builtin ones.
+func DefaultRetryPolicies() (RetryPolicies, error) {
+	defaultRetryOnce.Do(func() {
+		path := os.Getenv("RETRY_CONFIG")
+		if path == "" {
+			path = filepath.Join(store.DataDir(), "retry.json")
+		}
+		defaultRetry, defaultRetryErr = LoadRetryPolicies(path)
+	})
+	return defaultRetry, defaultRetryErr
+}
+
+// LoadRetryPolicies reads a policy file. The file's default replaces the
+// builtin default when given; its actions replace the builtin ones by name.
+func LoadRetryPolicies(path string) (RetryPolicies, error) {
+	policies := BuiltinRetryPolicies()
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return policies, nil
+	}
+	if err != nil {
+		return RetryPolicies{}, err
+	}
+
+	var config RetryPolicies
+	if err := json.Unmarshal(data, &config); err != nil {
+		return RetryPolicies{}, fmt.Errorf("invalid retry config %s: %w", path, err)
+	}
+
+	if config.Default.MaxAttempts != 0 {
+		if err := config.Default.validate(); err != nil {
+			return RetryPolicies{}, fmt.Errorf("default retry policy: %w", err)
+		}
+		policies.Default = config.Default
+	}
+	for action, policy := range config.Actions {
+		if err := policy.validate(); err != nil {
+			return RetryPolicies{}, fmt.Errorf("retry policy for %s: %w", action, err)
+		}
+		policies.Actions[action] = policy
+	}
+
+	return policies, nil
+}
+
+func (p RetryPolicy) validate() error {
+	if p.MaxAttempts < 1 {
+		return fmt.Errorf("max_attempts must be at least 1")
+	}
+	if p.BackoffSeconds < 0 || p.MaxBackoffSeconds < 0 || p.Multiplier < 0 {
+		return fmt.Errorf("backoff settings cannot be negative")
+	}
+	for _, class := range p.RetryOn {
+		if !slices.Contains(ErrorClasses, class) {
+			return fmt.Errorf("unknown error class %q", class)
+		}
+	}
+	return nil
+}
+
+func (p RetryPolicies) For(action string) RetryPolicy {
+	if policy, ok := p.Actions[action]; ok {
+		return policy
+	}
+	return p.Default
+}
+
+func (p RetryPolicy) Retryable(class string) bool {
+	return class != "" && slices.Contains(p.RetryOn, class)
+}
+
+// Backoff is the wait after the given (1-based) failed attempt.
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier == 0 {
+		multiplier = 2
+	}
+	seconds := p.BackoffSeconds * math.Pow(multiplier, float64(attempt-1))
+	if p.MaxBackoffSeconds > 0 && seconds > p.MaxBackoffSeconds {
+		seconds = p.MaxBackoffSeconds
+	}
+	return time.Duration(seconds * float64(time.Second))
+}
+
+var (
+	// Playwright appends the call log to timeouts, so a timeout waiting for
+	// an element is reported as a missing selector, not a timeout.
+	selectorErrors    = regexp.MustCompile(`(?i)waiting for (locator|selector)|no (element|node) (found|matches)|strict mode violation`)
+	timeoutErrors     = regexp.MustCompile(`(?i)timeout \d+ms exceeded|deadline exceeded|timed out|ERR_TIMED_OUT|NS_ERROR_NET_TIMEOUT`)
+	providerErrors    = regexp.MustCompile(`(?i)ollama returned status (\d)\d\d`)
+	networkErrors     = regexp.MustCompile(`(?i)net::ERR_|NS_ERROR_|could not contact ollama|ollama error|connection (refused|reset)|no such host`)
+	errorClassMatches = []struct {
+		pattern *regexp.Regexp
+		class   string
+	}{
+		{selectorErrors, ErrorClassSelectorNotFound},
+		{timeoutErrors, ErrorClassTimeout},
+		{networkErrors, ErrorClassNetwork},
+	}
+)
+
+// ClassifyJobError maps a job error to an error class, by its code where
+// that is specific enough and by its message otherwise.
+func ClassifyJobError(err *jobs.JobError) string {
+	switch err.Code {
+	case jobs.ErrNavigationFailed:
+		if class := ClassifyError(err.Message); class != "" {
+			return class
+		}
+		return ErrorClassNetwork
+	case jobs.ErrTimeout:
+		return ErrorClassTimeout
+	case jobs.ErrSelectorNotFound:
+		return ErrorClassSelectorNotFound
+	case jobs.ErrLLMUnavailable:
+		switch status := err.Details["status"]; {
+		case strings.HasPrefix(status, "5"):
+			return ErrorClassProvider5xx
+		case strings.HasPrefix(status, "4"):
+			return ErrorClassProvider4xx
+		}
+		return ErrorClassNetwork
+	}
+	return ClassifyError(err.Message)
+}
+
+// ClassifyError maps a worker's error message to an error class, or "" when
+// it matches none of them.
+func ClassifyError(message string) string {
+	if match := providerErrors.FindStringSubmatch(message); match != nil {
+		switch match[1] {
+		case "5":
+			return ErrorClassProvider5xx
+		case "4":
+			return ErrorClassProvider4xx
+		}
+	}
+	for _, m := range errorClassMatches {
+		if m.pattern.MatchString(message) {
+			return m.class
+		}
+	}
+	return ""
+}
@@ -0,0 +1,263 @@
+package orchestrator
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Runner starts a worker for a job. Whatever it runs on, the worker's
+// combined stdout/stderr comes back as plain text lines on Execution.Logs
+// and Execution.Control writes to its stdin.
+type Runner interface {
+	Run(ctx context.Context, req JobRequest) (*Execution, error)
+}
+
+type JobRequest struct {
+	// JobID is the job the worker runs for. Several workers can run for one
+	// job when it is retried.
+	JobID string
+	// Payload is the job as a single line of JSON. It never goes into the
+	// worker's environment, where `docker inspect` would show it: runners
+	// send it on stdin ahead of control messages or in a file the worker
+	// removes once read.
+	Payload string
+	// Browser selects the worker image: WORKER_IMAGE_<BROWSER> (for example
+	// WORKER_IMAGE_FIREFOX) when set, otherwise WORKER_IMAGE.
+	Browser string
+}
+
+// Execution is a running worker.
+type Execution struct {
+	Logs    io.ReadCloser
+	Control io.WriteCloser
+
+	exit     <-chan ExitStatus
+	exitOnce sync.Once
+	status   ExitStatus
+	// release frees what the worker ran in once its output has been read.
+	release func()
+}
+
+func newExecution(logs io.ReadCloser, control io.WriteCloser, exit <-chan ExitStatus) *Execution {
+	return &Execution{
+		Logs:    logs,
+		Control: control,
+		exit:    exit,
+	}
+}
+
+// Close stops relaying the worker and releases it. Callers that need its
+// exit status Wait first.
+func (e *Execution) Close() error {
+	e.Control.Close()
+	err := e.Logs.Close()
+	if e.release != nil {
+		e.release()
+	}
+	return err
+}
+
+// ExitStatus is how a worker ended.
+type ExitStatus struct {
+	Code      int64
+	OOMKilled bool
+	// Error is set when the runner reports one, or when the exit status
+	// could not be read at all.
+	Error string
+}
+
+func (s ExitStatus) String() string {
+	switch {
+	case s.OOMKilled:
+		return fmt.Sprintf("killed after running out of memory (exit code %d)", s.Code)
+	case s.Error != "":
+		return fmt.Sprintf("exit code %d: %s", s.Code, s.Error)
+	default:
+		return fmt.Sprintf("exit code %d", s.Code)
+	}
+}
+
+// Wait blocks until the worker has exited and returns its status.
+func (e *Execution) Wait() ExitStatus {
+	e.exitOnce.Do(func() {
+		e.status = <-e.exit
+	})
+	return e.status
+}
+
+var (
+	defaultRunner     Runner
+	defaultRunnerErr  error
+	defaultRunnerOnce sync.Once
+	defaultRunnerMu   sync.Mutex
+)
+
+// Default returns the runner selected by WORKER_RUNNER: docker (the
+// default), kubernetes, local or fake.
+func Default() (Runner, error) {
+	defaultRunnerMu.Lock()
+	defer defaultRunnerMu.Unlock()
+
+	defaultRunnerOnce.Do(func() {
+		if defaultRunner != nil {
+			return
+		}
+		switch name := os.Getenv("WORKER_RUNNER"); name {
+		case "", "docker":
+			defaultRunner, defaultRunnerErr = newDockerDefault()
+		case "kubernetes":
+			defaultRunner, defaultRunnerErr = newKubernetesDefault()
+		case "local":
+			defaultRunner = NewLocalRunnerFromEnv()
+		case "fake":
+			defaultRunner = &FakeRunner{}
+		default:
+			defaultRunnerErr = fmt.Errorf("unknown WORKER_RUNNER %q", name)
+		}
+	})
+	return defaultRunner, defaultRunnerErr
+}
+
+// SetDefault replaces the runner returned by Default, for tests and for
+// embedding the server with a custom backend.
+func SetDefault(runner Runner) {
+	defaultRunnerMu.Lock()
+	defer defaultRunnerMu.Unlock()
+
+	defaultRunnerOnce.Do(func() {})
+	defaultRunner, defaultRunnerErr = runner, nil
+}
+
+// Labels on worker containers and Kubernetes Jobs
+const (
+	workerJobIDLabel    = "bbaas.io/job-id"
+	workerInstanceLabel = "bbaas.io/instance"
+)
+
+// InstanceID names this server among those sharing a Docker host or cluster:
+// SERVER_INSTANCE_ID when set, otherwise the host name. It should survive a
+// restart so the restarted server recognizes its own leftover workers.
+func InstanceID() string {
+	if id := os.Getenv("SERVER_INSTANCE_ID"); id != "" {
+		return id
+	}
+	if host, err := os.Hostname(); err == nil && host != "" {
+		return host
+	}
+	return "bbaas"
+}
+
+// Orphan is a worker that was still running for a job the server no longer
+// tracks.
+type Orphan struct {
+	ID      string
+	JobID   string
+	State   string
+	Created time.Time
+}
+
+// Reconciler is a Runner that can find and stop its orphaned workers.
+type Reconciler interface {
+	Reconcile(ctx context.Context) ([]Orphan, error)
+}
+
+// ReconcileInterval is how often orphaned workers are looked for, from
+// WORKER_RECONCILE_INTERVAL (a Go duration such as 10m). Zero only
+// reconciles at startup.
+func ReconcileInterval() time.Duration {
+	if value := os.Getenv("WORKER_RECONCILE_INTERVAL"); value != "" {
+		if d, err := time.ParseDuration(value); err == nil {
+			return d
+		}
+		log.Printf("invalid WORKER_RECONCILE_INTERVAL %q, using %s", value, defaultReconcileInterval)
+	}
+	return defaultReconcileInterval
+}
+
+const defaultReconcileInterval = 5 * time.Minute
+
+// RunReconciliation removes orphaned workers right away and then every
+// interval until ctx is done, logging each one it removes.
+func RunReconciliation(ctx context.Context, r Reconciler, interval time.Duration) {
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		orphans, err := r.Reconcile(ctx)
+		for _, orphan := range orphans {
+			log.Printf("worker reconciliation: removed %s worker %.12s of job %q started %s",
+				orphan.State, orphan.ID, orphan.JobID, orphan.Created.Format(time.RFC3339))
+		}
+		if err != nil {
+			log.Printf("worker reconciliation: %v", err)
+		} else if len(orphans) > 0 {
+			log.Printf("worker reconciliation: removed %d orphaned workers", len(orphans))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick:
+		}
+	}
+}
+
+// workerEnv is the environment every worker gets, plus how it receives its
+// payload.
+func workerEnv(payloadEnv string) []string {
+	env := []string{payloadEnv}
+
+	if ollamaModel := os.Getenv("OLLAMA_MODEL"); ollamaModel != "" {
+		env = append(env, "OLLAMA_MODEL="+ollamaModel)
+	}
+	if ollamaEndpoint := os.Getenv("OLLAMA_ENDPOINT"); ollamaEndpoint != "" {
+		env = append(env, "OLLAMA_ENDPOINT="+ollamaEndpoint)
+	}
+	return env
+}
+
+// payloadOnStdin tells the worker to read its payload from stdin, where
+// sendPayload writes it.
+const payloadOnStdin = "JOB_PAYLOAD_STDIN=1"
+
+func sendPayload(stdin io.Writer, req JobRequest) error {
+	if _, err := io.WriteString(stdin, req.Payload+"\n"); err != nil {
+		return fmt.Errorf("could not send payload to worker: %w", err)
+	}
+	return nil
+}
+
+// workerImage is WORKER_IMAGE_<BROWSER> when set for the request's browser,
+// otherwise WORKER_IMAGE.
+func workerImage(req JobRequest) string {
+	image := os.Getenv("WORKER_IMAGE")
+	if image == "" {
+		image = "bbaas-worker:latest"
+	}
+	if req.Browser != "" {
+		if browserImage := os.Getenv("WORKER_IMAGE_" + strings.ToUpper(req.Browser)); browserImage != "" {
+			image = browserImage
+		}
+	}
+	return image
+}
+
+// workerMemoryMB is the WORKER_MEMORY_MB limit, or 0 for none.
+func workerMemoryMB() int64 {
+	limit, err := strconv.ParseInt(os.Getenv("WORKER_MEMORY_MB"), 10, 64)
+	if err != nil || limit < 0 {
+		return 0
+	}
+	return limit
+}
@@ -0,0 +1,80 @@
+// Package outbound sends the requests the server makes to URLs its users
+// choose, such as monitor notifications and webhooks, which must not reach
+// the server's own network.
+package outbound
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"syscall"
+	"time"
+)
+
+// ErrForbiddenAddress is returned for connections to addresses that are not
+// publicly routable.
+var ErrForbiddenAddress = errors.New("the address is not publicly routable")
+
+// Ranges IsPrivate and friends do not cover.
+var forbiddenPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	// Carrier-grade NAT, also used by some cloud metadata services
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+}
+
+// AllowPrivate reports whether OUTBOUND_ALLOW_PRIVATE lets requests reach
+// private and loopback addresses, for receivers on the same host or network
+// during development.
+func AllowPrivate() bool {
+	value := os.Getenv("OUTBOUND_ALLOW_PRIVATE")
+	return value == "true" || value == "1"
+}
+
+// Allowed reports whether an address is publicly routable.
+func Allowed(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return false
+	}
+	for _, prefix := range forbiddenPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// Client returns an HTTP client that only connects to publicly routable
+// addresses, unless AllowPrivate. Addresses are checked as they are dialed,
+// after resolution, so neither redirects nor DNS answers can lead it
+// elsewhere. It ignores the proxy environment variables, which would have
+// the proxy resolve names instead.
+func Client(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	if !AllowPrivate() {
+		dialer.Control = control
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
+
+func control(network, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	if !Allowed(addrPort.Addr()) {
+		return fmt.Errorf("%w: %s", ErrForbiddenAddress, addrPort.Addr())
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package outbound
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestAllowed(t *testing.T) {
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{"93.184.215.14", true},
+		{"2606:2800:21f:cb07:6820:80da:af6b:8b2c", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"100.100.100.200", false},
+		{"0.0.0.0", false},
+		{"255.255.255.255", false},
+		{"224.0.0.1", false},
+		{"fc00::1", false},
+		{"fe80::1", false},
+		{"::ffff:127.0.0.1", false},
+		{"::ffff:169.254.169.254", false},
+	}
+
+	for _, tt := range tests {
+		if got := Allowed(netip.MustParseAddr(tt.addr)); got != tt.want {
+			t.Errorf("Allowed(%s) = %v, want %v", tt.addr, got, tt.want)
+		}
+	}
+}
+
+func TestClientRefusesPrivateAddresses(t *testing.T) {
+	requested := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = true
+	}))
+	defer server.Close()
+
+	_, err := Client(time.Second).Get(server.URL)
+	if !errors.Is(err, ErrForbiddenAddress) {
+		t.Errorf("request to %s returned %v, want ErrForbiddenAddress", server.URL, err)
+	}
+	if requested {
+		t.Error("the request reached the server")
+	}
+}
+
+func TestClientAllowsPrivateAddressesWhenConfigured(t *testing.T) {
+	t.Setenv("OUTBOUND_ALLOW_PRIVATE", "true")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	res, err := Client(time.Second).Get(server.URL)
+	if err != nil {
+		t.Fatalf("request with OUTBOUND_ALLOW_PRIVATE: %v", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusNoContent {
+		t.Errorf("status %d, want 204", res.StatusCode)
+	}
+}
@@ -0,0 +1,228 @@
+package profiles
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"brian-nunez/bcode/internal/store"
+	"brian-nunez/bcode/internal/workspaces"
+)
+
+const fileSuffix = ".state.enc"
+
+var (
+	ErrInvalidName = errors.New("profile names may only contain letters, digits, '-' and '_'")
+	ErrNotFound    = errors.New("profile not found")
+	ErrLocked      = errors.New("profile is in use by another job")
+)
+
+var validName = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// Profile describes a stored browser profile. The storage state itself
+// (cookies, localStorage and IndexedDB) is only ever handed to a worker.
+type Profile struct {
+	Name      string    `json:"name"`
+	Size      int64     `json:"size"`
+	UpdatedAt time.Time `json:"updated_at"`
+	LockedBy  string    `json:"locked_by,omitempty"`
+}
+
+// Store keeps Playwright storage states encrypted on disk, one file per
+// profile, and hands out per-profile locks so that only one job at a time
+// can write a profile back.
+type Store struct {
+	dir string
+	key []byte
+
+	mu    sync.Mutex
+	locks map[string]string
+}
+
+var (
+	defaultStore *Store
+	defaultErr   error
+	defaultOnce  sync.Once
+
+	workspaceStores   = map[string]*Store{}
+	workspaceStoresMu sync.Mutex
+)
+
+// Default returns the default workspace's store under the server's data
+// directory.
+func Default() (*Store, error) {
+	defaultOnce.Do(func() {
+		key, err := store.MasterKey()
+		if err != nil {
+			defaultErr = fmt.Errorf("could not load master key: %w", err)
+			return
+		}
+		defaultStore, defaultErr = NewStore(filepath.Join(store.DataDir(), "profiles"), key)
+	})
+	return defaultStore, defaultErr
+}
+
+// ForWorkspace returns the store holding a workspace's profiles. Workspaces
+// other than the default one keep theirs under workspaces/<name>.
+func ForWorkspace(workspace string) (*Store, error) {
+	if workspace == workspaces.DefaultWorkspace {
+		return Default()
+	}
+	if err := workspaces.ValidateName(workspace); err != nil {
+		return nil, err
+	}
+
+	workspaceStoresMu.Lock()
+	defer workspaceStoresMu.Unlock()
+
+	if s, ok := workspaceStores[workspace]; ok {
+		return s, nil
+	}
+	key, err := store.MasterKey()
+	if err != nil {
+		return nil, fmt.Errorf("could not load master key: %w", err)
+	}
+	s, err := NewStore(filepath.Join(store.DataDir(), "workspaces", workspace, "profiles"), key)
+	if err != nil {
+		return nil, err
+	}
+	workspaceStores[workspace] = s
+	return s, nil
+}
+
+func NewStore(dir string, key []byte) (*Store, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &Store{
+		dir:   dir,
+		key:   key,
+		locks: make(map[string]string),
+	}, nil
+}
+
+func ValidateName(name string) error {
+	if !validName.MatchString(name) {
+		return ErrInvalidName
+	}
+	return nil
+}
+
+func (s *Store) path(name string) string {
+	return filepath.Join(s.dir, name+fileSuffix)
+}
+
+func (s *Store) List() ([]Profile, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := []Profile{}
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), fileSuffix)
+		if !ok || entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		list = append(list, Profile{
+			Name:      name,
+			Size:      info.Size(),
+			UpdatedAt: info.ModTime(),
+			LockedBy:  s.locks[name],
+		})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+
+	return list, nil
+}
+
+// Load returns the decrypted storage state, or nil if the profile has never
+// been saved.
+func (s *Store) Load(name string) ([]byte, error) {
+	if err := ValidateName(name); err != nil {
+		return nil, err
+	}
+
+	sealed, err := os.ReadFile(s.path(name))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return store.Open(s.key, sealed)
+}
+
+func (s *Store) Save(name string, state []byte) error {
+	if err := ValidateName(name); err != nil {
+		return err
+	}
+
+	sealed, err := store.Seal(s.key, state)
+	if err != nil {
+		return err
+	}
+
+	return store.WriteFileAtomic(s.path(name), sealed)
+}
+
+func (s *Store) Delete(name string) error {
+	if err := ValidateName(name); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, locked := s.locks[name]; locked {
+		return ErrLocked
+	}
+
+	err := os.Remove(s.path(name))
+	if os.IsNotExist(err) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// Lock reserves a profile for a job that will save back to it.
+func (s *Store) Lock(name, jobID string) error {
+	if err := ValidateName(name); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if owner, locked := s.locks[name]; locked && owner != jobID {
+		return ErrLocked
+	}
+	s.locks[name] = jobID
+
+	return nil
+}
+
+func (s *Store) Unlock(name, jobID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.locks[name] == jobID {
+		delete(s.locks, name)
+	}
+}
@@ -0,0 +1,59 @@
+package ratelimit
+
+import (
+	"math"
+	"time"
+)
+
+type bucket struct {
+	rate   Rate
+	tokens float64
+	last   time.Time
+}
+
+// newBucket starts full.
+func newBucket(rate Rate, now time.Time) *bucket {
+	return &bucket{
+		rate:   rate,
+		tokens: float64(rate.burst()),
+		last:   now,
+	}
+}
+
+func (r Rate) burst() int {
+	return max(r.Burst, 1)
+}
+
+func (b *bucket) refill(now time.Time) {
+	if b.rate.PerMinute == 0 {
+		return
+	}
+	elapsed := now.Sub(b.last).Minutes()
+	b.tokens = math.Min(float64(b.rate.burst()), b.tokens+elapsed*b.rate.PerMinute)
+	b.last = now
+}
+
+// wait is how long until a token is available, zero if one is now.
+func (b *bucket) wait(now time.Time) time.Duration {
+	if b.rate.PerMinute == 0 {
+		return 0
+	}
+	b.refill(now)
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.rate.PerMinute * float64(time.Minute))
+}
+
+func (b *bucket) take(now time.Time) {
+	if b.rate.PerMinute == 0 {
+		return
+	}
+	b.refill(now)
+	b.tokens--
+}
+
+func (b *bucket) full(now time.Time) bool {
+	b.refill(now)
+	return b.tokens >= float64(b.rate.burst())
+}
@@ -0,0 +1,40 @@
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"brian-nunez/bcode/internal/auth"
+	"brian-nunez/bcode/internal/handlers/errors"
+	"github.com/labstack/echo/v4"
+)
+
+// LimitSubmissions guards routes that submit jobs. It goes after
+// auth.RequireScope, and answers callers that are out of tokens with 429 and
+// a Retry-After header.
+func LimitSubmissions() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			limiter, err := DefaultSubmissions()
+			if err != nil {
+				response := errors.InternalServerError().Build()
+				return c.JSON(response.HTTPStatusCode, response)
+			}
+
+			principal, _ := auth.PrincipalFrom(c)
+			if ok, wait := limiter.Allow(principal); !ok {
+				seconds := strconv.Itoa(int(math.Ceil(wait.Seconds())))
+				c.Response().Header().Set("Retry-After", seconds)
+				response := errors.RateLimited().
+					WithMessage(fmt.Sprintf("Too many submissions, try again in %s", wait.Round(time.Second))).
+					WithDetails(map[string]string{"retry_after_seconds": seconds}).
+					Build()
+				return c.JSON(response.HTTPStatusCode, response)
+			}
+
+			return next(c)
+		}
+	}
+}
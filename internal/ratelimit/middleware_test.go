@@ -0,0 +1,85 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"brian-nunez/bcode/internal/auth"
+	"github.com/labstack/echo/v4"
+)
+
+func TestLimitSubmissions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ratelimits.json")
+	if err := os.WriteFile(path, []byte(`{"submissions": {"per_minute": 2, "burst": 1}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("RATE_LIMITS_CONFIG", path)
+
+	limiter, err := DefaultSubmissions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The limiter is shared by every run of the test
+	c := newClock()
+	limiter.mu.Lock()
+	limiter.buckets = map[string]*bucket{}
+	limiter.now = c.Now
+	limiter.mu.Unlock()
+
+	submit := func(principal auth.Principal) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/jobs", nil)
+		rec := httptest.NewRecorder()
+		ctx := echo.New().NewContext(req, rec)
+		auth.SetPrincipal(ctx, principal)
+
+		err := LimitSubmissions()(func(c echo.Context) error {
+			return c.NoContent(http.StatusAccepted)
+		})(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rec
+	}
+
+	alice := auth.Principal{Kind: "user", ID: "alice", Name: "alice"}
+	if rec := submit(alice); rec.Code != http.StatusAccepted {
+		t.Fatalf("the first submission answered %d: %s", rec.Code, rec.Body)
+	}
+
+	c.advance(10 * time.Second)
+	rec := submit(alice)
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("a submission past the limit answered %d, want 429", rec.Code)
+	}
+	// 20 of the 30 seconds to the next token are left
+	if got := rec.Header().Get("Retry-After"); got != "20" {
+		t.Errorf("Retry-After = %q, want 20", got)
+	}
+	var body struct {
+		Error struct {
+			ErrorCode string            `json:"error_code"`
+			Details   map[string]string `json:"details"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Error.ErrorCode != "RATE_LIMITED" || body.Error.Details["retry_after_seconds"] != "20" {
+		t.Errorf("body = %s", rec.Body)
+	}
+
+	// Other callers have their own bucket
+	if rec := submit(auth.Principal{Kind: "key", ID: "ci", Name: "ci"}); rec.Code != http.StatusAccepted {
+		t.Errorf("another caller's submission answered %d", rec.Code)
+	}
+
+	c.advance(20 * time.Second)
+	if rec := submit(alice); rec.Code != http.StatusAccepted {
+		t.Errorf("a submission after Retry-After answered %d", rec.Code)
+	}
+}
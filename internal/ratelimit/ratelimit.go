@@ -0,0 +1,352 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"brian-nunez/bcode/internal/auth"
+	"brian-nunez/bcode/internal/store"
+)
+
+// Rate is a token bucket that refills PerMinute tokens a minute and holds at
+// most Burst (at least one). A zero PerMinute means unlimited.
+type Rate struct {
+	PerMinute float64 `json:"per_minute"`
+	Burst     int     `json:"burst,omitempty"`
+}
+
+// SiteRate limits the jobs run against one site: how often they may start and
+// how many may run at once. A zero MaxConcurrent means unlimited.
+type SiteRate struct {
+	Rate
+	MaxConcurrent int `json:"max_concurrent,omitempty"`
+}
+
+// Config holds the rate limits. Keys and SiteLimits override the defaults
+// for API key IDs and registrable domains (example.co.uk covers its
+// subdomains).
+type Config struct {
+	Submissions Rate                `json:"submissions"`
+	Keys        map[string]Rate     `json:"keys,omitempty"`
+	Sites       SiteRate            `json:"sites"`
+	SiteLimits  map[string]SiteRate `json:"site_limits,omitempty"`
+}
+
+// BuiltinConfig lets each caller submit a job a second on average, and runs
+// at most four jobs at a time, thirty a minute, against any one site.
+func BuiltinConfig() Config {
+	return Config{
+		Submissions: Rate{PerMinute: 60, Burst: 20},
+		Sites:       SiteRate{Rate: Rate{PerMinute: 30, Burst: 10}, MaxConcurrent: 4},
+	}
+}
+
+var (
+	defaultConfig     Config
+	defaultConfigErr  error
+	defaultConfigOnce sync.Once
+)
+
+// DefaultConfig returns the limits in RATE_LIMITS_CONFIG, or ratelimits.json
+// in the data directory, on top of the builtin ones.
+func DefaultConfig() (Config, error) {
+	defaultConfigOnce.Do(func() {
+		path := os.Getenv("RATE_LIMITS_CONFIG")
+		if path == "" {
+			path = filepath.Join(store.DataDir(), "ratelimits.json")
+		}
+		defaultConfig, defaultConfigErr = LoadConfig(path)
+	})
+	return defaultConfig, defaultConfigErr
+}
+
+// LoadConfig reads a limits file. Fields it sets replace the builtin ones.
+func LoadConfig(path string) (Config, error) {
+	config := BuiltinConfig()
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return config, nil
+	}
+	if err != nil {
+		return Config{}, err
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return Config{}, fmt.Errorf("invalid rate limit config %s: %w", path, err)
+	}
+
+	if err := config.Submissions.validate(); err != nil {
+		return Config{}, fmt.Errorf("submission rate: %w", err)
+	}
+	for key, rate := range config.Keys {
+		if err := rate.validate(); err != nil {
+			return Config{}, fmt.Errorf("rate for key %s: %w", key, err)
+		}
+	}
+	if err := config.Sites.validate(); err != nil {
+		return Config{}, fmt.Errorf("site rate: %w", err)
+	}
+	for site, rate := range config.SiteLimits {
+		if err := rate.validate(); err != nil {
+			return Config{}, fmt.Errorf("rate for site %s: %w", site, err)
+		}
+	}
+
+	return config, nil
+}
+
+func (r Rate) validate() error {
+	if r.PerMinute < 0 || r.Burst < 0 {
+		return errors.New("rates cannot be negative")
+	}
+	return nil
+}
+
+func (r SiteRate) validate() error {
+	if r.MaxConcurrent < 0 {
+		return errors.New("max_concurrent cannot be negative")
+	}
+	return r.Rate.validate()
+}
+
+// Buckets that have been idle long enough to refill are forgotten once this
+// many are tracked.
+const maxTracked = 1024
+
+// BucketState is a caller's submission bucket.
+type BucketState struct {
+	Subject   string  `json:"subject"`
+	Tokens    float64 `json:"tokens"`
+	PerMinute float64 `json:"per_minute"`
+	Burst     int     `json:"burst"`
+}
+
+// Submissions rate limits job submission per caller: per API key, per
+// signed-in user.
+type Submissions struct {
+	config Config
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	now     func() time.Time
+}
+
+func NewSubmissions(config Config) *Submissions {
+	return &Submissions{
+		config:  config,
+		buckets: map[string]*bucket{},
+		now:     time.Now,
+	}
+}
+
+var (
+	defaultSubmissions     *Submissions
+	defaultSubmissionsErr  error
+	defaultSubmissionsOnce sync.Once
+)
+
+// DefaultSubmissions limits submissions with the DefaultConfig.
+func DefaultSubmissions() (*Submissions, error) {
+	defaultSubmissionsOnce.Do(func() {
+		config, err := DefaultConfig()
+		if err != nil {
+			defaultSubmissionsErr = err
+			return
+		}
+		defaultSubmissions = NewSubmissions(config)
+	})
+	return defaultSubmissions, defaultSubmissionsErr
+}
+
+func (s *Submissions) rateFor(principal auth.Principal) Rate {
+	if rate, ok := s.config.Keys[principal.ID]; ok && principal.Kind == "key" {
+		return rate
+	}
+	return s.config.Submissions
+}
+
+// Allow takes a token for a submission, or returns how long until the caller
+// has one.
+func (s *Submissions) Allow(principal auth.Principal) (bool, time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	subject := principal.Subject()
+	b, ok := s.buckets[subject]
+	if !ok {
+		if len(s.buckets) >= maxTracked {
+			pruneBuckets(s.buckets, now)
+		}
+		b = newBucket(s.rateFor(principal), now)
+		s.buckets[subject] = b
+	}
+
+	if wait := b.wait(now); wait > 0 {
+		return false, wait
+	}
+	b.take(now)
+	return true, 0
+}
+
+func (s *Submissions) State() []BucketState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	list := make([]BucketState, 0, len(s.buckets))
+	for subject, b := range s.buckets {
+		b.refill(now)
+		list = append(list, BucketState{Subject: subject, Tokens: b.tokens, PerMinute: b.rate.PerMinute, Burst: b.rate.burst()})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Subject < list[j].Subject
+	})
+	return list
+}
+
+func pruneBuckets(buckets map[string]*bucket, now time.Time) {
+	for key, b := range buckets {
+		if b.full(now) {
+			delete(buckets, key)
+		}
+	}
+}
+
+// SiteState is how busy one site is.
+type SiteState struct {
+	Site          string  `json:"site"`
+	Running       int     `json:"running"`
+	MaxConcurrent int     `json:"max_concurrent"`
+	Tokens        float64 `json:"tokens"`
+	PerMinute     float64 `json:"per_minute"`
+	Burst         int     `json:"burst"`
+}
+
+type site struct {
+	bucket  *bucket
+	limit   SiteRate
+	running int
+}
+
+// Sites limits how often jobs start, and how many run at once, against each
+// site.
+type Sites struct {
+	config Config
+
+	mu    sync.Mutex
+	sites map[string]*site
+	now   func() time.Time
+}
+
+func NewSites(config Config) *Sites {
+	return &Sites{
+		config: config,
+		sites:  map[string]*site{},
+		now:    time.Now,
+	}
+}
+
+var (
+	defaultSites     *Sites
+	defaultSitesErr  error
+	defaultSitesOnce sync.Once
+)
+
+// DefaultSites limits sites with the DefaultConfig.
+func DefaultSites() (*Sites, error) {
+	defaultSitesOnce.Do(func() {
+		config, err := DefaultConfig()
+		if err != nil {
+			defaultSitesErr = err
+			return
+		}
+		defaultSites = NewSites(config)
+	})
+	return defaultSites, defaultSitesErr
+}
+
+func (s *Sites) get(name string, now time.Time) *site {
+	state, ok := s.sites[name]
+	if !ok {
+		if len(s.sites) >= maxTracked {
+			for key, state := range s.sites {
+				if state.running == 0 && state.bucket.full(now) {
+					delete(s.sites, key)
+				}
+			}
+		}
+		limit, ok := s.config.SiteLimits[name]
+		if !ok {
+			limit = s.config.Sites
+		}
+		state = &site{bucket: newBucket(limit.Rate, now), limit: limit}
+		s.sites[name] = state
+	}
+	return state
+}
+
+// Check reports whether a job against the site may start now and, if it may
+// not only for its rate, how long until it may.
+func (s *Sites) Check(name string) (bool, time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	state := s.get(name, now)
+	if state.limit.MaxConcurrent > 0 && state.running >= state.limit.MaxConcurrent {
+		return false, 0
+	}
+	if wait := state.bucket.wait(now); wait > 0 {
+		return false, wait
+	}
+	return true, 0
+}
+
+func (s *Sites) Start(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	state := s.get(name, now)
+	state.bucket.take(now)
+	state.running++
+}
+
+func (s *Sites) Finish(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if state, ok := s.sites[name]; ok && state.running > 0 {
+		state.running--
+	}
+}
+
+func (s *Sites) State() []SiteState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	list := make([]SiteState, 0, len(s.sites))
+	for name, state := range s.sites {
+		state.bucket.refill(now)
+		list = append(list, SiteState{
+			Site:          name,
+			Running:       state.running,
+			MaxConcurrent: state.limit.MaxConcurrent,
+			Tokens:        state.bucket.tokens,
+			PerMinute:     state.limit.PerMinute,
+			Burst:         state.limit.burst(),
+		})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Site < list[j].Site
+	})
+	return list
+}
@@ -0,0 +1,231 @@
+package ratelimit
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"brian-nunez/bcode/internal/auth"
+)
+
+// clock is a time the tests move forward by hand.
+type clock struct {
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	return c.now
+}
+
+func (c *clock) advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+// about compares waits that float arithmetic may be off by a nanosecond.
+func about(got, want time.Duration) bool {
+	return (got - want).Abs() < time.Millisecond
+}
+
+func newClock() *clock {
+	return &clock{now: time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)}
+}
+
+func TestBucket(t *testing.T) {
+	c := newClock()
+	b := newBucket(Rate{PerMinute: 6, Burst: 2}, c.now)
+
+	// A bucket starts full
+	for range 2 {
+		if wait := b.wait(c.now); wait != 0 {
+			t.Fatalf("wait = %s with tokens left", wait)
+		}
+		b.take(c.now)
+	}
+	if wait := b.wait(c.now); wait != 10*time.Second {
+		t.Errorf("wait on an empty bucket = %s, want 10s", wait)
+	}
+
+	c.advance(4 * time.Second)
+	if wait := b.wait(c.now); !about(wait, 6*time.Second) {
+		t.Errorf("wait after 4s = %s, want 6s", wait)
+	}
+	c.advance(6 * time.Second)
+	if wait := b.wait(c.now); wait != 0 {
+		t.Errorf("wait after 10s = %s, want a token", wait)
+	}
+
+	// Refilling stops at the burst
+	c.advance(time.Hour)
+	if !b.full(c.now) || b.tokens != 2 {
+		t.Errorf("after an hour the bucket holds %v tokens, want 2", b.tokens)
+	}
+}
+
+func TestBucketBurstsAtLeastOne(t *testing.T) {
+	c := newClock()
+	b := newBucket(Rate{PerMinute: 60}, c.now)
+
+	b.take(c.now)
+	if wait := b.wait(c.now); wait != time.Second {
+		t.Errorf("wait = %s, want 1s", wait)
+	}
+}
+
+func TestUnlimitedBucket(t *testing.T) {
+	c := newClock()
+	b := newBucket(Rate{}, c.now)
+
+	for range 100 {
+		if wait := b.wait(c.now); wait != 0 {
+			t.Fatalf("an unlimited bucket made a caller wait %s", wait)
+		}
+		b.take(c.now)
+	}
+}
+
+func TestSubmissionsAllow(t *testing.T) {
+	c := newClock()
+	s := NewSubmissions(Config{
+		Submissions: Rate{PerMinute: 60, Burst: 1},
+		Keys:        map[string]Rate{"ci": {PerMinute: 60, Burst: 3}},
+	})
+	s.now = c.Now
+
+	alice := auth.Principal{Kind: "user", ID: "alice", Name: "alice"}
+	bob := auth.Principal{Kind: "user", ID: "bob", Name: "bob"}
+	ci := auth.Principal{Kind: "key", ID: "ci", Name: "ci"}
+	// A user named like a key does not get its rate
+	impostor := auth.Principal{Kind: "user", ID: "ci", Name: "ci"}
+
+	tests := []struct {
+		principal auth.Principal
+		allowed   int
+	}{
+		{alice, 1},
+		{bob, 1},
+		{ci, 3},
+		{impostor, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.principal.Subject(), func(t *testing.T) {
+			for i := range tt.allowed {
+				if ok, wait := s.Allow(tt.principal); !ok {
+					t.Fatalf("submission %d refused, wait %s", i+1, wait)
+				}
+			}
+			ok, wait := s.Allow(tt.principal)
+			if ok || wait != time.Second {
+				t.Errorf("Allow past the burst = %v, %s, want false, 1s", ok, wait)
+			}
+		})
+	}
+
+	c.advance(time.Second)
+	if ok, _ := s.Allow(alice); !ok {
+		t.Error("Allow refused a caller whose bucket refilled")
+	}
+
+	state := s.State()
+	if len(state) != 4 || state[0].Subject != "key:ci" || state[0].Burst != 3 {
+		t.Errorf("State = %+v, want the four callers by subject", state)
+	}
+}
+
+func TestSites(t *testing.T) {
+	c := newClock()
+	s := NewSites(Config{
+		Sites: SiteRate{Rate: Rate{PerMinute: 60, Burst: 2}, MaxConcurrent: 1},
+		SiteLimits: map[string]SiteRate{
+			"example.com": {Rate: Rate{PerMinute: 30, Burst: 1}},
+		},
+	})
+	s.now = c.Now
+
+	// The default limit runs one job at a time
+	if ok, retry := s.Check("shop.test"); !ok || retry != 0 {
+		t.Fatalf("Check = %v, %s, want the first job through", ok, retry)
+	}
+	s.Start("shop.test")
+	if ok, retry := s.Check("shop.test"); ok || retry != 0 {
+		t.Errorf("Check while a job runs = %v, %s, want false without a retry", ok, retry)
+	}
+	s.Finish("shop.test")
+	if ok, _ := s.Check("shop.test"); !ok {
+		t.Error("Check refused a job once the running one finished")
+	}
+
+	// A site limit replaces the default: no concurrency cap, a slower rate
+	s.Start("example.com")
+	ok, retry := s.Check("example.com")
+	if ok || retry != 2*time.Second {
+		t.Errorf("Check past the burst = %v, %s, want false, 2s", ok, retry)
+	}
+	c.advance(retry)
+	if ok, _ := s.Check("example.com"); !ok {
+		t.Error("Check refused a job after the retry")
+	}
+	s.Start("example.com")
+
+	// Finishing more jobs than started does not go negative
+	s.Finish("example.com")
+	s.Finish("example.com")
+	s.Finish("missing.test")
+
+	state := s.State()
+	if len(state) != 2 || state[0].Site != "example.com" || state[0].Running != 0 || state[0].MaxConcurrent != 0 {
+		t.Errorf("State = %+v", state)
+	}
+	if state[1].Site != "shop.test" || state[1].MaxConcurrent != 1 || state[1].Burst != 2 {
+		t.Errorf("State = %+v", state)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+
+	tests := []struct {
+		name    string
+		data    string
+		check   func(Config) bool
+		wantErr bool
+	}{
+		{
+			name:  "missing file",
+			check: func(c Config) bool { return c.Submissions == BuiltinConfig().Submissions },
+		},
+		{
+			name: "overrides",
+			data: `{"submissions": {"per_minute": 5}, "site_limits": {"example.com": {"per_minute": 1, "max_concurrent": 2}}}`,
+			check: func(c Config) bool {
+				return c.Submissions.PerMinute == 5 && c.Submissions.Burst == BuiltinConfig().Submissions.Burst &&
+					c.Sites == BuiltinConfig().Sites && c.SiteLimits["example.com"].MaxConcurrent == 2
+			},
+		},
+		{name: "negative rate", data: `{"keys": {"ci": {"per_minute": -1}}}`, wantErr: true},
+		{name: "negative concurrency", data: `{"sites": {"max_concurrent": -1}}`, wantErr: true},
+		{name: "invalid JSON", data: `{`, wantErr: true},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, "missing.json")
+			if tt.data != "" {
+				path = filepath.Join(dir, strconv.Itoa(i)+".json")
+				if err := os.WriteFile(path, []byte(tt.data), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			config, err := LoadConfig(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadConfig returned %v", err)
+			}
+			if tt.check != nil && !tt.check(config) {
+				t.Errorf("LoadConfig = %+v", config)
+			}
+		})
+	}
+}
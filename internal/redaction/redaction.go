@@ -0,0 +1,140 @@
+package redaction
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"brian-nunez/bcode/internal/store"
+)
+
+const Replacement = "[REDACTED]"
+
+// DefaultPatterns catch common credentials that show up in page text and logs.
+var DefaultPatterns = []string{
+	`(?i)bearer\s+[A-Za-z0-9._~+/-]+=*`,
+	`AKIA[0-9A-Z]{16}`,
+	`eyJ[A-Za-z0-9_-]{8,}\.[A-Za-z0-9_-]{8,}\.[A-Za-z0-9_-]+`,
+	`(?i)\b(password|passwd|pwd|secret|token|api[_-]?key)\s*[:=]\s*\S+`,
+}
+
+// Policy controls what is scrubbed from a job's logs, agent history, results
+// and screenshots. It is sent to the worker as part of the job payload so
+// that nothing leaves the container unredacted.
+type Policy struct {
+	// Patterns are regular expressions whose matches are replaced.
+	Patterns []string `json:"patterns,omitempty"`
+	// InputTypes lists input types (e.g. password) whose values are never
+	// shown to the model or written to the logs.
+	InputTypes []string `json:"input_types,omitempty"`
+	// MaskPasswords blacks out password fields in screenshots.
+	MaskPasswords bool `json:"mask_passwords,omitempty"`
+	// MaskSelectors are additional elements blacked out in screenshots.
+	MaskSelectors []string `json:"mask_selectors,omitempty"`
+}
+
+func DefaultPolicy() Policy {
+	return Policy{
+		Patterns:      append([]string(nil), DefaultPatterns...),
+		InputTypes:    []string{"password"},
+		MaskPasswords: true,
+	}
+}
+
+var (
+	defaultPolicy Policy
+	defaultErr    error
+	defaultOnce   sync.Once
+)
+
+// Default returns the server-wide policy. It is read from REDACTION_CONFIG,
+// or redaction.json in the data directory, and falls back to DefaultPolicy.
+func Default() (Policy, error) {
+	defaultOnce.Do(func() {
+		defaultPolicy, defaultErr = Load(configPath())
+	})
+	return defaultPolicy, defaultErr
+}
+
+func configPath() string {
+	if path := os.Getenv("REDACTION_CONFIG"); path != "" {
+		return path
+	}
+	return filepath.Join(store.DataDir(), "redaction.json")
+}
+
+// Load reads a policy file. A missing file yields the default policy.
+func Load(path string) (Policy, error) {
+	policy := DefaultPolicy()
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return policy, nil
+	}
+	if err != nil {
+		return Policy{}, err
+	}
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return Policy{}, fmt.Errorf("invalid redaction config %s: %w", path, err)
+	}
+	if err := policy.Validate(); err != nil {
+		return Policy{}, err
+	}
+
+	return policy, nil
+}
+
+func (p Policy) Validate() error {
+	for _, pattern := range p.Patterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("invalid redaction pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// WithSelectors returns a copy of the policy that also masks the given
+// selectors in screenshots.
+func (p Policy) WithSelectors(selectors []string) Policy {
+	p.MaskSelectors = append(append([]string(nil), p.MaskSelectors...), selectors...)
+	return p
+}
+
+// Redactor applies known secret values and the policy's patterns to text.
+type Redactor struct {
+	secrets  *strings.Replacer
+	patterns []*regexp.Regexp
+}
+
+// New builds a redactor. Invalid patterns are skipped; call Validate first
+// to report them.
+func New(p Policy, secrets *strings.Replacer) *Redactor {
+	r := &Redactor{secrets: secrets}
+	for _, pattern := range p.Patterns {
+		if re, err := regexp.Compile(pattern); err == nil {
+			r.patterns = append(r.patterns, re)
+		}
+	}
+	return r
+}
+
+// Secrets replaces only known secret values. It is safe to run over any
+// protocol line, including ones carrying image data.
+func (r *Redactor) Secrets(s string) string {
+	if r.secrets == nil {
+		return s
+	}
+	return r.secrets.Replace(s)
+}
+
+func (r *Redactor) Redact(s string) string {
+	s = r.Secrets(s)
+	for _, re := range r.patterns {
+		s = re.ReplaceAllString(s, Replacement)
+	}
+	return s
+}
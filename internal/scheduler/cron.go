@@ -0,0 +1,214 @@
+package scheduler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	// The server image has no zoneinfo of its own
+	_ "time/tzdata"
+)
+
+// Cron is a parsed five-field cron expression (minute, hour, day of month,
+// month, day of week) evaluated in a time zone. Fields take *, numbers,
+// ranges (1-5), steps (*/15, 0-30/10), lists (1,15) and, for months and
+// weekdays, three-letter names. As in classic cron, a day matches if either
+// the day of month or the day of week does when both are restricted.
+type Cron struct {
+	minute, hour, dom, month, dow uint64
+	// hourAny, domAny and dowAny are set when the field starts with *
+	hourAny, domAny, dowAny bool
+	loc                     *time.Location
+}
+
+var macros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var monthNames = map[string]int{
+	"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+	"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+}
+
+var dayNames = map[string]int{
+	"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+}
+
+// ParseCron parses an expression for a time zone, an IANA name such as
+// "Europe/Berlin"; an empty one means UTC.
+func ParseCron(expr, timeZone string) (*Cron, error) {
+	loc := time.UTC
+	if timeZone != "" {
+		var err error
+		if loc, err = time.LoadLocation(timeZone); err != nil {
+			return nil, fmt.Errorf("unknown time zone %q", timeZone)
+		}
+	}
+
+	expr = strings.TrimSpace(expr)
+	if macro, ok := macros[strings.ToLower(expr)]; ok {
+		expr = macro
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression %q must have 5 fields: minute hour day-of-month month day-of-week", expr)
+	}
+
+	c := &Cron{loc: loc}
+	var err error
+	if c.minute, err = parseField(fields[0], 0, 59, nil); err != nil {
+		return nil, fmt.Errorf("minute: %w", err)
+	}
+	if c.hour, err = parseField(fields[1], 0, 23, nil); err != nil {
+		return nil, fmt.Errorf("hour: %w", err)
+	}
+	if c.dom, err = parseField(fields[2], 1, 31, nil); err != nil {
+		return nil, fmt.Errorf("day of month: %w", err)
+	}
+	if c.month, err = parseField(fields[3], 1, 12, monthNames); err != nil {
+		return nil, fmt.Errorf("month: %w", err)
+	}
+	// 7 is Sunday too
+	if c.dow, err = parseField(fields[4], 0, 7, dayNames); err != nil {
+		return nil, fmt.Errorf("day of week: %w", err)
+	}
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	c.hourAny = strings.HasPrefix(fields[1], "*")
+	c.domAny = strings.HasPrefix(fields[2], "*")
+	c.dowAny = strings.HasPrefix(fields[4], "*")
+	return c, nil
+}
+
+// parseField returns the field's values as a bit set.
+func parseField(field string, low, high int, names map[string]int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepPart); err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step %q", stepPart)
+			}
+		}
+
+		start, end := low, high
+		switch {
+		case rangePart == "*":
+		case strings.Contains(rangePart, "-"):
+			from, to, _ := strings.Cut(rangePart, "-")
+			var err error
+			if start, err = parseValue(from, names); err != nil {
+				return 0, err
+			}
+			if end, err = parseValue(to, names); err != nil {
+				return 0, err
+			}
+		default:
+			value, err := parseValue(rangePart, names)
+			if err != nil {
+				return 0, err
+			}
+			start = value
+			// 5/15 means from 5 to the end in steps of 15
+			if !hasStep {
+				end = value
+			}
+		}
+		if start < low || end > high || start > end {
+			return 0, fmt.Errorf("%q is out of range %d-%d", part, low, high)
+		}
+
+		for value := start; value <= end; value += step {
+			bits |= 1 << value
+		}
+	}
+	return bits, nil
+}
+
+func parseValue(value string, names map[string]int) (int, error) {
+	if n, ok := names[strings.ToLower(value)]; ok {
+		return n, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", value)
+	}
+	return n, nil
+}
+
+func has(bits uint64, value int) bool {
+	return bits&(1<<value) != 0
+}
+
+// hourMatches handles daylight saving time as classic cron does: what was
+// due in the hour skipped when it starts runs in the hour after, and only
+// wildcard hours match the second pass through the hour repeated when it
+// ends.
+func (c *Cron) hourMatches(t time.Time) bool {
+	previous := t.Add(-time.Hour).Hour()
+	switch {
+	case previous == t.Hour():
+		return c.hourAny && has(c.hour, t.Hour())
+	case previous != (t.Hour()+23)%24:
+		return has(c.hour, t.Hour()) || has(c.hour, (previous+1)%24)
+	}
+	return has(c.hour, t.Hour())
+}
+
+func (c *Cron) dayMatches(t time.Time) bool {
+	dom := has(c.dom, t.Day())
+	dow := has(c.dow, int(t.Weekday()))
+	if c.domAny || c.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// Next returns the first time the expression matches after t, or the zero
+// time if it never does (such as on February 30th).
+func (c *Cron) Next(t time.Time) time.Time {
+	t = t.In(c.loc).Truncate(time.Minute).Add(time.Minute)
+
+	// Every expression that can match does so within a leap year cycle
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case !has(c.month, int(t.Month())):
+			t = c.date(t.Year(), t.Month()+1, 1, 0)
+		case !c.dayMatches(t):
+			t = c.date(t.Year(), t.Month(), t.Day()+1, 0)
+		case !c.hourMatches(t):
+			next := c.date(t.Year(), t.Month(), t.Day(), t.Hour()+1)
+			// In the hour repeated when daylight saving time ends
+			if !next.After(t) {
+				next = t.Add(time.Hour)
+			}
+			t = next
+		case !has(c.minute, t.Minute()):
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// date is the start of an hour in the expression's time zone. Of the two
+// passes through the hour repeated when daylight saving time ends, it is the
+// first, where time.Date may return the second.
+func (c *Cron) date(year int, month time.Month, day, hour int) time.Time {
+	t := time.Date(year, month, day, hour, 0, 0, 0, c.loc)
+	if first := t.Add(-time.Hour); first.Hour() == t.Hour() && first.Day() == t.Day() {
+		return first
+	}
+	return t
+}
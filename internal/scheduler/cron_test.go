@@ -0,0 +1,421 @@
+package scheduler
+
+import (
+	"context"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"brian-nunez/bcode/internal/auth"
+	"brian-nunez/bcode/internal/jobs"
+)
+
+func date(t *testing.T, loc string, value string) time.Time {
+	t.Helper()
+
+	location, err := time.LoadLocation(loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := time.ParseInLocation("2006-01-02 15:04", value, location)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed
+}
+
+func TestParseCron(t *testing.T) {
+	// Monday 1 January 2024
+	const from = "2024-01-01 00:00"
+
+	tests := []struct {
+		name string
+		expr string
+		want []string
+	}{
+		{"every minute", "* * * * *", []string{"2024-01-01 00:01", "2024-01-01 00:02"}},
+		{"fixed time", "30 9 * * *", []string{"2024-01-01 09:30", "2024-01-02 09:30"}},
+		{"range", "0 9-11 * * *", []string{"2024-01-01 09:00", "2024-01-01 10:00", "2024-01-01 11:00", "2024-01-02 09:00"}},
+		{"step", "*/20 * * * *", []string{"2024-01-01 00:20", "2024-01-01 00:40", "2024-01-01 01:00"}},
+		{"range with step", "0-30/15 12 * * *", []string{"2024-01-01 12:00", "2024-01-01 12:15", "2024-01-01 12:30", "2024-01-02 12:00"}},
+		{"start with step", "50/5 23 * * *", []string{"2024-01-01 23:50", "2024-01-01 23:55", "2024-01-02 23:50"}},
+		{"list", "0 8,17 * * *", []string{"2024-01-01 08:00", "2024-01-01 17:00", "2024-01-02 08:00"}},
+		{"named days", "0 9 * * sat,SUN", []string{"2024-01-06 09:00", "2024-01-07 09:00", "2024-01-13 09:00"}},
+		{"named day range", "0 9 * * Mon-Wed", []string{"2024-01-01 09:00", "2024-01-02 09:00", "2024-01-03 09:00", "2024-01-08 09:00"}},
+		{"sunday as 7", "0 0 * * 7", []string{"2024-01-07 00:00", "2024-01-14 00:00"}},
+		{"named months", "0 0 1 feb,aug *", []string{"2024-02-01 00:00", "2024-08-01 00:00", "2025-02-01 00:00"}},
+		{"leap day", "0 0 29 2 *", []string{"2024-02-29 00:00", "2028-02-29 00:00"}},
+		// Either day field matches when both are restricted
+		{"day of month or week", "0 0 13 * fri", []string{"2024-01-05 00:00", "2024-01-12 00:00", "2024-01-13 00:00", "2024-01-19 00:00"}},
+		{"macro", "@weekly", []string{"2024-01-07 00:00", "2024-01-14 00:00"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cron, err := ParseCron(tt.expr, "")
+			if err != nil {
+				t.Fatalf("ParseCron(%q): %v", tt.expr, err)
+			}
+			at := date(t, "UTC", from)
+			for _, want := range tt.want {
+				at = cron.Next(at)
+				if !at.Equal(date(t, "UTC", want)) {
+					t.Fatalf("next run %s, want %s", at.Format("2006-01-02 15:04"), want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseCronErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		expr     string
+		timeZone string
+	}{
+		{"too few fields", "* * * *", ""},
+		{"too many fields", "* * * * * *", ""},
+		{"minute out of range", "60 * * * *", ""},
+		{"hour out of range", "0 24 * * *", ""},
+		{"day of month zero", "0 0 0 * *", ""},
+		{"backwards range", "0 17-9 * * *", ""},
+		{"zero step", "*/0 * * * *", ""},
+		{"unknown name", "0 0 * * funday", ""},
+		{"names only for months and days", "0 jan * * *", ""},
+		{"unknown time zone", "0 0 * * *", "Mars/Olympus_Mons"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseCron(tt.expr, tt.timeZone); err == nil {
+				t.Errorf("ParseCron(%q, %q) succeeded", tt.expr, tt.timeZone)
+			}
+		})
+	}
+}
+
+func TestCronNeverMatches(t *testing.T) {
+	cron, err := ParseCron("0 0 30 2 *", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next := cron.Next(time.Now()); !next.IsZero() {
+		t.Errorf("February 30th matched at %s", next)
+	}
+}
+
+// In Europe/Berlin, 2024's clocks went from 02:00 to 03:00 on 31 March and
+// from 03:00 back to 02:00 on 27 October.
+func TestCronDaylightSavingTime(t *testing.T) {
+	const berlin = "Europe/Berlin"
+
+	tests := []struct {
+		name string
+		expr string
+		from time.Time
+		want []time.Time
+	}{
+		{
+			name: "a run in the skipped hour runs in the hour after",
+			expr: "30 2 * * *",
+			from: date(t, berlin, "2024-03-30 12:00"),
+			want: []time.Time{
+				time.Date(2024, 3, 31, 1, 30, 0, 0, time.UTC), // 03:30 CEST
+				time.Date(2024, 4, 1, 0, 30, 0, 0, time.UTC),  // 02:30 CEST
+			},
+		},
+		{
+			name: "hourly runs skip the missing hour",
+			expr: "0 * * * *",
+			from: date(t, berlin, "2024-03-31 01:00"),
+			want: []time.Time{
+				time.Date(2024, 3, 31, 1, 0, 0, 0, time.UTC), // 03:00 CEST
+				time.Date(2024, 3, 31, 2, 0, 0, 0, time.UTC), // 04:00 CEST
+			},
+		},
+		{
+			name: "a run in the repeated hour runs once",
+			expr: "30 2 * * *",
+			from: date(t, berlin, "2024-10-26 12:00"),
+			want: []time.Time{
+				time.Date(2024, 10, 27, 0, 30, 0, 0, time.UTC), // 02:30 CEST
+				time.Date(2024, 10, 28, 1, 30, 0, 0, time.UTC), // 02:30 CET the next day
+			},
+		},
+		{
+			name: "hourly runs run in both passes of the repeated hour",
+			expr: "0 * * * *",
+			from: time.Date(2024, 10, 26, 23, 30, 0, 0, time.UTC), // 01:30 CEST
+			want: []time.Time{
+				time.Date(2024, 10, 27, 0, 0, 0, 0, time.UTC), // 02:00 CEST
+				time.Date(2024, 10, 27, 1, 0, 0, 0, time.UTC), // 02:00 CET
+				time.Date(2024, 10, 27, 2, 0, 0, 0, time.UTC), // 03:00 CET
+			},
+		},
+		{
+			name: "daily runs keep their local time across the change",
+			expr: "0 9 * * *",
+			from: date(t, berlin, "2024-03-30 12:00"),
+			want: []time.Time{
+				time.Date(2024, 3, 31, 7, 0, 0, 0, time.UTC), // 09:00 CEST
+				time.Date(2024, 4, 1, 7, 0, 0, 0, time.UTC),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cron, err := ParseCron(tt.expr, berlin)
+			if err != nil {
+				t.Fatal(err)
+			}
+			at := tt.from
+			for _, want := range tt.want {
+				at = cron.Next(at)
+				if !at.Equal(want) {
+					t.Fatalf("next run %s, want %s", at.UTC(), want)
+				}
+			}
+		})
+	}
+}
+
+// recordingRun stands in for RunJob, finishing every job at once.
+type recordingRun struct {
+	mu   sync.Mutex
+	runs []url.Values
+}
+
+func (r *recordingRun) run(ctx context.Context, principal auth.Principal, fields url.Values, started func(jobID string)) (string, error) {
+	job := jobs.Default.Create(jobs.Payload{URL: fields.Get("url"), Action: fields.Get("action")}, principal.Workspace, principal.Subject())
+	started(job.ID)
+	jobs.Default.Update(job.ID, func(j *jobs.Job) {
+		j.Status = jobs.StatusSucceeded
+	})
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.runs = append(r.runs, fields)
+	return job.ID, nil
+}
+
+func (r *recordingRun) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.runs)
+}
+
+// newTestScheduler runs jobs with run, as though every schedule was created
+// by an admin of the default workspace.
+func newTestScheduler(store *Store, run *recordingRun) *Scheduler {
+	scheduler := New(store, run.run)
+	scheduler.lookup = func(subject string) (auth.Principal, error) {
+		return auth.Principal{Kind: "user", ID: "admin", Scopes: []string{auth.ScopeAdmin}, Workspace: "default"}, nil
+	}
+	return scheduler
+}
+
+func TestSchedulerMissedRuns(t *testing.T) {
+	now := time.Date(2024, 6, 3, 12, 0, 30, 0, time.UTC)
+	// The server was down for the last three hourly runs
+	missed := time.Date(2024, 6, 3, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		policy string
+		runs   int
+		status string
+	}{
+		{policy: MissedSkip, runs: 0, status: RunSkipped},
+		{policy: MissedRunOnce, runs: 1, status: RunSucceeded},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			store, err := NewStore(filepath.Join(t.TempDir(), "schedules.json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			store.now = func() time.Time { return now }
+			schedule, err := store.Create("default", "user:admin", Spec{
+				Name:       "hourly",
+				Cron:       "0 * * * *",
+				Template:   map[string]string{"url": "https://example.com", "action": "scrape"},
+				Enabled:    true,
+				MissedRuns: tt.policy,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			store.mu.Lock()
+			store.schedules[schedule.ID].NextRunAt = &missed
+			store.mu.Unlock()
+
+			run := &recordingRun{}
+			scheduler := newTestScheduler(store, run)
+			scheduler.now = func() time.Time { return now }
+
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				scheduler.Run(ctx, time.Millisecond)
+			}()
+
+			var history []Run
+			deadline := time.Now().Add(5 * time.Second)
+			for {
+				history, _ = store.Runs(schedule.ID)
+				if len(history) == 1 && history[0].Status != RunRunning {
+					break
+				}
+				if time.Now().After(deadline) {
+					t.Fatalf("timed out waiting for the missed run, have %+v", history)
+				}
+				time.Sleep(time.Millisecond)
+			}
+			// Later ticks find nothing more to run
+			time.Sleep(20 * time.Millisecond)
+			cancel()
+			<-done
+
+			history, _ = store.Runs(schedule.ID)
+			if len(history) != 1 || history[0].Status != tt.status || !history[0].ScheduledFor.Equal(missed) {
+				t.Errorf("history = %+v, want one %s run for %s", history, tt.status, missed)
+			}
+			if run.count() != tt.runs {
+				t.Errorf("ran %d jobs, want %d", run.count(), tt.runs)
+			}
+
+			current, _ := store.Get(schedule.ID)
+			if want := time.Date(2024, 6, 3, 13, 0, 0, 0, time.UTC); current.NextRunAt == nil || !current.NextRunAt.Equal(want) {
+				t.Errorf("next run at %v, want %s", current.NextRunAt, want)
+			}
+		})
+	}
+}
+
+// A run that is only a little late was not missed.
+func TestSchedulerRunsLateRuns(t *testing.T) {
+	store, err := NewStore(filepath.Join(t.TempDir(), "schedules.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	schedule, err := store.Create("default", "user:admin", Spec{
+		Name:     "every minute",
+		Cron:     "* * * * *",
+		Template: map[string]string{"url": "https://example.com", "action": "scrape"},
+		Enabled:  true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	due := *schedule.NextRunAt
+	run := &recordingRun{}
+	scheduler := newTestScheduler(store, run)
+	scheduler.now = func() time.Time { return due.Add(missedAfter / 2) }
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go scheduler.Run(ctx, time.Millisecond)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for run.count() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("the late run did not start")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSchedulerChecksCreator(t *testing.T) {
+	tests := []struct {
+		name    string
+		creator auth.Principal
+		err     error
+		reason  string
+	}{
+		{
+			name:    "member who may submit",
+			creator: auth.Principal{Kind: "key", ID: "k1", Scopes: []string{auth.ScopeSubmit}, Workspace: "acme"},
+		},
+		{
+			name:    "operator",
+			creator: auth.Principal{Kind: "user", ID: "admin", Scopes: []string{auth.ScopeAdmin}, Workspace: "default"},
+		},
+		{
+			name:   "revoked",
+			err:    auth.ErrNotFound,
+			reason: "no longer exists or was revoked",
+		},
+		{
+			name:    "read only",
+			creator: auth.Principal{Kind: "key", ID: "k1", Scopes: []string{auth.ScopeRead}, Workspace: "acme"},
+			reason:  "may no longer submit jobs",
+		},
+		{
+			name:    "other workspace",
+			creator: auth.Principal{Kind: "key", ID: "k1", Scopes: []string{auth.ScopeSubmit}, Workspace: "globex"},
+			reason:  "no longer belongs to workspace acme",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, err := NewStore(filepath.Join(t.TempDir(), "schedules.json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			schedule, err := store.Create("acme", "key:k1", Spec{
+				Name:     "hourly",
+				Cron:     "0 * * * *",
+				Template: map[string]string{"url": "https://example.com", "action": "scrape"},
+				Enabled:  true,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			run := &recordingRun{}
+			scheduler := New(store, run.run)
+			var looked string
+			scheduler.lookup = func(subject string) (auth.Principal, error) {
+				looked = subject
+				return tt.creator, tt.err
+			}
+			scheduler.fire(context.Background(), schedule, *schedule.NextRunAt)
+
+			var history []Run
+			deadline := time.Now().Add(5 * time.Second)
+			for {
+				history, _ = store.Runs(schedule.ID)
+				if len(history) == 1 && history[0].Status != RunRunning {
+					break
+				}
+				if time.Now().After(deadline) {
+					t.Fatalf("timed out waiting for the run, have %+v", history)
+				}
+				time.Sleep(time.Millisecond)
+			}
+
+			if looked != "key:k1" {
+				t.Errorf("looked up %q, want the schedule's creator", looked)
+			}
+			if tt.reason == "" {
+				if history[0].Status != RunSucceeded || run.count() != 1 {
+					t.Errorf("run %+v after %d jobs, want one that succeeded", history[0], run.count())
+				}
+				return
+			}
+			if history[0].Status != RunSkipped || !strings.Contains(history[0].Reason, tt.reason) {
+				t.Errorf("run %+v, want one skipped because %s", history[0], tt.reason)
+			}
+			if run.count() != 0 {
+				t.Errorf("ran %d jobs for a creator who may not", run.count())
+			}
+		})
+	}
+}
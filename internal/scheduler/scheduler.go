@@ -0,0 +1,226 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"sync"
+	"time"
+
+	"brian-nunez/bcode/internal/auth"
+	"brian-nunez/bcode/internal/jobs"
+)
+
+// A run more than this late was missed, e.g. while the server was down,
+// and is handled by the schedule's missed run policy.
+const missedAfter = time.Minute
+
+// RunFunc runs a job from a template's form fields as principal, calling
+// started with the job's ID once it has one, and returns once it is done.
+type RunFunc func(ctx context.Context, principal auth.Principal, fields url.Values, started func(jobID string)) (string, error)
+
+// Observer follows the runs of schedules, such as monitors comparing each
+// run's result with the previous one.
+type Observer interface {
+	// Prepare may add to the form fields a schedule's job is run with.
+	Prepare(schedule Schedule, fields url.Values)
+	// Observe is called once a run has finished.
+	Observe(ctx context.Context, schedule Schedule, run Run)
+}
+
+// Scheduler starts the jobs of the store's schedules when they are due.
+// Overlapping runs are only tracked within this server instance.
+type Scheduler struct {
+	store     *Store
+	run       RunFunc
+	observers []Observer
+
+	mu      sync.Mutex
+	running map[string]int
+	// queued holds the run waiting for the previous one under OverlapQueue
+	queued map[string]time.Time
+	now    func() time.Time
+	// lookup finds who a schedule's creator is now
+	lookup func(subject string) (auth.Principal, error)
+}
+
+func New(store *Store, run RunFunc, observers ...Observer) *Scheduler {
+	return &Scheduler{
+		store:     store,
+		run:       run,
+		observers: observers,
+		running:   map[string]int{},
+		queued:    map[string]time.Time{},
+		now:       time.Now,
+		lookup:    lookupCreator,
+	}
+}
+
+func lookupCreator(subject string) (auth.Principal, error) {
+	store, err := auth.Default()
+	if err != nil {
+		return auth.Principal{}, err
+	}
+	return store.Lookup(subject)
+}
+
+// unauthorized returns why the schedule's creator may no longer run its
+// jobs, if they were revoked, lost the submit scope or moved out of its
+// workspace since it was created.
+func (s *Scheduler) unauthorized(schedule Schedule) string {
+	creator, err := s.lookup(schedule.CreatedBy)
+	switch {
+	case errors.Is(err, auth.ErrNotFound):
+		return fmt.Sprintf("its creator %s no longer exists or was revoked", schedule.CreatedBy)
+	case err != nil:
+		return fmt.Sprintf("could not check its creator %s: %v", schedule.CreatedBy, err)
+	case !creator.Can(auth.ScopeSubmit):
+		return fmt.Sprintf("its creator %s may no longer submit jobs", schedule.CreatedBy)
+	case !creator.Sees(schedule.Workspace):
+		return fmt.Sprintf("its creator %s no longer belongs to workspace %s", schedule.CreatedBy, schedule.Workspace)
+	}
+	return ""
+}
+
+// Run checks for due schedules every interval until ctx is done. Jobs still
+// running then are cancelled along with ctx.
+func (s *Scheduler) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		now := s.now()
+		for _, due := range s.store.claimDue(now) {
+			if now.Sub(due.scheduledFor) > missedAfter && due.schedule.MissedRuns == MissedSkip {
+				s.store.addRun(due.schedule.ID, Run{
+					ScheduledFor: due.scheduledFor,
+					Status:       RunSkipped,
+					Reason:       "missed while the server was not running",
+				})
+				continue
+			}
+			s.fire(ctx, due.schedule, due.scheduledFor)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// fire starts a run unless its creator may no longer run it or the
+// schedule's overlap policy holds it back.
+func (s *Scheduler) fire(ctx context.Context, schedule Schedule, scheduledFor time.Time) {
+	if reason := s.unauthorized(schedule); reason != "" {
+		log.Printf("schedule %s (%s): skipped the run for %s: %s", schedule.Name, schedule.ID, scheduledFor.Format(time.RFC3339), reason)
+		s.store.addRun(schedule.ID, Run{
+			ScheduledFor: scheduledFor,
+			Status:       RunSkipped,
+			Reason:       reason,
+		})
+		return
+	}
+
+	s.mu.Lock()
+	if s.running[schedule.ID] > 0 {
+		switch schedule.Overlap {
+		case OverlapSkip:
+			s.mu.Unlock()
+			s.store.addRun(schedule.ID, Run{
+				ScheduledFor: scheduledFor,
+				Status:       RunSkipped,
+				Reason:       "the previous run was still running",
+			})
+			return
+		case OverlapQueue:
+			_, waiting := s.queued[schedule.ID]
+			if !waiting {
+				s.queued[schedule.ID] = scheduledFor
+			}
+			s.mu.Unlock()
+			if waiting {
+				s.store.addRun(schedule.ID, Run{
+					ScheduledFor: scheduledFor,
+					Status:       RunSkipped,
+					Reason:       "a run was already waiting for the previous one to finish",
+				})
+			}
+			return
+		}
+	}
+	s.running[schedule.ID]++
+	s.mu.Unlock()
+
+	go s.execute(ctx, schedule, scheduledFor)
+}
+
+func (s *Scheduler) execute(ctx context.Context, schedule Schedule, scheduledFor time.Time) {
+	startedAt := s.now()
+	runID := s.store.addRun(schedule.ID, Run{
+		ScheduledFor: scheduledFor,
+		Status:       RunRunning,
+		StartedAt:    &startedAt,
+	})
+
+	fields := schedule.Fields()
+	for _, observer := range s.observers {
+		observer.Prepare(schedule, fields)
+	}
+	jobID, err := s.run(ctx, schedule.Principal(), fields, func(jobID string) {
+		s.store.updateRun(schedule.ID, runID, func(run *Run) {
+			run.JobID = jobID
+		})
+	})
+
+	status, reason := RunSucceeded, ""
+	job, ok := jobs.Default.Get(jobID)
+	switch {
+	case ok && job.Status == jobs.StatusSucceeded:
+	case ok && job.Error != nil:
+		status, reason = RunFailed, job.Error.Message
+	case err != nil:
+		status, reason = RunFailed, err.Error()
+	default:
+		status, reason = RunFailed, fmt.Sprintf("the job ended %s", job.Status)
+	}
+	if status == RunFailed {
+		log.Printf("schedule %s (%s): run for %s failed: %s", schedule.Name, schedule.ID, scheduledFor.Format(time.RFC3339), reason)
+	}
+
+	finishedAt := s.now()
+	finished := Run{
+		ID:           runID,
+		ScheduledFor: scheduledFor,
+		Status:       status,
+		JobID:        jobID,
+		Reason:       reason,
+		StartedAt:    &startedAt,
+		FinishedAt:   &finishedAt,
+	}
+	s.store.updateRun(schedule.ID, runID, func(run *Run) {
+		*run = finished
+	})
+	for _, observer := range s.observers {
+		observer.Observe(ctx, schedule, finished)
+	}
+
+	s.mu.Lock()
+	s.running[schedule.ID]--
+	if s.running[schedule.ID] == 0 {
+		delete(s.running, schedule.ID)
+	}
+	next, waiting := s.queued[schedule.ID]
+	delete(s.queued, schedule.ID)
+	s.mu.Unlock()
+
+	// The schedule may have been changed, paused or deleted meanwhile
+	if waiting && ctx.Err() == nil {
+		if current, err := s.store.Get(schedule.ID); err == nil && current.Enabled {
+			s.fire(ctx, current, next)
+		}
+	}
+}
@@ -0,0 +1,495 @@
+package scheduler
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"brian-nunez/bcode/internal/auth"
+	"brian-nunez/bcode/internal/store"
+)
+
+// What to do about runs missed while the server was down.
+const (
+	MissedSkip    = "skip"
+	MissedRunOnce = "run_once"
+)
+
+// What to do when a run is due while the previous one is still running.
+const (
+	OverlapSkip  = "skip"
+	OverlapQueue = "queue"
+	OverlapAllow = "allow"
+)
+
+var (
+	MissedPolicies  = []string{MissedSkip, MissedRunOnce}
+	OverlapPolicies = []string{OverlapSkip, OverlapQueue, OverlapAllow}
+)
+
+// Statuses of a run.
+const (
+	RunRunning   = "running"
+	RunSucceeded = "succeeded"
+	RunFailed    = "failed"
+	RunSkipped   = "skipped"
+)
+
+var (
+	ErrNotFound        = errors.New("schedule not found")
+	ErrInvalidName     = errors.New("schedule names must be 1 to 100 characters")
+	ErrInvalidCron     = errors.New("invalid cron expression or time zone")
+	ErrInvalidTemplate = errors.New("the job template needs a url and an action")
+	ErrInvalidPolicy   = errors.New("unknown missed run or overlap policy")
+)
+
+// Runs kept per schedule, newest first.
+const maxRuns = 100
+
+// Schedule runs a job from its template whenever its cron expression
+// matches in its time zone. The template holds the form fields /execute
+// takes, such as url, action and instruction.
+type Schedule struct {
+	ID         string            `json:"id"`
+	Name       string            `json:"name"`
+	Workspace  string            `json:"workspace"`
+	Cron       string            `json:"cron"`
+	TimeZone   string            `json:"time_zone"`
+	Template   map[string]string `json:"template"`
+	Enabled    bool              `json:"enabled"`
+	MissedRuns string            `json:"missed_runs"`
+	Overlap    string            `json:"overlap"`
+	NextRunAt  *time.Time        `json:"next_run_at,omitempty"`
+	LastRunAt  *time.Time        `json:"last_run_at,omitempty"`
+	CreatedBy  string            `json:"created_by,omitempty"`
+	CreatedAt  time.Time         `json:"created_at"`
+	UpdatedAt  time.Time         `json:"updated_at"`
+}
+
+// Spec is what callers set on a schedule. Empty policies default to skip
+// and an empty time zone to UTC.
+type Spec struct {
+	Name       string            `json:"name"`
+	Cron       string            `json:"cron"`
+	TimeZone   string            `json:"time_zone"`
+	Template   map[string]string `json:"template"`
+	Enabled    bool              `json:"enabled"`
+	MissedRuns string            `json:"missed_runs"`
+	Overlap    string            `json:"overlap"`
+}
+
+// Principal is who the schedule's jobs run as: the schedule itself, in its
+// workspace. The scheduler checks before each run that the schedule's
+// creator may still submit jobs there.
+func (s Schedule) Principal() auth.Principal {
+	return auth.Principal{
+		Kind:      "schedule",
+		ID:        s.ID,
+		Name:      s.Name,
+		Scopes:    []string{auth.ScopeSubmit},
+		Workspace: s.Workspace,
+	}
+}
+
+// Fields returns the template as form values.
+func (s Schedule) Fields() url.Values {
+	fields := url.Values{}
+	for name, value := range s.Template {
+		fields.Set(name, value)
+	}
+	return fields
+}
+
+// Run is one time a schedule was due: the job it started, or why it did not
+// start one.
+type Run struct {
+	ID           string     `json:"id"`
+	ScheduledFor time.Time  `json:"scheduled_for"`
+	Status       string     `json:"status"`
+	JobID        string     `json:"job_id,omitempty"`
+	Reason       string     `json:"reason,omitempty"`
+	StartedAt    *time.Time `json:"started_at,omitempty"`
+	FinishedAt   *time.Time `json:"finished_at,omitempty"`
+}
+
+// Store keeps schedules and their run history in schedules.json in the data
+// directory.
+type Store struct {
+	path string
+
+	mu        sync.Mutex
+	schedules map[string]*Schedule
+	runs      map[string][]*Run
+	now       func() time.Time
+}
+
+type records struct {
+	Schedules []*Schedule       `json:"schedules"`
+	Runs      map[string][]*Run `json:"runs"`
+}
+
+var (
+	defaultStore *Store
+	defaultErr   error
+	defaultOnce  sync.Once
+)
+
+// Default returns the store under the server's data directory.
+func Default() (*Store, error) {
+	defaultOnce.Do(func() {
+		defaultStore, defaultErr = NewStore(filepath.Join(store.DataDir(), "schedules.json"))
+	})
+	return defaultStore, defaultErr
+}
+
+func NewStore(path string) (*Store, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+
+	s := &Store{
+		path:      path,
+		schedules: map[string]*Schedule{},
+		runs:      map[string][]*Run{},
+		now:       time.Now,
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var saved records
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, fmt.Errorf("invalid schedules %s: %w", path, err)
+	}
+	for _, schedule := range saved.Schedules {
+		s.schedules[schedule.ID] = schedule
+	}
+	now := s.now()
+	for id, runs := range saved.Runs {
+		// Runs the server went down in the middle of
+		for _, run := range runs {
+			if run.Status == RunRunning {
+				run.Status = RunFailed
+				run.Reason = "the server stopped while the job was running"
+				run.FinishedAt = &now
+			}
+		}
+		s.runs[id] = runs
+	}
+
+	return s, nil
+}
+
+// save writes the store; callers hold s.mu.
+func (s *Store) save() error {
+	saved := records{Schedules: []*Schedule{}, Runs: s.runs}
+	for _, schedule := range s.schedules {
+		saved.Schedules = append(saved.Schedules, schedule)
+	}
+	sort.Slice(saved.Schedules, func(i, j int) bool {
+		return saved.Schedules[i].ID < saved.Schedules[j].ID
+	})
+	data, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		return err
+	}
+	return store.WriteFileAtomic(s.path, data)
+}
+
+// validate checks a spec and fills in its defaults.
+func (spec *Spec) validate() (*Cron, error) {
+	spec.Name = strings.TrimSpace(spec.Name)
+	if spec.Name == "" || len(spec.Name) > 100 {
+		return nil, ErrInvalidName
+	}
+	cron, err := ParseCron(spec.Cron, spec.TimeZone)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCron, err)
+	}
+	if cron.Next(time.Now()).IsZero() {
+		return nil, fmt.Errorf("%w: %q never matches", ErrInvalidCron, spec.Cron)
+	}
+	if strings.TrimSpace(spec.Template["url"]) == "" || strings.TrimSpace(spec.Template["action"]) == "" {
+		return nil, ErrInvalidTemplate
+	}
+
+	if spec.MissedRuns == "" {
+		spec.MissedRuns = MissedSkip
+	}
+	if spec.Overlap == "" {
+		spec.Overlap = OverlapSkip
+	}
+	if !slices.Contains(MissedPolicies, spec.MissedRuns) || !slices.Contains(OverlapPolicies, spec.Overlap) {
+		return nil, ErrInvalidPolicy
+	}
+	return cron, nil
+}
+
+// Create adds a schedule to a workspace.
+func (s *Store) Create(workspace, createdBy string, spec Spec) (Schedule, error) {
+	cron, err := spec.validate()
+	if err != nil {
+		return Schedule{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	schedule := &Schedule{
+		ID:        randomID(),
+		Workspace: workspace,
+		CreatedBy: createdBy,
+		CreatedAt: now,
+	}
+	schedule.apply(spec, cron, now)
+	s.schedules[schedule.ID] = schedule
+	if err := s.save(); err != nil {
+		delete(s.schedules, schedule.ID)
+		return Schedule{}, err
+	}
+	return *schedule, nil
+}
+
+// Update replaces what a schedule runs and when.
+func (s *Store) Update(id string, spec Spec) (Schedule, error) {
+	cron, err := spec.validate()
+	if err != nil {
+		return Schedule{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	schedule, ok := s.schedules[id]
+	if !ok {
+		return Schedule{}, ErrNotFound
+	}
+	previous := *schedule
+	schedule.apply(spec, cron, s.now())
+	if err := s.save(); err != nil {
+		*schedule = previous
+		return Schedule{}, err
+	}
+	return *schedule, nil
+}
+
+// SetEnabled pauses or resumes a schedule. Runs that would have been due
+// while it was paused are not made up for.
+func (s *Store) SetEnabled(id string, enabled bool) (Schedule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	schedule, ok := s.schedules[id]
+	if !ok {
+		return Schedule{}, ErrNotFound
+	}
+	if schedule.Enabled == enabled {
+		return *schedule, nil
+	}
+	cron, err := ParseCron(schedule.Cron, schedule.TimeZone)
+	if err != nil {
+		return Schedule{}, err
+	}
+
+	previous := *schedule
+	spec := schedule.spec()
+	spec.Enabled = enabled
+	schedule.apply(spec, cron, s.now())
+	if err := s.save(); err != nil {
+		*schedule = previous
+		return Schedule{}, err
+	}
+	return *schedule, nil
+}
+
+func (s *Schedule) spec() Spec {
+	return Spec{
+		Name:       s.Name,
+		Cron:       s.Cron,
+		TimeZone:   s.TimeZone,
+		Template:   s.Template,
+		Enabled:    s.Enabled,
+		MissedRuns: s.MissedRuns,
+		Overlap:    s.Overlap,
+	}
+}
+
+// apply sets a validated spec and works out the next run from now.
+func (s *Schedule) apply(spec Spec, cron *Cron, now time.Time) {
+	s.Name = spec.Name
+	s.Cron = spec.Cron
+	s.TimeZone = spec.TimeZone
+	s.Template = spec.Template
+	s.Enabled = spec.Enabled
+	s.MissedRuns = spec.MissedRuns
+	s.Overlap = spec.Overlap
+	s.UpdatedAt = now
+
+	s.NextRunAt = nil
+	if s.Enabled {
+		next := cron.Next(now)
+		s.NextRunAt = &next
+	}
+}
+
+// Delete removes a schedule and its history. Jobs it started are kept.
+func (s *Store) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	schedule, ok := s.schedules[id]
+	if !ok {
+		return ErrNotFound
+	}
+	runs := s.runs[id]
+	delete(s.schedules, id)
+	delete(s.runs, id)
+	if err := s.save(); err != nil {
+		s.schedules[id] = schedule
+		s.runs[id] = runs
+		return err
+	}
+	return nil
+}
+
+func (s *Store) Get(id string) (Schedule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	schedule, ok := s.schedules[id]
+	if !ok {
+		return Schedule{}, ErrNotFound
+	}
+	return *schedule, nil
+}
+
+// List returns every schedule, by name.
+func (s *Store) List() []Schedule {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := make([]Schedule, 0, len(s.schedules))
+	for _, schedule := range s.schedules {
+		list = append(list, *schedule)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Name != list[j].Name {
+			return list[i].Name < list[j].Name
+		}
+		return list[i].ID < list[j].ID
+	})
+	return list
+}
+
+// Runs returns a schedule's history, newest first.
+func (s *Store) Runs(id string) ([]Run, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.schedules[id]; !ok {
+		return nil, ErrNotFound
+	}
+	list := make([]Run, 0, len(s.runs[id]))
+	for _, run := range s.runs[id] {
+		list = append(list, *run)
+	}
+	return list, nil
+}
+
+// due is a run a schedule owes.
+type due struct {
+	schedule     Schedule
+	scheduledFor time.Time
+}
+
+// claimDue returns the enabled schedules whose next run has come, and moves
+// each on to its first run after now.
+func (s *Store) claimDue(now time.Time) []due {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var list []due
+	for _, schedule := range s.schedules {
+		if !schedule.Enabled || schedule.NextRunAt == nil || schedule.NextRunAt.After(now) {
+			continue
+		}
+		cron, err := ParseCron(schedule.Cron, schedule.TimeZone)
+		if err != nil {
+			continue
+		}
+		scheduledFor := *schedule.NextRunAt
+		next := cron.Next(now)
+		schedule.NextRunAt = &next
+		schedule.LastRunAt = &scheduledFor
+		list = append(list, due{schedule: *schedule, scheduledFor: scheduledFor})
+	}
+	if len(list) > 0 {
+		s.saveOrLog()
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].scheduledFor.Before(list[j].scheduledFor)
+	})
+	return list
+}
+
+// addRun records a run, returning its ID.
+func (s *Store) addRun(scheduleID string, run Run) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.schedules[scheduleID]; !ok {
+		return ""
+	}
+	run.ID = randomID()
+	runs := append([]*Run{&run}, s.runs[scheduleID]...)
+	if len(runs) > maxRuns {
+		runs = runs[:maxRuns]
+	}
+	s.runs[scheduleID] = runs
+	s.saveOrLog()
+	return run.ID
+}
+
+// updateRun changes a run still in the history.
+func (s *Store) updateRun(scheduleID, runID string, fn func(run *Run)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, run := range s.runs[scheduleID] {
+		if run.ID == runID {
+			fn(run)
+			s.saveOrLog()
+			return
+		}
+	}
+}
+
+// saveOrLog saves for the scheduler, which has nobody to report a failure
+// to; callers hold s.mu.
+func (s *Store) saveOrLog() {
+	if err := s.save(); err != nil {
+		log.Printf("could not save schedules: %v", err)
+	}
+}
+
+func randomID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,290 @@
+package secrets
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"brian-nunez/bcode/internal/store"
+	"brian-nunez/bcode/internal/workspaces"
+)
+
+var (
+	ErrInvalidName = errors.New("secret names may only contain letters, digits, '-' and '_'")
+	ErrEmptyValue  = errors.New("secret value is required")
+	ErrNotFound    = errors.New("secret not found")
+)
+
+var (
+	validName = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+	// Placeholder matches references such as {{secret:github_password}}.
+	Placeholder = regexp.MustCompile(`\{\{secret:([A-Za-z0-9_-]{1,64})\}\}`)
+)
+
+// Secret is the metadata of a stored secret. Values are never listed.
+type Secret struct {
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type record struct {
+	Value     string    `json:"value"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Store keeps all secrets in a single file encrypted with the master key.
+type Store struct {
+	path string
+	key  []byte
+	mu   sync.Mutex
+}
+
+var (
+	defaultStore *Store
+	defaultErr   error
+	defaultOnce  sync.Once
+
+	workspaceStores   = map[string]*Store{}
+	workspaceStoresMu sync.Mutex
+)
+
+// Default returns the default workspace's store under the server's data
+// directory.
+func Default() (*Store, error) {
+	defaultOnce.Do(func() {
+		key, err := store.MasterKey()
+		if err != nil {
+			defaultErr = fmt.Errorf("could not load master key: %w", err)
+			return
+		}
+		defaultStore, defaultErr = NewStore(filepath.Join(store.DataDir(), "secrets.enc"), key)
+	})
+	return defaultStore, defaultErr
+}
+
+// ForWorkspace returns the store holding a workspace's secrets. Workspaces
+// other than the default one keep theirs under workspaces/<name>.
+func ForWorkspace(workspace string) (*Store, error) {
+	if workspace == workspaces.DefaultWorkspace {
+		return Default()
+	}
+	if err := workspaces.ValidateName(workspace); err != nil {
+		return nil, err
+	}
+
+	workspaceStoresMu.Lock()
+	defer workspaceStoresMu.Unlock()
+
+	if s, ok := workspaceStores[workspace]; ok {
+		return s, nil
+	}
+	key, err := store.MasterKey()
+	if err != nil {
+		return nil, fmt.Errorf("could not load master key: %w", err)
+	}
+	s, err := NewStore(filepath.Join(store.DataDir(), "workspaces", workspace, "secrets.enc"), key)
+	if err != nil {
+		return nil, err
+	}
+	workspaceStores[workspace] = s
+	return s, nil
+}
+
+func NewStore(path string, key []byte) (*Store, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+	return &Store{path: path, key: key}, nil
+}
+
+func ValidateName(name string) error {
+	if !validName.MatchString(name) {
+		return ErrInvalidName
+	}
+	return nil
+}
+
+// References returns the unique secret names referenced in the given texts.
+func References(texts ...string) []string {
+	seen := map[string]bool{}
+	var names []string
+	for _, text := range texts {
+		for _, match := range Placeholder.FindAllStringSubmatch(text, -1) {
+			if !seen[match[1]] {
+				seen[match[1]] = true
+				names = append(names, match[1])
+			}
+		}
+	}
+	return names
+}
+
+func (s *Store) load() (map[string]record, error) {
+	records := map[string]record{}
+
+	sealed, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return records, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := store.Open(s.key, sealed)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+func (s *Store) save(records map[string]record) error {
+	data, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+
+	sealed, err := store.Seal(s.key, data)
+	if err != nil {
+		return err
+	}
+
+	return store.WriteFileAtomic(s.path, sealed)
+}
+
+func (s *Store) List() ([]Secret, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]Secret, 0, len(records))
+	for name, r := range records {
+		list = append(list, Secret{Name: name, CreatedAt: r.CreatedAt, UpdatedAt: r.UpdatedAt})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+
+	return list, nil
+}
+
+func (s *Store) Set(name, value string) (Secret, error) {
+	if err := ValidateName(name); err != nil {
+		return Secret{}, err
+	}
+	if value == "" {
+		return Secret{}, ErrEmptyValue
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records, err := s.load()
+	if err != nil {
+		return Secret{}, err
+	}
+
+	now := time.Now()
+	r, exists := records[name]
+	if !exists {
+		r.CreatedAt = now
+	}
+	r.Value = value
+	r.UpdatedAt = now
+	records[name] = r
+
+	if err := s.save(records); err != nil {
+		return Secret{}, err
+	}
+
+	return Secret{Name: name, CreatedAt: r.CreatedAt, UpdatedAt: r.UpdatedAt}, nil
+}
+
+func (s *Store) Delete(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records, err := s.load()
+	if err != nil {
+		return err
+	}
+	if _, ok := records[name]; !ok {
+		return ErrNotFound
+	}
+	delete(records, name)
+
+	return s.save(records)
+}
+
+// Resolve returns the values of the named secrets.
+func (s *Store) Resolve(names []string) (map[string]string, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]string, len(names))
+	for _, name := range names {
+		r, ok := records[name]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrNotFound, name)
+		}
+		values[name] = r.Value
+	}
+
+	return values, nil
+}
+
+// Redactor replaces every known secret value with its placeholder. Values are
+// also matched in their JSON-escaped form since results travel as JSON.
+func Redactor(values map[string]string) *strings.Replacer {
+	type pair struct{ value, placeholder string }
+	var pairs []pair
+	for name, value := range values {
+		if value == "" {
+			continue
+		}
+		placeholder := "{{secret:" + name + "}}"
+		pairs = append(pairs, pair{value, placeholder})
+		if escaped, err := json.Marshal(value); err == nil {
+			if inner := string(escaped[1 : len(escaped)-1]); inner != value {
+				pairs = append(pairs, pair{inner, placeholder})
+			}
+		}
+	}
+
+	// Longer values first so a secret that contains another is not cut in half
+	sort.Slice(pairs, func(i, j int) bool {
+		return len(pairs[i].value) > len(pairs[j].value)
+	})
+
+	oldnew := make([]string, 0, len(pairs)*2)
+	for _, p := range pairs {
+		oldnew = append(oldnew, p.value, p.placeholder)
+	}
+	return strings.NewReplacer(oldnew...)
+}
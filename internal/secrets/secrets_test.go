@@ -0,0 +1,164 @@
+package secrets
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"brian-nunez/bcode/internal/store"
+)
+
+func newTestStore(t *testing.T) *Store {
+	t.Helper()
+
+	s, err := NewStore(filepath.Join(t.TempDir(), "secrets.enc"), bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestResolve(t *testing.T) {
+	s := newTestStore(t)
+	for name, value := range map[string]string{"github_password": "hunter2", "api-key": "k-123"} {
+		if _, err := s.Set(name, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		names []string
+		want  map[string]string
+		err   error
+	}{
+		{name: "none", names: nil, want: nil},
+		{name: "one", names: []string{"api-key"}, want: map[string]string{"api-key": "k-123"}},
+		{name: "all", names: []string{"api-key", "github_password"}, want: map[string]string{"api-key": "k-123", "github_password": "hunter2"}},
+		{name: "missing", names: []string{"api-key", "gitlab_password"}, err: ErrNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.Resolve(tt.names)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Resolve returned %v, want %v", err, tt.err)
+			}
+			if tt.err != nil {
+				if got != nil {
+					t.Errorf("Resolve returned values with an error: %v", got)
+				}
+				return
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("Resolve = %v, want %v", got, tt.want)
+			}
+			for name, value := range tt.want {
+				if got[name] != value {
+					t.Errorf("Resolve[%s] = %q, want %q", name, got[name], value)
+				}
+			}
+		})
+	}
+}
+
+func TestResolveUnreadableStore(t *testing.T) {
+	s := newTestStore(t)
+	if _, err := s.Set("token", "abc"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The file is sealed with another key
+	other, err := NewStore(s.path, bytes.Repeat([]byte{2}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.Resolve([]string{"token"}); !errors.Is(err, store.ErrInvalidCiphertext) {
+		t.Errorf("Resolve with the wrong key returned %v, want ErrInvalidCiphertext", err)
+	}
+
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("abc")) {
+		t.Error("secrets.enc holds a value in the clear")
+	}
+}
+
+func TestSetAndDelete(t *testing.T) {
+	s := newTestStore(t)
+
+	for _, tt := range []struct {
+		name, value string
+		want        error
+	}{
+		{"", "x", ErrInvalidName},
+		{"has space", "x", ErrInvalidName},
+		{"ok", "", ErrEmptyValue},
+	} {
+		if _, err := s.Set(tt.name, tt.value); !errors.Is(err, tt.want) {
+			t.Errorf("Set(%q, %q) returned %v, want %v", tt.name, tt.value, err, tt.want)
+		}
+	}
+
+	created, err := s.Set("token", "one")
+	if err != nil {
+		t.Fatal(err)
+	}
+	updated, err := s.Set("token", "two")
+	if err != nil || !updated.CreatedAt.Equal(created.CreatedAt) {
+		t.Errorf("Set of an existing secret = %+v, %v, want its creation time kept", updated, err)
+	}
+	if values, _ := s.Resolve([]string{"token"}); values["token"] != "two" {
+		t.Errorf("Resolve = %v, want the new value", values)
+	}
+
+	if err := s.Delete("token"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete("token"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Delete of a deleted secret returned %v, want ErrNotFound", err)
+	}
+	if list, err := s.List(); err != nil || len(list) != 0 {
+		t.Errorf("List = %v, %v, want no secrets", list, err)
+	}
+}
+
+func TestReferences(t *testing.T) {
+	got := References("log in as {{secret:user}} with {{secret:password}}", "{{secret:user}} {{secret:bad name}} {{ secret:spaced }}")
+	if want := []string{"user", "password"}; !slices.Equal(got, want) {
+		t.Errorf("References = %v, want %v", got, want)
+	}
+}
+
+func TestRedactor(t *testing.T) {
+	redactor := Redactor(map[string]string{
+		"password": `pa"ss\word`,
+		"pin":      "1234",
+		"long_pin": "123456",
+		"empty":    "",
+	})
+
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"plain", "typed pa\"ss\\word into the form", "typed {{secret:password}} into the form"},
+		{"JSON-escaped", `{"value":"pa\"ss\\word"}`, `{"value":"{{secret:password}}"}`},
+		{"longer value first", "pins 123456 and 1234", "pins {{secret:long_pin}} and {{secret:pin}}"},
+		{"no secrets", "nothing to hide", "nothing to hide"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := redactor.Replace(tt.text); got != tt.want {
+				t.Errorf("Replace(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
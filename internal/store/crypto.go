@@ -0,0 +1,95 @@
+package store
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const keySize = 32
+
+var ErrInvalidCiphertext = errors.New("invalid ciphertext")
+
+// MasterKey returns the key used to encrypt data at rest. It is read from
+// BCODE_MASTER_KEY (base64) or from master.key in the data directory, which is
+// generated on first use.
+func MasterKey() ([]byte, error) {
+	if encoded := os.Getenv("BCODE_MASTER_KEY"); encoded != "" {
+		return decodeKey(encoded)
+	}
+
+	path := filepath.Join(DataDir(), "master.key")
+	if data, err := os.ReadFile(path); err == nil {
+		return decodeKey(string(data))
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	key := make([]byte, keySize)
+	rand.Read(key)
+
+	if err := os.MkdirAll(DataDir(), 0o700); err != nil {
+		return nil, err
+	}
+	encoded := base64.StdEncoding.EncodeToString(key)
+	if err := os.WriteFile(path, []byte(encoded+"\n"), 0o600); err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+func decodeKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("master key is not valid base64: %w", err)
+	}
+	if len(key) != keySize {
+		return nil, fmt.Errorf("master key must be %d bytes, got %d", keySize, len(key))
+	}
+	return key, nil
+}
+
+// Seal encrypts plaintext with AES-256-GCM, prefixing the random nonce.
+func Seal(key, plaintext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	rand.Read(nonce)
+
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func Open(key, sealed []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, ErrInvalidCiphertext
+	}
+
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, ErrInvalidCiphertext
+	}
+	return plaintext, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,156 @@
+package store
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, keySize)
+}
+
+func TestSealOpen(t *testing.T) {
+	key := testKey(1)
+
+	for _, plaintext := range []string{"", "hunter2", strings.Repeat("long secret ", 1000)} {
+		sealed, err := Seal(key, []byte(plaintext))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(plaintext) > 0 && bytes.Contains(sealed, []byte(plaintext)) {
+			t.Errorf("sealed data contains the plaintext %q", plaintext)
+		}
+		opened, err := Open(key, sealed)
+		if err != nil || string(opened) != plaintext {
+			t.Errorf("Open = %q, %v, want %q", opened, err, plaintext)
+		}
+	}
+
+	// Every seal uses a new nonce
+	first, _ := Seal(key, []byte("same"))
+	second, _ := Seal(key, []byte("same"))
+	if bytes.Equal(first, second) {
+		t.Error("sealing the same plaintext twice gave the same ciphertext")
+	}
+}
+
+func TestOpenRejectsTamperedData(t *testing.T) {
+	key := testKey(1)
+	sealed, err := Seal(key, []byte("hunter2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	flip := func(i int) []byte {
+		tampered := bytes.Clone(sealed)
+		tampered[i] ^= 1
+		return tampered
+	}
+
+	tests := []struct {
+		name   string
+		key    []byte
+		sealed []byte
+	}{
+		{"wrong key", testKey(2), sealed},
+		{"nonce changed", key, flip(0)},
+		{"ciphertext changed", key, flip(len(sealed) / 2)},
+		{"tag changed", key, flip(len(sealed) - 1)},
+		{"truncated", key, sealed[:len(sealed)-1]},
+		{"shorter than a nonce", key, sealed[:4]},
+		{"empty", key, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if opened, err := Open(tt.key, tt.sealed); !errors.Is(err, ErrInvalidCiphertext) {
+				t.Errorf("Open = %q, %v, want ErrInvalidCiphertext", opened, err)
+			}
+		})
+	}
+}
+
+func TestSealRejectsBadKeys(t *testing.T) {
+	for _, size := range []int{0, 7, 33} {
+		if _, err := Seal(make([]byte, size), []byte("x")); err == nil {
+			t.Errorf("Seal accepted a %d byte key", size)
+		}
+		if _, err := Open(make([]byte, size), make([]byte, 64)); err == nil {
+			t.Errorf("Open accepted a %d byte key", size)
+		}
+	}
+}
+
+func TestMasterKeyFromEnv(t *testing.T) {
+	t.Setenv("DATA_DIR", t.TempDir())
+	key := testKey(7)
+
+	tests := []struct {
+		name    string
+		env     string
+		wantErr bool
+	}{
+		{"valid", base64.StdEncoding.EncodeToString(key), false},
+		{"surrounding whitespace", " " + base64.StdEncoding.EncodeToString(key) + "\n", false},
+		{"not base64", "not a key!", true},
+		{"too short", base64.StdEncoding.EncodeToString(key[:16]), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("BCODE_MASTER_KEY", tt.env)
+			got, err := MasterKey()
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("MasterKey accepted %q", tt.env)
+				}
+				return
+			}
+			if err != nil || !bytes.Equal(got, key) {
+				t.Errorf("MasterKey = %x, %v, want %x", got, err, key)
+			}
+		})
+	}
+
+	if _, err := os.Stat(filepath.Join(DataDir(), "master.key")); !os.IsNotExist(err) {
+		t.Errorf("a key was written although BCODE_MASTER_KEY was set: %v", err)
+	}
+}
+
+func TestMasterKeyFile(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "data")
+	t.Setenv("DATA_DIR", dir)
+	t.Setenv("BCODE_MASTER_KEY", "")
+
+	generated, err := MasterKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(generated) != keySize {
+		t.Fatalf("generated a %d byte key, want %d", len(generated), keySize)
+	}
+	info, err := os.Stat(filepath.Join(dir, "master.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Errorf("master.key has mode %o, want 600", perm)
+	}
+
+	loaded, err := MasterKey()
+	if err != nil || !bytes.Equal(loaded, generated) {
+		t.Errorf("MasterKey read back %x, %v, want the generated %x", loaded, err, generated)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "master.key"), []byte("short\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := MasterKey(); err == nil {
+		t.Error("MasterKey accepted a corrupt master.key")
+	}
+}
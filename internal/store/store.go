@@ -0,0 +1,34 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// DataDir is the directory holding everything the server persists locally.
+func DataDir() string {
+	if dir := os.Getenv("DATA_DIR"); dir != "" {
+		return dir
+	}
+	return "data"
+}
+
+// WriteFileAtomic writes to a temporary file in the same directory and renames
+// it into place, so a crash never leaves a torn file behind.
+func WriteFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
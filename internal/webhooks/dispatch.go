@@ -0,0 +1,274 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"brian-nunez/bcode/internal/jobs"
+	"brian-nunez/bcode/internal/outbound"
+)
+
+// Headers sent with every delivery.
+const (
+	EventHeader    = "X-Webhook-Event"
+	DeliveryHeader = "X-Webhook-Delivery"
+	// SignatureHeader carries "t=<unix time>,v1=<hex HMAC-SHA256 of
+	// "<unix time>.<body>" keyed with the subscription's secret>"
+	SignatureHeader = "X-Webhook-Signature"
+)
+
+const (
+	deliveryTimeout = 10 * time.Second
+	// A delivery is tried this many times, waiting twice as long after each
+	// failure, starting with retryBackoff.
+	maxAttempts  = 6
+	retryBackoff = 2 * time.Second
+)
+
+// Payload is the body of a delivery.
+type Payload struct {
+	ID        string    `json:"id"`
+	Event     string    `json:"event"`
+	CreatedAt time.Time `json:"created_at"`
+	Job       jobs.Job  `json:"job"`
+}
+
+// How often changes to the delivery log are written out.
+const saveInterval = time.Second
+
+// Dispatcher delivers job events to their subscriptions. Deliveries of
+// different events run independently, so receivers should not rely on their
+// order.
+type Dispatcher struct {
+	ctx     context.Context
+	store   *Store
+	client  *http.Client
+	backoff time.Duration
+	now     func() time.Time
+
+	// queued holds the events JobChanged has not handed over yet
+	mu     sync.Mutex
+	queued []queuedEvent
+	wake   chan struct{}
+}
+
+type queuedEvent struct {
+	job   jobs.Job
+	event string
+	last  bool
+}
+
+// NewDispatcher delivers events until ctx is done; retries still waiting
+// then are given up.
+func NewDispatcher(ctx context.Context, store *Store) *Dispatcher {
+	d := &Dispatcher{
+		ctx:     ctx,
+		store:   store,
+		client:  outbound.Client(deliveryTimeout),
+		backoff: retryBackoff,
+		now:     time.Now,
+		wake:    make(chan struct{}, 1),
+	}
+	go d.run()
+	return d
+}
+
+// JobChanged queues the event a job's status change makes, if any. It is
+// meant for jobs.Registry.OnStatusChange, and never blocks on the store or
+// the network.
+func (d *Dispatcher) JobChanged(job jobs.Job, previous jobs.Status) {
+	event, last := eventOf(job, previous)
+	if event == "" {
+		return
+	}
+
+	d.mu.Lock()
+	d.queued = append(d.queued, queuedEvent{job: job, event: event, last: last})
+	d.mu.Unlock()
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run starts the deliveries of queued events, in order, and writes the
+// delivery log out every saveInterval while it changes.
+func (d *Dispatcher) run() {
+	ticker := time.NewTicker(saveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.ctx.Done():
+			d.store.flushOrLog()
+			return
+		case <-ticker.C:
+			d.store.flushOrLog()
+		case <-d.wake:
+			d.mu.Lock()
+			queued := d.queued
+			d.queued = nil
+			d.mu.Unlock()
+			for _, queued := range queued {
+				d.dispatch(queued.job, queued.event, queued.last)
+			}
+		}
+	}
+}
+
+// dispatch starts delivering an event to each of its subscriptions.
+func (d *Dispatcher) dispatch(job jobs.Job, event string, last bool) {
+	subscriptions := d.store.subscriptions(job.Workspace, job.ID, event, last)
+	if len(subscriptions) == 0 {
+		return
+	}
+
+	// The screenshot of a prompt is for the live view, not for receivers
+	if job.Prompt != nil {
+		prompt := *job.Prompt
+		prompt.Image = ""
+		job.Prompt = &prompt
+	}
+	payload := Payload{ID: randomHex(8), Event: event, CreatedAt: d.now(), Job: job}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("could not encode %s event of job %s: %v", event, job.ID, err)
+		return
+	}
+
+	for _, subscription := range subscriptions {
+		delivery := &Delivery{
+			ID:        randomHex(8),
+			EventID:   payload.ID,
+			Event:     event,
+			Workspace: job.Workspace,
+			JobID:     job.ID,
+			WebhookID: subscription.WebhookID,
+			URL:       subscription.URL,
+			Status:    DeliveryPending,
+			Attempts:  []Attempt{},
+			CreatedAt: payload.CreatedAt,
+		}
+		d.store.addDelivery(delivery)
+		go d.deliver(delivery, subscription.Secret, body)
+	}
+}
+
+// eventOf names the event of a status change, and whether it is the job's
+// last.
+func eventOf(job jobs.Job, previous jobs.Status) (string, bool) {
+	switch {
+	case job.Status == jobs.StatusRunning && previous == jobs.StatusQueued:
+		return EventStarted, false
+	case job.Status == jobs.StatusPaused && job.Prompt != nil:
+		return EventNeedsInput, false
+	case job.Status == jobs.StatusSucceeded:
+		return EventSucceeded, true
+	case job.Status == jobs.StatusFailed:
+		return EventFailed, true
+	}
+	return "", false
+}
+
+// deliver tries a delivery until the receiver accepts it, answers that it
+// never will, or the attempts run out.
+func (d *Dispatcher) deliver(delivery *Delivery, secret string, body []byte) {
+	wait := d.backoff
+	for number := 1; ; number++ {
+		attempt, retry := d.attempt(delivery, secret, body)
+		done := attempt.Error == "" || !retry || number == maxAttempts
+		d.store.updateDelivery(delivery, func(delivery *Delivery) {
+			delivery.Attempts = append(delivery.Attempts, attempt)
+			delivery.NextAttemptAt = nil
+			switch {
+			case attempt.Error == "":
+				delivery.Status = DeliveryDelivered
+				delivery.DeliveredAt = &attempt.At
+			case done:
+				delivery.Status = DeliveryFailed
+			default:
+				next := attempt.At.Add(wait)
+				delivery.NextAttemptAt = &next
+			}
+		})
+		if done {
+			if attempt.Error != "" {
+				log.Printf("could not deliver %s of job %s to %s: %s", delivery.Event, delivery.JobID, delivery.URL, attempt.Error)
+			}
+			return
+		}
+
+		select {
+		case <-d.ctx.Done():
+			d.store.updateDelivery(delivery, func(delivery *Delivery) {
+				delivery.Status = DeliveryFailed
+				delivery.NextAttemptAt = nil
+			})
+			return
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+}
+
+// attempt posts the delivery once, reporting whether a failure is worth
+// retrying.
+func (d *Dispatcher) attempt(delivery *Delivery, secret string, body []byte) (attempt Attempt, retry bool) {
+	attempt.At = d.now()
+	defer func() {
+		attempt.DurationMS = d.now().Sub(attempt.At).Milliseconds()
+	}()
+
+	req, err := http.NewRequestWithContext(d.ctx, http.MethodPost, delivery.URL, bytes.NewReader(body))
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt, false
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "bcode-webhooks")
+	req.Header.Set(EventHeader, delivery.Event)
+	req.Header.Set(DeliveryHeader, delivery.ID)
+	req.Header.Set(SignatureHeader, Sign(secret, attempt.At, body))
+
+	res, err := d.client.Do(req)
+	if err != nil {
+		attempt.Error = err.Error()
+		// The receiver's address will not become public by asking again
+		return attempt, !errors.Is(err, outbound.ErrForbiddenAddress)
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(res.Body, 64<<10))
+
+	attempt.StatusCode = res.StatusCode
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return attempt, false
+	}
+	attempt.Error = fmt.Sprintf("the receiver answered %s", res.Status)
+	// Other client errors will not go away by asking again
+	retry = res.StatusCode == http.StatusRequestTimeout || res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500
+	return attempt, retry
+}
+
+// Sign returns the signature header of a body sent at the given time.
+// Receivers recompute it with their secret, compare it in constant time and
+// reject old timestamps to stop replays.
+func Sign(secret string, at time.Time, body []byte) string {
+	timestamp := strconv.FormatInt(at.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return fmt.Sprintf("t=%s,v1=%s", timestamp, hex.EncodeToString(mac.Sum(nil)))
+}
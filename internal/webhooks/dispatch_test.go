@@ -0,0 +1,363 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"brian-nunez/bcode/internal/jobs"
+)
+
+const (
+	testWorkspace = "acme"
+	testSecret    = "whsec_0123456789abcdef"
+)
+
+// receiver is a webhook endpoint answering each delivery with the next of
+// its handlers, and the last one after that.
+type receiver struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	handlers []http.HandlerFunc
+	requests []receivedRequest
+}
+
+type receivedRequest struct {
+	header http.Header
+	body   []byte
+}
+
+func newReceiver(t *testing.T, handlers ...http.HandlerFunc) *receiver {
+	t.Helper()
+
+	r := &receiver{handlers: handlers}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+
+		r.mu.Lock()
+		r.requests = append(r.requests, receivedRequest{header: req.Header.Clone(), body: body})
+		handler := r.handlers[min(len(r.requests), len(r.handlers))-1]
+		r.mu.Unlock()
+
+		handler(w, req)
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+func (r *receiver) received() []receivedRequest {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]receivedRequest(nil), r.requests...)
+}
+
+func status(code int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(code)
+	}
+}
+
+// newTestDispatcher dispatches to receivers on this machine, retrying after
+// milliseconds instead of seconds.
+func newTestDispatcher(t *testing.T) (*Dispatcher, *Store) {
+	t.Helper()
+
+	store, err := NewStore(filepath.Join(t.TempDir(), "webhooks.json"), bytes.Repeat([]byte{7}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	d := NewDispatcher(ctx, store)
+	d.client = &http.Client{Timeout: 200 * time.Millisecond}
+	d.backoff = time.Millisecond
+	return d, store
+}
+
+func finishedJob(id string) jobs.Job {
+	return jobs.Job{ID: id, Workspace: testWorkspace, Status: jobs.StatusSucceeded}
+}
+
+// settled waits for the workspace's deliveries to finish, successfully or
+// not, and returns them.
+func settled(t *testing.T, store *Store, count int) []Delivery {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		deliveries := store.Deliveries(testWorkspace)
+		done := len(deliveries) == count
+		for _, delivery := range deliveries {
+			done = done && delivery.Status != DeliveryPending
+		}
+		if done {
+			return deliveries
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %d deliveries, have %+v", count, deliveries)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestDeliverySignature(t *testing.T) {
+	d, store := newTestDispatcher(t)
+	receiver := newReceiver(t, status(http.StatusNoContent))
+	webhook, secret, err := store.Create(testWorkspace, receiver.URL, []string{EventSucceeded}, testSecret, "user:admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d.JobChanged(finishedJob("0123456789abcdef"), jobs.StatusRunning)
+	deliveries := settled(t, store, 1)
+
+	requests := receiver.received()
+	if len(requests) != 1 {
+		t.Fatalf("receiver got %d requests, want 1", len(requests))
+	}
+	req := requests[0]
+
+	// Verify the signature as a receiver would
+	timestamp, signature, ok := strings.Cut(req.header.Get(SignatureHeader), ",v1=")
+	timestamp, hasTime := strings.CutPrefix(timestamp, "t=")
+	if !ok || !hasTime {
+		t.Fatalf("signature header %q is not t=<time>,v1=<hex>", req.header.Get(SignatureHeader))
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(req.body)
+	if want := hex.EncodeToString(mac.Sum(nil)); !hmac.Equal([]byte(signature), []byte(want)) {
+		t.Errorf("signature %s does not match the body, want %s", signature, want)
+	}
+	if sent, err := strconv.ParseInt(timestamp, 10, 64); err != nil || time.Since(time.Unix(sent, 0)) > time.Minute {
+		t.Errorf("signature timestamp %q is not the time of sending", timestamp)
+	}
+
+	var payload Payload
+	if err := json.Unmarshal(req.body, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Event != EventSucceeded || payload.Job.ID != "0123456789abcdef" || payload.ID != deliveries[0].EventID {
+		t.Errorf("payload = %+v", payload)
+	}
+	if req.header.Get(EventHeader) != EventSucceeded || req.header.Get(DeliveryHeader) != deliveries[0].ID {
+		t.Errorf("event headers = %s, %s", req.header.Get(EventHeader), req.header.Get(DeliveryHeader))
+	}
+	if deliveries[0].WebhookID != webhook.ID {
+		t.Errorf("delivery of webhook %q, want %q", deliveries[0].WebhookID, webhook.ID)
+	}
+}
+
+func TestDeliveryRetries(t *testing.T) {
+	timeout := func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}
+
+	tests := []struct {
+		name     string
+		handlers []http.HandlerFunc
+		status   string
+		codes    []int
+	}{
+		{
+			name:     "server errors",
+			handlers: []http.HandlerFunc{status(http.StatusServiceUnavailable), status(http.StatusBadGateway), status(http.StatusOK)},
+			status:   DeliveryDelivered,
+			codes:    []int{503, 502, 200},
+		},
+		{
+			name:     "timeout",
+			handlers: []http.HandlerFunc{timeout, status(http.StatusOK)},
+			status:   DeliveryDelivered,
+			codes:    []int{0, 200},
+		},
+		{
+			name:     "too many requests",
+			handlers: []http.HandlerFunc{status(http.StatusTooManyRequests), status(http.StatusAccepted)},
+			status:   DeliveryDelivered,
+			codes:    []int{429, 202},
+		},
+		{
+			name:     "client error",
+			handlers: []http.HandlerFunc{status(http.StatusGone)},
+			status:   DeliveryFailed,
+			codes:    []int{410},
+		},
+		{
+			name:     "attempts run out",
+			handlers: []http.HandlerFunc{status(http.StatusInternalServerError)},
+			status:   DeliveryFailed,
+			codes:    []int{500, 500, 500, 500, 500, 500},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, store := newTestDispatcher(t)
+			receiver := newReceiver(t, tt.handlers...)
+			if _, _, err := store.Create(testWorkspace, receiver.URL, nil, testSecret, "user:admin"); err != nil {
+				t.Fatal(err)
+			}
+
+			d.JobChanged(finishedJob("0123456789abcdef"), jobs.StatusRunning)
+			delivery := settled(t, store, 1)[0]
+
+			if delivery.Status != tt.status {
+				t.Errorf("delivery %s, want %s", delivery.Status, tt.status)
+			}
+			var codes []int
+			for i, attempt := range delivery.Attempts {
+				codes = append(codes, attempt.StatusCode)
+				if failed := attempt.StatusCode < 200 || attempt.StatusCode >= 300; failed != (attempt.Error != "") {
+					t.Errorf("attempt %d answered %d with error %q", i+1, attempt.StatusCode, attempt.Error)
+				}
+			}
+			if !slices.Equal(codes, tt.codes) {
+				t.Errorf("attempts answered %v, want %v", codes, tt.codes)
+			}
+			if len(receiver.received()) != len(tt.codes) {
+				t.Errorf("receiver got %d requests, want %d", len(receiver.received()), len(tt.codes))
+			}
+			if delivery.Status == DeliveryDelivered && delivery.DeliveredAt == nil {
+				t.Error("delivered without a delivery time")
+			}
+		})
+	}
+}
+
+func TestDeliveryLog(t *testing.T) {
+	d, store := newTestDispatcher(t)
+	workspaceReceiver := newReceiver(t, status(http.StatusOK))
+	jobReceiver := newReceiver(t, status(http.StatusOK))
+	webhook, _, err := store.Create(testWorkspace, workspaceReceiver.URL, []string{EventStarted, EventSucceeded}, "", "user:admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SubscribeJob("0123456789abcdef", jobReceiver.URL, []string{EventSucceeded}, testSecret); err != nil {
+		t.Fatal(err)
+	}
+	// Another workspace's webhook hears nothing of this one's jobs
+	if _, _, err := store.Create("other", workspaceReceiver.URL, nil, "", "user:admin"); err != nil {
+		t.Fatal(err)
+	}
+
+	started := jobs.Job{ID: "0123456789abcdef", Workspace: testWorkspace, Status: jobs.StatusRunning}
+	d.JobChanged(started, jobs.StatusQueued)
+	// Changes that make no event are not logged
+	d.JobChanged(started, jobs.StatusPaused)
+	d.JobChanged(finishedJob("0123456789abcdef"), jobs.StatusRunning)
+	deliveries := settled(t, store, 3)
+
+	type entry struct{ event, webhookID, url string }
+	var got []entry
+	for _, delivery := range deliveries {
+		got = append(got, entry{delivery.Event, delivery.WebhookID, delivery.URL})
+		if delivery.Status != DeliveryDelivered || len(delivery.Attempts) != 1 || delivery.JobID != "0123456789abcdef" {
+			t.Errorf("delivery = %+v, want delivered on the first attempt", delivery)
+		}
+	}
+	// Newest first, and the job's own subscription has no webhook
+	want := []entry{
+		{EventSucceeded, webhook.ID, workspaceReceiver.URL},
+		{EventSucceeded, "", jobReceiver.URL},
+		{EventStarted, webhook.ID, workspaceReceiver.URL},
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("log = %+v, want %+v", got, want)
+	}
+	if deliveries[0].EventID != deliveries[1].EventID {
+		t.Error("the deliveries of one event have different event IDs")
+	}
+	if other := store.Deliveries("other"); len(other) != 0 {
+		t.Errorf("the other workspace logged %+v", other)
+	}
+
+	// The log is written out in the background
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		reopened, err := NewStore(store.path, store.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if saved := reopened.Deliveries(testWorkspace); len(saved) == 3 && saved[0].Status == DeliveryDelivered && saved[1].Status == DeliveryDelivered {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the delivery log was not saved")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Jobs change status in the middle of running; their events must not wait
+// for receivers.
+func TestJobChangedDoesNotBlock(t *testing.T) {
+	d, store := newTestDispatcher(t)
+	release := make(chan struct{})
+	receiver := newReceiver(t, func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	})
+	if _, _, err := store.Create(testWorkspace, receiver.URL, nil, testSecret, "user:admin"); err != nil {
+		t.Fatal(err)
+	}
+	d.client.Timeout = 0
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 50; i++ {
+			d.JobChanged(finishedJob(strconv.Itoa(i)), jobs.StatusRunning)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("JobChanged blocked on a receiver that does not answer")
+	}
+	close(release)
+	settled(t, store, 50)
+}
+
+func TestDeliveryToPrivateAddressIsRefused(t *testing.T) {
+	store, err := NewStore(filepath.Join(t.TempDir(), "webhooks.json"), bytes.Repeat([]byte{7}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// The production client, which may only reach public addresses
+	d := NewDispatcher(ctx, store)
+	d.backoff = time.Millisecond
+
+	receiver := newReceiver(t, status(http.StatusOK))
+	if err := store.SubscribeJob("0123456789abcdef", receiver.URL, nil, testSecret); err != nil {
+		t.Fatal(err)
+	}
+	d.JobChanged(finishedJob("0123456789abcdef"), jobs.StatusRunning)
+
+	delivery := settled(t, store, 1)[0]
+	if delivery.Status != DeliveryFailed || len(delivery.Attempts) != 1 || !strings.Contains(delivery.Attempts[0].Error, "not publicly routable") {
+		t.Errorf("delivery = %+v, want one refused attempt", delivery)
+	}
+	if len(receiver.received()) != 0 {
+		t.Error("the delivery reached the receiver")
+	}
+}
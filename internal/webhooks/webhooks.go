@@ -0,0 +1,422 @@
+package webhooks
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"brian-nunez/bcode/internal/store"
+)
+
+// Events a webhook can subscribe to.
+const (
+	EventStarted    = "job.started"
+	EventSucceeded  = "job.succeeded"
+	EventFailed     = "job.failed"
+	EventNeedsInput = "job.needs_input"
+)
+
+var Events = []string{EventStarted, EventSucceeded, EventFailed, EventNeedsInput}
+
+// Statuses of a delivery.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+var (
+	ErrNotFound      = errors.New("webhook not found")
+	ErrInvalidURL    = errors.New("webhook URLs must be http or https URLs")
+	ErrInvalidEvents = fmt.Errorf("webhook events must be among %s", strings.Join(Events, ", "))
+	ErrInvalidSecret = errors.New("webhook secrets must be at least 16 characters")
+)
+
+// Deliveries kept per workspace, newest first.
+const maxDeliveries = 200
+
+const minSecretLength = 16
+
+// Webhook subscribes a URL to the events of every job in a workspace.
+type Webhook struct {
+	ID        string    `json:"id"`
+	Workspace string    `json:"workspace"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	CreatedBy string    `json:"created_by,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Subscription is where a job's events go: a workspace webhook, or a URL
+// given with the job itself.
+type Subscription struct {
+	WebhookID string
+	URL       string
+	Events    []string
+	Secret    string
+}
+
+func (s Subscription) wants(event string) bool {
+	return slices.Contains(s.Events, event)
+}
+
+// Delivery is an event sent, or being sent, to a subscription.
+type Delivery struct {
+	ID        string `json:"id"`
+	EventID   string `json:"event_id"`
+	Event     string `json:"event"`
+	Workspace string `json:"workspace"`
+	JobID     string `json:"job_id"`
+	// WebhookID is empty for subscriptions made with the job
+	WebhookID     string     `json:"webhook_id,omitempty"`
+	URL           string     `json:"url"`
+	Status        string     `json:"status"`
+	Attempts      []Attempt  `json:"attempts"`
+	NextAttemptAt *time.Time `json:"next_attempt_at,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	DeliveredAt   *time.Time `json:"delivered_at,omitempty"`
+}
+
+// Attempt is one try at a delivery.
+type Attempt struct {
+	At         time.Time `json:"at"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	DurationMS int64     `json:"duration_ms"`
+}
+
+// Store keeps workspace webhooks, with their secrets encrypted, and the
+// delivery log in webhooks.json in the data directory. Webhooks are saved as
+// they change; the log is saved by Flush. Subscriptions made with a job only
+// live as long as the job does in this server instance.
+type Store struct {
+	path string
+	key  []byte
+
+	mu         sync.Mutex
+	webhooks   map[string]*record
+	deliveries map[string][]*Delivery
+	jobs       map[string][]Subscription
+	// dirty is set while the log has changes that are not saved
+	dirty bool
+	now   func() time.Time
+}
+
+type record struct {
+	Webhook
+	Secret []byte `json:"secret"`
+}
+
+type records struct {
+	Webhooks   []*record              `json:"webhooks"`
+	Deliveries map[string][]*Delivery `json:"deliveries"`
+}
+
+var (
+	defaultStore *Store
+	defaultErr   error
+	defaultOnce  sync.Once
+)
+
+// Default returns the store under the server's data directory.
+func Default() (*Store, error) {
+	defaultOnce.Do(func() {
+		key, err := store.MasterKey()
+		if err != nil {
+			defaultErr = err
+			return
+		}
+		defaultStore, defaultErr = NewStore(filepath.Join(store.DataDir(), "webhooks.json"), key)
+	})
+	return defaultStore, defaultErr
+}
+
+func NewStore(path string, key []byte) (*Store, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+
+	s := &Store{
+		path:       path,
+		key:        key,
+		webhooks:   map[string]*record{},
+		deliveries: map[string][]*Delivery{},
+		jobs:       map[string][]Subscription{},
+		now:        time.Now,
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var saved records
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, fmt.Errorf("invalid webhooks %s: %w", path, err)
+	}
+	for _, webhook := range saved.Webhooks {
+		s.webhooks[webhook.ID] = webhook
+	}
+	for workspace, deliveries := range saved.Deliveries {
+		// Retries do not survive a restart
+		for _, delivery := range deliveries {
+			if delivery.Status == DeliveryPending {
+				delivery.Status = DeliveryFailed
+				delivery.NextAttemptAt = nil
+			}
+		}
+		s.deliveries[workspace] = deliveries
+	}
+
+	return s, nil
+}
+
+// save writes the store; callers hold s.mu.
+func (s *Store) save() error {
+	saved := records{Webhooks: []*record{}, Deliveries: s.deliveries}
+	for _, webhook := range s.webhooks {
+		saved.Webhooks = append(saved.Webhooks, webhook)
+	}
+	sort.Slice(saved.Webhooks, func(i, j int) bool {
+		return saved.Webhooks[i].ID < saved.Webhooks[j].ID
+	})
+	data, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := store.WriteFileAtomic(s.path, data); err != nil {
+		return err
+	}
+	s.dirty = false
+	return nil
+}
+
+// Flush saves the delivery log if it has changed since it was last saved.
+func (s *Store) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.dirty {
+		return nil
+	}
+	return s.save()
+}
+
+// validate checks a subscription, defaulting to every event.
+func validate(rawURL string, events []string, secret string) ([]string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, ErrInvalidURL
+	}
+	if len(events) == 0 {
+		events = Events
+	}
+	for _, event := range events {
+		if !slices.Contains(Events, event) {
+			return nil, fmt.Errorf("%w, got %q", ErrInvalidEvents, event)
+		}
+	}
+	if secret != "" && len(secret) < minSecretLength {
+		return nil, ErrInvalidSecret
+	}
+	return slices.Compact(slices.Sorted(slices.Values(events))), nil
+}
+
+// Create subscribes a URL to events of the workspace's jobs. Without a
+// secret one is generated; the secret is only ever returned here.
+func (s *Store) Create(workspace, rawURL string, events []string, secret, createdBy string) (Webhook, string, error) {
+	events, err := validate(rawURL, events, secret)
+	if err != nil {
+		return Webhook{}, "", err
+	}
+	if secret == "" {
+		secret = "whsec_" + randomHex(24)
+	}
+	sealed, err := store.Seal(s.key, []byte(secret))
+	if err != nil {
+		return Webhook{}, "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	webhook := &record{
+		Webhook: Webhook{
+			ID:        randomHex(8),
+			Workspace: workspace,
+			URL:       rawURL,
+			Events:    events,
+			CreatedBy: createdBy,
+			CreatedAt: s.now(),
+		},
+		Secret: sealed,
+	}
+	s.webhooks[webhook.ID] = webhook
+	if err := s.save(); err != nil {
+		delete(s.webhooks, webhook.ID)
+		return Webhook{}, "", err
+	}
+	return webhook.Webhook, secret, nil
+}
+
+// Delete removes a webhook. Its deliveries stay in the log.
+func (s *Store) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	webhook, ok := s.webhooks[id]
+	if !ok {
+		return ErrNotFound
+	}
+	delete(s.webhooks, id)
+	if err := s.save(); err != nil {
+		s.webhooks[id] = webhook
+		return err
+	}
+	return nil
+}
+
+func (s *Store) Get(id string) (Webhook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	webhook, ok := s.webhooks[id]
+	if !ok {
+		return Webhook{}, ErrNotFound
+	}
+	return webhook.Webhook, nil
+}
+
+// List returns every webhook, oldest first.
+func (s *Store) List() []Webhook {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := make([]Webhook, 0, len(s.webhooks))
+	for _, webhook := range s.webhooks {
+		list = append(list, webhook.Webhook)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].CreatedAt.Equal(list[j].CreatedAt) {
+			return list[i].CreatedAt.Before(list[j].CreatedAt)
+		}
+		return list[i].ID < list[j].ID
+	})
+	return list
+}
+
+// SubscribeJob sends the events of one job to a URL, signed with the
+// caller's secret.
+func (s *Store) SubscribeJob(jobID, rawURL string, events []string, secret string) error {
+	if secret == "" {
+		return ErrInvalidSecret
+	}
+	events, err := validate(rawURL, events, secret)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.jobs[jobID] = append(s.jobs[jobID], Subscription{URL: rawURL, Events: events, Secret: secret})
+	return nil
+}
+
+// subscriptions returns where an event of a job goes. A job's own
+// subscriptions are dropped with its last event.
+func (s *Store) subscriptions(workspace, jobID, event string, last bool) []Subscription {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var list []Subscription
+	for _, subscription := range s.jobs[jobID] {
+		if subscription.wants(event) {
+			list = append(list, subscription)
+		}
+	}
+	if last {
+		delete(s.jobs, jobID)
+	}
+
+	for _, webhook := range s.webhooks {
+		if webhook.Workspace != workspace || !slices.Contains(webhook.Events, event) {
+			continue
+		}
+		secret, err := store.Open(s.key, webhook.Secret)
+		if err != nil {
+			log.Printf("could not decrypt the secret of webhook %s: %v", webhook.ID, err)
+			continue
+		}
+		list = append(list, Subscription{
+			WebhookID: webhook.ID,
+			URL:       webhook.URL,
+			Events:    webhook.Events,
+			Secret:    string(secret),
+		})
+	}
+	return list
+}
+
+// Deliveries returns a workspace's delivery log, newest first.
+func (s *Store) Deliveries(workspace string) []Delivery {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := make([]Delivery, 0, len(s.deliveries[workspace]))
+	for _, delivery := range s.deliveries[workspace] {
+		copied := *delivery
+		copied.Attempts = slices.Clone(delivery.Attempts)
+		list = append(list, copied)
+	}
+	return list
+}
+
+// addDelivery logs a delivery about to be attempted.
+func (s *Store) addDelivery(delivery *Delivery) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deliveries := append([]*Delivery{delivery}, s.deliveries[delivery.Workspace]...)
+	if len(deliveries) > maxDeliveries {
+		deliveries = deliveries[:maxDeliveries]
+	}
+	s.deliveries[delivery.Workspace] = deliveries
+	s.dirty = true
+}
+
+// updateDelivery changes a logged delivery.
+func (s *Store) updateDelivery(delivery *Delivery, fn func(delivery *Delivery)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fn(delivery)
+	s.dirty = true
+}
+
+// flushOrLog flushes for the dispatcher, which has nobody to report a
+// failure to.
+func (s *Store) flushOrLog() {
+	if err := s.Flush(); err != nil {
+		log.Printf("could not save webhooks: %v", err)
+	}
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
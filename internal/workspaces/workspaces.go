@@ -0,0 +1,368 @@
+package workspaces
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"brian-nunez/bcode/internal/store"
+)
+
+// DefaultWorkspace always exists. It holds everything created before
+// workspaces were introduced, and its admins manage every workspace.
+const DefaultWorkspace = "default"
+
+var (
+	ErrInvalidName  = errors.New("workspace names may only contain lowercase letters, digits and '-'")
+	ErrNotFound     = errors.New("workspace not found")
+	ErrDefault      = errors.New("the default workspace cannot be deleted")
+	ErrInvalidQuota = errors.New("quotas cannot be negative")
+)
+
+var validName = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+// Quota limits what a workspace's jobs may use. Zero means unlimited.
+// Browser minutes and LLM tokens are counted per calendar month and jobs per
+// calendar day, both in UTC.
+type Quota struct {
+	MaxConcurrentJobs      int     `json:"max_concurrent_jobs,omitempty"`
+	JobsPerDay             int     `json:"jobs_per_day,omitempty"`
+	BrowserMinutesPerMonth float64 `json:"browser_minutes_per_month,omitempty"`
+	LLMTokensPerMonth      int64   `json:"llm_tokens_per_month,omitempty"`
+}
+
+// Workspace owns jobs, secrets, profiles and schedules, and the API keys and
+// users that work with them.
+type Workspace struct {
+	Name      string    `json:"name"`
+	Quota     Quota     `json:"quota"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Usage is what a workspace has used in the current day and month. Running
+// jobs are only known to this server instance and are not persisted.
+type Usage struct {
+	Running        int     `json:"running"`
+	Day            string  `json:"day"`
+	JobsToday      int     `json:"jobs_today"`
+	Month          string  `json:"month"`
+	BrowserMinutes float64 `json:"browser_minutes"`
+	LLMTokens      int64   `json:"llm_tokens"`
+}
+
+// roll starts new counters when the day or month has changed.
+func (u *Usage) roll(now time.Time) {
+	now = now.UTC()
+	if day := now.Format(time.DateOnly); u.Day != day {
+		u.Day = day
+		u.JobsToday = 0
+	}
+	if month := now.Format("2006-01"); u.Month != month {
+		u.Month = month
+		u.BrowserMinutes = 0
+		u.LLMTokens = 0
+	}
+}
+
+// QuotaError refuses a job whose workspace has used up one of its quotas.
+type QuotaError struct {
+	Workspace string
+	Quota     string
+	Limit     float64
+	Used      float64
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("workspace %q has used its %s quota (%s of %s)", e.Workspace, e.Quota, formatAmount(e.Used), formatAmount(e.Limit))
+}
+
+// Details describe the exhausted quota in API error responses.
+func (e *QuotaError) Details() map[string]string {
+	return map[string]string{
+		"workspace": e.Workspace,
+		"quota":     e.Quota,
+		"limit":     formatAmount(e.Limit),
+		"used":      formatAmount(e.Used),
+	}
+}
+
+func formatAmount(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+// Store keeps workspaces in workspaces.json and their usage in usage.json,
+// both in the data directory.
+type Store struct {
+	path      string
+	usagePath string
+
+	mu         sync.Mutex
+	workspaces map[string]*Workspace
+	usage      map[string]*Usage
+	now        func() time.Time
+}
+
+var (
+	defaultStore *Store
+	defaultErr   error
+	defaultOnce  sync.Once
+)
+
+// Default returns the store under the server's data directory.
+func Default() (*Store, error) {
+	defaultOnce.Do(func() {
+		dir := store.DataDir()
+		defaultStore, defaultErr = NewStore(filepath.Join(dir, "workspaces.json"), filepath.Join(dir, "usage.json"))
+	})
+	return defaultStore, defaultErr
+}
+
+func NewStore(path, usagePath string) (*Store, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+
+	s := &Store{
+		path:       path,
+		usagePath:  usagePath,
+		workspaces: map[string]*Workspace{},
+		usage:      map[string]*Usage{},
+		now:        time.Now,
+	}
+
+	var config struct {
+		Workspaces []*Workspace `json:"workspaces"`
+	}
+	if err := readJSON(path, &config); err != nil {
+		return nil, fmt.Errorf("invalid workspace config %s: %w", path, err)
+	}
+	for _, workspace := range config.Workspaces {
+		if err := ValidateName(workspace.Name); err != nil {
+			return nil, fmt.Errorf("workspace %q: %w", workspace.Name, err)
+		}
+		s.workspaces[workspace.Name] = workspace
+	}
+	if _, ok := s.workspaces[DefaultWorkspace]; !ok {
+		s.workspaces[DefaultWorkspace] = &Workspace{Name: DefaultWorkspace, CreatedAt: s.now()}
+	}
+
+	if err := readJSON(usagePath, &s.usage); err != nil {
+		return nil, fmt.Errorf("invalid workspace usage %s: %w", usagePath, err)
+	}
+	for _, usage := range s.usage {
+		usage.Running = 0
+	}
+
+	return s, nil
+}
+
+// readJSON leaves v untouched when the file does not exist.
+func readJSON(path string, v any) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func ValidateName(name string) error {
+	if !validName.MatchString(name) {
+		return ErrInvalidName
+	}
+	return nil
+}
+
+// saveWorkspaces and saveUsage write the store; callers hold s.mu.
+func (s *Store) saveWorkspaces() error {
+	config := struct {
+		Workspaces []Workspace `json:"workspaces"`
+	}{Workspaces: s.list()}
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return err
+	}
+	return store.WriteFileAtomic(s.path, data)
+}
+
+func (s *Store) saveUsage() error {
+	data, err := json.MarshalIndent(s.usage, "", "  ")
+	if err != nil {
+		return err
+	}
+	return store.WriteFileAtomic(s.usagePath, data)
+}
+
+func (s *Store) list() []Workspace {
+	list := make([]Workspace, 0, len(s.workspaces))
+	for _, workspace := range s.workspaces {
+		list = append(list, *workspace)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+func (s *Store) List() []Workspace {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.list()
+}
+
+func (s *Store) Get(name string) (Workspace, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	workspace, ok := s.workspaces[name]
+	if !ok {
+		return Workspace{}, false
+	}
+	return *workspace, true
+}
+
+// Put creates a workspace or replaces its quota.
+func (s *Store) Put(name string, quota Quota) (Workspace, error) {
+	if err := ValidateName(name); err != nil {
+		return Workspace{}, err
+	}
+	if quota.MaxConcurrentJobs < 0 || quota.JobsPerDay < 0 || quota.BrowserMinutesPerMonth < 0 || quota.LLMTokensPerMonth < 0 {
+		return Workspace{}, ErrInvalidQuota
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	previous, exists := s.workspaces[name]
+	workspace := &Workspace{Name: name, Quota: quota, CreatedAt: s.now()}
+	if exists {
+		workspace.CreatedAt = previous.CreatedAt
+	}
+	s.workspaces[name] = workspace
+	if err := s.saveWorkspaces(); err != nil {
+		if exists {
+			s.workspaces[name] = previous
+		} else {
+			delete(s.workspaces, name)
+		}
+		return Workspace{}, err
+	}
+	return *workspace, nil
+}
+
+// Delete removes a workspace. Whatever it owned stays on disk but can no
+// longer be reached, and its keys can no longer run jobs.
+func (s *Store) Delete(name string) error {
+	if name == DefaultWorkspace {
+		return ErrDefault
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	workspace, ok := s.workspaces[name]
+	if !ok {
+		return ErrNotFound
+	}
+	delete(s.workspaces, name)
+	if err := s.saveWorkspaces(); err != nil {
+		s.workspaces[name] = workspace
+		return err
+	}
+	return nil
+}
+
+// Usage returns a workspace's usage in the current period.
+func (s *Store) Usage(name string) Usage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return *s.usageOf(name)
+}
+
+// usageOf returns the live counters of a workspace; callers hold s.mu.
+func (s *Store) usageOf(name string) *Usage {
+	usage, ok := s.usage[name]
+	if !ok {
+		usage = &Usage{}
+		s.usage[name] = usage
+	}
+	usage.roll(s.now())
+	return usage
+}
+
+// Check reports whether a workspace may start another job. It refuses jobs
+// with a *QuotaError once a daily or monthly quota is used up, and returns
+// false while the workspace already runs as many jobs as it may. Browser
+// minutes and tokens are charged when jobs finish, so running jobs can take
+// a workspace past those quotas.
+func (s *Store) Check(name string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	workspace, ok := s.workspaces[name]
+	if !ok {
+		return false, fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	quota := workspace.Quota
+	usage := s.usageOf(name)
+
+	exceeded := func(quota string, limit, used float64) (bool, error) {
+		return false, &QuotaError{Workspace: name, Quota: quota, Limit: limit, Used: used}
+	}
+	switch {
+	case quota.JobsPerDay > 0 && usage.JobsToday >= quota.JobsPerDay:
+		return exceeded("jobs_per_day", float64(quota.JobsPerDay), float64(usage.JobsToday))
+	case quota.BrowserMinutesPerMonth > 0 && usage.BrowserMinutes >= quota.BrowserMinutesPerMonth:
+		return exceeded("browser_minutes_per_month", quota.BrowserMinutesPerMonth, usage.BrowserMinutes)
+	case quota.LLMTokensPerMonth > 0 && usage.LLMTokens >= quota.LLMTokensPerMonth:
+		return exceeded("llm_tokens_per_month", float64(quota.LLMTokensPerMonth), float64(usage.LLMTokens))
+	}
+
+	if quota.MaxConcurrentJobs > 0 && usage.Running >= quota.MaxConcurrentJobs {
+		return false, nil
+	}
+	return true, nil
+}
+
+// Start counts a job that Check let through.
+func (s *Store) Start(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	usage := s.usageOf(name)
+	usage.Running++
+	usage.JobsToday++
+	s.persistUsage()
+}
+
+// Finish charges a finished job's browser time and LLM tokens.
+func (s *Store) Finish(name string, browserTime time.Duration, llmTokens int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	usage := s.usageOf(name)
+	if usage.Running > 0 {
+		usage.Running--
+	}
+	usage.BrowserMinutes += browserTime.Minutes()
+	usage.LLMTokens += llmTokens
+	s.persistUsage()
+}
+
+// persistUsage saves usage without failing the job that changed it; callers
+// hold s.mu.
+func (s *Store) persistUsage() {
+	if err := s.saveUsage(); err != nil {
+		log.Printf("could not save workspace usage: %v", err)
+	}
+}
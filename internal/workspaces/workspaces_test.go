@@ -0,0 +1,243 @@
+package workspaces
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// clock is a time the tests move forward by hand.
+type clock struct {
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	return c.now
+}
+
+func newTestStore(t *testing.T, quota Quota) (*Store, *clock) {
+	t.Helper()
+
+	dir := t.TempDir()
+	store, err := NewStore(filepath.Join(dir, "workspaces.json"), filepath.Join(dir, "usage.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &clock{now: time.Date(2026, 3, 31, 23, 0, 0, 0, time.UTC)}
+	store.now = c.Now
+	if _, err := store.Put("acme", quota); err != nil {
+		t.Fatal(err)
+	}
+	return store, c
+}
+
+func TestCheckRefusesExhaustedQuotas(t *testing.T) {
+	tests := []struct {
+		name    string
+		quota   Quota
+		use     func(s *Store)
+		refused string
+	}{
+		{
+			name:  "unlimited",
+			quota: Quota{},
+			use: func(s *Store) {
+				for range 100 {
+					s.Start("acme")
+					s.Finish("acme", time.Hour, 1_000_000)
+				}
+			},
+		},
+		{
+			name:    "jobs per day",
+			quota:   Quota{JobsPerDay: 2},
+			use:     func(s *Store) { s.Start("acme"); s.Finish("acme", 0, 0); s.Start("acme") },
+			refused: "jobs_per_day",
+		},
+		{
+			name:    "browser minutes",
+			quota:   Quota{BrowserMinutesPerMonth: 10},
+			use:     func(s *Store) { s.Start("acme"); s.Finish("acme", 10*time.Minute, 0) },
+			refused: "browser_minutes_per_month",
+		},
+		{
+			name:  "browser minutes left",
+			quota: Quota{BrowserMinutesPerMonth: 10},
+			use:   func(s *Store) { s.Start("acme"); s.Finish("acme", 9*time.Minute, 0) },
+		},
+		{
+			name:    "LLM tokens",
+			quota:   Quota{LLMTokensPerMonth: 500},
+			use:     func(s *Store) { s.Start("acme"); s.Finish("acme", 0, 600) },
+			refused: "llm_tokens_per_month",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, _ := newTestStore(t, tt.quota)
+			tt.use(store)
+
+			ok, err := store.Check("acme")
+			if tt.refused == "" {
+				if !ok || err != nil {
+					t.Errorf("Check = %v, %v, want the job let through", ok, err)
+				}
+				return
+			}
+			var quotaErr *QuotaError
+			if !errors.As(err, &quotaErr) || quotaErr.Quota != tt.refused || quotaErr.Workspace != "acme" {
+				t.Fatalf("Check = %v, %v, want the %s quota refused", ok, err, tt.refused)
+			}
+			if details := quotaErr.Details(); details["quota"] != tt.refused || details["limit"] == "" || details["used"] == "" {
+				t.Errorf("Details() = %v", details)
+			}
+		})
+	}
+}
+
+func TestCheckUnknownWorkspace(t *testing.T) {
+	store, _ := newTestStore(t, Quota{})
+	if _, err := store.Check("globex"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Check of an unknown workspace returned %v, want ErrNotFound", err)
+	}
+}
+
+func TestConcurrentJobs(t *testing.T) {
+	store, _ := newTestStore(t, Quota{MaxConcurrentJobs: 2})
+
+	for range 2 {
+		if ok, err := store.Check("acme"); !ok || err != nil {
+			t.Fatalf("Check = %v, %v, want the job let through", ok, err)
+		}
+		store.Start("acme")
+	}
+	// A full workspace holds jobs back rather than refusing them
+	if ok, err := store.Check("acme"); ok || err != nil {
+		t.Errorf("Check with every slot taken = %v, %v, want false, nil", ok, err)
+	}
+	if ok, _ := store.Check(DefaultWorkspace); !ok {
+		t.Error("another workspace's jobs were held back")
+	}
+
+	store.Finish("acme", time.Minute, 0)
+	if ok, err := store.Check("acme"); !ok || err != nil {
+		t.Errorf("Check after a job finished = %v, %v, want the job let through", ok, err)
+	}
+	if usage := store.Usage("acme"); usage.Running != 1 || usage.JobsToday != 2 {
+		t.Errorf("Usage = %+v, want 1 running of 2 today", usage)
+	}
+}
+
+func TestUsageRollsOver(t *testing.T) {
+	store, clock := newTestStore(t, Quota{JobsPerDay: 1, LLMTokensPerMonth: 100})
+	clock.now = time.Date(2026, 3, 30, 23, 0, 0, 0, time.UTC)
+
+	store.Start("acme")
+	store.Finish("acme", 30*time.Second, 100)
+	if _, err := store.Check("acme"); err == nil {
+		t.Fatal("Check let a job through with the daily quota used")
+	}
+
+	// A new day resets the daily count, but not the monthly ones
+	clock.now = clock.now.Add(time.Hour)
+	var quotaErr *QuotaError
+	if _, err := store.Check("acme"); !errors.As(err, &quotaErr) || quotaErr.Quota != "llm_tokens_per_month" {
+		t.Fatalf("Check on a new day returned %v, want the monthly token quota refused", err)
+	}
+	if usage := store.Usage("acme"); usage.Day != "2026-03-31" || usage.JobsToday != 0 || usage.LLMTokens != 100 {
+		t.Errorf("Usage on a new day = %+v, want no jobs on 2026-03-31 and the month's tokens", usage)
+	}
+
+	clock.now = clock.now.Add(24 * time.Hour)
+	if ok, err := store.Check("acme"); !ok || err != nil {
+		t.Errorf("Check in a new month = %v, %v, want the job let through", ok, err)
+	}
+	if usage := store.Usage("acme"); usage.Month != "2026-04" || usage.LLMTokens != 0 || usage.BrowserMinutes != 0 {
+		t.Errorf("Usage in a new month = %+v, want nothing used in 2026-04", usage)
+	}
+}
+
+func TestUsageRollsOverInUTC(t *testing.T) {
+	store, clock := newTestStore(t, Quota{JobsPerDay: 1, BrowserMinutesPerMonth: 1})
+
+	// 08:30 in Tokyo on the 15th is still the 14th in UTC
+	tokyo := time.FixedZone("JST", 9*60*60)
+	clock.now = time.Date(2026, 5, 15, 8, 30, 0, 0, tokyo)
+	store.Start("acme")
+	store.Finish("acme", 2*time.Minute, 0)
+
+	clock.now = time.Date(2026, 5, 15, 23, 30, 0, 0, time.UTC)
+	var quotaErr *QuotaError
+	if _, err := store.Check("acme"); !errors.As(err, &quotaErr) || quotaErr.Quota != "browser_minutes_per_month" {
+		t.Errorf("Check later the same month returned %v, want the browser minutes refused", err)
+	}
+	if usage := store.Usage("acme"); usage.JobsToday != 0 || usage.Day != "2026-05-15" {
+		t.Errorf("Usage = %+v, want no jobs on 2026-05-15", usage)
+	}
+
+	clock.now = time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)
+	if ok, err := store.Check("acme"); !ok || err != nil {
+		t.Errorf("Check in a new month = %v, %v, want the job let through", ok, err)
+	}
+}
+
+func TestUsageIsSaved(t *testing.T) {
+	store, _ := newTestStore(t, Quota{})
+	store.Start("acme")
+	store.Finish("acme", 3*time.Minute, 42)
+	store.Start("acme")
+
+	reopened, err := NewStore(store.path, store.usagePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reopened.now = store.now
+	usage := reopened.Usage("acme")
+	// Running jobs belonged to the previous server process
+	if usage.Running != 0 || usage.JobsToday != 2 || usage.BrowserMinutes != 3 || usage.LLMTokens != 42 {
+		t.Errorf("Usage after reopening = %+v", usage)
+	}
+	if workspace, ok := reopened.Get("acme"); !ok || workspace.Name != "acme" {
+		t.Errorf("Get after reopening = %+v, %v", workspace, ok)
+	}
+}
+
+func TestPutAndDelete(t *testing.T) {
+	store, clock := newTestStore(t, Quota{JobsPerDay: 1})
+	created, _ := store.Get("acme")
+
+	clock.now = clock.now.Add(time.Hour)
+	updated, err := store.Put("acme", Quota{JobsPerDay: 5})
+	if err != nil || updated.Quota.JobsPerDay != 5 || !updated.CreatedAt.Equal(created.CreatedAt) {
+		t.Errorf("Put of an existing workspace = %+v, %v, want the quota replaced and the creation time kept", updated, err)
+	}
+
+	for _, tt := range []struct {
+		name  string
+		quota Quota
+		want  error
+	}{
+		{"Acme", Quota{}, ErrInvalidName},
+		{"-acme", Quota{}, ErrInvalidName},
+		{"acme", Quota{MaxConcurrentJobs: -1}, ErrInvalidQuota},
+	} {
+		if _, err := store.Put(tt.name, tt.quota); !errors.Is(err, tt.want) {
+			t.Errorf("Put(%q, %+v) returned %v, want %v", tt.name, tt.quota, err, tt.want)
+		}
+	}
+
+	if err := store.Delete(DefaultWorkspace); !errors.Is(err, ErrDefault) {
+		t.Errorf("Delete of the default workspace returned %v, want ErrDefault", err)
+	}
+	if err := store.Delete("acme"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete("acme"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Delete of a deleted workspace returned %v, want ErrNotFound", err)
+	}
+	if names := store.List(); len(names) != 1 || names[0].Name != DefaultWorkspace {
+		t.Errorf("List = %+v, want only the default workspace", names)
+	}
+}